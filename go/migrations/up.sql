@@ -36,3 +36,163 @@ CREATE TABLE IF NOT EXISTS comments (
 CREATE TABLE IF NOT EXISTS websites (
        url TEXT PRIMARY KEY
 );
+
+CREATE TABLE IF NOT EXISTS scheduled_commands (
+       scheduled_command_id UUID PRIMARY KEY,
+       execute_at TIMESTAMP WITH TIME ZONE NOT NULL,
+       command JSONB NOT NULL,
+       status TEXT NOT NULL,
+       error TEXT,
+       created_on TIMESTAMP WITH TIME ZONE
+);
+
+ALTER TABLE scheduled_commands ADD COLUMN IF NOT EXISTS claimed_on TIMESTAMP WITH TIME ZONE;
+
+CREATE TABLE IF NOT EXISTS account_tokens (
+       token_hash BYTEA PRIMARY KEY,
+       account_id UUID REFERENCES accounts(account_id) ON DELETE CASCADE NOT NULL,
+       purpose TEXT NOT NULL,
+       created_on TIMESTAMP WITH TIME ZONE,
+       expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+       used_on TIMESTAMP WITH TIME ZONE
+);
+
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS sessions_valid_from TIMESTAMP WITH TIME ZONE;
+
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS email_verified_on TIMESTAMP WITH TIME ZONE;
+ALTER TABLE websites ADD COLUMN IF NOT EXISTS require_verified_email BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE comment_threads ADD COLUMN IF NOT EXISTS website TEXT REFERENCES websites(url);
+
+CREATE TABLE IF NOT EXISTS sessions (
+       session_id UUID PRIMARY KEY,
+       account_id UUID REFERENCES accounts(account_id) ON DELETE CASCADE NOT NULL,
+       created_on TIMESTAMP WITH TIME ZONE,
+       expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+       revoked_on TIMESTAMP WITH TIME ZONE
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+       token_hash BYTEA PRIMARY KEY,
+       session_id UUID REFERENCES sessions(session_id) ON DELETE CASCADE NOT NULL,
+       created_on TIMESTAMP WITH TIME ZONE,
+       used_on TIMESTAMP WITH TIME ZONE
+);
+
+-- Access tokens used to be stored in the events of sessions
+UPDATE events SET data = data - 'jwt' WHERE event_type IN ('AccountLoggedIn', 'SessionRefreshed') AND data ? 'jwt';
+
+CREATE TABLE IF NOT EXISTS signing_keys (
+       key_id TEXT PRIMARY KEY,
+       secret BYTEA NOT NULL,
+       created_on TIMESTAMP WITH TIME ZONE,
+       activated_on TIMESTAMP WITH TIME ZONE,
+       retired_on TIMESTAMP WITH TIME ZONE
+);
+
+-- Keys generated before algorithms could be chosen are HMAC keys
+ALTER TABLE signing_keys ADD COLUMN IF NOT EXISTS algorithm TEXT NOT NULL DEFAULT 'HS256';
+
+-- Accounts hashed with scrypt keep hashed_password and hash_salt until
+-- they log in and are rehashed into password_hash
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS password_hash TEXT;
+ALTER TABLE accounts ALTER COLUMN hashed_password DROP NOT NULL;
+ALTER TABLE accounts ALTER COLUMN hash_salt DROP NOT NULL;
+
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP WITH TIME ZONE;
+
+CREATE TABLE IF NOT EXISTS login_failures (
+       key TEXT PRIMARY KEY,
+       failures INTEGER NOT NULL,
+       last_failure_on TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS totp_secret BYTEA;
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS totp_enabled_on TIMESTAMP WITH TIME ZONE;
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+       code_hash BYTEA PRIMARY KEY,
+       account_id UUID REFERENCES accounts(account_id) ON DELETE CASCADE NOT NULL,
+       used_on TIMESTAMP WITH TIME ZONE
+);
+
+CREATE TABLE IF NOT EXISTS oidc_logins (
+       state_hash BYTEA PRIMARY KEY,
+       provider TEXT NOT NULL,
+       code_verifier TEXT NOT NULL,
+       nonce TEXT NOT NULL,
+       created_on TIMESTAMP WITH TIME ZONE,
+       expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+       used_on TIMESTAMP WITH TIME ZONE
+);
+
+CREATE TABLE IF NOT EXISTS external_identities (
+       provider TEXT NOT NULL,
+       subject TEXT NOT NULL,
+       account_id UUID REFERENCES accounts(account_id) ON DELETE CASCADE NOT NULL,
+       linked_on TIMESTAMP WITH TIME ZONE,
+       PRIMARY KEY (provider, subject)
+);
+
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS account_type TEXT NOT NULL DEFAULT 'registered';
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS display_name TEXT;
+ALTER TABLE accounts ALTER COLUMN username DROP NOT NULL;
+ALTER TABLE websites ADD COLUMN IF NOT EXISTS allow_guests BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Guests have no username and their email isn't unique. The unique
+-- constraints are replaced by unique indexes of the same name.
+ALTER TABLE accounts DROP CONSTRAINT IF EXISTS accounts_username_key;
+ALTER TABLE accounts DROP CONSTRAINT IF EXISTS accounts_email_key;
+CREATE UNIQUE INDEX IF NOT EXISTS accounts_username_key ON accounts (username) WHERE account_type = 'registered';
+CREATE UNIQUE INDEX IF NOT EXISTS accounts_email_key ON accounts (email) WHERE account_type = 'registered';
+
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS claimed_by UUID REFERENCES accounts(account_id);
+
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS avatar_url TEXT;
+
+CREATE TABLE IF NOT EXISTS username_changes (
+       account_id UUID REFERENCES accounts(account_id) ON DELETE CASCADE NOT NULL,
+       old_username TEXT NOT NULL,
+       new_username TEXT NOT NULL,
+       changed_on TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS avatar_key TEXT;
+
+CREATE TABLE IF NOT EXISTS website_roles (
+       website TEXT REFERENCES websites(url) ON DELETE CASCADE NOT NULL,
+       account_id UUID REFERENCES accounts(account_id) ON DELETE CASCADE NOT NULL,
+       role TEXT NOT NULL,
+       granted_on TIMESTAMP WITH TIME ZONE,
+       PRIMARY KEY (website, account_id)
+);
+
+CREATE TABLE IF NOT EXISTS bans (
+       ban_id UUID PRIMARY KEY,
+       account_id UUID REFERENCES accounts(account_id) ON DELETE CASCADE NOT NULL,
+       website TEXT REFERENCES websites(url) ON DELETE CASCADE,
+       suspension BOOLEAN NOT NULL DEFAULT FALSE,
+       reason TEXT,
+       hide_comments BOOLEAN NOT NULL DEFAULT FALSE,
+       banned_by UUID REFERENCES accounts(account_id) ON DELETE SET NULL,
+       created_on TIMESTAMP WITH TIME ZONE NOT NULL,
+       expires_at TIMESTAMP WITH TIME ZONE,
+       lifted_on TIMESTAMP WITH TIME ZONE,
+       lifted_by UUID REFERENCES accounts(account_id) ON DELETE SET NULL
+);
+
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS deleted BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+       api_token_id UUID PRIMARY KEY,
+       account_id UUID REFERENCES accounts(account_id) ON DELETE CASCADE NOT NULL,
+       name TEXT NOT NULL,
+       prefix TEXT NOT NULL,
+       token_hash BYTEA UNIQUE NOT NULL,
+       -- Separated by spaces
+       scopes TEXT NOT NULL,
+       created_on TIMESTAMP WITH TIME ZONE NOT NULL,
+       expires_at TIMESTAMP WITH TIME ZONE,
+       last_used_on TIMESTAMP WITH TIME ZONE,
+       revoked_on TIMESTAMP WITH TIME ZONE
+);
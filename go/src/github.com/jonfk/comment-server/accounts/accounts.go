@@ -2,8 +2,8 @@ package accounts
 
 import (
 	"crypto/rand"
-	"database/sql"
 	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -12,155 +12,329 @@ import (
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
+
+	"github.com/jonfk/comment-server/apperrors"
 )
 
 type Accounts struct {
-	DB                   *sqlx.DB
+	DB *sqlx.DB
+	// Store defaults to a DBStore using DB when nil.
+	Store                Store
 	SessionLengthInHours int
+	// AccessTokenLifetime defaults to DefaultAccessTokenLifetime when 0.
+	AccessTokenLifetime time.Duration
+	// PasswordPolicy defaults to DefaultPasswordPolicy when nil.
+	PasswordPolicy *PasswordPolicy
+	// LoginThrottle defaults to DefaultLoginThrottle when nil.
+	LoginThrottle *LoginThrottle
+	// Now returns the current time. It defaults to time.Now.
+	Now func() time.Time
+	// TOTPIssuer names the service in authenticator apps. It defaults to
+	// DefaultTOTPIssuer.
+	TOTPIssuer string
+	// OIDCProviders are the identity providers accounts can log in with.
+	OIDCProviders []OIDCProvider
+	// HTTPClient calls the identity providers. It defaults to
+	// http.DefaultClient.
+	HTTPClient *http.Client
+	// AvatarDir is the directory uploaded avatars are written to. Uploads
+	// are disabled when it's empty.
+	AvatarDir string
+	// AvatarBaseURL is the URL the AvatarHandler is served at. It defaults
+	// to AvatarPath.
+	AvatarBaseURL string
+	// Admins are the accounts allowed to run administrative commands, such
+	// as managing the signing keys.
+	Admins []uuid.UUID
 	// From http://security.stackexchange.com/questions/95972/what-are-requirements-for-hmac-secret-key
+	// HMACSecretKey signs tokens while no key of the keyring is active and
+	// verifies tokens without a kid header.
 	HMACSecretKey []byte // Should be a 512 bits random key
 }
 
+// Types of accounts. Registered accounts log in with a password, a login
+// link or an identity provider. Guests only have a display name and
+// optionally an email, which isn't unique, and keep the session they were
+// created with. Deleted accounts are stripped of their personal data and
+// only kept for the comments and bans referencing them.
+const (
+	AccountTypeRegistered = "registered"
+	AccountTypeGuest      = "guest"
+	AccountTypeDeleted    = "deleted"
+)
+
+// DeletedAccountName is shown instead of the name of deleted accounts.
+const DeletedAccountName = "[deleted]"
+
 type Account struct {
-	AccountId      uuid.UUID `db:"account_id" json:"accountId"`
-	Username       string    `db:"username" json:"username"`
-	Email          string    `db:"email" json:"email"`
-	HashedPassword []byte    `db:"hashed_password"`
+	AccountId   uuid.UUID `db:"account_id" json:"accountId"`
+	AccountType string    `db:"account_type" json:"accountType"`
+	// DisplayName is the name of guests, who have no Username. Registered
+	// accounts may set one to show instead of their Username.
+	DisplayName string `db:"display_name" json:"displayName,omitempty"`
+	AvatarURL   string `db:"avatar_url" json:"avatarUrl,omitempty"`
+	// AvatarKey identifies the thumbnails of an uploaded avatar, see
+	// Accounts.AvatarURL.
+	AvatarKey string `db:"avatar_key" json:"-"`
+	Username  string `db:"username" json:"username"`
+	Email     string `db:"email" json:"email"`
+	// PasswordHash is a PHC string, see PasswordPolicy.
+	PasswordHash string `db:"password_hash" json:"-"`
+	// HashedPassword and HashSalt are set instead of PasswordHash for
+	// accounts whose password was hashed by HashPassword and not rehashed
+	// since.
+	HashedPassword []byte    `db:"hashed_password" json:"-"`
 	CreatedOn      time.Time `db:"created_on" json:"createdOn"`
-	HashSalt       []byte    `db:"hash_salt"`
+	HashSalt       []byte    `db:"hash_salt" json:"-"`
+	// SessionsValidFrom is when the sessions of the account were last
+	// invalidated. Session tokens issued before it are rejected.
+	SessionsValidFrom *time.Time `db:"sessions_valid_from" json:"-"`
+	// EmailVerifiedOn is when the owner of the account proved they own
+	// Email. It is nil while Email is unverified.
+	EmailVerifiedOn *time.Time `db:"email_verified_on" json:"emailVerifiedOn,omitempty"`
+	// LockedUntil is set while failed login attempts lock the account.
+	LockedUntil *time.Time `db:"locked_until" json:"-"`
+	// TOTPSecret is set once TOTP enrollment starts, TOTPEnabledOn once it
+	// is confirmed. TOTPLastStep is the period of the last code accepted.
+	TOTPSecret    []byte     `db:"totp_secret" json:"-"`
+	TOTPEnabledOn *time.Time `db:"totp_enabled_on" json:"-"`
+	TOTPLastStep  int64      `db:"totp_last_step" json:"-"`
+	// ClaimedBy is the registered account a guest was claimed by.
+	ClaimedBy *uuid.UUID `db:"claimed_by" json:"claimedBy,omitempty"`
+}
+
+// EmailVerified reports whether the owner of the account proved they own
+// its email address.
+func (a Account) EmailVerified() bool {
+	return a.EmailVerifiedOn != nil
+}
+
+func (a *Accounts) store() Store {
+	if a.Store != nil {
+		return a.Store
+	}
+	return &DBStore{DB: a.DB}
+}
+
+// Name is the name shown with the comments of the account.
+func (a Account) Name() string {
+	if a.Deleted() {
+		return DeletedAccountName
+	}
+	if a.DisplayName != "" {
+		return a.DisplayName
+	}
+	return a.Username
+}
+
+func (a Account) Guest() bool {
+	return a.AccountType == AccountTypeGuest
+}
+
+func (a Account) Deleted() bool {
+	return a.AccountType == AccountTypeDeleted
+}
+
+// TwoFactorEnabled reports whether logging in requires a second factor.
+func (a Account) TwoFactorEnabled() bool {
+	return a.TOTPEnabledOn != nil
+}
+
+// Locked reports whether the account is locked out at now.
+func (a Account) Locked(now time.Time) bool {
+	return a.LockedUntil != nil && now.Before(*a.LockedUntil)
+}
+
+// passwordHash returns the PHC string of the password of the account.
+func (a Account) passwordHash() string {
+	return passwordHashOf(a.PasswordHash, a.HashedPassword, a.HashSalt)
 }
 
 func (a Account) Equal(b Account) bool {
 	if !a.CreatedOn.Equal(b.CreatedOn) ||
+		a.AccountType != b.AccountType ||
 		a.Email != b.Email ||
 		a.Username != b.Username ||
+		a.DisplayName != b.DisplayName ||
 		!uuid.Equal(a.AccountId, b.AccountId) ||
-		len(a.HashedPassword) != len(a.HashedPassword) ||
-		len(a.HashSalt) != len(b.HashSalt) {
+		a.passwordHash() != b.passwordHash() {
 		return false
 	}
-	for i, byte := range a.HashedPassword {
-		if byte != b.HashedPassword[i] {
-			return false
-		}
-	}
-	for i, byte := range a.HashSalt {
-		if byte != b.HashSalt[i] {
-			return false
-		}
-	}
 	return true
 }
 
-func (a *Accounts) CreateNewAccount(account Account, unhashedPassword string) (Account, error) {
-	var (
-		newAccount Account
-	)
-	salt, err := GenerateSalt()
-	if err != nil {
-		return newAccount, err
+func (a *Accounts) passwordPolicy() PasswordPolicy {
+	if a.PasswordPolicy != nil {
+		return *a.PasswordPolicy
 	}
+	return DefaultPasswordPolicy
+}
+
+// HashPassword returns the PHC string of password hashed following the
+// PasswordPolicy.
+func (a *Accounts) HashPassword(password string) (string, error) {
+	return a.passwordPolicy().Hash(password)
+}
+
+// PasswordNeedsRehash reports whether the password of account was hashed
+// with weaker parameters than the PasswordPolicy.
+func (a *Accounts) PasswordNeedsRehash(account Account) bool {
+	return a.passwordPolicy().NeedsRehash(account.passwordHash())
+}
 
-	account.HashedPassword, err = HashPassword(unhashedPassword, salt)
+// RehashPasswordIfNeeded replaces the hash of the password of account when
+// PasswordNeedsRehash, password must have been verified. Like sessions, the
+// new hash isn't recorded by an event as it accepts the same password.
+func (a *Accounts) RehashPasswordIfNeeded(account Account, password string) error {
+	if !a.PasswordNeedsRehash(account) {
+		return nil
+	}
+	passwordHash, err := a.HashPassword(password)
 	if err != nil {
-		return newAccount, err
+		return err
 	}
+	return a.store().RehashPassword(account.AccountId, passwordHash)
+}
 
-	err = a.DB.QueryRowx("INSERT INTO accounts (account_id, username,email,hashed_password,hash_salt,created_on) VALUES ($1,$2,$3,$4,$5,$6) RETURNING account_id,username,email,hashed_password,hash_salt,created_on",
-		uuid.NewV4().String(), account.Username, account.Email, account.HashedPassword, salt, account.CreatedOn).StructScan(&newAccount)
+func (a *Accounts) CreateNewAccount(account Account, unhashedPassword string) (Account, error) {
+	passwordHash, err := a.HashPassword(unhashedPassword)
+	if err != nil {
+		return Account{}, err
+	}
+	account.AccountId = uuid.NewV4()
+	account.AccountType = AccountTypeRegistered
+	account.PasswordHash = passwordHash
 
-	return newAccount, err
+	return a.store().InsertAccount(account)
 }
 
 func (a *Accounts) DeleteById(accountId uuid.UUID) (string, error) {
-	var deletedAccountId string
-	err := a.DB.QueryRowx("DELETE FROM accounts where account_id = $1 RETURNING account_id", accountId).Scan(&deletedAccountId)
-
-	return deletedAccountId, err
+	return a.store().DeleteById(accountId)
 }
 
+// Verify checks the password of an account. Unknown accounts and wrong
+// passwords both return InvalidCredentialsErr.
 func (a *Accounts) Verify(accountId uuid.UUID, unhashedPassword string) error {
 	account, err := a.GetAccountByAccountId(accountId)
-	if err != nil {
-		return err
-	}
+	return a.verifyPassword(account, err, unhashedPassword)
+}
 
-	hashedPassword, err := HashPassword(unhashedPassword, account.HashSalt)
-	if err != nil {
-		return err
+// Authenticate returns the account with email if password is its password.
+// Unknown emails and wrong passwords both return InvalidCredentialsErr
+// after hashing the password, so that neither the error nor the time taken
+// reveal whether an account exists for email.
+func (a *Accounts) Authenticate(email, password string) (Account, error) {
+	account, err := a.GetAccountByEmail(email)
+	if err := a.verifyPassword(account, err, password); err != nil {
+		return Account{}, err
 	}
+	return account, nil
+}
 
-	if len(hashedPassword) != len(account.HashedPassword) {
-		return fmt.Errorf("length of password does not match")
+// verifyPassword verifies password against the account returned with
+// lookupErr by a store lookup.
+func (a *Accounts) verifyPassword(account Account, lookupErr error, password string) error {
+	if lookupErr == AccountNotFoundErr {
+		// Spend the time verifying a password would have taken
+		a.HashPassword(password)
+		return InvalidCredentialsErr
+	}
+	if lookupErr != nil {
+		return lookupErr
+	}
+	if account.passwordHash() == "" {
+		// Accounts created with an identity provider have no password
+		a.HashPassword(password)
+		return InvalidCredentialsErr
 	}
 
-	for i, x := range hashedPassword {
-		if x != account.HashedPassword[i] {
-			return fmt.Errorf("password does not match")
-		}
+	ok, err := VerifyPassword(account.passwordHash(), password)
+	if err != nil {
+		return apperrors.Wrap(err, apperrors.Internal, "invalid password hash")
+	}
+	if !ok {
+		return InvalidCredentialsErr
 	}
 	return nil
 }
 
+// VerifyAndGenerateJWT returns TwoFactorRequiredErr for accounts with
+// two-factor authentication enabled, which log in with LoginAccount and
+// LoginTwoFactor instead.
 func (a *Accounts) VerifyAndGenerateJWT(accountId uuid.UUID, unhashedPassword string) (string, error) {
 	if err := a.Verify(accountId, unhashedPassword); err != nil {
 		return "", err
 	}
-	now := time.Now().UTC().Round(time.Second).Add(-1 * time.Second)
+	account, err := a.GetAccountByAccountId(accountId)
+	if err != nil {
+		return "", err
+	}
+	if account.TwoFactorEnabled() {
+		return "", TwoFactorRequiredErr
+	}
+	return a.GenerateJWT(accountId)
+}
 
-	return generateJWT(a.HMACSecretKey, accountId, now, now.Add(time.Duration(a.SessionLengthInHours)*time.Hour))
+// GenerateJWT starts a new session for an account that has already been
+// authenticated and returns its access token.
+func (a *Accounts) GenerateJWT(accountId uuid.UUID) (string, error) {
+	tokens, err := a.StartSession(accountId)
+	return tokens.AccessToken, err
 }
 
+// ValidateJWT returns the account of an access token. Tokens of revoked
+// sessions and tokens issued before the sessions of the account were
+// invalidated, e.g. by a password reset, are rejected. API tokens of any
+// scope are accepted too, ValidateToken also checks their scope.
 func (a *Accounts) ValidateJWT(token string) (uuid.UUID, error) {
-	return validateJWT(a.HMACSecretKey, token)
+	return a.ValidateToken(token, "")
 }
 
-func (a *Accounts) GetAccountByAccountId(accountId uuid.UUID) (Account, error) {
-	var account Account
-	err := a.DB.Get(&account, "SELECT account_id,username,email,hashed_password,hash_salt,created_on FROM accounts where account_id = $1",
-		accountId)
+func (a *Accounts) validateAccessToken(token string) (sessionClaims, error) {
+	claims, err := parseJWT(a.verificationKey, token)
 	if err != nil {
-		switch {
-		case err == sql.ErrNoRows:
-			return account, AccountNotFoundErr
-		default:
-			return account, err
-		}
+		return sessionClaims{}, err
 	}
-	return account, nil
-}
 
-func (a *Accounts) GetAccountByEmail(email string) (Account, error) {
-	var account Account
-	err := a.DB.Get(&account, "SELECT account_id,username,email,hashed_password,hash_salt,created_on FROM accounts where email = $1",
-		email)
+	account, err := a.GetAccountByAccountId(claims.AccountId)
+	if err == AccountNotFoundErr {
+		return sessionClaims{}, InvalidTokenErr
+	}
 	if err != nil {
-		switch {
-		case err == sql.ErrNoRows:
-			return account, AccountNotFoundErr
-		default:
-			return account, err
-		}
+		return sessionClaims{}, err
+	}
+	// Both iat and event timestamps have a precision of a second so tokens
+	// issued in the second the sessions were invalidated are rejected too.
+	// iat is one second before the second the token was issued in, see sessionTokens.
+	if account.SessionsValidFrom != nil && !claims.IssuedAt.Add(time.Second).After(*account.SessionsValidFrom) {
+		return sessionClaims{}, InvalidTokenErr
 	}
-	return account, err
-}
 
-func (a *Accounts) GetAccountByUsername(username string) (Account, error) {
-	var account Account
-	err := a.DB.Get(&account, "SELECT account_id,username,email,hashed_password,hash_salt,created_on FROM accounts where username = $1",
-		username)
+	session, err := a.store().GetSession(claims.SessionId)
 	if err != nil {
-		switch {
-		case err == sql.ErrNoRows:
-			return account, AccountNotFoundErr
-		default:
-			return account, err
-		}
+		return sessionClaims{}, err
+	}
+	if !uuid.Equal(session.AccountId, claims.AccountId) || !session.Active(a.now()) {
+		return sessionClaims{}, InvalidTokenErr
 	}
-	return account, err
+	return claims, nil
+}
+
+func (a *Accounts) GetAccountByAccountId(accountId uuid.UUID) (Account, error) {
+	return a.store().GetAccountByAccountId(accountId)
+}
+
+func (a *Accounts) GetAccountByEmail(email string) (Account, error) {
+	return a.store().GetAccountByEmail(email)
+}
+
+func (a *Accounts) GetAccountByUsername(username string) (Account, error) {
+	return a.store().GetAccountByUsername(username)
 }
 
-// HashPassword returns a hashed password from the unhashed password and a salt
+// HashPassword returns a hashed password from the unhashed password and a salt.
+// It is the scrypt hash used before passwords were stored as PHC strings,
+// new passwords are hashed with Accounts.HashPassword.
 func HashPassword(unhashedPassword string, salt []byte) ([]byte, error) {
 	return scrypt.Key([]byte(unhashedPassword), []byte(salt), 16384, 8, 1, 32)
 }
@@ -177,41 +351,116 @@ func GenerateSalt() ([]byte, error) {
 }
 
 func generateJWT(hmacSecretKey []byte, accountId uuid.UUID, issuedAt, expiresAt time.Time) (string, error) {
+	return generateSessionJWT(SigningKey{Algorithm: AlgorithmHS256, Secret: hmacSecretKey}, sessionClaims{
+		TokenId:   uuid.NewV4(),
+		AccountId: accountId,
+		IssuedAt:  issuedAt,
+	}, expiresAt)
+}
+
+func generateSessionJWT(key SigningKey, claims sessionClaims, expiresAt time.Time) (string, error) {
 	// Create a new token object, specifying signing method and the claims
 	// you would like it to contain.
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"aid": accountId.String(),
-		"iat": issuedAt.Unix(),
+	signingMethod, err := key.signingMethod()
+	if err != nil {
+		return "", err
+	}
+	privateKey, err := key.privateKey()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(signingMethod, jwt.MapClaims{
+		"jti": claims.TokenId.String(),
+		"sid": claims.SessionId.String(),
+		"aid": claims.AccountId.String(),
+		"iat": claims.IssuedAt.Unix(),
 		"exp": expiresAt.Unix(),
 	})
+	if key.KeyId != "" {
+		token.Header["kid"] = key.KeyId
+	}
 	// Sign and get the complete encoded token as a string using the secret
-	tokenString, err := token.SignedString(hmacSecretKey)
+	tokenString, err := token.SignedString(privateKey)
 	return tokenString, err
 }
 
+// sessionClaims are the claims of a valid access token.
+type sessionClaims struct {
+	TokenId   uuid.UUID
+	SessionId uuid.UUID
+	AccountId uuid.UUID
+	IssuedAt  time.Time
+}
+
 func validateJWT(hmacSecretKey []byte, tokenString string) (uuid.UUID, error) {
+	claims, err := parseJWT(func(string) (SigningKey, error) {
+		return SigningKey{Algorithm: AlgorithmHS256, Secret: hmacSecretKey}, nil
+	}, tokenString)
+	return claims.AccountId, err
+}
+
+// parseJWT validates a token signed with the key returned by keyFor for
+// the kid header of the token, which is empty for tokens without one.
+func parseJWT(keyFor func(keyId string) (SigningKey, error), tokenString string) (sessionClaims, error) {
 	// Parse takes the token string and a function for looking up the key. The latter is especially
 	// useful if you use multiple keys for your application.  The standard is to use 'kid' in the
 	// head of the token to identify which key to use, but the parsed token (head and claims) is provided
 	// to the callback, providing flexibility.
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Don't forget to validate the alg is what you expect:
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		keyId, _ := token.Header["kid"].(string)
+		key, err := keyFor(keyId)
+		if err != nil {
+			return nil, err
+		}
+		// Don't forget to validate the alg is what you expect: it is pinned
+		// by the key so that a token can't pick another algorithm, e.g. to
+		// use a public key as an HMAC secret.
+		if token.Method.Alg() != key.Algorithm {
 			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
 		}
-		return hmacSecretKey, nil
+		return key.verificationKey()
 	})
 	if err != nil {
-		return uuid.Nil, err
+		return sessionClaims{}, apperrors.Wrap(err, apperrors.AuthInvalidToken, InvalidTokenErr.Message)
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		if accountId, ok := claims["aid"].(string); ok {
-			return uuid.FromString(accountId)
-		} else {
-			return uuid.Nil, fmt.Errorf("validateJWT: cannot cast aid claim to string")
-		}
-	} else {
-		return uuid.Nil, fmt.Errorf("validateJWT: invalid token with claims: %v", claims)
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return sessionClaims{}, apperrors.Wrap(fmt.Errorf("parseJWT: invalid token with claims: %v", claims), apperrors.AuthInvalidToken, InvalidTokenErr.Message)
+	}
+	parsedAccountId, err := uuidClaim(claims, "aid")
+	if err != nil {
+		return sessionClaims{}, err
+	}
+	tokenId, err := uuidClaim(claims, "jti")
+	if err != nil {
+		return sessionClaims{}, err
+	}
+	sessionId, err := uuidClaim(claims, "sid")
+	if err != nil {
+		return sessionClaims{}, err
+	}
+	// jwt-go decodes numbers as float64
+	issuedAt, ok := claims["iat"].(float64)
+	if !ok {
+		return sessionClaims{}, apperrors.Wrap(fmt.Errorf("parseJWT: cannot cast iat claim to number"), apperrors.AuthInvalidToken, InvalidTokenErr.Message)
+	}
+	return sessionClaims{
+		TokenId:   tokenId,
+		SessionId: sessionId,
+		AccountId: parsedAccountId,
+		IssuedAt:  time.Unix(int64(issuedAt), 0).UTC(),
+	}, nil
+}
+
+func uuidClaim(claims jwt.MapClaims, name string) (uuid.UUID, error) {
+	value, ok := claims[name].(string)
+	if !ok {
+		return uuid.Nil, apperrors.Wrap(fmt.Errorf("parseJWT: cannot cast %s claim to string", name), apperrors.AuthInvalidToken, InvalidTokenErr.Message)
+	}
+	parsed, err := uuid.FromString(value)
+	if err != nil {
+		return uuid.Nil, apperrors.Wrap(err, apperrors.AuthInvalidToken, InvalidTokenErr.Message)
 	}
+	return parsed, nil
 }
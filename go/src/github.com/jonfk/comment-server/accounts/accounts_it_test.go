@@ -45,9 +45,10 @@ func TestCreateNewAccount_and_DeleteById(t *testing.T) {
 	}
 
 	expectedAccount := Account{
-		Username:  "username",
-		Email:     "email",
-		CreatedOn: time.Now().UTC().Round(time.Second),
+		AccountType: AccountTypeRegistered,
+		Username:    "username",
+		Email:       "email",
+		CreatedOn:   time.Now().UTC().Round(time.Second),
 	}
 
 	expectedUnhashedPassword := "unhashedPassword"
@@ -64,10 +65,10 @@ func TestCreateNewAccount_and_DeleteById(t *testing.T) {
 	}
 
 	expectedAccount.AccountId = createdAccount.AccountId
-	expectedAccount.HashSalt = createdAccount.HashSalt
-	expectedAccount.HashedPassword, err = HashPassword(expectedUnhashedPassword, expectedAccount.HashSalt)
-	if err != nil {
-		t.Fatalf("HashPassword failed: %v", err)
+	expectedAccount.PasswordHash = createdAccount.PasswordHash
+	if ok, err := VerifyPassword(createdAccount.PasswordHash, expectedUnhashedPassword); err != nil || !ok {
+		accounts.DeleteById(createdAccount.AccountId)
+		t.Fatalf("Created Account password hash doesn't verify the password : %v", err)
 	}
 
 	testGetAccountByAccountId(t, expectedAccount, accounts)
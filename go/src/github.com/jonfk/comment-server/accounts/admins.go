@@ -0,0 +1,24 @@
+package accounts
+
+import (
+	"github.com/satori/go.uuid"
+)
+
+// IsAdmin reports whether accountId is one of the Admins.
+func (a *Accounts) IsAdmin(accountId uuid.UUID) bool {
+	for _, adminId := range a.Admins {
+		if uuid.Equal(adminId, accountId) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckAdmin returns AdminRequiredErr unless accountId is one of the
+// Admins.
+func (a *Accounts) CheckAdmin(accountId uuid.UUID) error {
+	if !a.IsAdmin(accountId) {
+		return AdminRequiredErr
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package accounts
+
+import (
+	"strings"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/apperrors"
+)
+
+const (
+	// APITokenPrefix starts every API token so that they can be told apart
+	// from session access tokens, and found by secret scanners.
+	APITokenPrefix = "cs_"
+	// apiTokenPrefixLength is the length of the start of API tokens kept
+	// to identify them in listings.
+	apiTokenPrefixLength = len(APITokenPrefix) + 8
+	// apiTokenUseResolution bounds how often the last use of an API token
+	// is recorded.
+	apiTokenUseResolution = time.Minute
+	maxAPITokenNameLength = 100
+)
+
+// Scopes of API tokens. Session access tokens have every scope.
+const (
+	// ScopeComment allows creating and deleting the comments of the
+	// account, e.g. to import comments.
+	ScopeComment = "comment"
+	// ScopeModerate allows what the roles of the account allow on
+	// websites besides commenting.
+	ScopeModerate = "moderate"
+	// ScopeExport allows exporting the data of the account.
+	ScopeExport = "export"
+)
+
+var apiTokenScopes = []string{ScopeComment, ScopeModerate, ScopeExport}
+
+// APIToken is a named, revocable token used by scripts to act as an
+// account without its password. Only the hash of the token is stored.
+type APIToken struct {
+	APITokenId uuid.UUID `db:"api_token_id" json:"apiTokenId"`
+	AccountId  uuid.UUID `db:"account_id" json:"accountId"`
+	Name       string    `db:"name" json:"name"`
+	// Prefix is the start of the token, shown to tell tokens apart.
+	Prefix     string     `db:"prefix" json:"prefix"`
+	TokenHash  []byte     `db:"token_hash" json:"-"`
+	Scopes     []string   `db:"-" json:"scopes"`
+	CreatedOn  time.Time  `db:"created_on" json:"createdOn"`
+	ExpiresAt  *time.Time `db:"expires_at" json:"expiresAt,omitempty"`
+	LastUsedOn *time.Time `db:"last_used_on" json:"lastUsedOn,omitempty"`
+	RevokedOn  *time.Time `db:"revoked_on" json:"revokedOn,omitempty"`
+}
+
+// Active reports whether the token can be used at now.
+func (t APIToken) Active(now time.Time) bool {
+	return t.RevokedOn == nil && (t.ExpiresAt == nil || now.Before(*t.ExpiresAt))
+}
+
+func (t APIToken) HasScope(scope string) bool {
+	for _, granted := range t.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// generateAPIToken generates the token of a new APIToken. It returns the
+// token to give to its owner along with its prefix and hash.
+func generateAPIToken() (token, prefix string, tokenHash []byte, err error) {
+	random, err := generateToken()
+	if err != nil {
+		return "", "", nil, apperrors.Wrap(err, apperrors.Internal, "generating token failed")
+	}
+	token = APITokenPrefix + random
+	return token, token[:apiTokenPrefixLength], hashToken(token), nil
+}
+
+// validateNewAPIToken checks the name, scopes and expiry of a new API
+// token and returns its name and scopes normalized.
+func validateNewAPIToken(name string, scopes []string, expiresAt *time.Time, now time.Time) (string, []string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxAPITokenNameLength {
+		return "", nil, apperrors.Newf(apperrors.CommandInvalid, "API tokens need a name of at most %d characters", maxAPITokenNameLength)
+	}
+	if len(scopes) == 0 {
+		return "", nil, apperrors.New(apperrors.CommandInvalid, "API tokens need at least one scope")
+	}
+	var normalized []string
+	for _, scope := range apiTokenScopes {
+		for _, requested := range scopes {
+			if requested == scope {
+				normalized = append(normalized, scope)
+				break
+			}
+		}
+	}
+	for _, requested := range scopes {
+		if !(APIToken{Scopes: normalized}).HasScope(requested) {
+			return "", nil, apperrors.Newf(apperrors.CommandInvalid, "unknown scope %s", requested)
+		}
+	}
+	if expiresAt != nil && !expiresAt.After(now) {
+		return "", nil, apperrors.New(apperrors.CommandInvalid, "An API token must expire in the future")
+	}
+	return name, normalized, nil
+}
+
+// ListAPITokens returns the API tokens of an account, including revoked
+// and expired ones, oldest first.
+func (a *Accounts) ListAPITokens(accountId uuid.UUID) ([]APIToken, error) {
+	return a.store().ListAPITokens(accountId)
+}
+
+func (a *Accounts) GetAPIToken(apiTokenId uuid.UUID) (APIToken, error) {
+	return a.store().GetAPIToken(apiTokenId)
+}
+
+// ValidateToken returns the account of a session access token or of an
+// API token with scope, or of any scope when scope is empty. Using an API
+// token records its last use.
+func (a *Accounts) ValidateToken(token string, scope string) (uuid.UUID, error) {
+	if !strings.HasPrefix(token, APITokenPrefix) {
+		claims, err := a.validateAccessToken(token)
+		return claims.AccountId, err
+	}
+	apiToken, err := a.validateAPIToken(token)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if scope != "" && !apiToken.HasScope(scope) {
+		return uuid.Nil, InsufficientScopeErr
+	}
+	return apiToken.AccountId, nil
+}
+
+func (a *Accounts) validateAPIToken(token string) (APIToken, error) {
+	apiToken, err := a.store().GetAPITokenByHash(hashToken(token))
+	if err == APITokenNotFoundErr {
+		return APIToken{}, InvalidTokenErr
+	}
+	if err != nil {
+		return APIToken{}, err
+	}
+	now := a.now()
+	if !apiToken.Active(now) {
+		return APIToken{}, InvalidTokenErr
+	}
+	// API tokens outlive the sessions a global ban revokes
+	if err := a.CheckNotBanned(apiToken.AccountId, ""); err != nil {
+		return APIToken{}, err
+	}
+
+	if apiToken.LastUsedOn == nil || now.Sub(*apiToken.LastUsedOn) >= apiTokenUseResolution {
+		if err := a.store().RecordAPITokenUse(apiToken.APITokenId, now); err != nil {
+			return APIToken{}, err
+		}
+	}
+	return apiToken, nil
+}
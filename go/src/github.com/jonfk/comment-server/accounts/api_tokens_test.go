@@ -0,0 +1,118 @@
+package accounts
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/apperrors"
+	"github.com/jonfk/comment-server/commands"
+	"github.com/jonfk/comment-server/events"
+)
+
+func TestAPITokens(t *testing.T) {
+	handler, accountId := newSessionsTestHandler(t)
+	loggedIn := login(t, handler)
+	createAPIToken := func(token, name string, scopes ...string) (events.APITokenCreated, string, error) {
+		response, err := handler.HandleCommand(commands.CreateCommand(commands.CreateAPIToken{Token: token, Name: name, Scopes: scopes}))
+		if err != nil {
+			return events.APITokenCreated{}, "", err
+		}
+		return response.Event.Payload.(events.APITokenCreated), response.Secrets.APIToken, nil
+	}
+
+	if _, _, err := createAPIToken(loggedIn.AccessToken, "import", "comment", "admin"); !apperrors.Is(err, apperrors.CommandInvalid) {
+		t.Fatalf("CreateAPIToken should reject unknown scopes but returned %v", err)
+	}
+	if _, _, err := createAPIToken(loggedIn.AccessToken, " ", ScopeComment); !apperrors.Is(err, apperrors.CommandInvalid) {
+		t.Fatalf("CreateAPIToken should require a name but returned %v", err)
+	}
+	created, apiToken, err := createAPIToken(loggedIn.AccessToken, " import ", ScopeModerate, ScopeComment)
+	if err != nil {
+		t.Fatalf("CreateAPIToken failed : %v\n", err)
+	}
+	if !strings.HasPrefix(apiToken, APITokenPrefix) || !strings.HasPrefix(apiToken, created.Prefix) {
+		t.Fatalf("API tokens should start with their prefix but got %s (%s)", apiToken, created.Prefix)
+	}
+	if _, _, err := createAPIToken(apiToken, "other", ScopeComment); !apperrors.Is(err, apperrors.AuthInvalidToken) {
+		t.Fatalf("API tokens shouldn't create other tokens but CreateAPIToken returned %v", err)
+	}
+
+	validatedAccountId, err := handler.AccountsService.ValidateToken(apiToken, ScopeComment)
+	if err != nil || !uuid.Equal(validatedAccountId, accountId) {
+		t.Fatalf("ValidateToken should accept the API token : %v", err)
+	}
+	if _, err := handler.AccountsService.ValidateToken(apiToken, ScopeExport); err != InsufficientScopeErr {
+		t.Fatalf("ValidateToken should check the scopes of API tokens but returned %v", err)
+	}
+	if validatedAccountId, err := handler.AccountsService.ValidateJWT(apiToken); err != nil || !uuid.Equal(validatedAccountId, accountId) {
+		t.Fatalf("ValidateJWT should accept API tokens : %v", err)
+	}
+	if validatedAccountId, err := handler.AccountsService.ValidateToken(loggedIn.AccessToken, ScopeExport); err != nil || !uuid.Equal(validatedAccountId, accountId) {
+		t.Fatalf("session access tokens should have every scope : %v", err)
+	}
+	if _, err := handler.AccountsService.ValidateToken(APITokenPrefix+"unknown", ""); err != InvalidTokenErr {
+		t.Fatalf("ValidateToken should reject unknown API tokens but returned %v", err)
+	}
+
+	tokens, err := handler.AccountsService.ListAPITokens(accountId)
+	if err != nil {
+		t.Fatalf("ListAPITokens failed : %v\n", err)
+	}
+	if len(tokens) != 1 || tokens[0].Name != "import" || tokens[0].LastUsedOn == nil ||
+		len(tokens[0].Scopes) != 2 || tokens[0].Scopes[0] != ScopeComment || tokens[0].Scopes[1] != ScopeModerate {
+		t.Fatalf("ListAPITokens should return the used token with its scopes but returned %v", tokens)
+	}
+	if !bytes.Equal(tokens[0].TokenHash, hashToken(apiToken)) {
+		t.Fatal("API tokens should be stored hashed")
+	}
+
+	_, err = handler.HandleCommand(commands.CreateCommand(commands.RevokeAPIToken{Token: loggedIn.AccessToken, APITokenId: created.APITokenId}))
+	if err != nil {
+		t.Fatalf("RevokeAPIToken failed : %v\n", err)
+	}
+	if _, err := handler.AccountsService.ValidateToken(apiToken, ScopeComment); err != InvalidTokenErr {
+		t.Fatalf("ValidateToken should reject revoked API tokens but returned %v", err)
+	}
+}
+
+func TestAPITokenExpiry(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	handler, accountId := newSessionsTestHandler(t)
+	handler.AccountsService.Now = func() time.Time { return now }
+	token, prefix, tokenHash, err := generateAPIToken()
+	if err != nil {
+		t.Fatalf("generateAPIToken failed : %v\n", err)
+	}
+	expiresAt := now.Add(time.Hour)
+	err = handler.EventHandler.HandleEvent(events.NewEventWithId(now, events.APITokenCreated{
+		APITokenId: uuid.NewV4(),
+		AccountId:  accountId,
+		Name:       "moderation",
+		Prefix:     prefix,
+		TokenHash:  tokenHash,
+		Scopes:     []string{ScopeModerate},
+		ExpiresAt:  &expiresAt,
+	}, uuid.NewV4()))
+	if err != nil {
+		t.Fatalf("HandleEvent failed : %v\n", err)
+	}
+
+	if _, err := handler.AccountsService.ValidateToken(token, ScopeModerate); err != nil {
+		t.Fatalf("ValidateToken should accept the API token until it expires : %v", err)
+	}
+	err = handler.EventHandler.HandleEvent(events.NewEventNow(events.AccountBanned{BanId: uuid.NewV4(), AccountId: accountId}))
+	if err != nil {
+		t.Fatalf("HandleEvent failed : %v\n", err)
+	}
+	if _, err := handler.AccountsService.ValidateToken(token, ScopeModerate); err != AccountBannedErr {
+		t.Fatalf("ValidateToken should reject the API tokens of banned accounts but returned %v", err)
+	}
+	now = expiresAt
+	if _, err := handler.AccountsService.ValidateToken(token, ScopeModerate); err != InvalidTokenErr {
+		t.Fatalf("ValidateToken should reject expired API tokens but returned %v", err)
+	}
+}
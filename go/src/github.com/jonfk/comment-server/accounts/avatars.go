@@ -0,0 +1,192 @@
+package accounts
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/jonfk/comment-server/apperrors"
+)
+
+const (
+	// AvatarPath is where the AvatarHandler is conventionally served.
+	AvatarPath = "/avatars/"
+	// MaxAvatarBytes and MaxAvatarDimension bound uploaded images, the
+	// latter before they are decoded.
+	MaxAvatarBytes     = 1 << 20
+	MaxAvatarDimension = 4096
+	// DefaultAvatarSize is the size in pixels of the avatars returned with
+	// comments.
+	DefaultAvatarSize = 64
+)
+
+// AvatarSizes are the sizes of the square thumbnails uploaded avatars are
+// resized into, smallest first.
+var AvatarSizes = []int{32, 64, 128}
+
+// avatarFileName matches the thumbnails written by SaveAvatar, named after
+// the avatar key and their size.
+var avatarFileName = regexp.MustCompile(`^[0-9a-f]{32}-[0-9]+\.png$`)
+
+// AvatarURL returns the URL of the avatar of account closest to size
+// pixels. Uploaded avatars come first, then the avatar URL of the profile.
+// Other accounts get their Gravatar, falling back to an identicon derived
+// from the email hash, or from the account id for accounts without email.
+func (a *Accounts) AvatarURL(account Account, size int) string {
+	if account.AvatarKey != "" {
+		return a.avatarBaseURL() + avatarFile(account.AvatarKey, thumbnailSize(size))
+	}
+	if account.AvatarURL != "" {
+		return account.AvatarURL
+	}
+	identity := strings.ToLower(strings.TrimSpace(account.Email))
+	force := ""
+	if identity == "" {
+		identity, force = account.AccountId.String(), "&f=y"
+	}
+	hash := md5.Sum([]byte(identity))
+	return fmt.Sprintf("https://www.gravatar.com/avatar/%s?d=identicon&s=%d%s", hex.EncodeToString(hash[:]), size, force)
+}
+
+func (a *Accounts) avatarBaseURL() string {
+	if a.AvatarBaseURL != "" {
+		return strings.TrimSuffix(a.AvatarBaseURL, "/") + "/"
+	}
+	return AvatarPath
+}
+
+// thumbnailSize returns the smallest thumbnail at least size pixels wide,
+// or the largest one.
+func thumbnailSize(size int) int {
+	for _, thumbnail := range AvatarSizes {
+		if thumbnail >= size {
+			return thumbnail
+		}
+	}
+	return AvatarSizes[len(AvatarSizes)-1]
+}
+
+func avatarFile(avatarKey string, size int) string {
+	return fmt.Sprintf("%s-%d.png", avatarKey, size)
+}
+
+// SaveAvatar validates an uploaded PNG, JPEG or GIF image and writes its
+// thumbnails to the AvatarDir. It returns the key identifying them.
+func (a *Accounts) SaveAvatar(data []byte) (string, error) {
+	if a.AvatarDir == "" {
+		return "", apperrors.New(apperrors.CommandInvalid, "Avatar uploads are disabled")
+	}
+	if len(data) == 0 || len(data) > MaxAvatarBytes {
+		return "", apperrors.Newf(apperrors.CommandInvalid, "Avatars must be at most %d bytes", MaxAvatarBytes)
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", apperrors.New(apperrors.CommandInvalid, "Avatars must be PNG, JPEG or GIF images")
+	}
+	if config.Width > MaxAvatarDimension || config.Height > MaxAvatarDimension {
+		return "", apperrors.Newf(apperrors.CommandInvalid, "Avatars must be at most %dx%d pixels", MaxAvatarDimension, MaxAvatarDimension)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", apperrors.New(apperrors.CommandInvalid, "Avatars must be PNG, JPEG or GIF images")
+	}
+
+	key, err := generateAvatarKey()
+	if err != nil {
+		return "", err
+	}
+	for _, size := range AvatarSizes {
+		var encoded bytes.Buffer
+		if err := png.Encode(&encoded, thumbnail(img, size)); err != nil {
+			return "", apperrors.Wrap(err, apperrors.Internal, "encoding avatar failed")
+		}
+		err := ioutil.WriteFile(filepath.Join(a.AvatarDir, avatarFile(key, size)), encoded.Bytes(), 0644)
+		if err != nil {
+			return "", apperrors.Wrap(err, apperrors.Internal, "writing avatar failed")
+		}
+	}
+	return key, nil
+}
+
+func generateAvatarKey() (string, error) {
+	token, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	hash := hashToken(token)
+	return hex.EncodeToString(hash[:16]), nil
+}
+
+// thumbnail crops the center square of img and scales it to size pixels,
+// averaging the source pixels covered by each thumbnail pixel.
+func thumbnail(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+	left := bounds.Min.X + (bounds.Dx()-side)/2
+	top := bounds.Min.Y + (bounds.Dy()-side)/2
+
+	thumb := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		y0, y1 := top+y*side/size, top+(y+1)*side/size
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < size; x++ {
+			x0, x1 := left+x*side/size, left+(x+1)*side/size
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, alpha, count uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, b, alpha, count = r+uint64(pr), g+uint64(pg), b+uint64(pb), alpha+uint64(pa), count+1
+				}
+			}
+			thumb.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(r / count),
+				G: uint16(g / count),
+				B: uint16(b / count),
+				A: uint16(alpha / count),
+			})
+		}
+	}
+	return thumb
+}
+
+// AvatarHandler serves the thumbnails of uploaded avatars from the
+// AvatarDir of the AccountsService, named by the last segment of request
+// paths.
+type AvatarHandler struct {
+	AccountsService *Accounts
+}
+
+func (h *AvatarHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	if !avatarFileName.MatchString(name) {
+		http.Error(w, "Not found", 404)
+		return
+	}
+	// Avatars are never modified, a new upload gets a new key
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeFile(w, r, filepath.Join(h.AccountsService.AvatarDir, name))
+}
@@ -0,0 +1,125 @@
+package accounts
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/apperrors"
+	"github.com/jonfk/comment-server/commands"
+	"github.com/jonfk/comment-server/events"
+)
+
+func TestAvatarURL_Gravatar(t *testing.T) {
+	accountsService := &Accounts{}
+	// Example of the Gravatar documentation
+	account := Account{AccountId: uuid.NewV4(), Email: " MyEmailAddress@example.com "}
+	expected := "https://www.gravatar.com/avatar/0bc83cb571cd1c50ba6f3e8a78ef1346?d=identicon&s=64"
+	if avatarURL := accountsService.AvatarURL(account, 64); avatarURL != expected {
+		t.Fatalf("AvatarURL should be %s but was %s", expected, avatarURL)
+	}
+
+	guest := Account{AccountId: uuid.NewV4(), AccountType: AccountTypeGuest}
+	if avatarURL := accountsService.AvatarURL(guest, 64); !strings.HasSuffix(avatarURL, "&f=y") {
+		t.Fatalf("accounts without email should get an identicon but got %s", avatarURL)
+	}
+
+	account.AvatarURL = "https://example.com/avatar.png"
+	if avatarURL := accountsService.AvatarURL(account, 64); avatarURL != account.AvatarURL {
+		t.Fatalf("AvatarURL should be the avatar of the profile but was %s", avatarURL)
+	}
+}
+
+func testImage(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: 255, A: 255})
+		}
+	}
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, img); err != nil {
+		t.Fatalf("png.Encode failed : %v\n", err)
+	}
+	return encoded.Bytes()
+}
+
+func TestUploadAvatar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "avatars")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed : %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+	handler, accountId := newSessionsTestHandler(t)
+	handler.AccountsService.AvatarDir = dir
+	loggedIn := login(t, handler)
+	upload := func(image []byte) (events.EventPayload, error) {
+		response, err := handler.HandleCommand(commands.CreateCommand(commands.UploadAvatar{Token: loggedIn.AccessToken, Image: image}))
+		return response.Event.Payload, err
+	}
+
+	invalidImages := map[string][]byte{
+		"not an image": []byte("<svg></svg>"),
+		"too large":    make([]byte, MaxAvatarBytes+1),
+		"too wide":     testImage(t, MaxAvatarDimension+1, 1),
+	}
+	for name, image := range invalidImages {
+		if _, err := upload(image); !apperrors.Is(err, apperrors.CommandInvalid) {
+			t.Fatalf("UploadAvatar should reject an image %s but returned %v", name, err)
+		}
+	}
+
+	if _, err := upload(testImage(t, 300, 200)); err != nil {
+		t.Fatalf("UploadAvatar failed : %v\n", err)
+	}
+	account, err := handler.AccountsService.GetAccountByAccountId(accountId)
+	if err != nil || account.AvatarKey == "" {
+		t.Fatalf("UploadAvatar should set the avatar of the account but got %v (%v)", account, err)
+	}
+	avatarURL := handler.AccountsService.AvatarURL(account, 50)
+	if avatarURL != AvatarPath+account.AvatarKey+"-64.png" {
+		t.Fatalf("AvatarURL should return the closest thumbnail but returned %s", avatarURL)
+	}
+
+	avatarHandler := &AvatarHandler{AccountsService: handler.AccountsService}
+	recorder := httptest.NewRecorder()
+	avatarHandler.ServeHTTP(recorder, httptest.NewRequest("GET", avatarURL, nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("AvatarHandler should serve the thumbnail but returned %d", recorder.Code)
+	}
+	thumbnail, err := png.Decode(recorder.Body)
+	if err != nil {
+		t.Fatalf("thumbnail should be a PNG : %v", err)
+	}
+	if bounds := thumbnail.Bounds(); bounds.Dx() != 64 || bounds.Dy() != 64 {
+		t.Fatalf("thumbnail should be 64x64 but was %v", bounds)
+	}
+	if r, g, b, a := thumbnail.At(32, 32).RGBA(); r != 0xffff || g != 0 || b != 0 || a != 0xffff {
+		t.Fatalf("thumbnail should keep the colors of the image but was %v %v %v %v", r, g, b, a)
+	}
+
+	recorder = httptest.NewRecorder()
+	avatarHandler.ServeHTTP(recorder, httptest.NewRequest("GET", AvatarPath+"..%2f..%2fetc%2fpasswd", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("AvatarHandler should only serve thumbnails but returned %d", recorder.Code)
+	}
+
+	// An avatar URL set afterwards replaces the upload
+	_, err = handler.HandleCommand(commands.CreateCommand(commands.UpdateProfile{Token: loggedIn.AccessToken, AvatarURL: "https://example.com/avatar.png"}))
+	if err != nil {
+		t.Fatalf("UpdateProfile failed : %v\n", err)
+	}
+	account, _ = handler.AccountsService.GetAccountByAccountId(accountId)
+	if avatarURL := handler.AccountsService.AvatarURL(account, 64); avatarURL != "https://example.com/avatar.png" {
+		t.Fatalf("AvatarURL should be the avatar of the profile but was %s", avatarURL)
+	}
+}
@@ -0,0 +1,65 @@
+package accounts
+
+import (
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+// Ban stops an account from commenting on Website, or from logging in and
+// commenting anywhere when Website is empty. Suspensions are bans which
+// always expire. Lifted and expired bans are kept as the audit trail of
+// the moderation of the account.
+type Ban struct {
+	BanId      uuid.UUID `db:"ban_id" json:"banId"`
+	AccountId  uuid.UUID `db:"account_id" json:"accountId"`
+	Website    string    `db:"website" json:"website,omitempty"`
+	Suspension bool      `db:"suspension" json:"suspension"`
+	Reason     string    `db:"reason" json:"reason,omitempty"`
+	// HideComments hides the comments of the account while the ban is
+	// active.
+	HideComments bool `db:"hide_comments" json:"hideComments"`
+	// BannedBy is the moderator, or the administrator for global bans, who
+	// issued the ban.
+	BannedBy  *uuid.UUID `db:"banned_by" json:"bannedBy,omitempty"`
+	CreatedOn time.Time  `db:"created_on" json:"createdOn"`
+	ExpiresAt *time.Time `db:"expires_at" json:"expiresAt,omitempty"`
+	LiftedOn  *time.Time `db:"lifted_on" json:"liftedOn,omitempty"`
+	LiftedBy  *uuid.UUID `db:"lifted_by" json:"liftedBy,omitempty"`
+}
+
+// Global reports whether the ban applies to every website.
+func (b Ban) Global() bool {
+	return b.Website == ""
+}
+
+// Active reports whether the ban applies at now.
+func (b Ban) Active(now time.Time) bool {
+	return b.LiftedOn == nil && (b.ExpiresAt == nil || now.Before(*b.ExpiresAt))
+}
+
+// ListBans returns every ban of an account, oldest first.
+func (a *Accounts) ListBans(accountId uuid.UUID) ([]Ban, error) {
+	return a.store().ListBans(accountId)
+}
+
+func (a *Accounts) GetBan(banId uuid.UUID) (Ban, error) {
+	return a.store().GetBan(banId)
+}
+
+// CheckNotBanned returns AccountBannedErr if an active ban stops an account
+// from commenting on website. Only global bans are checked when website is
+// empty.
+func (a *Accounts) CheckNotBanned(accountId uuid.UUID, website string) error {
+	bans, err := a.ListBans(accountId)
+	if err != nil {
+		return err
+	}
+	now := a.now()
+	for _, ban := range bans {
+		if ban.Active(now) && (ban.Global() || ban.Website == website) {
+			return AccountBannedErr
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package accounts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/apperrors"
+	"github.com/jonfk/comment-server/commands"
+	"github.com/jonfk/comment-server/events"
+)
+
+func TestGlobalBan(t *testing.T) {
+	handler, accountId := newSessionsTestHandler(t)
+	loggedIn := login(t, handler)
+	banId := uuid.NewV4()
+
+	err := handler.EventHandler.HandleEvent(events.NewEventNow(events.AccountBanned{BanId: banId, AccountId: accountId, Reason: "spam"}))
+	if err != nil {
+		t.Fatalf("HandleEvent failed : %v\n", err)
+	}
+	_, err = handler.HandleCommand(commands.CreateCommand(commands.RefreshSession{RefreshToken: loggedIn.RefreshToken}))
+	if err == nil {
+		t.Fatal("a global ban should revoke the sessions of the account")
+	}
+	_, err = handler.HandleCommand(commands.CreateCommand(commands.LoginAccount{Email: "email@example.com", Password: "password"}))
+	if !apperrors.Is(err, apperrors.AccountBanned) {
+		t.Fatalf("LoginAccount should reject banned accounts but returned %v", err)
+	}
+
+	err = handler.EventHandler.HandleEvent(events.NewEventNow(events.AccountUnbanned{BanId: banId, AccountId: accountId}))
+	if err != nil {
+		t.Fatalf("HandleEvent failed : %v\n", err)
+	}
+	login(t, handler)
+
+	bans, err := handler.AccountsService.ListBans(accountId)
+	if err != nil {
+		t.Fatalf("ListBans failed : %v\n", err)
+	}
+	if len(bans) != 1 || bans[0].Reason != "spam" || bans[0].LiftedOn == nil {
+		t.Fatalf("ListBans should keep lifted bans but returned %v", bans)
+	}
+}
+
+func TestCheckNotBanned(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	expiresAt := now.Add(time.Hour)
+	service := &Accounts{Store: NewMemoryStore(), Now: func() time.Time { return now }}
+	accountId := uuid.NewV4()
+	err := service.store().InsertBan(Ban{
+		BanId:     uuid.NewV4(),
+		AccountId: accountId,
+		Website:   "https://example.com",
+		CreatedOn: now,
+		ExpiresAt: &expiresAt,
+	})
+	if err != nil {
+		t.Fatalf("InsertBan failed : %v\n", err)
+	}
+
+	if err := service.CheckNotBanned(accountId, "https://example.com"); err != AccountBannedErr {
+		t.Fatalf("CheckNotBanned should reject the banned website but returned %v", err)
+	}
+	if err := service.CheckNotBanned(accountId, "https://other.example.com"); err != nil {
+		t.Fatalf("CheckNotBanned should accept other websites but returned %v", err)
+	}
+	if err := service.CheckNotBanned(accountId, ""); err != nil {
+		t.Fatalf("website bans shouldn't stop logging in but CheckNotBanned returned %v", err)
+	}
+	now = expiresAt
+	if err := service.CheckNotBanned(accountId, "https://example.com"); err != nil {
+		t.Fatalf("CheckNotBanned should ignore expired bans but returned %v", err)
+	}
+}
@@ -1,8 +1,9 @@
 package accounts
 
 import (
-	"fmt"
+	"strings"
 
+	"github.com/jonfk/comment-server/apperrors"
 	"github.com/jonfk/comment-server/commands"
 	"github.com/jonfk/comment-server/events"
 
@@ -15,64 +16,639 @@ type CommandHandler struct {
 	AccountsService *Accounts
 }
 
-func (c *CommandHandler) HandleCommand(command commands.Command) (events.Event, error) {
+// HandleCommand decides the event resulting from command and handles it
+// with the EventHandler. The event of a rejected command, such as
+// AccountLocked, is handled too but only the error is returned.
+func (c *CommandHandler) HandleCommand(command commands.Command) (commands.Response, error) {
+	event, secrets, decideErr := c.decide(command)
+	if event.Payload != nil {
+		if err := c.EventHandler.HandleEvent(event); err != nil {
+			return commands.Response{}, err
+		}
+	}
+	if decideErr != nil {
+		return commands.Response{}, decideErr
+	}
+	// Sessions reference their account, so guests and accounts created by
+	// an identity provider can only be logged in once the event is handled.
+	var err error
+	switch eventPayload := event.Payload.(type) {
+	case events.ExternalIdentityLinked:
+		if eventPayload.NewAccount {
+			event, secrets, err = c.startSession(eventPayload.AccountId)
+		}
+	case events.AccountCreated:
+		if eventPayload.AccountType == AccountTypeGuest {
+			event, secrets, err = c.startSession(eventPayload.AccountId)
+		}
+	}
+	if err != nil {
+		return commands.Response{}, err
+	}
+	return commands.Response{Event: event, Secrets: secrets}, nil
+}
+
+// DecideCommand returns the event resulting from command. The secrets
+// issued by the command are only returned by HandleCommand.
+func (c *CommandHandler) DecideCommand(command commands.Command) (events.Event, error) {
+	event, _, err := c.decide(command)
+	return event, err
+}
+
+// decide returns the event resulting from command and the secrets it
+// issued to the client.
+func (c *CommandHandler) decide(command commands.Command) (events.Event, *commands.Secrets, error) {
 	switch commandPayload := command.Payload.(type) {
 	case commands.CreateAccount:
-		salt, err := GenerateSalt()
+		if err := c.AccountsService.CheckUsernameAvailable(commandPayload.Username); err != nil {
+			return events.Event{}, nil, err
+		}
+		if err := c.AccountsService.CheckEmailAvailable(commandPayload.Email); err != nil {
+			return events.Event{}, nil, err
+		}
+
+		passwordHash, err := c.AccountsService.HashPassword(commandPayload.Password)
 		if err != nil {
 			// Fix error to be friendly
-			return events.Event{}, err
+			return events.Event{}, nil, err
+		}
+
+		eventPayload := events.AccountCreated{
+			AccountId:    uuid.NewV4(),
+			AccountType:  AccountTypeRegistered,
+			Username:     commandPayload.Username,
+			Email:        commandPayload.Email,
+			PasswordHash: passwordHash,
 		}
-		hashedPassword, err := HashPassword(commandPayload.Password, salt)
+		return events.NewEventNow(eventPayload), nil, nil
+	case commands.ChangeUsername:
+		account, err := c.accountOfAccessToken(commandPayload.Token)
 		if err != nil {
-			// Fix error to be friendly
-			return events.Event{}, err
+			return events.Event{}, nil, err
+		}
+		if account.Guest() {
+			return events.Event{}, nil, apperrors.New(apperrors.CommandInvalid, "Guests have no username")
+		}
+		username := strings.TrimSpace(commandPayload.Username)
+		if username == "" {
+			return events.Event{}, nil, apperrors.New(apperrors.CommandInvalid, "A username is required")
+		}
+		if username == account.Username {
+			return events.Event{}, nil, nil
+		}
+		if err := c.AccountsService.CheckUsernameAvailable(username); err != nil {
+			return events.Event{}, nil, err
+		}
+
+		eventPayload := events.UsernameChanged{
+			AccountId:   account.AccountId,
+			OldUsername: account.Username,
+			Username:    username,
+		}
+		return events.NewEventNow(eventPayload), nil, nil
+	case commands.ChangeEmail:
+		account, err := c.accountOfAccessToken(commandPayload.Token)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+		// A stolen access token shouldn't be enough to take over an account
+		// by changing the email its login links and password resets go to.
+		if account.passwordHash() != "" {
+			if err := c.AccountsService.verifyPassword(account, nil, commandPayload.Password); err != nil {
+				return events.Event{}, nil, err
+			}
+		}
+		email := strings.TrimSpace(commandPayload.Email)
+		if email == "" && !account.Guest() {
+			return events.Event{}, nil, apperrors.New(apperrors.CommandInvalid, "An email is required")
+		}
+		if email == account.Email {
+			return events.Event{}, nil, nil
+		}
+		if !account.Guest() {
+			if err := c.AccountsService.CheckEmailAvailable(email); err != nil {
+				return events.Event{}, nil, err
+			}
+		}
+
+		eventPayload := events.EmailChanged{
+			AccountId: account.AccountId,
+			OldEmail:  account.Email,
+			Email:     email,
+		}
+		return events.NewEventNow(eventPayload), nil, nil
+	case commands.UpdateProfile:
+		account, err := c.accountOfAccessToken(commandPayload.Token)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+		displayName := strings.TrimSpace(commandPayload.DisplayName)
+		if displayName == "" && account.Guest() {
+			return events.Event{}, nil, apperrors.New(apperrors.CommandInvalid, "A display name is required")
+		}
+		if err := validateAvatarURL(commandPayload.AvatarURL); err != nil {
+			return events.Event{}, nil, err
+		}
+
+		eventPayload := events.ProfileUpdated{
+			AccountId:   account.AccountId,
+			DisplayName: displayName,
+			AvatarURL:   commandPayload.AvatarURL,
+		}
+		return events.NewEventNow(eventPayload), nil, nil
+	case commands.UploadAvatar:
+		account, err := c.accountOfAccessToken(commandPayload.Token)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+		avatarKey, err := c.AccountsService.SaveAvatar(commandPayload.Image)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+
+		eventPayload := events.AvatarUploaded{
+			AccountId: account.AccountId,
+			AvatarKey: avatarKey,
+		}
+		return events.NewEventNow(eventPayload), nil, nil
+	case commands.CreateGuestAccount:
+		displayName := strings.TrimSpace(commandPayload.DisplayName)
+		if displayName == "" {
+			return events.Event{}, nil, apperrors.New(apperrors.CommandInvalid, "A display name is required")
 		}
 
 		eventPayload := events.AccountCreated{
-			AccountId:      uuid.NewV4(),
-			Username:       commandPayload.Username,
-			Email:          commandPayload.Email,
-			HashedPassword: hashedPassword,
-			HashSalt:       salt,
-		}
-		event := events.NewEventNow(eventPayload)
-		c.EventHandler.HandleEvent(event)
+			AccountId:   uuid.NewV4(),
+			AccountType: AccountTypeGuest,
+			DisplayName: displayName,
+			Email:       commandPayload.Email,
+		}
+		return events.NewEventNow(eventPayload), nil, nil
+	case commands.ClaimGuestIdentity:
+		account, err := c.accountOfAccessToken(commandPayload.Token)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+		if account.Guest() {
+			return events.Event{}, nil, apperrors.New(apperrors.CommandInvalid, "Guests can't claim other guests")
+		}
+		// Only the owner of a verified email can take over the comments
+		// of a guest, whose session proves they wrote them.
+		if !account.EmailVerified() {
+			return events.Event{}, nil, EmailNotVerifiedErr
+		}
+		guest, err := c.accountOfAccessToken(commandPayload.GuestToken)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+		if !guest.Guest() {
+			return events.Event{}, nil, NotGuestErr
+		}
+
+		eventPayload := events.GuestIdentityClaimed{
+			AccountId:      account.AccountId,
+			GuestAccountId: guest.AccountId,
+		}
+		return events.NewEventNow(eventPayload), nil, nil
 	case commands.DeleteAccount:
+		mode := commandPayload.Mode
+		if mode == "" {
+			mode = DeletionAnonymize
+		}
+		if !ValidDeletionMode(mode) {
+			return events.Event{}, nil, apperrors.Newf(apperrors.CommandInvalid, "unknown deletion mode %s", mode)
+		}
 		account, err := c.AccountsService.GetAccountByAccountId(commandPayload.AccountId)
 		if err != nil {
-			return events.Event{}, err
+			return events.Event{}, nil, err
+		}
+		if account.Deleted() {
+			return events.Event{}, nil, AccountNotFoundErr
 		}
 
-		event := events.NewEventNow(events.AccountDeleted{AccountId: account.AccountId})
-		c.EventHandler.HandleEvent(event)
+		return events.NewEventNow(events.AccountDeleted{AccountId: account.AccountId, Mode: mode}), nil, nil
 	case commands.LoginAccount:
+		if err := c.AccountsService.CheckLoginAttempt(commandPayload.Email, commandPayload.Client); err != nil {
+			return events.Event{}, nil, err
+		}
+		account, err := c.AccountsService.Authenticate(commandPayload.Email, commandPayload.Password)
+		if err == InvalidCredentialsErr {
+			locked, recordErr := c.AccountsService.RecordLoginFailure(commandPayload.Email, commandPayload.Client)
+			if recordErr != nil {
+				return events.Event{}, nil, recordErr
+			}
+			if locked != nil {
+				// The lockout is recorded while the attempt is still rejected
+				return events.NewEventNow(locked), nil, err
+			}
+			return events.Event{}, nil, err
+		}
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+		if err := c.AccountsService.ClearLoginFailures(commandPayload.Email, commandPayload.Client); err != nil {
+			return events.Event{}, nil, err
+		}
+		if err := c.AccountsService.RehashPasswordIfNeeded(account, commandPayload.Password); err != nil {
+			return events.Event{}, nil, err
+		}
+
+		return c.completeLogin(account)
+	case commands.RequestLoginLink:
 		account, err := c.AccountsService.GetAccountByEmail(commandPayload.Email)
+		if err == AccountNotFoundErr {
+			// Don't reveal whether an account exists for the email
+			return events.Event{}, nil, nil
+		}
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+
+		return events.NewEventNow(events.LoginLinkRequested{AccountId: account.AccountId}), nil, nil
+	case commands.ConfirmLoginLink:
+		accountId, err := c.AccountsService.ConsumeToken(TokenPurposeLogin, commandPayload.Token)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+		account, err := c.AccountsService.GetAccountByAccountId(accountId)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+
+		return c.completeLogin(account)
+	case commands.RequestPasswordReset:
+		account, err := c.AccountsService.GetAccountByEmail(commandPayload.Email)
+		if err == AccountNotFoundErr {
+			// Don't reveal whether an account exists for the email
+			return events.Event{}, nil, nil
+		}
 		if err != nil {
-			return events.Event{}, err
+			return events.Event{}, nil, err
 		}
 
-		token, err := c.AccountsService.VerifyAndGenerateJWT(account.AccountId, commandPayload.Password)
+		return events.NewEventNow(events.PasswordResetRequested{AccountId: account.AccountId}), nil, nil
+	case commands.ResetPassword:
+		accountId, err := c.AccountsService.ConsumeToken(TokenPurposePasswordReset, commandPayload.Token)
 		if err != nil {
-			return events.Event{}, err
+			return events.Event{}, nil, err
 		}
 
-		eventPayload := events.AccountLoggedIn{
+		passwordHash, err := c.AccountsService.HashPassword(commandPayload.NewPassword)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+
+		eventPayload := events.PasswordChanged{
+			AccountId:    accountId,
+			PasswordHash: passwordHash,
+		}
+		return events.NewEventNow(eventPayload), nil, nil
+	case commands.RequestEmailVerification:
+		account, err := c.AccountsService.GetAccountByEmail(commandPayload.Email)
+		if err == AccountNotFoundErr {
+			// Don't reveal whether an account exists for the email
+			return events.Event{}, nil, nil
+		}
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+		if account.EmailVerified() {
+			return events.Event{}, nil, nil
+		}
+
+		return events.NewEventNow(events.EmailVerificationRequested{AccountId: account.AccountId}), nil, nil
+	case commands.VerifyEmail:
+		accountId, err := c.AccountsService.ConsumeToken(TokenPurposeEmailVerification, commandPayload.Token)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+		account, err := c.AccountsService.GetAccountByAccountId(accountId)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+
+		eventPayload := events.EmailVerified{
 			AccountId: account.AccountId,
-			JWT:       token,
+			Email:     account.Email,
+		}
+		return events.NewEventNow(eventPayload), nil, nil
+	case commands.RefreshSession:
+		tokens, err := c.AccountsService.RefreshSession(commandPayload.RefreshToken)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+
+		eventPayload := events.SessionRefreshed{
+			AccountId: tokens.AccountId,
+			SessionId: tokens.SessionId,
 		}
-		event := events.NewEventNow(eventPayload)
-		c.EventHandler.HandleEvent(event)
+		return events.NewEventNow(eventPayload), &commands.Secrets{AccessToken: tokens.AccessToken, RefreshToken: tokens.RefreshToken}, nil
+	case commands.Logout:
+		claims, err := c.AccountsService.validateAccessToken(commandPayload.Token)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+
+		return events.NewEventNow(events.LoggedOut{AccountId: claims.AccountId, SessionId: claims.SessionId}), nil, nil
+	case commands.LogoutEverywhere:
+		claims, err := c.AccountsService.validateAccessToken(commandPayload.Token)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+
+		return events.NewEventNow(events.LoggedOutEverywhere{AccountId: claims.AccountId}), nil, nil
+	case commands.GenerateSigningKey:
+		if _, err := c.adminOfAccessToken(commandPayload.Token); err != nil {
+			return events.Event{}, nil, err
+		}
+		algorithm := commandPayload.Algorithm
+		if algorithm == "" {
+			algorithm = AlgorithmHS256
+		}
+		if !ValidAlgorithm(algorithm) {
+			return events.Event{}, nil, apperrors.Newf(apperrors.CommandInvalid, "unknown signing algorithm %s", algorithm)
+		}
+		key, err := c.AccountsService.GenerateSigningKey(algorithm)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+
+		return events.NewEventNow(events.SigningKeyGenerated{KeyId: key.KeyId, Algorithm: key.Algorithm}), nil, nil
+	case commands.ActivateSigningKey:
+		if _, err := c.adminOfAccessToken(commandPayload.Token); err != nil {
+			return events.Event{}, nil, err
+		}
+		key, err := c.AccountsService.GetSigningKey(commandPayload.KeyId)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+		if key.Retired() {
+			return events.Event{}, nil, SigningKeyRetiredErr
+		}
+
+		return events.NewEventNow(events.SigningKeyActivated{KeyId: key.KeyId}), nil, nil
+	case commands.RetireSigningKey:
+		if _, err := c.adminOfAccessToken(commandPayload.Token); err != nil {
+			return events.Event{}, nil, err
+		}
+		key, err := c.AccountsService.GetSigningKey(commandPayload.KeyId)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+		if active, err := c.AccountsService.ActiveSigningKey(); err == nil && active.KeyId == key.KeyId {
+			return events.Event{}, nil, SigningKeyActiveErr
+		} else if err != nil && err != SigningKeyNotFoundErr {
+			return events.Event{}, nil, err
+		}
+
+		return events.NewEventNow(events.SigningKeyRetired{KeyId: key.KeyId}), nil, nil
+	case commands.UnlockAccount:
+		if _, err := c.adminOfAccessToken(commandPayload.Token); err != nil {
+			return events.Event{}, nil, err
+		}
+		account, err := c.AccountsService.GetAccountByAccountId(commandPayload.AccountId)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+
+		return events.NewEventNow(events.AccountUnlocked{AccountId: account.AccountId}), nil, nil
+	case commands.LoginTwoFactor:
+		accountId, err := c.AccountsService.ConsumeToken(TokenPurposeTwoFactor, commandPayload.Token)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+		account, err := c.AccountsService.GetAccountByAccountId(accountId)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+		if err := c.AccountsService.VerifySecondFactor(account, commandPayload.Code); err != nil {
+			return events.Event{}, nil, err
+		}
+		// The account may have been banned or locked since its first factor
+		if err := c.checkLoginAllowed(account); err != nil {
+			return events.Event{}, nil, err
+		}
+
+		return c.startSession(account.AccountId)
+	case commands.EnrollTOTP:
+		account, err := c.accountOfAccessToken(commandPayload.Token)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+		enrollment, err := c.AccountsService.StartTOTPEnrollment(account)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+
+		secrets := &commands.Secrets{TOTPSecret: enrollment.Secret, TOTPURI: enrollment.URI}
+		return events.NewEventNow(events.TOTPEnrollmentStarted{AccountId: account.AccountId}), secrets, nil
+	case commands.ConfirmTOTP:
+		account, err := c.accountOfAccessToken(commandPayload.Token)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+		if account.TwoFactorEnabled() {
+			return events.Event{}, nil, TwoFactorEnabledErr
+		}
+		if err := c.AccountsService.VerifyTOTP(account, commandPayload.Code); err != nil {
+			return events.Event{}, nil, err
+		}
+		recoveryCodes, err := c.AccountsService.GenerateRecoveryCodes(account.AccountId)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+
+		return events.NewEventNow(events.TwoFactorEnabled{AccountId: account.AccountId}), &commands.Secrets{RecoveryCodes: recoveryCodes}, nil
+	case commands.DisableTOTP:
+		account, err := c.accountOfAccessToken(commandPayload.Token)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+		if err := c.AccountsService.VerifySecondFactor(account, commandPayload.Code); err != nil {
+			return events.Event{}, nil, err
+		}
+
+		return events.NewEventNow(events.TwoFactorDisabled{AccountId: account.AccountId}), nil, nil
+	case commands.StartOIDCLogin:
+		authorizationURL, err := c.AccountsService.StartOIDCLogin(commandPayload.Provider)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+
+		return events.NewEventNow(events.OIDCLoginStarted{Provider: commandPayload.Provider}), &commands.Secrets{AuthorizationURL: authorizationURL}, nil
+	case commands.CompleteOIDCLogin:
+		provider, claims, err := c.AccountsService.CompleteOIDCLogin(commandPayload.State, commandPayload.Code)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+		account, err := c.AccountsService.GetAccountByExternalIdentity(provider.Name, claims.Subject)
+		if err == nil {
+			return c.completeLogin(account)
+		}
+		if err != AccountNotFoundErr {
+			return events.Event{}, nil, err
+		}
+
+		eventPayload := events.ExternalIdentityLinked{Provider: provider.Name, Subject: claims.Subject}
+		if commandPayload.Token != "" {
+			account, err := c.accountOfAccessToken(commandPayload.Token)
+			if err != nil {
+				return events.Event{}, nil, err
+			}
+			eventPayload.AccountId = account.AccountId
+			return events.NewEventNow(eventPayload), nil, nil
+		}
+
+		if claims.Email == "" {
+			return events.Event{}, nil, apperrors.New(apperrors.CommandInvalid, "The identity provider didn't share an email address")
+		}
+		// Identities are only linked to existing accounts by their owner
+		// logging in, the provider may not have verified the email
+		if _, err := c.AccountsService.GetAccountByEmail(claims.Email); err != AccountNotFoundErr {
+			if err == nil {
+				return events.Event{}, nil, EmailTakenErr
+			}
+			return events.Event{}, nil, err
+		}
+		username, err := c.AccountsService.AvailableUsername(usernameOf(claims))
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+		eventPayload.AccountId = uuid.NewV4()
+		eventPayload.NewAccount = true
+		eventPayload.Username = username
+		eventPayload.Email = claims.Email
+		eventPayload.EmailVerified = claims.EmailVerified
+		return events.NewEventNow(eventPayload), nil, nil
 	case commands.CreateCommentThread:
 		// Command not handled by Accounts
 	case commands.CreateComment:
 		// Command not handled by Accounts
 	case commands.DeleteComment:
 		// Command not handled by Accounts
+	case commands.ConfigureWebsite:
+		// Command not handled by Accounts
+	case commands.GrantRole:
+		// Command not handled by Accounts
+	case commands.RevokeRole:
+		// Command not handled by Accounts
+	case commands.BanAccount:
+		// Command not handled by Accounts
+	case commands.SuspendAccount:
+		// Command not handled by Accounts
+	case commands.UnbanAccount:
+		// Command not handled by Accounts
+	case commands.BatchCommand:
+		// Command not handled by Accounts
+	case commands.CreateAPIToken:
+		account, err := c.accountOfAccessToken(commandPayload.Token)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+		if account.Guest() {
+			return events.Event{}, nil, apperrors.New(apperrors.AuthForbidden, "Guests can't create API tokens")
+		}
+		name, scopes, err := validateNewAPIToken(commandPayload.Name, commandPayload.Scopes, commandPayload.ExpiresAt, c.AccountsService.now())
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+		token, prefix, tokenHash, err := generateAPIToken()
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+
+		eventPayload := events.APITokenCreated{
+			APITokenId: uuid.NewV4(),
+			AccountId:  account.AccountId,
+			Name:       name,
+			Prefix:     prefix,
+			TokenHash:  tokenHash,
+			Scopes:     scopes,
+			ExpiresAt:  commandPayload.ExpiresAt,
+		}
+		return events.NewEventNow(eventPayload), &commands.Secrets{APIToken: token}, nil
+	case commands.RevokeAPIToken:
+		account, err := c.accountOfAccessToken(commandPayload.Token)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+		apiToken, err := c.AccountsService.GetAPIToken(commandPayload.APITokenId)
+		if err != nil {
+			return events.Event{}, nil, err
+		}
+		// Tokens of other accounts are reported as missing
+		if !uuid.Equal(apiToken.AccountId, account.AccountId) {
+			return events.Event{}, nil, APITokenNotFoundErr
+		}
+		if apiToken.RevokedOn != nil {
+			return events.Event{}, nil, apperrors.New(apperrors.CommandInvalid, "The API token was already revoked")
+		}
+
+		return events.NewEventNow(events.APITokenRevoked{APITokenId: apiToken.APITokenId, AccountId: account.AccountId}), nil, nil
 	default:
-		return events.Event{}, fmt.Errorf("unrecognized command type : %s", commandPayload.CommandType())
+		return events.Event{}, nil, apperrors.Newf(apperrors.CommandUnknownType, "unrecognized command type : %s", commandPayload.CommandType())
+	}
+	return events.Event{}, nil, nil
+}
+
+// completeLogin starts a session for an account that passed its first
+// factor, unless the account requires a second factor to log in.
+func (c *CommandHandler) completeLogin(account Account) (events.Event, *commands.Secrets, error) {
+	if err := c.checkLoginAllowed(account); err != nil {
+		return events.Event{}, nil, err
+	}
+	if !account.TwoFactorEnabled() {
+		return c.startSession(account.AccountId)
+	}
+	token, err := c.AccountsService.IssueToken(account.AccountId, TokenPurposeTwoFactor, TwoFactorLoginLifetime)
+	if err != nil {
+		return events.Event{}, nil, err
+	}
+
+	return events.NewEventNow(events.TwoFactorRequired{AccountId: account.AccountId}), &commands.Secrets{TwoFactorToken: token}, nil
+}
+
+// checkLoginAllowed rejects logins to banned accounts and to accounts locked
+// by failed login attempts, whatever they log in with.
+func (c *CommandHandler) checkLoginAllowed(account Account) error {
+	if account.Locked(c.AccountsService.now()) {
+		return LoginThrottledErr
+	}
+	return c.AccountsService.CheckNotBanned(account.AccountId, "")
+}
+
+func (c *CommandHandler) accountOfAccessToken(token string) (Account, error) {
+	claims, err := c.AccountsService.validateAccessToken(token)
+	if err != nil {
+		return Account{}, err
+	}
+	return c.AccountsService.GetAccountByAccountId(claims.AccountId)
+}
+
+// adminOfAccessToken returns the account of an access token if it is one
+// of the Admins.
+func (c *CommandHandler) adminOfAccessToken(token string) (Account, error) {
+	account, err := c.accountOfAccessToken(token)
+	if err != nil {
+		return Account{}, err
+	}
+	if err := c.AccountsService.CheckAdmin(account.AccountId); err != nil {
+		return Account{}, err
+	}
+	return account, nil
+}
+
+func (c *CommandHandler) startSession(accountId uuid.UUID) (events.Event, *commands.Secrets, error) {
+	tokens, err := c.AccountsService.StartSession(accountId)
+	if err != nil {
+		return events.Event{}, nil, err
+	}
+
+	eventPayload := events.AccountLoggedIn{
+		AccountId: accountId,
+		SessionId: tokens.SessionId,
 	}
-	return events.Event{}, nil
+	return events.NewEventNow(eventPayload), &commands.Secrets{AccessToken: tokens.AccessToken, RefreshToken: tokens.RefreshToken}, nil
 }
 
 func NewCommandHandler(db *sqlx.DB) CommandHandler {
@@ -0,0 +1,159 @@
+package accounts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/apperrors"
+	"github.com/jonfk/comment-server/commands"
+	"github.com/jonfk/comment-server/commandtest"
+	"github.com/jonfk/comment-server/events"
+)
+
+func newTestSystem() commandtest.System {
+	accountsService := &Accounts{
+		Store:                NewMemoryStore(),
+		HMACSecretKey:        []byte("secret_key"),
+		SessionLengthInHours: 1,
+		PasswordPolicy:       &testPasswordPolicy,
+	}
+	eventHandler := &EventHandler{AccountsService: accountsService}
+	return commandtest.System{
+		Decider: &CommandHandler{
+			AccountsService: accountsService,
+			EventHandler:    eventHandler,
+		},
+		EventHandler: eventHandler,
+	}
+}
+
+func accountCreated(t *testing.T, accountId uuid.UUID, username, email, password string) events.AccountCreated {
+	salt, err := GenerateSalt()
+	if err != nil {
+		t.Fatalf("GenerateSalt failed : %v\n", err)
+	}
+	hashedPassword, err := HashPassword(password, salt)
+	if err != nil {
+		t.Fatalf("HashPassword failed : %v\n", err)
+	}
+	return events.AccountCreated{
+		AccountId:      accountId,
+		Username:       username,
+		Email:          email,
+		HashedPassword: hashedPassword,
+		HashSalt:       salt,
+	}
+}
+
+func TestCommandHandler(t *testing.T) {
+	accountId := uuid.NewV4()
+	existingAccount := accountCreated(t, accountId, "username", "email@example.com", "password")
+
+	commandtest.Run(t, newTestSystem,
+		commandtest.Scenario{
+			Name: "CreateAccount creates an account",
+			When: commands.CreateAccount{Username: "username", Email: "email@example.com", Password: "password"},
+			Then: []events.EventPayload{
+				events.AccountCreated{Username: "username", Email: "email@example.com"},
+			},
+		},
+		commandtest.Scenario{
+			Name:      "CreateAccount rejects a taken username",
+			Given:     []events.EventPayload{existingAccount},
+			When:      commands.CreateAccount{Username: "username", Email: "other@example.com", Password: "password"},
+			ThenError: apperrors.AccountUsernameTaken,
+		},
+		commandtest.Scenario{
+			Name:      "CreateAccount rejects a taken email",
+			Given:     []events.EventPayload{existingAccount},
+			When:      commands.CreateAccount{Username: "other", Email: "email@example.com", Password: "password"},
+			ThenError: apperrors.AccountEmailTaken,
+		},
+		commandtest.Scenario{
+			Name: "CreateGuestAccount creates a guest",
+			When: commands.CreateGuestAccount{DisplayName: " guest ", Email: "email@example.com"},
+			Then: []events.EventPayload{
+				events.AccountCreated{AccountType: AccountTypeGuest, DisplayName: "guest", Email: "email@example.com"},
+			},
+		},
+		commandtest.Scenario{
+			Name:      "CreateGuestAccount requires a display name",
+			When:      commands.CreateGuestAccount{DisplayName: " "},
+			ThenError: apperrors.CommandInvalid,
+		},
+		commandtest.Scenario{
+			Name:  "DeleteAccount deletes an existing account",
+			Given: []events.EventPayload{existingAccount},
+			When:  commands.DeleteAccount{AccountId: accountId},
+			Then:  []events.EventPayload{events.AccountDeleted{AccountId: accountId}},
+		},
+		commandtest.Scenario{
+			Name:      "DeleteAccount fails on a deleted account",
+			Given:     []events.EventPayload{existingAccount, events.AccountDeleted{AccountId: accountId}},
+			When:      commands.DeleteAccount{AccountId: accountId},
+			ThenError: apperrors.AccountNotFound,
+		},
+		commandtest.Scenario{
+			Name:  "LoginAccount logs in with the right password",
+			Given: []events.EventPayload{existingAccount},
+			When:  commands.LoginAccount{Email: "email@example.com", Password: "password"},
+			Then:  []events.EventPayload{events.AccountLoggedIn{AccountId: accountId}},
+		},
+		commandtest.Scenario{
+			Name:      "LoginAccount rejects a wrong password",
+			Given:     []events.EventPayload{existingAccount},
+			When:      commands.LoginAccount{Email: "email@example.com", Password: "wrong password"},
+			ThenError: apperrors.AuthInvalidCredentials,
+		},
+		commandtest.Scenario{
+			Name:      "LoginAccount rejects an unknown email like a wrong password",
+			Given:     []events.EventPayload{existingAccount},
+			When:      commands.LoginAccount{Email: "unknown@example.com", Password: "password"},
+			ThenError: apperrors.AuthInvalidCredentials,
+		},
+		commandtest.Scenario{
+			Name: "LoginAccount rejects the right password while the account is locked",
+			Given: []events.EventPayload{existingAccount,
+				events.AccountLocked{AccountId: accountId, LockedUntil: time.Now().Add(time.Hour)}},
+			When:      commands.LoginAccount{Email: "email@example.com", Password: "password"},
+			ThenError: apperrors.AuthTooManyAttempts,
+		},
+		commandtest.Scenario{
+			Name: "UnlockAccount requires the access token of an administrator",
+			Given: []events.EventPayload{existingAccount,
+				events.AccountLocked{AccountId: accountId, LockedUntil: time.Now().Add(time.Hour)}},
+			When:      commands.UnlockAccount{AccountId: accountId},
+			ThenError: apperrors.AuthInvalidToken,
+		},
+		commandtest.Scenario{
+			Name:  "RequestPasswordReset is requested for an existing account",
+			Given: []events.EventPayload{existingAccount},
+			When:  commands.RequestPasswordReset{Email: "email@example.com"},
+			Then:  []events.EventPayload{events.PasswordResetRequested{AccountId: accountId}},
+		},
+		commandtest.Scenario{
+			Name:      "ResetPassword rejects an unknown token",
+			Given:     []events.EventPayload{existingAccount},
+			When:      commands.ResetPassword{Token: "unknown", NewPassword: "new password"},
+			ThenError: apperrors.AuthInvalidToken,
+		},
+	)
+}
+
+func TestLoginAccountErrorsDontRevealAccounts(t *testing.T) {
+	handler, _ := newSessionsTestHandler(t)
+
+	_, wrongPasswordErr := handler.HandleCommand(commands.CreateCommand(commands.LoginAccount{Email: "email@example.com", Password: "wrong password"}))
+	_, unknownEmailErr := handler.HandleCommand(commands.CreateCommand(commands.LoginAccount{Email: "unknown@example.com", Password: "wrong password"}))
+	if wrongPasswordErr == nil || unknownEmailErr == nil {
+		t.Fatalf("LoginAccount should fail but returned %v and %v", wrongPasswordErr, unknownEmailErr)
+	}
+
+	if apperrors.HTTPStatusOf(wrongPasswordErr) != apperrors.HTTPStatusOf(unknownEmailErr) ||
+		apperrors.ResponseOf(wrongPasswordErr) != apperrors.ResponseOf(unknownEmailErr) {
+		t.Fatalf("LoginAccount should respond the same to an unknown email and a wrong password but responded %v and %v",
+			apperrors.ResponseOf(unknownEmailErr), apperrors.ResponseOf(wrongPasswordErr))
+	}
+}
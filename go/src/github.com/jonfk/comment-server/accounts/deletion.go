@@ -0,0 +1,38 @@
+package accounts
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/jonfk/comment-server/apperrors"
+)
+
+// Deletion modes of accounts. Deleted accounts always lose their personal
+// data, the mode decides what happens to their comments.
+const (
+	// DeletionAnonymize keeps the comments, shown as written by
+	// DeletedAccountName.
+	DeletionAnonymize = "anonymize"
+	// DeletionPurge removes the comments, leaving tombstones in place of
+	// the comments with replies so that threads keep their structure.
+	DeletionPurge = "purge"
+)
+
+func ValidDeletionMode(mode string) bool {
+	return mode == DeletionAnonymize || mode == DeletionPurge
+}
+
+// RemoveAvatar removes the thumbnails of an uploaded avatar from the
+// AvatarDir.
+func (a *Accounts) RemoveAvatar(avatarKey string) error {
+	if avatarKey == "" || a.AvatarDir == "" {
+		return nil
+	}
+	for _, size := range AvatarSizes {
+		err := os.Remove(filepath.Join(a.AvatarDir, avatarFile(avatarKey, size)))
+		if err != nil && !os.IsNotExist(err) {
+			return apperrors.Wrap(err, apperrors.Internal, "removing avatar failed")
+		}
+	}
+	return nil
+}
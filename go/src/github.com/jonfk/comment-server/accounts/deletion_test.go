@@ -0,0 +1,99 @@
+package accounts
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/apperrors"
+	"github.com/jonfk/comment-server/commands"
+	"github.com/jonfk/comment-server/events"
+)
+
+func TestDeleteAccount(t *testing.T) {
+	handler, accountId := newSessionsTestHandler(t)
+	loggedIn := login(t, handler)
+	_, err := handler.HandleCommand(commands.CreateCommand(commands.LoginAccount{Email: "email@example.com", Password: "wrong password"}))
+	if !apperrors.Is(err, apperrors.AuthInvalidCredentials) {
+		t.Fatalf("LoginAccount should reject a wrong password but returned %v", err)
+	}
+
+	_, err = handler.HandleCommand(commands.CreateCommand(commands.DeleteAccount{AccountId: accountId, Mode: "erase"}))
+	if !apperrors.Is(err, apperrors.CommandInvalid) {
+		t.Fatalf("DeleteAccount should reject unknown modes but returned %v", err)
+	}
+	response, err := handler.HandleCommand(commands.CreateCommand(commands.DeleteAccount{AccountId: accountId}))
+	if err != nil {
+		t.Fatalf("DeleteAccount failed : %v\n", err)
+	}
+	if response.Event.Payload.(events.AccountDeleted).Mode != DeletionAnonymize {
+		t.Fatalf("DeleteAccount should anonymize by default but returned %v", response)
+	}
+
+	account, err := handler.AccountsService.GetAccountByAccountId(accountId)
+	if err != nil {
+		t.Fatalf("deleted accounts should be kept but GetAccountByAccountId returned %v", err)
+	}
+	if !account.Deleted() || account.Email != "" || account.Username != "" || account.PasswordHash != "" ||
+		account.Name() != DeletedAccountName {
+		t.Fatalf("deleted accounts should lose their personal data but got %v", account)
+	}
+	_, err = handler.HandleCommand(commands.CreateCommand(commands.RefreshSession{RefreshToken: loggedIn.RefreshToken}))
+	if err == nil {
+		t.Fatal("deleting an account should end its sessions")
+	}
+	failures, err := handler.AccountsService.store().GetLoginFailures(emailLoginKey("email@example.com"))
+	if err != nil || failures.Failures != 0 {
+		t.Fatalf("deleting an account should forget the failed logins of its email but got %v (%v)", failures, err)
+	}
+	if err := handler.AccountsService.CheckEmailAvailable("email@example.com"); err != nil {
+		t.Fatalf("the email of a deleted account should be available but got %v", err)
+	}
+	_, err = handler.HandleCommand(commands.CreateCommand(commands.DeleteAccount{AccountId: accountId}))
+	if !apperrors.Is(err, apperrors.AccountNotFound) {
+		t.Fatalf("DeleteAccount should fail on deleted accounts but returned %v", err)
+	}
+
+	err = handler.EventHandler.HandleEvent(events.NewEventNow(accountCreated(t, uuid.NewV4(), "username", "email@example.com", "password")))
+	if err != nil {
+		t.Fatalf("a new account should be able to use the username of a deleted account : %v", err)
+	}
+}
+
+func TestDeleteAccountRemovesAvatar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "avatars")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed : %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+	handler, accountId := newSessionsTestHandler(t)
+	handler.AccountsService.AvatarDir = dir
+	loggedIn := login(t, handler)
+	_, err = handler.HandleCommand(commands.CreateCommand(commands.UploadAvatar{Token: loggedIn.AccessToken, Image: testImage(t, 100, 100)}))
+	if err != nil {
+		t.Fatalf("UploadAvatar failed : %v\n", err)
+	}
+	account, err := handler.AccountsService.GetAccountByAccountId(accountId)
+	if err != nil {
+		t.Fatalf("GetAccountByAccountId failed : %v\n", err)
+	}
+	thumbnail := filepath.Join(dir, avatarFile(account.AvatarKey, AvatarSizes[0]))
+
+	// Deciding alone must not remove anything, the event may never be stored
+	if _, err := handler.DecideCommand(commands.CreateCommand(commands.DeleteAccount{AccountId: accountId})); err != nil {
+		t.Fatalf("DecideCommand failed : %v\n", err)
+	}
+	if _, err := os.Stat(thumbnail); err != nil {
+		t.Fatalf("deciding DeleteAccount should keep the avatar : %v", err)
+	}
+
+	if _, err := handler.HandleCommand(commands.CreateCommand(commands.DeleteAccount{AccountId: accountId})); err != nil {
+		t.Fatalf("DeleteAccount failed : %v\n", err)
+	}
+	if _, err := os.Stat(thumbnail); !os.IsNotExist(err) {
+		t.Fatalf("deleting an account should remove its avatar but got %v", err)
+	}
+}
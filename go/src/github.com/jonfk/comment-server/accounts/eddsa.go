@@ -0,0 +1,46 @@
+package accounts
+
+import (
+	"github.com/dgrijalva/jwt-go"
+	"golang.org/x/crypto/ed25519"
+)
+
+// signingMethodEdDSA signs tokens with Ed25519 (RFC 8037), which jwt-go
+// doesn't implement. Sign expects an ed25519.PrivateKey and Verify an
+// ed25519.PublicKey.
+type signingMethodEdDSA struct{}
+
+var SigningMethodEdDSA = &signingMethodEdDSA{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+func (m *signingMethodEdDSA) Alg() string {
+	return AlgorithmEdDSA
+}
+
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok || len(privateKey) != ed25519.PrivateKeySize {
+		return "", jwt.ErrInvalidKey
+	}
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+func (m *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok || len(publicKey) != ed25519.PublicKeySize {
+		return jwt.ErrInvalidKey
+	}
+	decodedSignature, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, []byte(signingString), decodedSignature) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
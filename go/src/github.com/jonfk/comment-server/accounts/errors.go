@@ -1,9 +1,58 @@
 package accounts
 
 import (
-	"errors"
+	"database/sql"
+
+	"github.com/lib/pq"
+
+	"github.com/jonfk/comment-server/apperrors"
 )
 
 var (
-	AccountNotFoundErr = errors.New("Account Not Found")
+	AccountNotFoundErr     = apperrors.New(apperrors.AccountNotFound, "Account Not Found")
+	UsernameTakenErr       = apperrors.New(apperrors.AccountUsernameTaken, "Username is already taken")
+	EmailTakenErr          = apperrors.New(apperrors.AccountEmailTaken, "Email is already in use")
+	InvalidCredentialsErr  = apperrors.New(apperrors.AuthInvalidCredentials, "Invalid credentials")
+	InvalidTokenErr        = apperrors.New(apperrors.AuthInvalidToken, "Invalid token")
+	LoginThrottledErr      = apperrors.New(apperrors.AuthTooManyAttempts, "Too many failed login attempts, try again later")
+	TwoFactorRequiredErr   = apperrors.New(apperrors.AuthTwoFactorRequired, "A second factor is required to log in")
+	TwoFactorEnabledErr    = apperrors.New(apperrors.TwoFactorInvalidState, "Two-factor authentication is already enabled")
+	TwoFactorNotEnabledErr = apperrors.New(apperrors.TwoFactorInvalidState, "Two-factor authentication is not set up")
+	EmailNotVerifiedErr    = apperrors.New(apperrors.AccountEmailNotVerified, "Email address is not verified")
+	NotGuestErr            = apperrors.New(apperrors.CommandInvalid, "Only guests can be claimed")
+	AccountBannedErr       = apperrors.New(apperrors.AccountBanned, "The account is banned")
+	BanNotFoundErr         = apperrors.New(apperrors.BanNotFound, "Ban Not Found")
+	APITokenNotFoundErr    = apperrors.New(apperrors.APITokenNotFound, "API Token Not Found")
+	InsufficientScopeErr   = apperrors.New(apperrors.AuthForbidden, "The API token doesn't have the scope required")
+	AdminRequiredErr       = apperrors.New(apperrors.AuthForbidden, "Only administrators can do this")
+	SigningKeyNotFoundErr  = apperrors.New(apperrors.SigningKeyNotFound, "Signing Key Not Found")
+	SigningKeyRetiredErr   = apperrors.New(apperrors.SigningKeyInvalidState, "Signing key is retired")
+	SigningKeyActiveErr    = apperrors.New(apperrors.SigningKeyInvalidState, "The active signing key can't be retired, activate another key first")
 )
+
+const (
+	uniqueViolationCode      = pq.ErrorCode("23505")
+	usernameUniqueConstraint = "accounts_username_key"
+	emailUniqueConstraint    = "accounts_email_key"
+)
+
+// translateDBError maps errors returned by the database to the errors
+// of this package. Errors it doesn't know about are wrapped as internal
+// errors so that their details are not sent to clients.
+func translateDBError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if err == sql.ErrNoRows {
+		return AccountNotFoundErr
+	}
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == uniqueViolationCode {
+		switch pqErr.Constraint {
+		case usernameUniqueConstraint:
+			return UsernameTakenErr
+		case emailUniqueConstraint:
+			return EmailTakenErr
+		}
+	}
+	return apperrors.Wrap(err, apperrors.Internal, "database error")
+}
@@ -0,0 +1,32 @@
+package accounts
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+
+	"github.com/jonfk/comment-server/apperrors"
+)
+
+func TestTranslateDBError(t *testing.T) {
+	inputs := []struct {
+		err  error
+		code apperrors.Code
+	}{
+		{nil, ""},
+		{sql.ErrNoRows, apperrors.AccountNotFound},
+		{&pq.Error{Code: uniqueViolationCode, Constraint: usernameUniqueConstraint}, apperrors.AccountUsernameTaken},
+		{&pq.Error{Code: uniqueViolationCode, Constraint: emailUniqueConstraint}, apperrors.AccountEmailTaken},
+		{&pq.Error{Code: uniqueViolationCode, Constraint: "accounts_pkey"}, apperrors.Internal},
+		{fmt.Errorf("dial tcp: connection refused"), apperrors.Internal},
+	}
+
+	for _, input := range inputs {
+		err := translateDBError(input.err)
+		if code := apperrors.CodeOf(err); code != input.code {
+			t.Fatalf("translateDBError(%v) has code %s, expected %s", input.err, code, input.code)
+		}
+	}
+}
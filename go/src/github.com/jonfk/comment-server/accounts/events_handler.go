@@ -1,10 +1,10 @@
 package accounts
 
 import (
-	"fmt"
 	"github.com/jonfk/comment-server/events"
 )
 
+// EventHandler projects account events into the AccountsService's Store.
 type EventHandler struct {
 	AccountsService *Accounts
 }
@@ -13,12 +13,123 @@ func (e *EventHandler) HandleEvent(event events.Event) error {
 
 	switch eventPayload := event.Payload.(type) {
 	case events.AccountCreated:
+		_, err := e.AccountsService.store().InsertAccount(Account{
+			AccountId:      eventPayload.AccountId,
+			AccountType:    eventPayload.AccountType,
+			Username:       eventPayload.Username,
+			DisplayName:    eventPayload.DisplayName,
+			Email:          eventPayload.Email,
+			PasswordHash:   eventPayload.PasswordHash,
+			HashedPassword: eventPayload.HashedPassword,
+			HashSalt:       eventPayload.HashSalt,
+			CreatedOn:      event.Timestamp,
+		})
+		return err
 	case events.AccountDeleted:
-	case events.CommentThreadCreated:
-	case events.CommentCreated:
-	case events.CommentDeleted:
-	default:
-		fmt.Println(eventPayload)
+		account, err := e.AccountsService.GetAccountByAccountId(eventPayload.AccountId)
+		if err != nil {
+			return err
+		}
+		// Accounts are kept for the comments and bans referencing them
+		if err := e.AccountsService.store().AnonymizeAccount(eventPayload.AccountId, event.Timestamp); err != nil {
+			return err
+		}
+		return e.AccountsService.RemoveAvatar(account.AvatarKey)
+	case events.PasswordChanged:
+		// Sessions issued before the password changed are no longer valid
+		err := e.AccountsService.store().UpdatePassword(eventPayload.AccountId,
+			passwordHashOf(eventPayload.PasswordHash, eventPayload.HashedPassword, eventPayload.HashSalt), event.Timestamp)
+		if err != nil {
+			return err
+		}
+		return e.AccountsService.store().RevokeSessions(eventPayload.AccountId, event.Timestamp)
+	case events.AccountLocked:
+		return e.AccountsService.store().LockAccount(eventPayload.AccountId, eventPayload.LockedUntil)
+	case events.AccountUnlocked:
+		account, err := e.AccountsService.GetAccountByAccountId(eventPayload.AccountId)
+		if err != nil {
+			return err
+		}
+		if err := e.AccountsService.store().ClearLoginFailures(emailLoginKey(account.Email)); err != nil {
+			return err
+		}
+		return e.AccountsService.store().UnlockAccount(eventPayload.AccountId)
+	case events.TwoFactorEnabled:
+		return e.AccountsService.store().EnableTOTP(eventPayload.AccountId, event.Timestamp)
+	case events.TwoFactorDisabled:
+		return e.AccountsService.store().DisableTOTP(eventPayload.AccountId)
+	case events.ExternalIdentityLinked:
+		if eventPayload.NewAccount {
+			_, err := e.AccountsService.store().InsertAccount(Account{
+				AccountId:   eventPayload.AccountId,
+				AccountType: AccountTypeRegistered,
+				Username:    eventPayload.Username,
+				Email:       eventPayload.Email,
+				CreatedOn:   event.Timestamp,
+			})
+			if err != nil {
+				return err
+			}
+			if eventPayload.EmailVerified {
+				err := e.AccountsService.store().MarkEmailVerified(eventPayload.AccountId, eventPayload.Email, event.Timestamp)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return e.AccountsService.store().InsertExternalIdentity(ExternalIdentity{
+			Provider:  eventPayload.Provider,
+			Subject:   eventPayload.Subject,
+			AccountId: eventPayload.AccountId,
+			LinkedOn:  event.Timestamp,
+		})
+	case events.LoggedOut:
+		return e.AccountsService.store().RevokeSession(eventPayload.SessionId, event.Timestamp)
+	case events.LoggedOutEverywhere:
+		return e.AccountsService.store().RevokeSessions(eventPayload.AccountId, event.Timestamp)
+	case events.SigningKeyActivated:
+		return e.AccountsService.store().ActivateSigningKey(eventPayload.KeyId, event.Timestamp)
+	case events.SigningKeyRetired:
+		return e.AccountsService.store().RetireSigningKey(eventPayload.KeyId, event.Timestamp)
+	case events.UsernameChanged:
+		return e.AccountsService.store().ChangeUsername(eventPayload.AccountId, eventPayload.Username, event.Timestamp)
+	case events.EmailChanged:
+		return e.AccountsService.store().ChangeEmail(eventPayload.AccountId, eventPayload.Email)
+	case events.ProfileUpdated:
+		return e.AccountsService.store().UpdateProfile(eventPayload.AccountId, eventPayload.DisplayName, eventPayload.AvatarURL)
+	case events.AvatarUploaded:
+		return e.AccountsService.store().SetAvatarKey(eventPayload.AccountId, eventPayload.AvatarKey)
+	case events.AccountBanned:
+		return e.AccountsService.store().InsertBan(Ban{
+			BanId:        eventPayload.BanId,
+			AccountId:    eventPayload.AccountId,
+			Website:      eventPayload.Website,
+			Suspension:   eventPayload.Suspension,
+			Reason:       eventPayload.Reason,
+			HideComments: eventPayload.HideComments,
+			BannedBy:     eventPayload.BannedBy,
+			CreatedOn:    event.Timestamp,
+			ExpiresAt:    eventPayload.ExpiresAt,
+		})
+	case events.AccountUnbanned:
+		return e.AccountsService.store().LiftBan(eventPayload.BanId, eventPayload.LiftedBy, event.Timestamp)
+	case events.APITokenCreated:
+		return e.AccountsService.store().InsertAPIToken(APIToken{
+			APITokenId: eventPayload.APITokenId,
+			AccountId:  eventPayload.AccountId,
+			Name:       eventPayload.Name,
+			Prefix:     eventPayload.Prefix,
+			TokenHash:  eventPayload.TokenHash,
+			Scopes:     eventPayload.Scopes,
+			CreatedOn:  event.Timestamp,
+			ExpiresAt:  eventPayload.ExpiresAt,
+		})
+	case events.APITokenRevoked:
+		return e.AccountsService.store().RevokeAPIToken(eventPayload.APITokenId, event.Timestamp)
+	case events.GuestIdentityClaimed:
+		return e.AccountsService.store().ClaimGuest(eventPayload.GuestAccountId, eventPayload.AccountId, event.Timestamp)
+	case events.EmailVerified:
+		return e.AccountsService.store().MarkEmailVerified(eventPayload.AccountId, eventPayload.Email, event.Timestamp)
 	}
 	return nil
 }
@@ -0,0 +1,103 @@
+package accounts
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/crypto/ed25519"
+
+	"github.com/jonfk/comment-server/apperrors"
+)
+
+// JWKSPath is where the JWKSHandler is conventionally served.
+const JWKSPath = "/.well-known/jwks.json"
+
+// JWK is a JSON Web Key (RFC 7517) holding the public key of a signing key.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyId     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// Curve and X are set for Ed25519 keys (RFC 8037)
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	// Modulus and Exponent are set for RSA keys (RFC 7518)
+	Modulus  string `json:"n,omitempty"`
+	Exponent string `json:"e,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the asymmetric signing keys that aren't
+// retired, which are the keys other services need to verify access tokens.
+func (a *Accounts) JWKS() (JWKS, error) {
+	keys, err := a.store().ListSigningKeys()
+	if err != nil {
+		return JWKS{}, err
+	}
+	jwks := JWKS{Keys: []JWK{}}
+	for _, key := range keys {
+		if key.Retired() || key.Algorithm == AlgorithmHS256 {
+			continue
+		}
+		jwk, err := toJWK(key)
+		if err != nil {
+			return JWKS{}, apperrors.Wrap(err, apperrors.Internal, "invalid signing key")
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks, nil
+}
+
+func toJWK(key SigningKey) (JWK, error) {
+	publicKey, err := key.PublicKey()
+	if err != nil {
+		return JWK{}, err
+	}
+	jwk := JWK{KeyId: key.KeyId, Use: "sig", Algorithm: key.Algorithm}
+	switch publicKey := publicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.Modulus = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+		jwk.Exponent = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+	}
+	return jwk, nil
+}
+
+// JWKSHandler serves the JWKS of the AccountsService.
+type JWKSHandler struct {
+	AccountsService *Accounts
+}
+
+func (h *JWKSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	jwks, err := h.AccountsService.JWKS()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"context": "JWKSHandler",
+			"error":   err,
+		}).Error("Listing signing keys failed")
+		w.WriteHeader(apperrors.HTTPStatusOf(err))
+		json.NewEncoder(w).Encode(apperrors.ResponseOf(err))
+		return
+	}
+	// Keys are rotated rarely, verifiers can cache them for a while
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(jwks)
+}
@@ -0,0 +1,119 @@
+package accounts
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/satori/go.uuid"
+	"golang.org/x/crypto/ed25519"
+
+	"github.com/jonfk/comment-server/apperrors"
+	"github.com/jonfk/comment-server/commands"
+	"github.com/jonfk/comment-server/events"
+)
+
+func decodeBase64(t *testing.T, value string) []byte {
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		t.Fatalf("DecodeString failed : %v\n", err)
+	}
+	return decoded
+}
+
+// publicKeyOf returns the public key of jwk as another service would.
+func publicKeyOf(t *testing.T, jwk JWK) interface{} {
+	switch jwk.KeyType {
+	case "RSA":
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(decodeBase64(t, jwk.Modulus)),
+			E: int(new(big.Int).SetBytes(decodeBase64(t, jwk.Exponent)).Int64()),
+		}
+	case "OKP":
+		return ed25519.PublicKey(decodeBase64(t, jwk.X))
+	}
+	t.Fatalf("unexpected key type %s", jwk.KeyType)
+	return nil
+}
+
+func TestAsymmetricSigningKeys(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmRS256, AlgorithmEdDSA} {
+		handler, accountId := newSessionsTestHandler(t)
+		handler.AccountsService.Admins = []uuid.UUID{accountId}
+		adminToken := login(t, handler).AccessToken
+		response, err := handler.HandleCommand(commands.CreateCommand(commands.GenerateSigningKey{Token: adminToken, Algorithm: algorithm}))
+		if err != nil {
+			t.Fatalf("GenerateSigningKey %s failed : %v\n", algorithm, err)
+		}
+		keyId := response.Event.Payload.(events.SigningKeyGenerated).KeyId
+		if _, err := handler.HandleCommand(commands.CreateCommand(commands.ActivateSigningKey{Token: adminToken, KeyId: keyId})); err != nil {
+			t.Fatalf("ActivateSigningKey failed : %v\n", err)
+		}
+
+		token := login(t, handler).AccessToken
+		if validatedAccountId, err := handler.AccountsService.ValidateJWT(token); err != nil || !uuid.Equal(validatedAccountId, accountId) {
+			t.Fatalf("%s token should be valid : %v", algorithm, err)
+		}
+
+		recorder := httptest.NewRecorder()
+		(&JWKSHandler{AccountsService: handler.AccountsService}).ServeHTTP(recorder, httptest.NewRequest("GET", JWKSPath, nil))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("JWKSHandler returned %d", recorder.Code)
+		}
+		var jwks JWKS
+		if err := json.Unmarshal(recorder.Body.Bytes(), &jwks); err != nil {
+			t.Fatalf("json.Unmarshal failed : %v\n", err)
+		}
+		if len(jwks.Keys) != 1 || jwks.Keys[0].KeyId != keyId || jwks.Keys[0].Algorithm != algorithm {
+			t.Fatalf("JWKS should publish key %s but is %v", keyId, jwks)
+		}
+
+		// Another service verifies the token with the published key only
+		_, err = jwt.Parse(token, func(*jwt.Token) (interface{}, error) {
+			return publicKeyOf(t, jwks.Keys[0]), nil
+		})
+		if err != nil {
+			t.Fatalf("%s token should be verifiable with the JWKS : %v", algorithm, err)
+		}
+	}
+}
+
+func TestAlgorithmIsPinnedByKey(t *testing.T) {
+	handler, accountId := newSessionsTestHandler(t)
+	handler.AccountsService.Admins = []uuid.UUID{accountId}
+	adminToken := login(t, handler).AccessToken
+	response, err := handler.HandleCommand(commands.CreateCommand(commands.GenerateSigningKey{Token: adminToken, Algorithm: AlgorithmRS256}))
+	if err != nil {
+		t.Fatalf("GenerateSigningKey failed : %v\n", err)
+	}
+	key, err := handler.AccountsService.GetSigningKey(response.Event.Payload.(events.SigningKeyGenerated).KeyId)
+	if err != nil {
+		t.Fatalf("GetSigningKey failed : %v\n", err)
+	}
+	jwk, err := toJWK(key)
+	if err != nil {
+		t.Fatalf("toJWK failed : %v\n", err)
+	}
+
+	// A forged token using the public RSA modulus as an HMAC secret
+	forged, err := generateSessionJWT(SigningKey{KeyId: key.KeyId, Algorithm: AlgorithmHS256, Secret: decodeBase64(t, jwk.Modulus)}, sessionClaims{
+		TokenId:   uuid.NewV4(),
+		AccountId: accountId,
+	}, key.CreatedOn.Add(DefaultAccessTokenLifetime))
+	if err != nil {
+		t.Fatalf("generateSessionJWT failed : %v\n", err)
+	}
+	if _, err := parseJWT(handler.AccountsService.verificationKey, forged); !apperrors.Is(err, apperrors.AuthInvalidToken) {
+		t.Fatalf("a token with another algorithm than its key should be invalid but returned %v", err)
+	}
+
+	_, err = handler.HandleCommand(commands.CreateCommand(commands.GenerateSigningKey{Token: adminToken, Algorithm: "none"}))
+	if !apperrors.Is(err, apperrors.CommandInvalid) {
+		t.Fatalf("GenerateSigningKey should reject unknown algorithms but returned %v", err)
+	}
+}
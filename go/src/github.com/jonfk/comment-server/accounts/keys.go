@@ -0,0 +1,220 @@
+package accounts
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"golang.org/x/crypto/ed25519"
+)
+
+// Algorithms of the signing keys, named after their JWT alg header.
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+const (
+	signingKeyLength = 64
+	rsaKeyBits       = 2048
+	keyIdLength      = 12
+)
+
+// SigningKey is a key of the keyring used to sign and verify access tokens.
+// Keys are identified in tokens by the kid header.
+//
+// A generated key is only used for signing once it is activated. Every key
+// that isn't retired is accepted for verification, so that tokens signed
+// with a previously active key stay valid until the key is retired.
+//
+// Tokens signed with an RS256 or EdDSA key can be verified by other
+// services with the public key published in the JWKS.
+type SigningKey struct {
+	KeyId     string `db:"key_id"`
+	Algorithm string `db:"algorithm"`
+	// Secret is the HMAC secret, the PKCS #1 DER encoded RSA private key
+	// or the Ed25519 private key depending on Algorithm.
+	Secret      []byte     `db:"secret"`
+	CreatedOn   time.Time  `db:"created_on"`
+	ActivatedOn *time.Time `db:"activated_on"`
+	RetiredOn   *time.Time `db:"retired_on"`
+}
+
+func (k SigningKey) Retired() bool {
+	return k.RetiredOn != nil
+}
+
+func (k SigningKey) signingMethod() (jwt.SigningMethod, error) {
+	switch k.Algorithm {
+	case AlgorithmHS256:
+		return jwt.SigningMethodHS256, nil
+	case AlgorithmRS256:
+		return jwt.SigningMethodRS256, nil
+	case AlgorithmEdDSA:
+		return SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("unknown signing algorithm %s", k.Algorithm)
+}
+
+// privateKey returns the key signing tokens for the jwt.SigningMethod of k.
+func (k SigningKey) privateKey() (interface{}, error) {
+	switch k.Algorithm {
+	case AlgorithmHS256:
+		return k.Secret, nil
+	case AlgorithmRS256:
+		return x509.ParsePKCS1PrivateKey(k.Secret)
+	case AlgorithmEdDSA:
+		return ed25519.PrivateKey(k.Secret), nil
+	}
+	return nil, fmt.Errorf("unknown signing algorithm %s", k.Algorithm)
+}
+
+// PublicKey returns the key verifying tokens signed with k, which is nil
+// for HS256 keys as they are verified with their secret.
+func (k SigningKey) PublicKey() (crypto.PublicKey, error) {
+	switch k.Algorithm {
+	case AlgorithmHS256:
+		return nil, nil
+	case AlgorithmRS256:
+		privateKey, err := x509.ParsePKCS1PrivateKey(k.Secret)
+		if err != nil {
+			return nil, err
+		}
+		return &privateKey.PublicKey, nil
+	case AlgorithmEdDSA:
+		return ed25519.PrivateKey(k.Secret).Public(), nil
+	}
+	return nil, fmt.Errorf("unknown signing algorithm %s", k.Algorithm)
+}
+
+// verificationKey returns the key verifying tokens for the
+// jwt.SigningMethod of k.
+func (k SigningKey) verificationKey() (interface{}, error) {
+	if k.Algorithm == AlgorithmHS256 {
+		return k.Secret, nil
+	}
+	return k.PublicKey()
+}
+
+// ValidAlgorithm reports whether keys can be generated for algorithm.
+func ValidAlgorithm(algorithm string) bool {
+	return algorithm == AlgorithmHS256 || algorithm == AlgorithmRS256 || algorithm == AlgorithmEdDSA
+}
+
+func generateSecret(algorithm string) ([]byte, error) {
+	switch algorithm {
+	case AlgorithmHS256:
+		secret := make([]byte, signingKeyLength)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		return secret, nil
+	case AlgorithmRS256:
+		privateKey, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return nil, err
+		}
+		return x509.MarshalPKCS1PrivateKey(privateKey), nil
+	case AlgorithmEdDSA:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		return privateKey, err
+	}
+	return nil, fmt.Errorf("unknown signing algorithm %s", algorithm)
+}
+
+// activeSigningKey returns the most recently activated key that isn't
+// retired. keys are ordered by creation.
+func activeSigningKey(keys []SigningKey) (SigningKey, bool) {
+	var active SigningKey
+	found := false
+	for _, key := range keys {
+		if key.ActivatedOn == nil || key.Retired() {
+			continue
+		}
+		// Activation times have a precision of a second, ties go to the
+		// most recently created key
+		if !found || !key.ActivatedOn.Before(*active.ActivatedOn) {
+			active, found = key, true
+		}
+	}
+	return active, found
+}
+
+// GenerateSigningKey stores a new inactive key for algorithm in the
+// keyring.
+func (a *Accounts) GenerateSigningKey(algorithm string) (SigningKey, error) {
+	secret, err := generateSecret(algorithm)
+	if err != nil {
+		return SigningKey{}, err
+	}
+	keyId := make([]byte, keyIdLength)
+	if _, err := rand.Read(keyId); err != nil {
+		return SigningKey{}, err
+	}
+	key := SigningKey{
+		KeyId:     base64.RawURLEncoding.EncodeToString(keyId),
+		Algorithm: algorithm,
+		Secret:    secret,
+		CreatedOn: a.now().Round(time.Second),
+	}
+	if err := a.store().InsertSigningKey(key); err != nil {
+		return SigningKey{}, err
+	}
+	return key, nil
+}
+
+// ActiveSigningKey returns the key currently used to sign tokens.
+func (a *Accounts) ActiveSigningKey() (SigningKey, error) {
+	keys, err := a.store().ListSigningKeys()
+	if err != nil {
+		return SigningKey{}, err
+	}
+	key, ok := activeSigningKey(keys)
+	if !ok {
+		return SigningKey{}, SigningKeyNotFoundErr
+	}
+	return key, nil
+}
+
+// signingKey returns the active key of the keyring, or the HMACSecretKey
+// when no key was activated yet.
+func (a *Accounts) signingKey() (SigningKey, error) {
+	key, err := a.ActiveSigningKey()
+	if err == SigningKeyNotFoundErr {
+		return a.hmacSigningKey(), nil
+	}
+	return key, err
+}
+
+func (a *Accounts) hmacSigningKey() SigningKey {
+	return SigningKey{Algorithm: AlgorithmHS256, Secret: a.HMACSecretKey}
+}
+
+// verificationKey returns the key identified by keyId that verifies
+// tokens.
+func (a *Accounts) verificationKey(keyId string) (SigningKey, error) {
+	if keyId == "" {
+		if len(a.HMACSecretKey) == 0 {
+			return SigningKey{}, fmt.Errorf("token without kid and no HMACSecretKey")
+		}
+		return a.hmacSigningKey(), nil
+	}
+	key, err := a.store().GetSigningKey(keyId)
+	if err != nil {
+		return SigningKey{}, err
+	}
+	if key.Retired() {
+		return SigningKey{}, fmt.Errorf("signing key %s is retired", keyId)
+	}
+	return key, nil
+}
+
+func (a *Accounts) GetSigningKey(keyId string) (SigningKey, error) {
+	return a.store().GetSigningKey(keyId)
+}
@@ -0,0 +1,118 @@
+package accounts
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/apperrors"
+	"github.com/jonfk/comment-server/commands"
+	"github.com/jonfk/comment-server/events"
+)
+
+func keyIdOf(t *testing.T, token string) string {
+	header, err := jwt.DecodeSegment(strings.Split(token, ".")[0])
+	if err != nil {
+		t.Fatalf("DecodeSegment failed : %v\n", err)
+	}
+	var parsed struct {
+		KeyId string `json:"kid"`
+	}
+	if err := json.Unmarshal(header, &parsed); err != nil {
+		t.Fatalf("json.Unmarshal failed : %v\n", err)
+	}
+	return parsed.KeyId
+}
+
+func TestSigningKeyRotation(t *testing.T) {
+	handler, accountId := newSessionsTestHandler(t)
+	handler.AccountsService.Admins = []uuid.UUID{accountId}
+	handle := func(payload commands.CommandPayload) (events.Event, error) {
+		response, err := handler.HandleCommand(commands.CreateCommand(payload))
+		return response.Event, err
+	}
+	adminToken := func() string {
+		return login(t, handler).AccessToken
+	}
+	generateAndActivate := func() string {
+		event, err := handle(commands.GenerateSigningKey{Token: adminToken()})
+		if err != nil {
+			t.Fatalf("GenerateSigningKey failed : %v\n", err)
+		}
+		keyId := event.Payload.(events.SigningKeyGenerated).KeyId
+		if _, err := handle(commands.ActivateSigningKey{Token: adminToken(), KeyId: keyId}); err != nil {
+			t.Fatalf("ActivateSigningKey failed : %v\n", err)
+		}
+		return keyId
+	}
+
+	hmacToken := login(t, handler).AccessToken
+	if keyId := keyIdOf(t, hmacToken); keyId != "" {
+		t.Fatalf("tokens should be signed with the HMACSecretKey while no key is active but kid is %s", keyId)
+	}
+
+	firstKeyId := generateAndActivate()
+	firstKeyToken := login(t, handler).AccessToken
+	if keyId := keyIdOf(t, firstKeyToken); keyId != firstKeyId {
+		t.Fatalf("tokens should be signed with the active key %s but kid is %s", firstKeyId, keyId)
+	}
+
+	secondKeyId := generateAndActivate()
+	secondKeyToken := login(t, handler).AccessToken
+	if keyId := keyIdOf(t, secondKeyToken); keyId != secondKeyId {
+		t.Fatalf("tokens should be signed with the active key %s but kid is %s", secondKeyId, keyId)
+	}
+	for _, token := range []string{hmacToken, firstKeyToken, secondKeyToken} {
+		if _, err := handler.AccountsService.ValidateJWT(token); err != nil {
+			t.Fatalf("tokens signed with keys that aren't retired should be valid : %v", err)
+		}
+	}
+
+	if _, err := handle(commands.RetireSigningKey{Token: adminToken(), KeyId: secondKeyId}); !apperrors.Is(err, apperrors.SigningKeyInvalidState) {
+		t.Fatalf("the active key should not be retired but returned %v", err)
+	}
+	if _, err := handle(commands.RetireSigningKey{Token: adminToken(), KeyId: firstKeyId}); err != nil {
+		t.Fatalf("RetireSigningKey failed : %v\n", err)
+	}
+	if _, err := handler.AccountsService.ValidateJWT(firstKeyToken); !apperrors.Is(err, apperrors.AuthInvalidToken) {
+		t.Fatalf("tokens signed with a retired key should be invalid but returned %v", err)
+	}
+	if _, err := handler.AccountsService.ValidateJWT(secondKeyToken); err != nil {
+		t.Fatalf("tokens signed with the active key should be valid : %v", err)
+	}
+	if _, err := handle(commands.ActivateSigningKey{Token: adminToken(), KeyId: firstKeyId}); !apperrors.Is(err, apperrors.SigningKeyInvalidState) {
+		t.Fatalf("a retired key should not be activated but returned %v", err)
+	}
+	if _, err := handle(commands.ActivateSigningKey{Token: adminToken(), KeyId: "unknown"}); !apperrors.Is(err, apperrors.SigningKeyNotFound) {
+		t.Fatalf("activating an unknown key should fail but returned %v", err)
+	}
+}
+
+func TestSigningKeysRequireAdmin(t *testing.T) {
+	handler, accountId := newSessionsTestHandler(t)
+	token := login(t, handler).AccessToken
+
+	for _, payload := range []commands.CommandPayload{
+		commands.GenerateSigningKey{Token: token},
+		commands.ActivateSigningKey{Token: token, KeyId: "keyId"},
+		commands.RetireSigningKey{Token: token, KeyId: "keyId"},
+	} {
+		if _, err := handler.HandleCommand(commands.CreateCommand(payload)); !apperrors.Is(err, apperrors.AuthForbidden) {
+			t.Fatalf("%s should be reserved to admins but returned %v", payload.CommandType(), err)
+		}
+		if _, err := handler.HandleCommand(commands.CreateCommand(payload)); err != AdminRequiredErr {
+			t.Fatalf("%s should fail with AdminRequiredErr but returned %v", payload.CommandType(), err)
+		}
+	}
+
+	handler.AccountsService.Admins = []uuid.UUID{accountId}
+	if _, err := handler.HandleCommand(commands.CreateCommand(commands.GenerateSigningKey{Token: token})); err != nil {
+		t.Fatalf("GenerateSigningKey failed for an admin : %v\n", err)
+	}
+	if _, err := handler.HandleCommand(commands.CreateCommand(commands.GenerateSigningKey{Token: "invalid"})); !apperrors.Is(err, apperrors.AuthInvalidToken) {
+		t.Fatalf("GenerateSigningKey should require a valid access token but returned %v", err)
+	}
+}
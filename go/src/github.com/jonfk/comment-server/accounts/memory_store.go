@@ -0,0 +1,734 @@
+package accounts
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+// MemoryStore is a Store kept in memory, used to test the accounts
+// handlers without a database.
+type MemoryStore struct {
+	mutex    sync.Mutex
+	accounts map[uuid.UUID]Account
+	tokens   []memoryToken
+	sessions map[uuid.UUID]Session
+	// refreshTokens are keyed by token hash
+	refreshTokens map[string]memoryRefreshToken
+	signingKeys   []SigningKey
+	loginFailures map[string]LoginFailures
+	recoveryCodes []memoryRecoveryCode
+	oidcLogins    []memoryOIDCLogin
+	identities    []ExternalIdentity
+	usernames     []UsernameChange
+	bans          []Ban
+	apiTokens     []APIToken
+}
+
+type memoryToken struct {
+	AccountToken
+	used bool
+}
+
+type memoryRecoveryCode struct {
+	accountId uuid.UUID
+	codeHash  []byte
+	used      bool
+}
+
+type memoryOIDCLogin struct {
+	OIDCLogin
+	used bool
+}
+
+type memoryRefreshToken struct {
+	RefreshToken
+	used bool
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		accounts:      map[uuid.UUID]Account{},
+		sessions:      map[uuid.UUID]Session{},
+		refreshTokens: map[string]memoryRefreshToken{},
+		loginFailures: map[string]LoginFailures{},
+	}
+}
+
+func (s *MemoryStore) InsertAccount(account Account) (Account, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	account.AccountType = accountTypeOf(account)
+	for _, existing := range s.accounts {
+		if account.Guest() || existing.Guest() {
+			continue
+		}
+		if existing.Username == account.Username {
+			return Account{}, UsernameTakenErr
+		}
+		if existing.Email == account.Email {
+			return Account{}, EmailTakenErr
+		}
+	}
+	s.accounts[account.AccountId] = account
+	return account, nil
+}
+
+func (s *MemoryStore) DeleteById(accountId uuid.UUID) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.accounts[accountId]; !ok {
+		return "", AccountNotFoundErr
+	}
+	delete(s.accounts, accountId)
+	return accountId.String(), nil
+}
+
+func (s *MemoryStore) AnonymizeAccount(accountId uuid.UUID, deletedOn time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	account, ok := s.accounts[accountId]
+	if !ok {
+		return AccountNotFoundErr
+	}
+	if account.Email != "" {
+		delete(s.loginFailures, emailLoginKey(account.Email))
+	}
+	s.accounts[accountId] = Account{
+		AccountId:         accountId,
+		AccountType:       AccountTypeDeleted,
+		CreatedOn:         account.CreatedOn,
+		SessionsValidFrom: &deletedOn,
+		ClaimedBy:         account.ClaimedBy,
+	}
+
+	tokens := s.tokens[:0]
+	for _, token := range s.tokens {
+		if !uuid.Equal(token.AccountId, accountId) {
+			tokens = append(tokens, token)
+		}
+	}
+	s.tokens = tokens
+	for sessionId, session := range s.sessions {
+		if uuid.Equal(session.AccountId, accountId) {
+			delete(s.sessions, sessionId)
+			for tokenHash, refreshToken := range s.refreshTokens {
+				if uuid.Equal(refreshToken.SessionId, sessionId) {
+					delete(s.refreshTokens, tokenHash)
+				}
+			}
+		}
+	}
+	recoveryCodes := s.recoveryCodes[:0]
+	for _, code := range s.recoveryCodes {
+		if !uuid.Equal(code.accountId, accountId) {
+			recoveryCodes = append(recoveryCodes, code)
+		}
+	}
+	s.recoveryCodes = recoveryCodes
+	identities := s.identities[:0]
+	for _, identity := range s.identities {
+		if !uuid.Equal(identity.AccountId, accountId) {
+			identities = append(identities, identity)
+		}
+	}
+	s.identities = identities
+	usernames := s.usernames[:0]
+	for _, change := range s.usernames {
+		if !uuid.Equal(change.AccountId, accountId) {
+			usernames = append(usernames, change)
+		}
+	}
+	s.usernames = usernames
+	apiTokens := s.apiTokens[:0]
+	for _, token := range s.apiTokens {
+		if !uuid.Equal(token.AccountId, accountId) {
+			apiTokens = append(apiTokens, token)
+		}
+	}
+	s.apiTokens = apiTokens
+	return nil
+}
+
+func (s *MemoryStore) GetAccountByAccountId(accountId uuid.UUID) (Account, error) {
+	return s.find(func(account Account) bool { return uuid.Equal(account.AccountId, accountId) })
+}
+
+func (s *MemoryStore) GetAccountByEmail(email string) (Account, error) {
+	return s.find(func(account Account) bool { return !account.Guest() && account.Email == email })
+}
+
+func (s *MemoryStore) GetAccountByUsername(username string) (Account, error) {
+	return s.find(func(account Account) bool { return !account.Guest() && account.Username == username })
+}
+
+func (s *MemoryStore) find(match func(Account) bool) (Account, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, account := range s.accounts {
+		if match(account) {
+			return account, nil
+		}
+	}
+	return Account{}, AccountNotFoundErr
+}
+
+func (s *MemoryStore) UpdatePassword(accountId uuid.UUID, passwordHash string, sessionsValidFrom time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	account, ok := s.accounts[accountId]
+	if !ok {
+		return AccountNotFoundErr
+	}
+	account.PasswordHash, account.HashedPassword, account.HashSalt = passwordHash, nil, nil
+	account.SessionsValidFrom = &sessionsValidFrom
+	s.accounts[accountId] = account
+	return nil
+}
+
+func (s *MemoryStore) RehashPassword(accountId uuid.UUID, passwordHash string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	account, ok := s.accounts[accountId]
+	if !ok {
+		return AccountNotFoundErr
+	}
+	account.PasswordHash, account.HashedPassword, account.HashSalt = passwordHash, nil, nil
+	s.accounts[accountId] = account
+	return nil
+}
+
+func (s *MemoryStore) ChangeUsername(accountId uuid.UUID, username string, changedOn time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, existing := range s.accounts {
+		if !existing.Guest() && existing.Username == username && !uuid.Equal(existing.AccountId, accountId) {
+			return UsernameTakenErr
+		}
+	}
+	var oldUsername string
+	err := s.updateAccount(accountId, func(account *Account) {
+		oldUsername, account.Username = account.Username, username
+	})
+	if err != nil {
+		return err
+	}
+	s.usernames = append(s.usernames, UsernameChange{
+		AccountId:   accountId,
+		OldUsername: oldUsername,
+		NewUsername: username,
+		ChangedOn:   changedOn,
+	})
+	return nil
+}
+
+func (s *MemoryStore) ListUsernameChanges(accountId uuid.UUID) ([]UsernameChange, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	changes := []UsernameChange{}
+	for _, change := range s.usernames {
+		if uuid.Equal(change.AccountId, accountId) {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+func (s *MemoryStore) ChangeEmail(accountId uuid.UUID, email string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	account, ok := s.accounts[accountId]
+	if !ok {
+		return AccountNotFoundErr
+	}
+	for _, existing := range s.accounts {
+		if !account.Guest() && !existing.Guest() && existing.Email == email && !uuid.Equal(existing.AccountId, accountId) {
+			return EmailTakenErr
+		}
+	}
+	account.Email, account.EmailVerifiedOn = email, nil
+	s.accounts[accountId] = account
+	tokens := s.tokens[:0]
+	for _, token := range s.tokens {
+		if token.used || !uuid.Equal(token.AccountId, accountId) {
+			tokens = append(tokens, token)
+		}
+	}
+	s.tokens = tokens
+	return nil
+}
+
+func (s *MemoryStore) UpdateProfile(accountId uuid.UUID, displayName, avatarURL string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.updateAccount(accountId, func(account *Account) {
+		account.DisplayName = displayName
+		if avatarURL != "" {
+			account.AvatarURL, account.AvatarKey = avatarURL, ""
+		}
+	})
+}
+
+func (s *MemoryStore) SetAvatarKey(accountId uuid.UUID, avatarKey string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.updateAccount(accountId, func(account *Account) {
+		account.AvatarURL, account.AvatarKey = "", avatarKey
+	})
+}
+
+func (s *MemoryStore) InsertBan(ban Ban) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.bans = append(s.bans, ban)
+	if !ban.Global() {
+		return nil
+	}
+	for sessionId, session := range s.sessions {
+		if uuid.Equal(session.AccountId, ban.AccountId) && session.RevokedOn == nil {
+			revokedOn := ban.CreatedOn
+			session.RevokedOn = &revokedOn
+			s.sessions[sessionId] = session
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetBan(banId uuid.UUID) (Ban, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, ban := range s.bans {
+		if uuid.Equal(ban.BanId, banId) {
+			return ban, nil
+		}
+	}
+	return Ban{}, BanNotFoundErr
+}
+
+func (s *MemoryStore) ListBans(accountId uuid.UUID) ([]Ban, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	bans := []Ban{}
+	for _, ban := range s.bans {
+		if uuid.Equal(ban.AccountId, accountId) {
+			bans = append(bans, ban)
+		}
+	}
+	return bans, nil
+}
+
+func (s *MemoryStore) LiftBan(banId uuid.UUID, liftedBy *uuid.UUID, liftedOn time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, ban := range s.bans {
+		if uuid.Equal(ban.BanId, banId) && ban.LiftedOn == nil {
+			s.bans[i].LiftedOn, s.bans[i].LiftedBy = &liftedOn, liftedBy
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) InsertAPIToken(token APIToken) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.apiTokens = append(s.apiTokens, token)
+	return nil
+}
+
+func (s *MemoryStore) GetAPIToken(apiTokenId uuid.UUID) (APIToken, error) {
+	return s.findAPIToken(func(token APIToken) bool { return uuid.Equal(token.APITokenId, apiTokenId) })
+}
+
+func (s *MemoryStore) GetAPITokenByHash(tokenHash []byte) (APIToken, error) {
+	return s.findAPIToken(func(token APIToken) bool { return bytes.Equal(token.TokenHash, tokenHash) })
+}
+
+func (s *MemoryStore) findAPIToken(match func(APIToken) bool) (APIToken, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, token := range s.apiTokens {
+		if match(token) {
+			return token, nil
+		}
+	}
+	return APIToken{}, APITokenNotFoundErr
+}
+
+func (s *MemoryStore) ListAPITokens(accountId uuid.UUID) ([]APIToken, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	tokens := []APIToken{}
+	for _, token := range s.apiTokens {
+		if uuid.Equal(token.AccountId, accountId) {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+func (s *MemoryStore) RevokeAPIToken(apiTokenId uuid.UUID, revokedOn time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, token := range s.apiTokens {
+		if uuid.Equal(token.APITokenId, apiTokenId) && token.RevokedOn == nil {
+			s.apiTokens[i].RevokedOn = &revokedOn
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) RecordAPITokenUse(apiTokenId uuid.UUID, usedOn time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, token := range s.apiTokens {
+		if uuid.Equal(token.APITokenId, apiTokenId) {
+			s.apiTokens[i].LastUsedOn = &usedOn
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) ClaimGuest(guestAccountId, accountId uuid.UUID, now time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if guest, ok := s.accounts[guestAccountId]; ok && guest.Guest() {
+		guest.ClaimedBy = &accountId
+		s.accounts[guestAccountId] = guest
+	}
+	for sessionId, session := range s.sessions {
+		if uuid.Equal(session.AccountId, guestAccountId) && session.RevokedOn == nil {
+			session.RevokedOn = &now
+			s.sessions[sessionId] = session
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) LockAccount(accountId uuid.UUID, lockedUntil time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if account, ok := s.accounts[accountId]; ok {
+		account.LockedUntil = &lockedUntil
+		s.accounts[accountId] = account
+	}
+	return nil
+}
+
+func (s *MemoryStore) UnlockAccount(accountId uuid.UUID) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if account, ok := s.accounts[accountId]; ok {
+		account.LockedUntil = nil
+		s.accounts[accountId] = account
+	}
+	return nil
+}
+
+func (s *MemoryStore) RecordLoginFailure(key string, now time.Time) (LoginFailures, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	failures := s.loginFailures[key]
+	failures.Key = key
+	failures.Failures++
+	failures.LastFailureOn = now
+	s.loginFailures[key] = failures
+	return failures, nil
+}
+
+func (s *MemoryStore) GetLoginFailures(key string) (LoginFailures, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	failures := s.loginFailures[key]
+	failures.Key = key
+	return failures, nil
+}
+
+func (s *MemoryStore) ClearLoginFailures(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.loginFailures, key)
+	return nil
+}
+
+// updateAccount applies update to an existing account, s.mutex must be held.
+func (s *MemoryStore) updateAccount(accountId uuid.UUID, update func(*Account)) error {
+	account, ok := s.accounts[accountId]
+	if !ok {
+		return AccountNotFoundErr
+	}
+	update(&account)
+	s.accounts[accountId] = account
+	return nil
+}
+
+func (s *MemoryStore) SetTOTPSecret(accountId uuid.UUID, secret []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.updateAccount(accountId, func(account *Account) {
+		account.TOTPSecret, account.TOTPEnabledOn, account.TOTPLastStep = secret, nil, 0
+	})
+}
+
+func (s *MemoryStore) EnableTOTP(accountId uuid.UUID, enabledOn time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.updateAccount(accountId, func(account *Account) {
+		if account.TOTPSecret != nil {
+			account.TOTPEnabledOn = &enabledOn
+		}
+	})
+}
+
+func (s *MemoryStore) DisableTOTP(accountId uuid.UUID) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.deleteRecoveryCodes(accountId)
+	return s.updateAccount(accountId, func(account *Account) {
+		account.TOTPSecret, account.TOTPEnabledOn, account.TOTPLastStep = nil, nil, 0
+	})
+}
+
+func (s *MemoryStore) UseTOTPStep(accountId uuid.UUID, step int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	account, ok := s.accounts[accountId]
+	if !ok || account.TOTPLastStep >= step {
+		return InvalidCredentialsErr
+	}
+	account.TOTPLastStep = step
+	s.accounts[accountId] = account
+	return nil
+}
+
+// deleteRecoveryCodes removes the codes of an account, s.mutex must be held.
+func (s *MemoryStore) deleteRecoveryCodes(accountId uuid.UUID) {
+	kept := []memoryRecoveryCode{}
+	for _, code := range s.recoveryCodes {
+		if !uuid.Equal(code.accountId, accountId) {
+			kept = append(kept, code)
+		}
+	}
+	s.recoveryCodes = kept
+}
+
+func (s *MemoryStore) InsertRecoveryCodes(accountId uuid.UUID, codeHashes [][]byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.deleteRecoveryCodes(accountId)
+	for _, codeHash := range codeHashes {
+		s.recoveryCodes = append(s.recoveryCodes, memoryRecoveryCode{accountId: accountId, codeHash: codeHash})
+	}
+	return nil
+}
+
+func (s *MemoryStore) UseRecoveryCode(accountId uuid.UUID, codeHash []byte, now time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, code := range s.recoveryCodes {
+		if uuid.Equal(code.accountId, accountId) && bytes.Equal(code.codeHash, codeHash) && !code.used {
+			s.recoveryCodes[i].used = true
+			return nil
+		}
+	}
+	return InvalidCredentialsErr
+}
+
+func (s *MemoryStore) InsertOIDCLogin(login OIDCLogin) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.oidcLogins = append(s.oidcLogins, memoryOIDCLogin{OIDCLogin: login})
+	return nil
+}
+
+func (s *MemoryStore) ConsumeOIDCLogin(stateHash []byte, now time.Time) (OIDCLogin, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, login := range s.oidcLogins {
+		if bytes.Equal(login.StateHash, stateHash) && !login.used && login.ExpiresAt.After(now) {
+			s.oidcLogins[i].used = true
+			return login.OIDCLogin, nil
+		}
+	}
+	return OIDCLogin{}, InvalidTokenErr
+}
+
+func (s *MemoryStore) InsertExternalIdentity(identity ExternalIdentity) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, existing := range s.identities {
+		if existing.Provider == identity.Provider && existing.Subject == identity.Subject {
+			return nil
+		}
+	}
+	s.identities = append(s.identities, identity)
+	return nil
+}
+
+func (s *MemoryStore) GetAccountByExternalIdentity(provider, subject string) (Account, error) {
+	s.mutex.Lock()
+	var accountId *uuid.UUID
+	for _, identity := range s.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			accountId = &identity.AccountId
+		}
+	}
+	s.mutex.Unlock()
+	if accountId == nil {
+		return Account{}, AccountNotFoundErr
+	}
+	return s.GetAccountByAccountId(*accountId)
+}
+
+func (s *MemoryStore) MarkEmailVerified(accountId uuid.UUID, email string, verifiedOn time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	account, ok := s.accounts[accountId]
+	if !ok || account.Email != email {
+		return nil
+	}
+	account.EmailVerifiedOn = &verifiedOn
+	s.accounts[accountId] = account
+	return nil
+}
+
+func (s *MemoryStore) InsertToken(token AccountToken) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.accounts[token.AccountId]; !ok {
+		return AccountNotFoundErr
+	}
+	s.tokens = append(s.tokens, memoryToken{AccountToken: token})
+	return nil
+}
+
+func (s *MemoryStore) ConsumeToken(purpose string, tokenHash []byte, now time.Time) (uuid.UUID, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, token := range s.tokens {
+		if bytes.Equal(token.TokenHash, tokenHash) && token.Purpose == purpose &&
+			!token.used && token.ExpiresAt.After(now) {
+			s.tokens[i].used = true
+			return token.AccountId, nil
+		}
+	}
+	return uuid.Nil, InvalidTokenErr
+}
+
+func (s *MemoryStore) InsertSession(session Session) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.accounts[session.AccountId]; !ok {
+		return AccountNotFoundErr
+	}
+	s.sessions[session.SessionId] = session
+	return nil
+}
+
+func (s *MemoryStore) GetSession(sessionId uuid.UUID) (Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	session, ok := s.sessions[sessionId]
+	if !ok {
+		return Session{}, InvalidTokenErr
+	}
+	return session, nil
+}
+
+func (s *MemoryStore) InsertRefreshToken(token RefreshToken) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.refreshTokens[string(token.TokenHash)] = memoryRefreshToken{RefreshToken: token}
+	return nil
+}
+
+func (s *MemoryStore) RotateRefreshToken(tokenHash []byte, next RefreshToken, now time.Time) (Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	token, ok := s.refreshTokens[string(tokenHash)]
+	if !ok {
+		return Session{}, InvalidTokenErr
+	}
+	session := s.sessions[token.SessionId]
+	if !session.Active(now) {
+		return Session{}, InvalidTokenErr
+	}
+	if token.used {
+		session.RevokedOn = &now
+		s.sessions[session.SessionId] = session
+		return Session{}, InvalidTokenErr
+	}
+
+	token.used = true
+	s.refreshTokens[string(tokenHash)] = token
+	next.SessionId = session.SessionId
+	s.refreshTokens[string(next.TokenHash)] = memoryRefreshToken{RefreshToken: next}
+	return session, nil
+}
+
+func (s *MemoryStore) RevokeSession(sessionId uuid.UUID, now time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if session, ok := s.sessions[sessionId]; ok && session.RevokedOn == nil {
+		session.RevokedOn = &now
+		s.sessions[sessionId] = session
+	}
+	return nil
+}
+
+func (s *MemoryStore) RevokeSessions(accountId uuid.UUID, now time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for sessionId, session := range s.sessions {
+		if uuid.Equal(session.AccountId, accountId) && session.RevokedOn == nil {
+			session.RevokedOn = &now
+			s.sessions[sessionId] = session
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) InsertSigningKey(key SigningKey) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.signingKeys = append(s.signingKeys, key)
+	return nil
+}
+
+func (s *MemoryStore) GetSigningKey(keyId string) (SigningKey, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, key := range s.signingKeys {
+		if key.KeyId == keyId {
+			return key, nil
+		}
+	}
+	return SigningKey{}, SigningKeyNotFoundErr
+}
+
+func (s *MemoryStore) ListSigningKeys() ([]SigningKey, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]SigningKey{}, s.signingKeys...), nil
+}
+
+func (s *MemoryStore) ActivateSigningKey(keyId string, now time.Time) error {
+	return s.updateSigningKey(keyId, func(key *SigningKey) { key.ActivatedOn = &now })
+}
+
+func (s *MemoryStore) RetireSigningKey(keyId string, now time.Time) error {
+	return s.updateSigningKey(keyId, func(key *SigningKey) { key.RetiredOn = &now })
+}
+
+func (s *MemoryStore) updateSigningKey(keyId string, update func(*SigningKey)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i := range s.signingKeys {
+		if s.signingKeys[i].KeyId == keyId {
+			update(&s.signingKeys[i])
+			return nil
+		}
+	}
+	return SigningKeyNotFoundErr
+}
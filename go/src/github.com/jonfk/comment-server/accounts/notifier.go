@@ -0,0 +1,130 @@
+package accounts
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/events"
+	"github.com/jonfk/comment-server/mailer"
+)
+
+const (
+	LoginLinkLifetime         = 15 * time.Minute
+	PasswordResetLifetime     = time.Hour
+	EmailVerificationLifetime = 24 * time.Hour
+	loginLinkPath             = "/login"
+	passwordResetPath         = "/reset-password"
+	emailVerificationPath     = "/verify-email"
+)
+
+// Notifier is an EventHandler that emails account owners in reaction to
+// account events. Tokens are issued here rather than when deciding the
+// command so that they are never part of an event.
+type Notifier struct {
+	AccountsService *Accounts
+	Mailer          mailer.Mailer
+	// BaseURL is the URL of the client the links sent by email point to.
+	BaseURL string
+}
+
+func (n *Notifier) HandleEvent(event events.Event) error {
+	switch eventPayload := event.Payload.(type) {
+	case events.AccountCreated:
+		// The emails of guests aren't used to log in so they aren't verified
+		if eventPayload.Email == "" || eventPayload.AccountType == AccountTypeGuest {
+			return nil
+		}
+		return n.sendEmailVerification(eventPayload.AccountId)
+	case events.EmailVerificationRequested:
+		return n.sendEmailVerification(eventPayload.AccountId)
+	case events.EmailChanged:
+		if eventPayload.OldEmail != "" {
+			// Let the owner of the previous email know in case the change
+			// wasn't theirs
+			err := n.Mailer.Send(mailer.Message{
+				To:      eventPayload.OldEmail,
+				Subject: "Your email address was changed",
+				Body: fmt.Sprintf("Hi,\n\nThe email address of your account was changed to %s.\n\nIf you didn't make this change, reset your password and contact us.\n",
+					eventPayload.Email),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if eventPayload.Email == "" {
+			return nil
+		}
+		return n.sendEmailVerification(eventPayload.AccountId)
+	case events.LoginLinkRequested:
+		account, err := n.AccountsService.GetAccountByAccountId(eventPayload.AccountId)
+		if err != nil {
+			return err
+		}
+		token, err := n.AccountsService.IssueToken(account.AccountId, TokenPurposeLogin, LoginLinkLifetime)
+		if err != nil {
+			return err
+		}
+		return n.Mailer.Send(mailer.Message{
+			To:      account.Email,
+			Subject: "Your login link",
+			Body: fmt.Sprintf("Hi %s,\n\nFollow this link to log in:\n\n%s\n\nThe link expires in %s and can only be used once.\n",
+				account.Name(), n.link(loginLinkPath, token), formatLifetime(LoginLinkLifetime)),
+		})
+	case events.PasswordResetRequested:
+		account, err := n.AccountsService.GetAccountByAccountId(eventPayload.AccountId)
+		if err != nil {
+			return err
+		}
+		token, err := n.AccountsService.IssueToken(account.AccountId, TokenPurposePasswordReset, PasswordResetLifetime)
+		if err != nil {
+			return err
+		}
+		return n.Mailer.Send(mailer.Message{
+			To:      account.Email,
+			Subject: "Reset your password",
+			Body: fmt.Sprintf("Hi %s,\n\nFollow this link to choose a new password:\n\n%s\n\nThe link expires in %s and can only be used once. If you didn't ask to reset your password you can ignore this email.\n",
+				account.Name(), n.link(passwordResetPath, token), formatLifetime(PasswordResetLifetime)),
+		})
+	}
+	return nil
+}
+
+func (n *Notifier) sendEmailVerification(accountId uuid.UUID) error {
+	account, err := n.AccountsService.GetAccountByAccountId(accountId)
+	if err != nil {
+		return err
+	}
+	if account.Guest() {
+		return nil
+	}
+	token, err := n.AccountsService.IssueToken(account.AccountId, TokenPurposeEmailVerification, EmailVerificationLifetime)
+	if err != nil {
+		return err
+	}
+	return n.Mailer.Send(mailer.Message{
+		To:      account.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nFollow this link to verify your email address:\n\n%s\n\nThe link expires in %s and can only be used once.\n",
+			account.Name(), n.link(emailVerificationPath, token), formatLifetime(EmailVerificationLifetime)),
+	})
+}
+
+// formatLifetime formats a lifetime of whole minutes or hours for emails,
+// e.g. "15 minutes" or "1 hour".
+func formatLifetime(lifetime time.Duration) string {
+	count, unit := int(lifetime/time.Minute), "minute"
+	if lifetime%time.Hour == 0 {
+		count, unit = int(lifetime/time.Hour), "hour"
+	}
+	if count != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s", count, unit)
+}
+
+func (n *Notifier) link(path, token string) string {
+	return n.BaseURL + path + "?token=" + url.QueryEscape(token)
+}
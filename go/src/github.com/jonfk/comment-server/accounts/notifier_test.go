@@ -0,0 +1,306 @@
+package accounts
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/apperrors"
+	"github.com/jonfk/comment-server/commands"
+	"github.com/jonfk/comment-server/events"
+	"github.com/jonfk/comment-server/mailer"
+)
+
+type recordingMailer struct {
+	messages []mailer.Message
+}
+
+func (m *recordingMailer) Send(message mailer.Message) error {
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+// tokenFromLink returns the token of the link in the last message sent.
+func (m *recordingMailer) tokenFromLink(t *testing.T) string {
+	if len(m.messages) == 0 {
+		t.Fatal("no message was sent")
+	}
+	for _, word := range strings.Fields(m.messages[len(m.messages)-1].Body) {
+		if link, err := url.Parse(word); err == nil && link.Query().Get("token") != "" {
+			return link.Query().Get("token")
+		}
+	}
+	t.Fatalf("no link found in message %v", m.messages[len(m.messages)-1])
+	return ""
+}
+
+func TestLoginLink(t *testing.T) {
+	accountsService := &Accounts{
+		Store:                NewMemoryStore(),
+		HMACSecretKey:        []byte("secret_key"),
+		SessionLengthInHours: 1,
+		PasswordPolicy:       &testPasswordPolicy,
+	}
+	recorder := &recordingMailer{}
+	handler := &CommandHandler{
+		AccountsService: accountsService,
+		EventHandler: events.EventHandlers{
+			&EventHandler{AccountsService: accountsService},
+			&Notifier{AccountsService: accountsService, Mailer: recorder, BaseURL: "https://comments.example.com"},
+		},
+	}
+
+	accountId := uuid.NewV4()
+	err := handler.EventHandler.HandleEvent(events.NewEventNow(accountCreated(t, accountId, "username", "email@example.com", "password")))
+	if err != nil {
+		t.Fatalf("HandleEvent failed : %v\n", err)
+	}
+	// Forget the email verification sent for the new account
+	recorder.messages = nil
+
+	response, err := handler.HandleCommand(commands.CreateCommand(commands.RequestLoginLink{Email: "unknown@example.com"}))
+	if err != nil || response.Event.Payload != nil || len(recorder.messages) != 0 {
+		t.Fatalf("RequestLoginLink for an unknown email should silently do nothing, returned %v, %v", response, err)
+	}
+
+	_, err = handler.HandleCommand(commands.CreateCommand(commands.RequestLoginLink{Email: "email@example.com"}))
+	if err != nil {
+		t.Fatalf("RequestLoginLink failed : %v\n", err)
+	}
+	if recorder.messages[0].To != "email@example.com" {
+		t.Fatalf("login link sent to %s", recorder.messages[0].To)
+	}
+	token := recorder.tokenFromLink(t)
+
+	response, err = handler.HandleCommand(commands.CreateCommand(commands.ConfirmLoginLink{Token: token}))
+	if err != nil {
+		t.Fatalf("ConfirmLoginLink failed : %v\n", err)
+	}
+	loggedIn, ok := response.Event.Payload.(events.AccountLoggedIn)
+	if !ok || !uuid.Equal(loggedIn.AccountId, accountId) || response.Secrets == nil {
+		t.Fatalf("ConfirmLoginLink should log in account %v but returned %v", accountId, response)
+	}
+	if validatedAccountId, err := accountsService.ValidateJWT(response.Secrets.AccessToken); err != nil || !uuid.Equal(validatedAccountId, accountId) {
+		t.Fatalf("ConfirmLoginLink returned an invalid JWT : %v", err)
+	}
+
+	_, err = handler.HandleCommand(commands.CreateCommand(commands.ConfirmLoginLink{Token: token}))
+	if !apperrors.Is(err, apperrors.AuthInvalidToken) {
+		t.Fatalf("login link should only be usable once but returned %v", err)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	accountsService := &Accounts{
+		Store:                NewMemoryStore(),
+		HMACSecretKey:        []byte("secret_key"),
+		SessionLengthInHours: 1,
+		PasswordPolicy:       &testPasswordPolicy,
+	}
+	recorder := &recordingMailer{}
+	handler := &CommandHandler{
+		AccountsService: accountsService,
+		EventHandler: events.EventHandlers{
+			&EventHandler{AccountsService: accountsService},
+			&Notifier{AccountsService: accountsService, Mailer: recorder, BaseURL: "https://comments.example.com"},
+		},
+	}
+
+	accountId := uuid.NewV4()
+	err := handler.EventHandler.HandleEvent(events.NewEventNow(accountCreated(t, accountId, "username", "email@example.com", "password")))
+	if err != nil {
+		t.Fatalf("HandleEvent failed : %v\n", err)
+	}
+	// Forget the email verification sent for the new account
+	recorder.messages = nil
+	session, err := accountsService.GenerateJWT(accountId)
+	if err != nil {
+		t.Fatalf("GenerateJWT failed : %v\n", err)
+	}
+
+	response, err := handler.HandleCommand(commands.CreateCommand(commands.RequestPasswordReset{Email: "unknown@example.com"}))
+	if err != nil || response.Event.Payload != nil || len(recorder.messages) != 0 {
+		t.Fatalf("RequestPasswordReset for an unknown email should silently do nothing, returned %v, %v", response, err)
+	}
+
+	_, err = handler.HandleCommand(commands.CreateCommand(commands.RequestPasswordReset{Email: "email@example.com"}))
+	if err != nil {
+		t.Fatalf("RequestPasswordReset failed : %v\n", err)
+	}
+	token := recorder.tokenFromLink(t)
+
+	_, err = handler.HandleCommand(commands.CreateCommand(commands.ConfirmLoginLink{Token: token}))
+	if !apperrors.Is(err, apperrors.AuthInvalidToken) {
+		t.Fatalf("password reset token should not be usable as a login link but returned %v", err)
+	}
+
+	response, err = handler.HandleCommand(commands.CreateCommand(commands.ResetPassword{Token: token, NewPassword: "new password"}))
+	if err != nil {
+		t.Fatalf("ResetPassword failed : %v\n", err)
+	}
+	if changed, ok := response.Event.Payload.(events.PasswordChanged); !ok || !uuid.Equal(changed.AccountId, accountId) {
+		t.Fatalf("ResetPassword should change the password of account %v but returned %v", accountId, response)
+	}
+
+	if err := accountsService.Verify(accountId, "new password"); err != nil {
+		t.Fatalf("new password should be valid : %v", err)
+	}
+	if err := accountsService.Verify(accountId, "password"); err != InvalidCredentialsErr {
+		t.Fatalf("old password should be invalid but returned %v", err)
+	}
+	if _, err := accountsService.ValidateJWT(session); !apperrors.Is(err, apperrors.AuthInvalidToken) {
+		t.Fatalf("sessions issued before the reset should be invalid but returned %v", err)
+	}
+
+	_, err = handler.HandleCommand(commands.CreateCommand(commands.ResetPassword{Token: token, NewPassword: "other password"}))
+	if !apperrors.Is(err, apperrors.AuthInvalidToken) {
+		t.Fatalf("password reset token should only be usable once but returned %v", err)
+	}
+}
+
+func TestEmailVerification(t *testing.T) {
+	accountsService := &Accounts{
+		Store:                NewMemoryStore(),
+		HMACSecretKey:        []byte("secret_key"),
+		SessionLengthInHours: 1,
+		PasswordPolicy:       &testPasswordPolicy,
+	}
+	recorder := &recordingMailer{}
+	handler := &CommandHandler{
+		AccountsService: accountsService,
+		EventHandler: events.EventHandlers{
+			&EventHandler{AccountsService: accountsService},
+			&Notifier{AccountsService: accountsService, Mailer: recorder, BaseURL: "https://comments.example.com"},
+		},
+	}
+
+	response, err := handler.HandleCommand(commands.CreateCommand(commands.CreateAccount{Username: "username", Email: "email@example.com", Password: "password"}))
+	if err != nil {
+		t.Fatalf("CreateAccount failed : %v\n", err)
+	}
+	accountId := response.Event.Payload.(events.AccountCreated).AccountId
+	if len(recorder.messages) != 1 || recorder.messages[0].To != "email@example.com" {
+		t.Fatalf("an email verification should be sent to the new account but sent %v", recorder.messages)
+	}
+	if body := recorder.messages[0].Body; !strings.HasPrefix(body, "Hi username,") || !strings.Contains(body, "expires in 24 hours") {
+		t.Fatalf("unexpected email verification %q", body)
+	}
+	firstToken := recorder.tokenFromLink(t)
+
+	_, err = handler.HandleCommand(commands.CreateCommand(commands.RequestEmailVerification{Email: "email@example.com"}))
+	if err != nil {
+		t.Fatalf("RequestEmailVerification failed : %v\n", err)
+	}
+	if len(recorder.messages) != 2 {
+		t.Fatalf("RequestEmailVerification should send a new email verification but sent %v", recorder.messages)
+	}
+
+	response, err = handler.HandleCommand(commands.CreateCommand(commands.VerifyEmail{Token: firstToken}))
+	if err != nil {
+		t.Fatalf("VerifyEmail failed : %v\n", err)
+	}
+	verified, ok := response.Event.Payload.(events.EmailVerified)
+	if !ok || !uuid.Equal(verified.AccountId, accountId) || verified.Email != "email@example.com" {
+		t.Fatalf("VerifyEmail should verify the email of account %v but returned %v", accountId, response)
+	}
+	account, err := accountsService.GetAccountByAccountId(accountId)
+	if err != nil || !account.EmailVerified() {
+		t.Fatalf("account should be verified : %v, %v", account, err)
+	}
+
+	_, err = handler.HandleCommand(commands.CreateCommand(commands.VerifyEmail{Token: firstToken}))
+	if !apperrors.Is(err, apperrors.AuthInvalidToken) {
+		t.Fatalf("email verification token should only be usable once but returned %v", err)
+	}
+
+	response, err = handler.HandleCommand(commands.CreateCommand(commands.RequestEmailVerification{Email: "email@example.com"}))
+	if err != nil || response.Event.Payload != nil || len(recorder.messages) != 2 {
+		t.Fatalf("RequestEmailVerification for a verified email should silently do nothing, returned %v, %v", response, err)
+	}
+}
+
+func TestNoEmailVerificationForGuests(t *testing.T) {
+	accountsService := &Accounts{
+		Store:                NewMemoryStore(),
+		HMACSecretKey:        []byte("secret_key"),
+		SessionLengthInHours: 1,
+		PasswordPolicy:       &testPasswordPolicy,
+	}
+	recorder := &recordingMailer{}
+	handler := &CommandHandler{
+		AccountsService: accountsService,
+		EventHandler: events.EventHandlers{
+			&EventHandler{AccountsService: accountsService},
+			&Notifier{AccountsService: accountsService, Mailer: recorder, BaseURL: "https://comments.example.com"},
+		},
+	}
+
+	_, err := handler.HandleCommand(commands.CreateCommand(commands.CreateGuestAccount{DisplayName: "Guest", Email: "guest@example.com"}))
+	if err != nil {
+		t.Fatalf("CreateGuestAccount failed : %v\n", err)
+	}
+	if len(recorder.messages) != 0 {
+		t.Fatalf("no email verification should be sent to guests but sent %v", recorder.messages)
+	}
+}
+
+func TestFormatLifetime(t *testing.T) {
+	lifetimes := map[time.Duration]string{
+		LoginLinkLifetime:         "15 minutes",
+		PasswordResetLifetime:     "1 hour",
+		EmailVerificationLifetime: "24 hours",
+		time.Minute:               "1 minute",
+	}
+	for lifetime, expected := range lifetimes {
+		if formatted := formatLifetime(lifetime); formatted != expected {
+			t.Fatalf("formatLifetime(%v) = %q, expected %q", lifetime, formatted, expected)
+		}
+	}
+}
+
+func TestChangeEmail(t *testing.T) {
+	handler, accountId := newSessionsTestHandler(t)
+	accountsService := handler.AccountsService
+	recorder := &recordingMailer{}
+	handler.EventHandler = events.EventHandlers{
+		&EventHandler{AccountsService: accountsService},
+		&Notifier{AccountsService: accountsService, Mailer: recorder, BaseURL: "https://comments.example.com"},
+	}
+	loggedIn := login(t, handler)
+	resetToken, err := accountsService.IssueToken(accountId, TokenPurposePasswordReset, PasswordResetLifetime)
+	if err != nil {
+		t.Fatalf("IssueToken failed : %v\n", err)
+	}
+
+	_, err = handler.HandleCommand(commands.CreateCommand(commands.ChangeEmail{Token: loggedIn.AccessToken, Email: "new@example.com", Password: "wrong"}))
+	if !apperrors.Is(err, apperrors.AuthInvalidCredentials) {
+		t.Fatalf("ChangeEmail should require the password but returned %v", err)
+	}
+	_, err = handler.HandleCommand(commands.CreateCommand(commands.ChangeEmail{Token: loggedIn.AccessToken, Email: "new@example.com", Password: "password"}))
+	if err != nil {
+		t.Fatalf("ChangeEmail failed : %v\n", err)
+	}
+	if len(recorder.messages) != 2 || recorder.messages[0].To != "email@example.com" || recorder.messages[1].To != "new@example.com" {
+		t.Fatalf("ChangeEmail should notify the previous email and verify the new one but sent %v", recorder.messages)
+	}
+	account, err := accountsService.GetAccountByAccountId(accountId)
+	if err != nil || account.Email != "new@example.com" || account.EmailVerified() {
+		t.Fatalf("account should have an unverified new email but got %v (%v)", account, err)
+	}
+
+	// Tokens sent to the previous email can no longer be used
+	_, err = handler.HandleCommand(commands.CreateCommand(commands.ResetPassword{Token: resetToken, NewPassword: "other"}))
+	if !apperrors.Is(err, apperrors.AuthInvalidToken) {
+		t.Fatalf("ResetPassword should reject tokens sent to the previous email but returned %v", err)
+	}
+	_, err = handler.HandleCommand(commands.CreateCommand(commands.VerifyEmail{Token: recorder.tokenFromLink(t)}))
+	if err != nil {
+		t.Fatalf("VerifyEmail failed : %v\n", err)
+	}
+	if account, _ := accountsService.GetAccountByAccountId(accountId); !account.EmailVerified() {
+		t.Fatal("the new email should be verified")
+	}
+}
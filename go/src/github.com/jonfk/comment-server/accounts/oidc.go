@@ -0,0 +1,338 @@
+package accounts
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/apperrors"
+)
+
+// OIDCLoginLifetime is how long a user has to log in with the identity
+// provider once the login started.
+const OIDCLoginLifetime = 10 * time.Minute
+
+// OIDCProvider is an OpenID Connect identity provider accounts can log in
+// with, using the authorization code flow with PKCE (RFC 7636).
+type OIDCProvider struct {
+	// Name identifies the provider in commands, e.g. "google".
+	Name                  string `json:"name"`
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+
+	ClientId string `json:"-"`
+	// ClientSecret is empty for public clients which only rely on PKCE.
+	ClientSecret string `json:"-"`
+	// RedirectURL is where the provider sends the user back with the State
+	// and Code of CompleteOIDCLogin.
+	RedirectURL string `json:"-"`
+	// Scopes default to openid, email and profile.
+	Scopes []string `json:"-"`
+}
+
+// DiscoverOIDCProvider returns the endpoints of the provider of issuer
+// from its discovery document. Name and the client settings are left to be
+// set by the caller.
+func DiscoverOIDCProvider(client *http.Client, issuer string) (OIDCProvider, error) {
+	response, err := client.Get(strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return OIDCProvider{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return OIDCProvider{}, fmt.Errorf("discovery of %s failed with status %d", issuer, response.StatusCode)
+	}
+	var provider OIDCProvider
+	if err := json.NewDecoder(response.Body).Decode(&provider); err != nil {
+		return OIDCProvider{}, err
+	}
+	if provider.Issuer != issuer {
+		return OIDCProvider{}, fmt.Errorf("discovery of %s returned issuer %s", issuer, provider.Issuer)
+	}
+	return provider, nil
+}
+
+// OIDCLogin is a login started with an identity provider. Only the hash of
+// its state is stored.
+type OIDCLogin struct {
+	StateHash    []byte    `db:"state_hash"`
+	Provider     string    `db:"provider"`
+	CodeVerifier string    `db:"code_verifier"`
+	Nonce        string    `db:"nonce"`
+	CreatedOn    time.Time `db:"created_on"`
+	ExpiresAt    time.Time `db:"expires_at"`
+}
+
+// ExternalIdentity links the subject of an identity provider to an
+// account.
+type ExternalIdentity struct {
+	Provider  string    `db:"provider"`
+	Subject   string    `db:"subject"`
+	AccountId uuid.UUID `db:"account_id"`
+	LinkedOn  time.Time `db:"linked_on"`
+}
+
+// OIDCClaims are the claims of a verified ID token used by accounts.
+type OIDCClaims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+}
+
+func (a *Accounts) httpClient() *http.Client {
+	if a.HTTPClient != nil {
+		return a.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (a *Accounts) oidcProvider(name string) (OIDCProvider, error) {
+	for _, provider := range a.OIDCProviders {
+		if provider.Name == name {
+			return provider, nil
+		}
+	}
+	return OIDCProvider{}, apperrors.Newf(apperrors.CommandInvalid, "unknown identity provider %s", name)
+}
+
+// StartOIDCLogin stores a new login with provider and returns the URL of
+// the provider the user has to be sent to.
+func (a *Accounts) StartOIDCLogin(providerName string) (string, error) {
+	provider, err := a.oidcProvider(providerName)
+	if err != nil {
+		return "", err
+	}
+	state, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	codeVerifier, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	now := a.now().Round(time.Second)
+	err = a.store().InsertOIDCLogin(OIDCLogin{
+		StateHash:    hashToken(state),
+		Provider:     provider.Name,
+		CodeVerifier: codeVerifier,
+		Nonce:        nonce,
+		CreatedOn:    now,
+		ExpiresAt:    now.Add(OIDCLoginLifetime),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	scopes := provider.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", provider.ClientId)
+	params.Set("redirect_uri", provider.RedirectURL)
+	params.Set("scope", strings.Join(scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+	return provider.AuthorizationEndpoint + "?" + params.Encode(), nil
+}
+
+// CompleteOIDCLogin consumes the login of state, exchanges code for an ID
+// token and returns the provider and the verified claims of the token.
+func (a *Accounts) CompleteOIDCLogin(state, code string) (OIDCProvider, OIDCClaims, error) {
+	login, err := a.store().ConsumeOIDCLogin(hashToken(state), a.now())
+	if err != nil {
+		return OIDCProvider{}, OIDCClaims{}, err
+	}
+	provider, err := a.oidcProvider(login.Provider)
+	if err != nil {
+		return OIDCProvider{}, OIDCClaims{}, err
+	}
+
+	idToken, err := a.exchangeOIDCCode(provider, code, login.CodeVerifier)
+	if err != nil {
+		return OIDCProvider{}, OIDCClaims{}, err
+	}
+	claims, err := a.verifyIDToken(provider, idToken, login.Nonce)
+	if err != nil {
+		return OIDCProvider{}, OIDCClaims{}, apperrors.Wrap(err, apperrors.AuthInvalidToken, "Invalid ID token")
+	}
+	return provider, claims, nil
+}
+
+func (a *Accounts) exchangeOIDCCode(provider OIDCProvider, code, codeVerifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", provider.RedirectURL)
+	form.Set("client_id", provider.ClientId)
+	form.Set("code_verifier", codeVerifier)
+	if provider.ClientSecret != "" {
+		form.Set("client_secret", provider.ClientSecret)
+	}
+	response, err := a.httpClient().PostForm(provider.TokenEndpoint, form)
+	if err != nil {
+		return "", apperrors.Wrap(err, apperrors.Internal, "identity provider unavailable")
+	}
+	defer response.Body.Close()
+
+	var tokens struct {
+		IdToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&tokens); err != nil {
+		return "", apperrors.Wrap(err, apperrors.Internal, "invalid identity provider response")
+	}
+	if response.StatusCode != http.StatusOK || tokens.IdToken == "" {
+		return "", apperrors.Newf(apperrors.AuthInvalidCredentials, "identity provider rejected the login: %s", tokens.Error)
+	}
+	return tokens.IdToken, nil
+}
+
+// verifyIDToken checks the signature of an RS256 ID token with the keys
+// of the provider and its issuer, audience, expiry and nonce.
+func (a *Accounts) verifyIDToken(provider OIDCProvider, idToken, nonce string) (OIDCClaims, error) {
+	token, err := jwt.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		if token.Method.Alg() != AlgorithmRS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		keyId, _ := token.Header["kid"].(string)
+		return a.providerKey(provider, keyId)
+	})
+	if err != nil {
+		return OIDCClaims{}, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return OIDCClaims{}, fmt.Errorf("invalid ID token")
+	}
+	if !claims.VerifyIssuer(provider.Issuer, true) {
+		return OIDCClaims{}, fmt.Errorf("ID token issued by %v", claims["iss"])
+	}
+	if !audienceContains(claims["aud"], provider.ClientId) {
+		return OIDCClaims{}, fmt.Errorf("ID token issued for %v", claims["aud"])
+	}
+	if _, ok := claims["exp"]; !ok {
+		return OIDCClaims{}, fmt.Errorf("ID token without exp")
+	}
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+		return OIDCClaims{}, fmt.Errorf("ID token nonce doesn't match")
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return OIDCClaims{}, fmt.Errorf("ID token without sub")
+	}
+	email, _ := claims["email"].(string)
+	emailVerified, _ := claims["email_verified"].(bool)
+	preferredUsername, _ := claims["preferred_username"].(string)
+	return OIDCClaims{
+		Subject:           subject,
+		Email:             email,
+		EmailVerified:     emailVerified,
+		PreferredUsername: preferredUsername,
+	}, nil
+}
+
+func audienceContains(audience interface{}, clientId string) bool {
+	switch audience := audience.(type) {
+	case string:
+		return audience == clientId
+	case []interface{}:
+		for _, aud := range audience {
+			if aud == clientId {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// providerKey returns the RSA key keyId of the JWKS of provider.
+func (a *Accounts) providerKey(provider OIDCProvider, keyId string) (*rsa.PublicKey, error) {
+	response, err := a.httpClient().Get(provider.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	var jwks JWKS
+	if err := json.NewDecoder(response.Body).Decode(&jwks); err != nil {
+		return nil, err
+	}
+	for _, jwk := range jwks.Keys {
+		if jwk.KeyId != keyId || jwk.KeyType != "RSA" {
+			continue
+		}
+		modulus, err := base64.RawURLEncoding.DecodeString(jwk.Modulus)
+		if err != nil {
+			return nil, err
+		}
+		exponent, err := base64.RawURLEncoding.DecodeString(jwk.Exponent)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(modulus),
+			E: int(new(big.Int).SetBytes(exponent).Int64()),
+		}, nil
+	}
+	return nil, fmt.Errorf("no RSA key %s in the JWKS of %s", keyId, provider.Name)
+}
+
+func (a *Accounts) GetAccountByExternalIdentity(provider, subject string) (Account, error) {
+	return a.store().GetAccountByExternalIdentity(provider, subject)
+}
+
+// AvailableUsername returns username, or username followed by a random
+// suffix if it's already taken.
+func (a *Accounts) AvailableUsername(username string) (string, error) {
+	if username == "" {
+		username = "user"
+	}
+	candidate := username
+	for {
+		_, err := a.GetAccountByUsername(candidate)
+		if err == AccountNotFoundErr {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		suffix := make([]byte, 3)
+		if _, err := rand.Read(suffix); err != nil {
+			return "", err
+		}
+		candidate = username + "-" + hex.EncodeToString(suffix)
+	}
+}
+
+// usernameOf returns the username suggested by the claims of a new
+// account.
+func usernameOf(claims OIDCClaims) string {
+	if claims.PreferredUsername != "" {
+		return claims.PreferredUsername
+	}
+	return strings.SplitN(claims.Email, "@", 2)[0]
+}
@@ -0,0 +1,238 @@
+package accounts
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/apperrors"
+	"github.com/jonfk/comment-server/commands"
+	"github.com/jonfk/comment-server/events"
+)
+
+// mockIdentityProvider is a local OpenID Connect provider. Users are
+// "logged in" with authorize, which returns the code the provider would
+// redirect the user back with.
+type mockIdentityProvider struct {
+	*httptest.Server
+	key      *rsa.PrivateKey
+	clientId string
+
+	mutex sync.Mutex
+	codes map[string]mockAuthorization
+}
+
+type mockAuthorization struct {
+	challenge string
+	nonce     string
+	claims    jwt.MapClaims
+}
+
+func newMockIdentityProvider(t *testing.T) *mockIdentityProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey failed : %v\n", err)
+	}
+	idp := &mockIdentityProvider{key: key, clientId: "client-id", codes: map[string]mockAuthorization{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(JWKS{Keys: []JWK{{
+			KeyType:   "RSA",
+			KeyId:     "mock",
+			Use:       "sig",
+			Algorithm: AlgorithmRS256,
+			Modulus:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			Exponent:  base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", idp.token)
+	idp.Server = httptest.NewServer(mux)
+	return idp
+}
+
+// authorize returns a code for the login started at authorizationURL
+// of the user with subject and email.
+func (idp *mockIdentityProvider) authorize(t *testing.T, authorizationURL, subject, email string) (string, string) {
+	parsed, err := url.Parse(authorizationURL)
+	if err != nil {
+		t.Fatalf("invalid authorization URL : %v\n", err)
+	}
+	params := parsed.Query()
+	if params.Get("client_id") != idp.clientId || params.Get("code_challenge_method") != "S256" {
+		t.Fatalf("authorization URL should use PKCE for the client but was %s", authorizationURL)
+	}
+	code, err := generateToken()
+	if err != nil {
+		t.Fatalf("generateToken failed : %v\n", err)
+	}
+	idp.mutex.Lock()
+	defer idp.mutex.Unlock()
+	idp.codes[code] = mockAuthorization{
+		challenge: params.Get("code_challenge"),
+		nonce:     params.Get("nonce"),
+		claims:    jwt.MapClaims{"sub": subject, "email": email, "email_verified": true},
+	}
+	return params.Get("state"), code
+}
+
+func (idp *mockIdentityProvider) token(w http.ResponseWriter, r *http.Request) {
+	idp.mutex.Lock()
+	authorization, ok := idp.codes[r.PostFormValue("code")]
+	delete(idp.codes, r.PostFormValue("code"))
+	idp.mutex.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != authorization.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	claims := jwt.MapClaims{
+		"iss":   idp.URL,
+		"aud":   idp.clientId,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": authorization.nonce,
+	}
+	for name, value := range authorization.claims {
+		claims[name] = value
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "mock"
+	idToken, err := token.SignedString(idp.key)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "token_type": "Bearer"})
+}
+
+func TestOIDCLogin(t *testing.T) {
+	idp := newMockIdentityProvider(t)
+	defer idp.Close()
+	handler, accountId := newSessionsTestHandler(t)
+	provider, err := DiscoverOIDCProvider(http.DefaultClient, idp.URL)
+	if err != nil {
+		t.Fatalf("DiscoverOIDCProvider failed : %v\n", err)
+	}
+	provider.Name, provider.ClientId, provider.RedirectURL = "mock", idp.clientId, "https://example.com/oidc/callback"
+	handler.AccountsService.OIDCProviders = []OIDCProvider{provider}
+
+	handle := func(command commands.CommandPayload) (events.EventPayload, error) {
+		response, err := handler.HandleCommand(commands.CreateCommand(command))
+		return response.Event.Payload, err
+	}
+	startLogin := func() string {
+		response, err := handler.HandleCommand(commands.CreateCommand(commands.StartOIDCLogin{Provider: "mock"}))
+		if err != nil {
+			t.Fatalf("StartOIDCLogin failed : %v\n", err)
+		}
+		if _, ok := response.Event.Payload.(events.OIDCLoginStarted); !ok || response.Secrets == nil {
+			t.Fatalf("StartOIDCLogin should return an authorization URL but returned %#v", response)
+		}
+		return response.Secrets.AuthorizationURL
+	}
+	loginAs := func(subject, email string) (events.EventPayload, error) {
+		state, code := idp.authorize(t, startLogin(), subject, email)
+		return handle(commands.CompleteOIDCLogin{State: state, Code: code})
+	}
+
+	// A new identity creates an account
+	event, err := loginAs("new-subject", "new@example.com")
+	if err != nil {
+		t.Fatalf("CompleteOIDCLogin failed : %v\n", err)
+	}
+	loggedIn := event.(events.AccountLoggedIn)
+	created, err := handler.AccountsService.GetAccountByEmail("new@example.com")
+	if err != nil || !uuid.Equal(created.AccountId, loggedIn.AccountId) || created.Username != "new" || !created.EmailVerified() {
+		t.Fatalf("CompleteOIDCLogin should create a verified account for a new identity but got %v (%v)", created, err)
+	}
+	if err := handler.AccountsService.Verify(created.AccountId, ""); !apperrors.Is(err, apperrors.AuthInvalidCredentials) {
+		t.Fatalf("accounts created with an identity provider should have no password but Verify returned %v", err)
+	}
+
+	// A linked identity logs in to its account
+	event, err = loginAs("new-subject", "new@example.com")
+	if err != nil {
+		t.Fatalf("CompleteOIDCLogin failed : %v\n", err)
+	}
+	if !uuid.Equal(event.(events.AccountLoggedIn).AccountId, created.AccountId) {
+		t.Fatalf("CompleteOIDCLogin should log in to the linked account but returned %v", event)
+	}
+
+	// Identities are only linked to existing accounts by their owner
+	if _, err := loginAs("other-subject", "email@example.com"); !apperrors.Is(err, apperrors.AccountEmailTaken) {
+		t.Fatalf("CompleteOIDCLogin should not link an identity to the account of its email but returned %v", err)
+	}
+	state, code := idp.authorize(t, startLogin(), "other-subject", "email@example.com")
+	event, err = handle(commands.CompleteOIDCLogin{State: state, Code: code, Token: login(t, handler).AccessToken})
+	if err != nil {
+		t.Fatalf("CompleteOIDCLogin failed : %v\n", err)
+	}
+	if linked := event.(events.ExternalIdentityLinked); !uuid.Equal(linked.AccountId, accountId) || linked.NewAccount {
+		t.Fatalf("CompleteOIDCLogin should link the identity to the logged in account but returned %v", linked)
+	}
+	if account, err := handler.AccountsService.GetAccountByExternalIdentity("mock", "other-subject"); err != nil || !uuid.Equal(account.AccountId, accountId) {
+		t.Fatalf("identity should be linked to the account : %v", err)
+	}
+
+	// States are single-use
+	if _, err := handle(commands.CompleteOIDCLogin{State: state, Code: code}); !apperrors.Is(err, apperrors.AuthInvalidToken) {
+		t.Fatalf("CompleteOIDCLogin should reject a used state but returned %v", err)
+	}
+}
+
+func TestOIDCLoginRequiresCodeVerifier(t *testing.T) {
+	idp := newMockIdentityProvider(t)
+	defer idp.Close()
+	handler, _ := newSessionsTestHandler(t)
+	handler.AccountsService.OIDCProviders = []OIDCProvider{{
+		Name:                  "mock",
+		Issuer:                idp.URL,
+		AuthorizationEndpoint: idp.URL + "/authorize",
+		TokenEndpoint:         idp.URL + "/token",
+		JWKSURI:               idp.URL + "/jwks",
+		ClientId:              idp.clientId,
+	}}
+
+	// A code intercepted by another client can't be exchanged without the
+	// code verifier of the login it was issued for
+	first, err := handler.AccountsService.StartOIDCLogin("mock")
+	if err != nil {
+		t.Fatalf("StartOIDCLogin failed : %v\n", err)
+	}
+	second, err := handler.AccountsService.StartOIDCLogin("mock")
+	if err != nil {
+		t.Fatalf("StartOIDCLogin failed : %v\n", err)
+	}
+	_, code := idp.authorize(t, first, "subject", "subject@example.com")
+	secondState, _ := idp.authorize(t, second, "subject", "subject@example.com")
+	_, err = handler.HandleCommand(commands.CreateCommand(commands.CompleteOIDCLogin{State: secondState, Code: code}))
+	if !apperrors.Is(err, apperrors.AuthInvalidCredentials) {
+		t.Fatalf("CompleteOIDCLogin should fail without the right code verifier but returned %v", err)
+	}
+
+	if _, err := handler.AccountsService.StartOIDCLogin("unknown"); !apperrors.Is(err, apperrors.CommandInvalid) {
+		t.Fatalf("StartOIDCLogin should reject unknown providers but returned %v", err)
+	}
+}
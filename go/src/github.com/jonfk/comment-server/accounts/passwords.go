@@ -0,0 +1,216 @@
+package accounts
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Algorithms of the password hashes, named after their PHC string id.
+const (
+	PasswordAlgorithmArgon2id = "argon2id"
+	PasswordAlgorithmScrypt   = "scrypt"
+)
+
+// Parameters of the hashes computed by HashPassword before passwords were
+// stored as PHC strings.
+const (
+	legacyScryptLogN   = 14
+	legacyScryptR      = 8
+	legacyScryptP      = 1
+	legacyScryptKeyLen = 32
+)
+
+// PasswordPolicy decides how new passwords are hashed. Passwords hashed
+// with weaker parameters are rehashed when their owner logs in.
+type PasswordPolicy struct {
+	// Algorithm is PasswordAlgorithmArgon2id or PasswordAlgorithmScrypt.
+	Algorithm string
+
+	// Argon2Time is the number of passes and Argon2Memory the memory used
+	// in KiB.
+	Argon2Time    uint32
+	Argon2Memory  uint32
+	Argon2Threads uint8
+
+	// ScryptLogN is the base 2 logarithm of the scrypt cost N.
+	ScryptLogN uint8
+	ScryptR    int
+	ScryptP    int
+
+	SaltLength int
+	KeyLength  int
+}
+
+// DefaultPasswordPolicy follows the OWASP recommendations for argon2id.
+var DefaultPasswordPolicy = PasswordPolicy{
+	Algorithm:     PasswordAlgorithmArgon2id,
+	Argon2Time:    3,
+	Argon2Memory:  64 * 1024,
+	Argon2Threads: 2,
+	ScryptLogN:    15,
+	ScryptR:       8,
+	ScryptP:       1,
+	SaltLength:    16,
+	KeyLength:     32,
+}
+
+// passwordHash is a decoded PHC string:
+// $<algorithm>$[v=<version>$]<param>=<value>,...$<salt>$<hash>
+type passwordHash struct {
+	Algorithm string
+	Version   int
+	Params    map[string]int
+	Salt      []byte
+	Hash      []byte
+}
+
+func (h passwordHash) String() string {
+	params := []string{}
+	switch h.Algorithm {
+	case PasswordAlgorithmArgon2id:
+		params = append(params, fmt.Sprintf("m=%d", h.Params["m"]), fmt.Sprintf("t=%d", h.Params["t"]), fmt.Sprintf("p=%d", h.Params["p"]))
+	case PasswordAlgorithmScrypt:
+		params = append(params, fmt.Sprintf("ln=%d", h.Params["ln"]), fmt.Sprintf("r=%d", h.Params["r"]), fmt.Sprintf("p=%d", h.Params["p"]))
+	}
+	version := ""
+	if h.Version != 0 {
+		version = fmt.Sprintf("$v=%d", h.Version)
+	}
+	return fmt.Sprintf("$%s%s$%s$%s$%s", h.Algorithm, version, strings.Join(params, ","),
+		base64.RawStdEncoding.EncodeToString(h.Salt), base64.RawStdEncoding.EncodeToString(h.Hash))
+}
+
+func parsePasswordHash(encoded string) (passwordHash, error) {
+	fields := strings.Split(encoded, "$")
+	if len(fields) < 5 || fields[0] != "" {
+		return passwordHash{}, fmt.Errorf("invalid PHC string")
+	}
+	h := passwordHash{Algorithm: fields[1], Params: map[string]int{}}
+	fields = fields[2:]
+	if strings.HasPrefix(fields[0], "v=") {
+		version, err := strconv.Atoi(strings.TrimPrefix(fields[0], "v="))
+		if err != nil {
+			return passwordHash{}, fmt.Errorf("invalid PHC version %s", fields[0])
+		}
+		h.Version = version
+		fields = fields[1:]
+	}
+	if len(fields) != 3 {
+		return passwordHash{}, fmt.Errorf("invalid PHC string")
+	}
+	for _, param := range strings.Split(fields[0], ",") {
+		parts := strings.SplitN(param, "=", 2)
+		value, err := strconv.Atoi(parts[len(parts)-1])
+		if len(parts) != 2 || err != nil {
+			return passwordHash{}, fmt.Errorf("invalid PHC parameter %s", param)
+		}
+		h.Params[parts[0]] = value
+	}
+	var err error
+	if h.Salt, err = base64.RawStdEncoding.DecodeString(fields[1]); err != nil {
+		return passwordHash{}, err
+	}
+	if h.Hash, err = base64.RawStdEncoding.DecodeString(fields[2]); err != nil {
+		return passwordHash{}, err
+	}
+	return h, nil
+}
+
+// key derives the key of password with the algorithm, parameters and salt
+// of h.
+func (h passwordHash) key(password string, keyLength int) ([]byte, error) {
+	switch h.Algorithm {
+	case PasswordAlgorithmArgon2id:
+		if h.Version != argon2.Version {
+			return nil, fmt.Errorf("unsupported argon2 version %d", h.Version)
+		}
+		return argon2.IDKey([]byte(password), h.Salt, uint32(h.Params["t"]), uint32(h.Params["m"]), uint8(h.Params["p"]), uint32(keyLength)), nil
+	case PasswordAlgorithmScrypt:
+		return scrypt.Key([]byte(password), h.Salt, 1<<uint(h.Params["ln"]), h.Params["r"], h.Params["p"], keyLength)
+	}
+	return nil, fmt.Errorf("unsupported password hash algorithm %s", h.Algorithm)
+}
+
+// Hash returns the PHC string of password hashed following the policy.
+func (p PasswordPolicy) Hash(password string) (string, error) {
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	h := passwordHash{Algorithm: p.Algorithm, Salt: salt}
+	switch p.Algorithm {
+	case PasswordAlgorithmArgon2id:
+		h.Version = argon2.Version
+		h.Params = map[string]int{"m": int(p.Argon2Memory), "t": int(p.Argon2Time), "p": int(p.Argon2Threads)}
+	case PasswordAlgorithmScrypt:
+		h.Params = map[string]int{"ln": int(p.ScryptLogN), "r": p.ScryptR, "p": p.ScryptP}
+	default:
+		return "", fmt.Errorf("unsupported password hash algorithm %s", p.Algorithm)
+	}
+	var err error
+	if h.Hash, err = h.key(password, p.KeyLength); err != nil {
+		return "", err
+	}
+	return h.String(), nil
+}
+
+// NeedsRehash reports whether encoded was hashed with another algorithm or
+// weaker parameters than the policy.
+func (p PasswordPolicy) NeedsRehash(encoded string) bool {
+	h, err := parsePasswordHash(encoded)
+	if err != nil || h.Algorithm != p.Algorithm || len(h.Salt) < p.SaltLength || len(h.Hash) < p.KeyLength {
+		return true
+	}
+	switch h.Algorithm {
+	case PasswordAlgorithmArgon2id:
+		return h.Version != argon2.Version ||
+			h.Params["m"] < int(p.Argon2Memory) ||
+			h.Params["t"] < int(p.Argon2Time) ||
+			h.Params["p"] < int(p.Argon2Threads)
+	case PasswordAlgorithmScrypt:
+		return h.Params["ln"] < int(p.ScryptLogN) ||
+			h.Params["r"] < p.ScryptR ||
+			h.Params["p"] < p.ScryptP
+	}
+	return true
+}
+
+// VerifyPassword reports whether password matches the PHC string encoded.
+func VerifyPassword(encoded, password string) (bool, error) {
+	h, err := parsePasswordHash(encoded)
+	if err != nil {
+		return false, err
+	}
+	key, err := h.key(password, len(h.Hash))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, h.Hash) == 1, nil
+}
+
+// legacyPasswordHash returns the PHC string of a hash computed by
+// HashPassword.
+func legacyPasswordHash(hashedPassword, salt []byte) string {
+	return passwordHash{
+		Algorithm: PasswordAlgorithmScrypt,
+		Params:    map[string]int{"ln": legacyScryptLogN, "r": legacyScryptR, "p": legacyScryptP},
+		Salt:      salt,
+		Hash:      hashedPassword,
+	}.String()
+}
+
+// passwordHashOf returns passwordHash, or the PHC string of the legacy
+// hashedPassword and salt for accounts and events predating PHC strings.
+func passwordHashOf(passwordHash string, hashedPassword, salt []byte) string {
+	if passwordHash == "" && len(hashedPassword) > 0 {
+		return legacyPasswordHash(hashedPassword, salt)
+	}
+	return passwordHash
+}
@@ -0,0 +1,130 @@
+package accounts
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/commands"
+	"github.com/jonfk/comment-server/events"
+)
+
+// testPasswordPolicy is cheap to hash with so that tests stay fast
+var testPasswordPolicy = PasswordPolicy{
+	Algorithm:     PasswordAlgorithmArgon2id,
+	Argon2Time:    1,
+	Argon2Memory:  1024,
+	Argon2Threads: 1,
+	ScryptLogN:    10,
+	ScryptR:       8,
+	ScryptP:       1,
+	SaltLength:    16,
+	KeyLength:     32,
+}
+
+func TestPasswordPolicy_Hash_and_VerifyPassword(t *testing.T) {
+	scryptPolicy := testPasswordPolicy
+	scryptPolicy.Algorithm = PasswordAlgorithmScrypt
+
+	for _, policy := range []PasswordPolicy{testPasswordPolicy, scryptPolicy} {
+		encoded, err := policy.Hash("password")
+		if err != nil {
+			t.Fatalf("Hash failed : %v\n", err)
+		}
+		if !strings.HasPrefix(encoded, "$"+policy.Algorithm+"$") {
+			t.Fatalf("Hash should return a PHC string for %s but returned %s", policy.Algorithm, encoded)
+		}
+		if other, _ := policy.Hash("password"); other == encoded {
+			t.Fatal("Hash should salt every hash")
+		}
+		if ok, err := VerifyPassword(encoded, "password"); err != nil || !ok {
+			t.Fatalf("VerifyPassword should accept the password of %s : %v", encoded, err)
+		}
+		if ok, err := VerifyPassword(encoded, "wrong password"); err != nil || ok {
+			t.Fatalf("VerifyPassword should reject another password for %s : %v", encoded, err)
+		}
+		if policy.NeedsRehash(encoded) {
+			t.Fatalf("%s should not need a rehash with the policy that hashed it", encoded)
+		}
+	}
+
+	if _, err := VerifyPassword("not a PHC string", "password"); err == nil {
+		t.Fatal("VerifyPassword should fail on invalid PHC strings")
+	}
+}
+
+func TestPasswordPolicy_NeedsRehash(t *testing.T) {
+	weaker := testPasswordPolicy
+	weaker.Argon2Memory = testPasswordPolicy.Argon2Memory / 2
+	weakHash, err := weaker.Hash("password")
+	if err != nil {
+		t.Fatalf("Hash failed : %v\n", err)
+	}
+	if !testPasswordPolicy.NeedsRehash(weakHash) {
+		t.Fatal("hashes with less memory than the policy should be rehashed")
+	}
+
+	salt, err := GenerateSalt()
+	if err != nil {
+		t.Fatalf("GenerateSalt failed : %v\n", err)
+	}
+	hashedPassword, err := HashPassword("password", salt)
+	if err != nil {
+		t.Fatalf("HashPassword failed : %v\n", err)
+	}
+	legacyHash := legacyPasswordHash(hashedPassword, salt)
+	if ok, err := VerifyPassword(legacyHash, "password"); err != nil || !ok {
+		t.Fatalf("VerifyPassword should accept passwords hashed by HashPassword : %v", err)
+	}
+	if !testPasswordPolicy.NeedsRehash(legacyHash) {
+		t.Fatal("passwords hashed by HashPassword should be rehashed")
+	}
+}
+
+func TestLoginRehashesPassword(t *testing.T) {
+	policy := testPasswordPolicy
+	accountsService := &Accounts{
+		Store:                NewMemoryStore(),
+		HMACSecretKey:        []byte("secret_key"),
+		SessionLengthInHours: 1,
+		PasswordPolicy:       &policy,
+	}
+	handler := &CommandHandler{
+		AccountsService: accountsService,
+		EventHandler:    &EventHandler{AccountsService: accountsService},
+	}
+	accountId := uuid.NewV4()
+	err := handler.EventHandler.HandleEvent(events.NewEventNow(accountCreated(t, accountId, "username", "email@example.com", "password")))
+	if err != nil {
+		t.Fatalf("HandleEvent failed : %v\n", err)
+	}
+
+	login := commands.CreateCommand(commands.LoginAccount{Email: "email@example.com", Password: "password"})
+	if _, err := handler.HandleCommand(login); err != nil {
+		t.Fatalf("LoginAccount failed : %v\n", err)
+	}
+	account, err := accountsService.GetAccountByAccountId(accountId)
+	if err != nil {
+		t.Fatalf("GetAccountByAccountId failed : %v\n", err)
+	}
+	if account.HashedPassword != nil || !strings.HasPrefix(account.PasswordHash, "$argon2id$") {
+		t.Fatalf("LoginAccount should rehash legacy passwords with the policy but the account has %v", account)
+	}
+
+	// A stronger policy rehashes again on the next login
+	policy.Argon2Time++
+	if _, err := handler.HandleCommand(login); err != nil {
+		t.Fatalf("LoginAccount failed : %v\n", err)
+	}
+	rehashed, err := accountsService.GetAccountByAccountId(accountId)
+	if err != nil {
+		t.Fatalf("GetAccountByAccountId failed : %v\n", err)
+	}
+	if rehashed.PasswordHash == account.PasswordHash || policy.NeedsRehash(rehashed.PasswordHash) {
+		t.Fatalf("LoginAccount should rehash passwords hashed with weaker parameters")
+	}
+	if err := accountsService.Verify(accountId, "password"); err != nil {
+		t.Fatalf("rehashed password should still verify : %v", err)
+	}
+}
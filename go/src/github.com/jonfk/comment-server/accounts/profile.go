@@ -0,0 +1,63 @@
+package accounts
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/apperrors"
+)
+
+// UsernameChange is an entry of the username history of an account.
+type UsernameChange struct {
+	AccountId   uuid.UUID `db:"account_id" json:"accountId"`
+	OldUsername string    `db:"old_username" json:"oldUsername"`
+	NewUsername string    `db:"new_username" json:"newUsername"`
+	ChangedOn   time.Time `db:"changed_on" json:"changedOn"`
+}
+
+// UsernameHistory returns the username changes of an account, oldest
+// first.
+func (a *Accounts) UsernameHistory(accountId uuid.UUID) ([]UsernameChange, error) {
+	return a.store().ListUsernameChanges(accountId)
+}
+
+// CheckUsernameAvailable returns UsernameTakenErr if a registered account
+// already uses username.
+func (a *Accounts) CheckUsernameAvailable(username string) error {
+	_, err := a.GetAccountByUsername(username)
+	if err == nil {
+		return UsernameTakenErr
+	}
+	if err == AccountNotFoundErr {
+		return nil
+	}
+	return err
+}
+
+// CheckEmailAvailable returns EmailTakenErr if a registered account
+// already uses email.
+func (a *Accounts) CheckEmailAvailable(email string) error {
+	_, err := a.GetAccountByEmail(email)
+	if err == nil {
+		return EmailTakenErr
+	}
+	if err == AccountNotFoundErr {
+		return nil
+	}
+	return err
+}
+
+// validateAvatarURL only accepts absolute http and https URLs so that
+// clients can safely use avatars as image sources.
+func validateAvatarURL(avatarURL string) error {
+	if avatarURL == "" {
+		return nil
+	}
+	parsed, err := url.Parse(avatarURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return apperrors.New(apperrors.CommandInvalid, "The avatar must be an http or https URL")
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package accounts
+
+import (
+	"testing"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/apperrors"
+	"github.com/jonfk/comment-server/commands"
+	"github.com/jonfk/comment-server/events"
+)
+
+func TestChangeUsername(t *testing.T) {
+	handler, accountId := newSessionsTestHandler(t)
+	err := handler.EventHandler.HandleEvent(events.NewEventNow(accountCreated(t, uuid.NewV4(), "other", "other@example.com", "password")))
+	if err != nil {
+		t.Fatalf("HandleEvent failed : %v\n", err)
+	}
+	loggedIn := login(t, handler)
+	changeUsername := func(username string) error {
+		_, err := handler.HandleCommand(commands.CreateCommand(commands.ChangeUsername{Token: loggedIn.AccessToken, Username: username}))
+		return err
+	}
+
+	if err := changeUsername("other"); !apperrors.Is(err, apperrors.AccountUsernameTaken) {
+		t.Fatalf("ChangeUsername should reject a taken username but returned %v", err)
+	}
+	if err := changeUsername("renamed"); err != nil {
+		t.Fatalf("ChangeUsername failed : %v\n", err)
+	}
+	if err := changeUsername("final"); err != nil {
+		t.Fatalf("ChangeUsername failed : %v\n", err)
+	}
+
+	if account, err := handler.AccountsService.GetAccountByUsername("final"); err != nil || !uuid.Equal(account.AccountId, accountId) {
+		t.Fatalf("account should be found by its new username : %v", err)
+	}
+	// Previous usernames are free for other accounts
+	if err := handler.AccountsService.CheckUsernameAvailable("username"); err != nil {
+		t.Fatalf("previous username should be available but got %v", err)
+	}
+	history, err := handler.AccountsService.UsernameHistory(accountId)
+	if err != nil {
+		t.Fatalf("UsernameHistory failed : %v\n", err)
+	}
+	if len(history) != 2 || history[0].OldUsername != "username" || history[0].NewUsername != "renamed" ||
+		history[1].OldUsername != "renamed" || history[1].NewUsername != "final" {
+		t.Fatalf("UsernameHistory should return both changes in order but returned %v", history)
+	}
+}
+
+func TestUpdateProfile(t *testing.T) {
+	handler, accountId := newSessionsTestHandler(t)
+	loggedIn := login(t, handler)
+	updateProfile := func(displayName, avatarURL string) error {
+		_, err := handler.HandleCommand(commands.CreateCommand(commands.UpdateProfile{Token: loggedIn.AccessToken, DisplayName: displayName, AvatarURL: avatarURL}))
+		return err
+	}
+
+	for _, avatarURL := range []string{"javascript:alert(1)", "/avatar.png", "ftp://example.com/avatar.png"} {
+		if err := updateProfile("Name", avatarURL); !apperrors.Is(err, apperrors.CommandInvalid) {
+			t.Fatalf("UpdateProfile should reject the avatar %s but returned %v", avatarURL, err)
+		}
+	}
+	if err := updateProfile(" Name ", "https://example.com/avatar.png"); err != nil {
+		t.Fatalf("UpdateProfile failed : %v\n", err)
+	}
+	account, err := handler.AccountsService.GetAccountByAccountId(accountId)
+	if err != nil || account.DisplayName != "Name" || account.AvatarURL != "https://example.com/avatar.png" {
+		t.Fatalf("UpdateProfile should update the profile but got %v (%v)", account, err)
+	}
+}
@@ -0,0 +1,132 @@
+package accounts
+
+import (
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+const DefaultAccessTokenLifetime = 15 * time.Minute
+
+// Session is a login of an account. The access tokens of a session are
+// short-lived JWTs and it is kept alive by exchanging refresh tokens, which
+// are rotated on every use. Revoking a session invalidates all its tokens.
+type Session struct {
+	SessionId uuid.UUID  `db:"session_id"`
+	AccountId uuid.UUID  `db:"account_id"`
+	CreatedOn time.Time  `db:"created_on"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	RevokedOn *time.Time `db:"revoked_on"`
+}
+
+// Active reports whether the tokens of the session can still be used at now.
+func (s Session) Active(now time.Time) bool {
+	return s.RevokedOn == nil && s.ExpiresAt.After(now)
+}
+
+// RefreshToken is a single-use token exchanged for new tokens of a session.
+// Only the hash of the token is stored.
+type RefreshToken struct {
+	TokenHash []byte    `db:"token_hash"`
+	SessionId uuid.UUID `db:"session_id"`
+	CreatedOn time.Time `db:"created_on"`
+}
+
+// SessionTokens are the tokens given to a client for a session.
+type SessionTokens struct {
+	AccountId    uuid.UUID
+	SessionId    uuid.UUID
+	AccessToken  string
+	RefreshToken string
+}
+
+func (a *Accounts) accessTokenLifetime() time.Duration {
+	if a.AccessTokenLifetime > 0 {
+		return a.AccessTokenLifetime
+	}
+	return DefaultAccessTokenLifetime
+}
+
+func (a *Accounts) now() time.Time {
+	if a.Now != nil {
+		return a.Now().UTC()
+	}
+	return time.Now().UTC()
+}
+
+// StartSession starts a new session for an account that has already been
+// authenticated. The session lasts SessionLengthInHours.
+func (a *Accounts) StartSession(accountId uuid.UUID) (SessionTokens, error) {
+	now := a.now().Round(time.Second)
+	session := Session{
+		SessionId: uuid.NewV4(),
+		AccountId: accountId,
+		CreatedOn: now,
+		ExpiresAt: now.Add(time.Duration(a.SessionLengthInHours) * time.Hour),
+	}
+	if err := a.store().InsertSession(session); err != nil {
+		return SessionTokens{}, err
+	}
+
+	refreshToken, err := generateToken()
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	err = a.store().InsertRefreshToken(RefreshToken{
+		TokenHash: hashToken(refreshToken),
+		SessionId: session.SessionId,
+		CreatedOn: now,
+	})
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	return a.sessionTokens(session, refreshToken)
+}
+
+// RefreshSession exchanges a refresh token for new tokens of its session.
+// A refresh token can only be used once: using it again is taken as a sign
+// that it leaked and revokes the whole session.
+func (a *Accounts) RefreshSession(refreshToken string) (SessionTokens, error) {
+	nextRefreshToken, err := generateToken()
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	now := a.now().Round(time.Second)
+	session, err := a.store().RotateRefreshToken(hashToken(refreshToken), RefreshToken{
+		TokenHash: hashToken(nextRefreshToken),
+		CreatedOn: now,
+	}, now)
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	return a.sessionTokens(session, nextRefreshToken)
+}
+
+func (a *Accounts) sessionTokens(session Session, refreshToken string) (SessionTokens, error) {
+	// iat is backdated by a second so that tokens are valid right away
+	// even if the clock of the validating server is a little behind
+	issuedAt := a.now().Round(time.Second).Add(-1 * time.Second)
+	expiresAt := issuedAt.Add(a.accessTokenLifetime())
+	if expiresAt.After(session.ExpiresAt) {
+		expiresAt = session.ExpiresAt
+	}
+	signingKey, err := a.signingKey()
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	accessToken, err := generateSessionJWT(signingKey, sessionClaims{
+		TokenId:   uuid.NewV4(),
+		SessionId: session.SessionId,
+		AccountId: session.AccountId,
+		IssuedAt:  issuedAt,
+	}, expiresAt)
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	return SessionTokens{
+		AccountId:    session.AccountId,
+		SessionId:    session.SessionId,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+	}, nil
+}
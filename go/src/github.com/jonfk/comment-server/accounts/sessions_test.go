@@ -0,0 +1,199 @@
+package accounts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/apperrors"
+	"github.com/jonfk/comment-server/commands"
+	"github.com/jonfk/comment-server/events"
+)
+
+func newSessionsTestHandler(t *testing.T) (*CommandHandler, uuid.UUID) {
+	accountsService := &Accounts{
+		Store:                NewMemoryStore(),
+		HMACSecretKey:        []byte("secret_key"),
+		SessionLengthInHours: 1,
+		PasswordPolicy:       &testPasswordPolicy,
+	}
+	handler := &CommandHandler{
+		AccountsService: accountsService,
+		EventHandler:    &EventHandler{AccountsService: accountsService},
+	}
+	accountId := uuid.NewV4()
+	err := handler.EventHandler.HandleEvent(events.NewEventNow(accountCreated(t, accountId, "username", "email@example.com", "password")))
+	if err != nil {
+		t.Fatalf("HandleEvent failed : %v\n", err)
+	}
+	return handler, accountId
+}
+
+// session is the event and the secrets returned when logging in.
+type session struct {
+	events.AccountLoggedIn
+	commands.Secrets
+}
+
+func login(t *testing.T, handler *CommandHandler) session {
+	response, err := handler.HandleCommand(commands.CreateCommand(commands.LoginAccount{Email: "email@example.com", Password: "password"}))
+	if err != nil {
+		t.Fatalf("LoginAccount failed : %v\n", err)
+	}
+	if response.Secrets == nil || response.Secrets.RefreshToken == "" {
+		t.Fatal("LoginAccount should return a refresh token")
+	}
+	return session{response.Event.Payload.(events.AccountLoggedIn), *response.Secrets}
+}
+
+func TestRefreshSession(t *testing.T) {
+	handler, accountId := newSessionsTestHandler(t)
+	loggedIn := login(t, handler)
+
+	response, err := handler.HandleCommand(commands.CreateCommand(commands.RefreshSession{RefreshToken: loggedIn.RefreshToken}))
+	if err != nil {
+		t.Fatalf("RefreshSession failed : %v\n", err)
+	}
+	refreshed := response.Secrets
+	if event := response.Event.Payload.(events.SessionRefreshed); !uuid.Equal(event.SessionId, loggedIn.SessionId) || refreshed.RefreshToken == loggedIn.RefreshToken {
+		t.Fatalf("RefreshSession should rotate the refresh token of the session but returned %v", response)
+	}
+	if validatedAccountId, err := handler.AccountsService.ValidateJWT(refreshed.AccessToken); err != nil || !uuid.Equal(validatedAccountId, accountId) {
+		t.Fatalf("RefreshSession returned an invalid JWT : %v", err)
+	}
+
+	// Reusing a refresh token revokes the whole session
+	_, err = handler.HandleCommand(commands.CreateCommand(commands.RefreshSession{RefreshToken: loggedIn.RefreshToken}))
+	if !apperrors.Is(err, apperrors.AuthInvalidToken) {
+		t.Fatalf("refresh token should only be usable once but returned %v", err)
+	}
+	if _, err := handler.AccountsService.ValidateJWT(refreshed.AccessToken); !apperrors.Is(err, apperrors.AuthInvalidToken) {
+		t.Fatalf("access tokens of a revoked session should be invalid but returned %v", err)
+	}
+	_, err = handler.HandleCommand(commands.CreateCommand(commands.RefreshSession{RefreshToken: refreshed.RefreshToken}))
+	if !apperrors.Is(err, apperrors.AuthInvalidToken) {
+		t.Fatalf("refresh tokens of a revoked session should be invalid but returned %v", err)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	handler, _ := newSessionsTestHandler(t)
+	loggedIn, otherLoggedIn := login(t, handler), login(t, handler)
+
+	_, err := handler.HandleCommand(commands.CreateCommand(commands.Logout{Token: loggedIn.AccessToken}))
+	if err != nil {
+		t.Fatalf("Logout failed : %v\n", err)
+	}
+	if _, err := handler.AccountsService.ValidateJWT(loggedIn.AccessToken); !apperrors.Is(err, apperrors.AuthInvalidToken) {
+		t.Fatalf("access token should be revoked by Logout but returned %v", err)
+	}
+	_, err = handler.HandleCommand(commands.CreateCommand(commands.RefreshSession{RefreshToken: loggedIn.RefreshToken}))
+	if !apperrors.Is(err, apperrors.AuthInvalidToken) {
+		t.Fatalf("refresh token should be revoked by Logout but returned %v", err)
+	}
+	if _, err := handler.AccountsService.ValidateJWT(otherLoggedIn.AccessToken); err != nil {
+		t.Fatalf("Logout should not end the other sessions : %v", err)
+	}
+
+	_, err = handler.HandleCommand(commands.CreateCommand(commands.LogoutEverywhere{Token: otherLoggedIn.AccessToken}))
+	if err != nil {
+		t.Fatalf("LogoutEverywhere failed : %v\n", err)
+	}
+	if _, err := handler.AccountsService.ValidateJWT(otherLoggedIn.AccessToken); !apperrors.Is(err, apperrors.AuthInvalidToken) {
+		t.Fatalf("access token should be revoked by LogoutEverywhere but returned %v", err)
+	}
+	_, err = handler.HandleCommand(commands.CreateCommand(commands.Logout{Token: otherLoggedIn.AccessToken}))
+	if !apperrors.Is(err, apperrors.AuthInvalidToken) {
+		t.Fatalf("Logout should require a valid access token but returned %v", err)
+	}
+}
+
+func TestSessionsFollowClock(t *testing.T) {
+	handler, _ := newSessionsTestHandler(t)
+	now := time.Now().UTC()
+	handler.AccountsService.Now = func() time.Time { return now }
+
+	loggedIn := login(t, handler)
+	if _, err := handler.AccountsService.ValidateJWT(loggedIn.AccessToken); err != nil {
+		t.Fatalf("ValidateJWT failed : %v\n", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, err := handler.AccountsService.ValidateJWT(loggedIn.AccessToken); !apperrors.Is(err, apperrors.AuthInvalidToken) {
+		t.Fatalf("ValidateJWT should reject the token of a session that expired by the clock of the service but returned %v", err)
+	}
+}
+
+func TestGuestSession(t *testing.T) {
+	handler, _ := newSessionsTestHandler(t)
+
+	// Guests can use the email of an account, which stays the account of
+	// the email
+	response, err := handler.HandleCommand(commands.CreateCommand(commands.CreateGuestAccount{DisplayName: "guest", Email: "email@example.com"}))
+	if err != nil {
+		t.Fatalf("CreateGuestAccount failed : %v\n", err)
+	}
+	loggedIn, ok := response.Event.Payload.(events.AccountLoggedIn)
+	if !ok || response.Secrets == nil || response.Secrets.RefreshToken == "" {
+		t.Fatalf("CreateGuestAccount should log in the guest but returned %#v", response)
+	}
+	accountId, err := handler.AccountsService.ValidateJWT(response.Secrets.AccessToken)
+	if err != nil || !uuid.Equal(accountId, loggedIn.AccountId) {
+		t.Fatalf("CreateGuestAccount returned an invalid JWT : %v", err)
+	}
+	guest, err := handler.AccountsService.GetAccountByAccountId(accountId)
+	if err != nil || !guest.Guest() || guest.DisplayName != "guest" {
+		t.Fatalf("CreateGuestAccount should create a guest but got %v (%v)", guest, err)
+	}
+	if account, err := handler.AccountsService.GetAccountByEmail("email@example.com"); err != nil || account.Guest() {
+		t.Fatalf("GetAccountByEmail should only return registered accounts but got %v (%v)", account, err)
+	}
+	login(t, handler)
+}
+
+func TestClaimGuestIdentity(t *testing.T) {
+	handler, accountId := newSessionsTestHandler(t)
+	handle := func(command commands.CommandPayload) (events.EventPayload, error) {
+		response, err := handler.HandleCommand(commands.CreateCommand(command))
+		return response.Event.Payload, err
+	}
+	response, err := handler.HandleCommand(commands.CreateCommand(commands.CreateGuestAccount{DisplayName: "guest"}))
+	if err != nil {
+		t.Fatalf("CreateGuestAccount failed : %v\n", err)
+	}
+	guest := session{response.Event.Payload.(events.AccountLoggedIn), *response.Secrets}
+	loggedIn := login(t, handler)
+
+	claim := commands.ClaimGuestIdentity{Token: loggedIn.AccessToken, GuestToken: guest.AccessToken}
+	if _, err := handle(claim); !apperrors.Is(err, apperrors.AccountEmailNotVerified) {
+		t.Fatalf("ClaimGuestIdentity should require a verified email but returned %v", err)
+	}
+	err = handler.EventHandler.HandleEvent(events.NewEventNow(events.EmailVerified{AccountId: accountId, Email: "email@example.com"}))
+	if err != nil {
+		t.Fatalf("HandleEvent failed : %v\n", err)
+	}
+	if _, err := handle(commands.ClaimGuestIdentity{Token: loggedIn.AccessToken, GuestToken: loggedIn.AccessToken}); !apperrors.Is(err, apperrors.CommandInvalid) {
+		t.Fatalf("ClaimGuestIdentity should only claim guests but returned %v", err)
+	}
+
+	event, err := handle(claim)
+	if err != nil {
+		t.Fatalf("ClaimGuestIdentity failed : %v\n", err)
+	}
+	if claimed := event.(events.GuestIdentityClaimed); !uuid.Equal(claimed.AccountId, accountId) || !uuid.Equal(claimed.GuestAccountId, guest.AccountId) {
+		t.Fatalf("ClaimGuestIdentity returned %v", claimed)
+	}
+	claimedGuest, err := handler.AccountsService.GetAccountByAccountId(guest.AccountId)
+	if err != nil || claimedGuest.ClaimedBy == nil || !uuid.Equal(*claimedGuest.ClaimedBy, accountId) {
+		t.Fatalf("guest should be claimed by the account but got %v (%v)", claimedGuest, err)
+	}
+
+	// The guest session ends once claimed
+	if _, err := handler.AccountsService.ValidateJWT(guest.AccessToken); !apperrors.Is(err, apperrors.AuthInvalidToken) {
+		t.Fatalf("sessions of a claimed guest should be revoked but ValidateJWT returned %v", err)
+	}
+	if _, err := handle(claim); !apperrors.Is(err, apperrors.AuthInvalidToken) {
+		t.Fatalf("a guest should only be claimed once but ClaimGuestIdentity returned %v", err)
+	}
+}
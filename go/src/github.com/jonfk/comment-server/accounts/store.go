@@ -0,0 +1,709 @@
+package accounts
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/database"
+)
+
+// Store persists accounts. Implementations return AccountNotFoundErr,
+// UsernameTakenErr and EmailTakenErr rather than their own errors.
+type Store interface {
+	InsertAccount(Account) (Account, error)
+	DeleteById(accountId uuid.UUID) (string, error)
+	// AnonymizeAccount turns an account into a deleted account, removing
+	// its personal data, credentials, sessions, tokens and the failed
+	// logins recorded for its email.
+	AnonymizeAccount(accountId uuid.UUID, deletedOn time.Time) error
+	GetAccountByAccountId(accountId uuid.UUID) (Account, error)
+	// GetAccountByEmail and GetAccountByUsername only return registered
+	// accounts.
+	GetAccountByEmail(email string) (Account, error)
+	GetAccountByUsername(username string) (Account, error)
+
+	// UpdatePassword replaces the password of an account and invalidates
+	// the sessions issued before sessionsValidFrom.
+	UpdatePassword(accountId uuid.UUID, passwordHash string, sessionsValidFrom time.Time) error
+	// RehashPassword replaces the hash of the password of an account with
+	// passwordHash, which hashes the same password.
+	RehashPassword(accountId uuid.UUID, passwordHash string) error
+
+	// MarkEmailVerified records that email was verified for an account. It
+	// does nothing if the email of the account is no longer email.
+	MarkEmailVerified(accountId uuid.UUID, email string, verifiedOn time.Time) error
+
+	// ChangeUsername replaces the username of an account and records the
+	// change in its username history.
+	ChangeUsername(accountId uuid.UUID, username string, changedOn time.Time) error
+	// ListUsernameChanges returns the username history of an account,
+	// oldest first.
+	ListUsernameChanges(accountId uuid.UUID) ([]UsernameChange, error)
+	// ChangeEmail replaces the email of an account, marks it unverified and
+	// invalidates the unused tokens sent to the previous email.
+	ChangeEmail(accountId uuid.UUID, email string) error
+	// UpdateProfile replaces the display name of an account and, when
+	// avatarURL isn't empty, its avatar.
+	UpdateProfile(accountId uuid.UUID, displayName, avatarURL string) error
+	// SetAvatarKey replaces the avatar of an account with an uploaded one.
+	SetAvatarKey(accountId uuid.UUID, avatarKey string) error
+
+	// InsertBan stores a ban and, for global bans, revokes the sessions of
+	// the banned account.
+	InsertBan(Ban) error
+	// GetBan returns BanNotFoundErr if the ban doesn't exist.
+	GetBan(banId uuid.UUID) (Ban, error)
+	ListBans(accountId uuid.UUID) ([]Ban, error)
+	LiftBan(banId uuid.UUID, liftedBy *uuid.UUID, liftedOn time.Time) error
+
+	InsertAPIToken(APIToken) error
+	// GetAPIToken and GetAPITokenByHash return APITokenNotFoundErr if the
+	// token doesn't exist.
+	GetAPIToken(apiTokenId uuid.UUID) (APIToken, error)
+	GetAPITokenByHash(tokenHash []byte) (APIToken, error)
+	ListAPITokens(accountId uuid.UUID) ([]APIToken, error)
+	RevokeAPIToken(apiTokenId uuid.UUID, revokedOn time.Time) error
+	RecordAPITokenUse(apiTokenId uuid.UUID, usedOn time.Time) error
+
+	// ClaimGuest records that a guest was claimed by accountId and revokes
+	// the sessions of the guest.
+	ClaimGuest(guestAccountId, accountId uuid.UUID, now time.Time) error
+
+	// LockAccount locks an account until lockedUntil.
+	LockAccount(accountId uuid.UUID, lockedUntil time.Time) error
+	UnlockAccount(accountId uuid.UUID) error
+
+	// RecordLoginFailure counts a failed login attempt for key and returns
+	// its failures. GetLoginFailures returns no failures for unknown keys.
+	RecordLoginFailure(key string, now time.Time) (LoginFailures, error)
+	GetLoginFailures(key string) (LoginFailures, error)
+	ClearLoginFailures(key string) error
+
+	// SetTOTPSecret stores the secret of a TOTP enrollment, disabling the
+	// previous one. EnableTOTP confirms it.
+	SetTOTPSecret(accountId uuid.UUID, secret []byte) error
+	EnableTOTP(accountId uuid.UUID, enabledOn time.Time) error
+	// DisableTOTP removes the TOTP secret and recovery codes of an account.
+	DisableTOTP(accountId uuid.UUID) error
+	// UseTOTPStep records that a code of step was used. It returns
+	// InvalidCredentialsErr if a code of step or a later one was already
+	// used.
+	UseTOTPStep(accountId uuid.UUID, step int64) error
+	// InsertRecoveryCodes replaces the recovery codes of an account.
+	InsertRecoveryCodes(accountId uuid.UUID, codeHashes [][]byte) error
+	// UseRecoveryCode marks an unused recovery code as used. It returns
+	// InvalidCredentialsErr if there is no such unused code.
+	UseRecoveryCode(accountId uuid.UUID, codeHash []byte, now time.Time) error
+
+	InsertOIDCLogin(OIDCLogin) error
+	// ConsumeOIDCLogin atomically marks the unused and unexpired login with
+	// stateHash as used and returns it, or InvalidTokenErr.
+	ConsumeOIDCLogin(stateHash []byte, now time.Time) (OIDCLogin, error)
+	InsertExternalIdentity(ExternalIdentity) error
+	// GetAccountByExternalIdentity returns AccountNotFoundErr if no account
+	// is linked to the subject of provider.
+	GetAccountByExternalIdentity(provider, subject string) (Account, error)
+
+	InsertToken(AccountToken) error
+	// ConsumeToken atomically marks the unused and unexpired token with
+	// tokenHash as used and returns its account id.
+	ConsumeToken(purpose string, tokenHash []byte, now time.Time) (uuid.UUID, error)
+
+	InsertSession(Session) error
+	// GetSession returns InvalidTokenErr if the session doesn't exist.
+	GetSession(sessionId uuid.UUID) (Session, error)
+	InsertRefreshToken(RefreshToken) error
+	// RotateRefreshToken atomically marks the refresh token with tokenHash
+	// as used and stores next for the same session, which it returns. If
+	// the token was already used the session is revoked. InvalidTokenErr is
+	// returned if the token doesn't exist, was already used or its session
+	// is no longer active at now.
+	RotateRefreshToken(tokenHash []byte, next RefreshToken, now time.Time) (Session, error)
+	RevokeSession(sessionId uuid.UUID, now time.Time) error
+	// RevokeSessions revokes every active session of an account.
+	RevokeSessions(accountId uuid.UUID, now time.Time) error
+
+	InsertSigningKey(SigningKey) error
+	// GetSigningKey returns SigningKeyNotFoundErr if the key doesn't exist.
+	GetSigningKey(keyId string) (SigningKey, error)
+	ListSigningKeys() ([]SigningKey, error)
+	ActivateSigningKey(keyId string, now time.Time) error
+	RetireSigningKey(keyId string, now time.Time) error
+}
+
+const accountColumns = "account_id,account_type,COALESCE(username,'') AS username,COALESCE(display_name,'') AS display_name,COALESCE(avatar_url,'') AS avatar_url,COALESCE(avatar_key,'') AS avatar_key,COALESCE(email,'') AS email,COALESCE(password_hash,'') AS password_hash,hashed_password,hash_salt,created_on,sessions_valid_from,email_verified_on,locked_until,totp_secret,totp_enabled_on,totp_last_step,claimed_by"
+
+const signingKeyColumns = "key_id,algorithm,secret,created_on,activated_on,retired_on"
+
+// accountTypeOf defaults accounts without type to registered accounts.
+func accountTypeOf(account Account) string {
+	if account.AccountType == "" {
+		return AccountTypeRegistered
+	}
+	return account.AccountType
+}
+
+// DBStore is a Store backed by the accounts table.
+type DBStore struct {
+	// DB is a *sqlx.DB, or a *sqlx.Tx to bind the store to a transaction.
+	DB database.Handle
+}
+
+func (s *DBStore) InsertAccount(account Account) (Account, error) {
+	var newAccount Account
+	err := s.DB.QueryRowx(`INSERT INTO accounts (account_id,account_type,username,display_name,email,password_hash,hashed_password,hash_salt,created_on)
+VALUES ($1,$2,NULLIF($3,''),NULLIF($4,''),NULLIF($5,''),NULLIF($6,''),$7,$8,$9) RETURNING `+accountColumns,
+		account.AccountId, accountTypeOf(account), account.Username, account.DisplayName, account.Email,
+		account.PasswordHash, account.HashedPassword, account.HashSalt, account.CreatedOn).StructScan(&newAccount)
+
+	return newAccount, translateDBError(err)
+}
+
+func (s *DBStore) DeleteById(accountId uuid.UUID) (string, error) {
+	var deletedAccountId string
+	err := s.DB.QueryRowx("DELETE FROM accounts where account_id = $1 RETURNING account_id", accountId).Scan(&deletedAccountId)
+
+	return deletedAccountId, translateDBError(err)
+}
+
+func (s *DBStore) AnonymizeAccount(accountId uuid.UUID, deletedOn time.Time) error {
+	tx, err := database.Begin(s.DB)
+	if err != nil {
+		return translateDBError(err)
+	}
+	defer tx.Rollback()
+
+	var email sql.NullString
+	if err := tx.Get(&email, "SELECT email FROM accounts where account_id = $1", accountId); err != nil {
+		return translateDBError(err)
+	}
+	if email.Valid {
+		if _, err := tx.Exec("DELETE FROM login_failures where key = $1", emailLoginKey(email.String)); err != nil {
+			return translateDBError(err)
+		}
+	}
+	_, err = tx.Exec(`UPDATE accounts SET account_type = 'deleted', username = NULL, display_name = NULL, avatar_url = NULL,
+avatar_key = NULL, email = NULL, password_hash = NULL, hashed_password = NULL, hash_salt = NULL, sessions_valid_from = $1,
+email_verified_on = NULL, locked_until = NULL, totp_secret = NULL, totp_enabled_on = NULL, totp_last_step = 0
+where account_id = $2`, deletedOn, accountId)
+	if err != nil {
+		return translateDBError(err)
+	}
+	for _, table := range []string{"sessions", "account_tokens", "recovery_codes", "external_identities", "username_changes", "api_tokens"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" where account_id = $1", accountId); err != nil {
+			return translateDBError(err)
+		}
+	}
+	return translateDBError(tx.Commit())
+}
+
+func (s *DBStore) GetAccountByAccountId(accountId uuid.UUID) (Account, error) {
+	var account Account
+	err := s.DB.Get(&account, "SELECT "+accountColumns+" FROM accounts where account_id = $1",
+		accountId)
+	if err != nil {
+		return account, translateDBError(err)
+	}
+	return account, nil
+}
+
+func (s *DBStore) GetAccountByEmail(email string) (Account, error) {
+	var account Account
+	err := s.DB.Get(&account, "SELECT "+accountColumns+" FROM accounts where email = $1 AND account_type = 'registered'",
+		email)
+	if err != nil {
+		return account, translateDBError(err)
+	}
+	return account, nil
+}
+
+func (s *DBStore) GetAccountByUsername(username string) (Account, error) {
+	var account Account
+	err := s.DB.Get(&account, "SELECT "+accountColumns+" FROM accounts where username = $1 AND account_type = 'registered'",
+		username)
+	if err != nil {
+		return account, translateDBError(err)
+	}
+	return account, nil
+}
+
+func (s *DBStore) UpdatePassword(accountId uuid.UUID, passwordHash string, sessionsValidFrom time.Time) error {
+	result, err := s.DB.Exec("UPDATE accounts SET password_hash = $1, hashed_password = NULL, hash_salt = NULL, sessions_valid_from = $2 where account_id = $3",
+		passwordHash, sessionsValidFrom, accountId)
+	if err != nil {
+		return translateDBError(err)
+	}
+	if updated, err := result.RowsAffected(); err == nil && updated == 0 {
+		return AccountNotFoundErr
+	}
+	return nil
+}
+
+func (s *DBStore) RehashPassword(accountId uuid.UUID, passwordHash string) error {
+	result, err := s.DB.Exec("UPDATE accounts SET password_hash = $1, hashed_password = NULL, hash_salt = NULL where account_id = $2",
+		passwordHash, accountId)
+	if err != nil {
+		return translateDBError(err)
+	}
+	if updated, err := result.RowsAffected(); err == nil && updated == 0 {
+		return AccountNotFoundErr
+	}
+	return nil
+}
+
+func (s *DBStore) MarkEmailVerified(accountId uuid.UUID, email string, verifiedOn time.Time) error {
+	_, err := s.DB.Exec("UPDATE accounts SET email_verified_on = $1 where account_id = $2 AND email = $3",
+		verifiedOn, accountId, email)
+	return translateDBError(err)
+}
+
+func (s *DBStore) ChangeUsername(accountId uuid.UUID, username string, changedOn time.Time) error {
+	tx, err := database.Begin(s.DB)
+	if err != nil {
+		return translateDBError(err)
+	}
+	defer tx.Rollback()
+
+	var oldUsername string
+	err = tx.QueryRowx("SELECT username FROM accounts where account_id = $1 FOR UPDATE", accountId).Scan(&oldUsername)
+	if err != nil {
+		return translateDBError(err)
+	}
+	if _, err := tx.Exec("UPDATE accounts SET username = $1 where account_id = $2", username, accountId); err != nil {
+		return translateDBError(err)
+	}
+	_, err = tx.Exec("INSERT INTO username_changes (account_id,old_username,new_username,changed_on) VALUES ($1,$2,$3,$4)",
+		accountId, oldUsername, username, changedOn)
+	if err != nil {
+		return translateDBError(err)
+	}
+	return translateDBError(tx.Commit())
+}
+
+func (s *DBStore) ListUsernameChanges(accountId uuid.UUID) ([]UsernameChange, error) {
+	changes := []UsernameChange{}
+	err := s.DB.Select(&changes, "SELECT account_id,old_username,new_username,changed_on FROM username_changes where account_id = $1 ORDER BY changed_on",
+		accountId)
+	return changes, translateDBError(err)
+}
+
+func (s *DBStore) ChangeEmail(accountId uuid.UUID, email string) error {
+	tx, err := database.Begin(s.DB)
+	if err != nil {
+		return translateDBError(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE accounts SET email = NULLIF($1,''), email_verified_on = NULL where account_id = $2", email, accountId)
+	if err != nil {
+		return translateDBError(err)
+	}
+	if _, err := tx.Exec("DELETE FROM account_tokens where account_id = $1 AND used_on IS NULL", accountId); err != nil {
+		return translateDBError(err)
+	}
+	return translateDBError(tx.Commit())
+}
+
+func (s *DBStore) UpdateProfile(accountId uuid.UUID, displayName, avatarURL string) error {
+	_, err := s.DB.Exec(`UPDATE accounts SET display_name = NULLIF($1,''),
+avatar_url = COALESCE(NULLIF($2,''), avatar_url), avatar_key = CASE WHEN $2 = '' THEN avatar_key END
+where account_id = $3`,
+		displayName, avatarURL, accountId)
+	return translateDBError(err)
+}
+
+func (s *DBStore) SetAvatarKey(accountId uuid.UUID, avatarKey string) error {
+	_, err := s.DB.Exec("UPDATE accounts SET avatar_key = $1, avatar_url = NULL where account_id = $2", avatarKey, accountId)
+	return translateDBError(err)
+}
+
+const banColumns = "ban_id,account_id,COALESCE(website,'') AS website,suspension,COALESCE(reason,'') AS reason,hide_comments,banned_by,created_on,expires_at,lifted_on,lifted_by"
+
+func (s *DBStore) InsertBan(ban Ban) error {
+	tx, err := database.Begin(s.DB)
+	if err != nil {
+		return translateDBError(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO bans (ban_id,account_id,website,suspension,reason,hide_comments,banned_by,created_on,expires_at)
+VALUES ($1,$2,NULLIF($3,''),$4,NULLIF($5,''),$6,$7,$8,$9)`,
+		ban.BanId, ban.AccountId, ban.Website, ban.Suspension, ban.Reason, ban.HideComments, ban.BannedBy, ban.CreatedOn, ban.ExpiresAt)
+	if err != nil {
+		return translateDBError(err)
+	}
+	if ban.Global() {
+		_, err = tx.Exec("UPDATE sessions SET revoked_on = $1 where account_id = $2 AND revoked_on IS NULL", ban.CreatedOn, ban.AccountId)
+		if err != nil {
+			return translateDBError(err)
+		}
+	}
+	return translateDBError(tx.Commit())
+}
+
+func (s *DBStore) GetBan(banId uuid.UUID) (Ban, error) {
+	var ban Ban
+	err := s.DB.Get(&ban, "SELECT "+banColumns+" FROM bans where ban_id = $1", banId)
+	if err == sql.ErrNoRows {
+		return Ban{}, BanNotFoundErr
+	}
+	return ban, translateDBError(err)
+}
+
+func (s *DBStore) ListBans(accountId uuid.UUID) ([]Ban, error) {
+	bans := []Ban{}
+	err := s.DB.Select(&bans, "SELECT "+banColumns+" FROM bans where account_id = $1 ORDER BY created_on", accountId)
+	return bans, translateDBError(err)
+}
+
+func (s *DBStore) LiftBan(banId uuid.UUID, liftedBy *uuid.UUID, liftedOn time.Time) error {
+	_, err := s.DB.Exec("UPDATE bans SET lifted_on = $1, lifted_by = $2 where ban_id = $3 AND lifted_on IS NULL",
+		liftedOn, liftedBy, banId)
+	return translateDBError(err)
+}
+
+const apiTokenColumns = "api_token_id,account_id,name,prefix,token_hash,scopes,created_on,expires_at,last_used_on,revoked_on"
+
+// apiTokenRow is an APIToken with its scopes separated by spaces.
+type apiTokenRow struct {
+	APIToken
+	ScopeList string `db:"scopes"`
+}
+
+func (row apiTokenRow) apiToken() APIToken {
+	token := row.APIToken
+	token.Scopes = strings.Fields(row.ScopeList)
+	return token
+}
+
+func (s *DBStore) InsertAPIToken(token APIToken) error {
+	_, err := s.DB.Exec(`INSERT INTO api_tokens (api_token_id,account_id,name,prefix,token_hash,scopes,created_on,expires_at)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8)`,
+		token.APITokenId, token.AccountId, token.Name, token.Prefix, token.TokenHash, strings.Join(token.Scopes, " "), token.CreatedOn, token.ExpiresAt)
+	return translateDBError(err)
+}
+
+func (s *DBStore) GetAPIToken(apiTokenId uuid.UUID) (APIToken, error) {
+	return s.getAPIToken("api_token_id = $1", apiTokenId)
+}
+
+func (s *DBStore) GetAPITokenByHash(tokenHash []byte) (APIToken, error) {
+	return s.getAPIToken("token_hash = $1", tokenHash)
+}
+
+func (s *DBStore) getAPIToken(where string, arg interface{}) (APIToken, error) {
+	var row apiTokenRow
+	err := s.DB.Get(&row, "SELECT "+apiTokenColumns+" FROM api_tokens where "+where, arg)
+	if err == sql.ErrNoRows {
+		return APIToken{}, APITokenNotFoundErr
+	}
+	return row.apiToken(), translateDBError(err)
+}
+
+func (s *DBStore) ListAPITokens(accountId uuid.UUID) ([]APIToken, error) {
+	rows := []apiTokenRow{}
+	err := s.DB.Select(&rows, "SELECT "+apiTokenColumns+" FROM api_tokens where account_id = $1 ORDER BY created_on", accountId)
+	tokens := make([]APIToken, 0, len(rows))
+	for _, row := range rows {
+		tokens = append(tokens, row.apiToken())
+	}
+	return tokens, translateDBError(err)
+}
+
+func (s *DBStore) RevokeAPIToken(apiTokenId uuid.UUID, revokedOn time.Time) error {
+	_, err := s.DB.Exec("UPDATE api_tokens SET revoked_on = $1 where api_token_id = $2 AND revoked_on IS NULL", revokedOn, apiTokenId)
+	return translateDBError(err)
+}
+
+func (s *DBStore) RecordAPITokenUse(apiTokenId uuid.UUID, usedOn time.Time) error {
+	_, err := s.DB.Exec("UPDATE api_tokens SET last_used_on = $1 where api_token_id = $2", usedOn, apiTokenId)
+	return translateDBError(err)
+}
+
+func (s *DBStore) ClaimGuest(guestAccountId, accountId uuid.UUID, now time.Time) error {
+	tx, err := database.Begin(s.DB)
+	if err != nil {
+		return translateDBError(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE accounts SET claimed_by = $1 where account_id = $2 AND account_type = 'guest'", accountId, guestAccountId)
+	if err != nil {
+		return translateDBError(err)
+	}
+	_, err = tx.Exec("UPDATE sessions SET revoked_on = $1 where account_id = $2 AND revoked_on IS NULL", now, guestAccountId)
+	if err != nil {
+		return translateDBError(err)
+	}
+	return translateDBError(tx.Commit())
+}
+
+func (s *DBStore) LockAccount(accountId uuid.UUID, lockedUntil time.Time) error {
+	_, err := s.DB.Exec("UPDATE accounts SET locked_until = $1 where account_id = $2", lockedUntil, accountId)
+	return translateDBError(err)
+}
+
+func (s *DBStore) UnlockAccount(accountId uuid.UUID) error {
+	_, err := s.DB.Exec("UPDATE accounts SET locked_until = NULL where account_id = $1", accountId)
+	return translateDBError(err)
+}
+
+func (s *DBStore) RecordLoginFailure(key string, now time.Time) (LoginFailures, error) {
+	var failures LoginFailures
+	err := s.DB.QueryRowx(`INSERT INTO login_failures (key,failures,last_failure_on) VALUES ($1,1,$2)
+ON CONFLICT (key) DO UPDATE SET failures = login_failures.failures + 1, last_failure_on = EXCLUDED.last_failure_on
+RETURNING key,failures,last_failure_on`, key, now).StructScan(&failures)
+	return failures, translateDBError(err)
+}
+
+func (s *DBStore) GetLoginFailures(key string) (LoginFailures, error) {
+	var failures LoginFailures
+	err := s.DB.Get(&failures, "SELECT key,failures,last_failure_on FROM login_failures where key = $1", key)
+	if err == sql.ErrNoRows {
+		return LoginFailures{Key: key}, nil
+	}
+	return failures, translateDBError(err)
+}
+
+func (s *DBStore) ClearLoginFailures(key string) error {
+	_, err := s.DB.Exec("DELETE FROM login_failures where key = $1", key)
+	return translateDBError(err)
+}
+
+func (s *DBStore) SetTOTPSecret(accountId uuid.UUID, secret []byte) error {
+	_, err := s.DB.Exec("UPDATE accounts SET totp_secret = $1, totp_enabled_on = NULL, totp_last_step = 0 where account_id = $2",
+		secret, accountId)
+	return translateDBError(err)
+}
+
+func (s *DBStore) EnableTOTP(accountId uuid.UUID, enabledOn time.Time) error {
+	_, err := s.DB.Exec("UPDATE accounts SET totp_enabled_on = $1 where account_id = $2 AND totp_secret IS NOT NULL",
+		enabledOn, accountId)
+	return translateDBError(err)
+}
+
+func (s *DBStore) DisableTOTP(accountId uuid.UUID) error {
+	tx, err := database.Begin(s.DB)
+	if err != nil {
+		return translateDBError(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE accounts SET totp_secret = NULL, totp_enabled_on = NULL, totp_last_step = 0 where account_id = $1", accountId)
+	if err != nil {
+		return translateDBError(err)
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes where account_id = $1", accountId); err != nil {
+		return translateDBError(err)
+	}
+	return translateDBError(tx.Commit())
+}
+
+func (s *DBStore) UseTOTPStep(accountId uuid.UUID, step int64) error {
+	result, err := s.DB.Exec("UPDATE accounts SET totp_last_step = $1 where account_id = $2 AND totp_last_step < $1",
+		step, accountId)
+	if err != nil {
+		return translateDBError(err)
+	}
+	if updated, err := result.RowsAffected(); err == nil && updated == 0 {
+		return InvalidCredentialsErr
+	}
+	return nil
+}
+
+func (s *DBStore) InsertRecoveryCodes(accountId uuid.UUID, codeHashes [][]byte) error {
+	tx, err := database.Begin(s.DB)
+	if err != nil {
+		return translateDBError(err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM recovery_codes where account_id = $1", accountId); err != nil {
+		return translateDBError(err)
+	}
+	for _, codeHash := range codeHashes {
+		if _, err := tx.Exec("INSERT INTO recovery_codes (code_hash,account_id) VALUES ($1,$2)", codeHash, accountId); err != nil {
+			return translateDBError(err)
+		}
+	}
+	return translateDBError(tx.Commit())
+}
+
+func (s *DBStore) UseRecoveryCode(accountId uuid.UUID, codeHash []byte, now time.Time) error {
+	result, err := s.DB.Exec("UPDATE recovery_codes SET used_on = $1 where account_id = $2 AND code_hash = $3 AND used_on IS NULL",
+		now, accountId, codeHash)
+	if err != nil {
+		return translateDBError(err)
+	}
+	if updated, err := result.RowsAffected(); err == nil && updated == 0 {
+		return InvalidCredentialsErr
+	}
+	return nil
+}
+
+func (s *DBStore) InsertOIDCLogin(login OIDCLogin) error {
+	_, err := s.DB.Exec("INSERT INTO oidc_logins (state_hash,provider,code_verifier,nonce,created_on,expires_at) VALUES ($1,$2,$3,$4,$5,$6)",
+		login.StateHash, login.Provider, login.CodeVerifier, login.Nonce, login.CreatedOn, login.ExpiresAt)
+	return translateDBError(err)
+}
+
+func (s *DBStore) ConsumeOIDCLogin(stateHash []byte, now time.Time) (OIDCLogin, error) {
+	var login OIDCLogin
+	err := s.DB.QueryRowx(`UPDATE oidc_logins SET used_on = $1
+WHERE state_hash = $2 AND used_on IS NULL AND expires_at > $1
+RETURNING state_hash,provider,code_verifier,nonce,created_on,expires_at`, now, stateHash).StructScan(&login)
+	if err == sql.ErrNoRows {
+		return OIDCLogin{}, InvalidTokenErr
+	}
+	return login, translateDBError(err)
+}
+
+func (s *DBStore) InsertExternalIdentity(identity ExternalIdentity) error {
+	_, err := s.DB.Exec("INSERT INTO external_identities (provider,subject,account_id,linked_on) VALUES ($1,$2,$3,$4) ON CONFLICT DO NOTHING",
+		identity.Provider, identity.Subject, identity.AccountId, identity.LinkedOn)
+	return translateDBError(err)
+}
+
+func (s *DBStore) GetAccountByExternalIdentity(provider, subject string) (Account, error) {
+	var account Account
+	err := s.DB.Get(&account, "SELECT "+accountColumns+" FROM accounts where account_id = (SELECT account_id FROM external_identities where provider = $1 AND subject = $2)",
+		provider, subject)
+	return account, translateDBError(err)
+}
+
+func (s *DBStore) InsertToken(token AccountToken) error {
+	_, err := s.DB.Exec("INSERT INTO account_tokens (token_hash,account_id,purpose,created_on,expires_at) VALUES ($1,$2,$3,$4,$5)",
+		token.TokenHash, token.AccountId, token.Purpose, token.CreatedOn, token.ExpiresAt)
+	return translateDBError(err)
+}
+
+func (s *DBStore) ConsumeToken(purpose string, tokenHash []byte, now time.Time) (uuid.UUID, error) {
+	var accountId uuid.UUID
+	err := s.DB.QueryRowx("UPDATE account_tokens SET used_on = $1 where token_hash = $2 AND purpose = $3 AND used_on IS NULL AND expires_at > $1 RETURNING account_id",
+		now, tokenHash, purpose).Scan(&accountId)
+	if err == sql.ErrNoRows {
+		return uuid.Nil, InvalidTokenErr
+	}
+	return accountId, translateDBError(err)
+}
+
+func (s *DBStore) InsertSession(session Session) error {
+	_, err := s.DB.Exec("INSERT INTO sessions (session_id,account_id,created_on,expires_at) VALUES ($1,$2,$3,$4)",
+		session.SessionId, session.AccountId, session.CreatedOn, session.ExpiresAt)
+	return translateDBError(err)
+}
+
+func (s *DBStore) GetSession(sessionId uuid.UUID) (Session, error) {
+	var session Session
+	err := s.DB.Get(&session, "SELECT session_id,account_id,created_on,expires_at,revoked_on FROM sessions where session_id = $1",
+		sessionId)
+	if err == sql.ErrNoRows {
+		return session, InvalidTokenErr
+	}
+	return session, translateDBError(err)
+}
+
+func (s *DBStore) InsertRefreshToken(token RefreshToken) error {
+	_, err := s.DB.Exec("INSERT INTO refresh_tokens (token_hash,session_id,created_on) VALUES ($1,$2,$3)",
+		token.TokenHash, token.SessionId, token.CreatedOn)
+	return translateDBError(err)
+}
+
+func (s *DBStore) RotateRefreshToken(tokenHash []byte, next RefreshToken, now time.Time) (Session, error) {
+	tx, err := database.Begin(s.DB)
+	if err != nil {
+		return Session{}, translateDBError(err)
+	}
+	defer tx.Rollback()
+
+	var row struct {
+		Session
+		UsedOn *time.Time `db:"used_on"`
+	}
+	err = tx.Get(&row, `SELECT s.session_id,s.account_id,s.created_on,s.expires_at,s.revoked_on,t.used_on
+FROM refresh_tokens t JOIN sessions s ON s.session_id = t.session_id
+WHERE t.token_hash = $1 FOR UPDATE`, tokenHash)
+	if err == sql.ErrNoRows {
+		return Session{}, InvalidTokenErr
+	}
+	if err != nil {
+		return Session{}, translateDBError(err)
+	}
+	if !row.Session.Active(now) {
+		return Session{}, InvalidTokenErr
+	}
+	if row.UsedOn != nil {
+		if _, err := tx.Exec("UPDATE sessions SET revoked_on = $1 where session_id = $2", now, row.SessionId); err != nil {
+			return Session{}, translateDBError(err)
+		}
+		if err := tx.Commit(); err != nil {
+			return Session{}, translateDBError(err)
+		}
+		return Session{}, InvalidTokenErr
+	}
+
+	if _, err := tx.Exec("UPDATE refresh_tokens SET used_on = $1 where token_hash = $2", now, tokenHash); err != nil {
+		return Session{}, translateDBError(err)
+	}
+	if _, err := tx.Exec("INSERT INTO refresh_tokens (token_hash,session_id,created_on) VALUES ($1,$2,$3)",
+		next.TokenHash, row.SessionId, next.CreatedOn); err != nil {
+		return Session{}, translateDBError(err)
+	}
+	return row.Session, translateDBError(tx.Commit())
+}
+
+func (s *DBStore) RevokeSession(sessionId uuid.UUID, now time.Time) error {
+	_, err := s.DB.Exec("UPDATE sessions SET revoked_on = $1 where session_id = $2 AND revoked_on IS NULL",
+		now, sessionId)
+	return translateDBError(err)
+}
+
+func (s *DBStore) RevokeSessions(accountId uuid.UUID, now time.Time) error {
+	_, err := s.DB.Exec("UPDATE sessions SET revoked_on = $1 where account_id = $2 AND revoked_on IS NULL",
+		now, accountId)
+	return translateDBError(err)
+}
+
+func (s *DBStore) InsertSigningKey(key SigningKey) error {
+	_, err := s.DB.Exec("INSERT INTO signing_keys (key_id,algorithm,secret,created_on) VALUES ($1,$2,$3,$4)",
+		key.KeyId, key.Algorithm, key.Secret, key.CreatedOn)
+	return translateDBError(err)
+}
+
+func (s *DBStore) GetSigningKey(keyId string) (SigningKey, error) {
+	var key SigningKey
+	err := s.DB.Get(&key, "SELECT "+signingKeyColumns+" FROM signing_keys where key_id = $1", keyId)
+	if err == sql.ErrNoRows {
+		return key, SigningKeyNotFoundErr
+	}
+	return key, translateDBError(err)
+}
+
+func (s *DBStore) ListSigningKeys() ([]SigningKey, error) {
+	keys := []SigningKey{}
+	err := s.DB.Select(&keys, "SELECT "+signingKeyColumns+" FROM signing_keys ORDER BY created_on")
+	return keys, translateDBError(err)
+}
+
+func (s *DBStore) ActivateSigningKey(keyId string, now time.Time) error {
+	return s.updateSigningKey("UPDATE signing_keys SET activated_on = $1 where key_id = $2", now, keyId)
+}
+
+func (s *DBStore) RetireSigningKey(keyId string, now time.Time) error {
+	return s.updateSigningKey("UPDATE signing_keys SET retired_on = $1 where key_id = $2", now, keyId)
+}
+
+func (s *DBStore) updateSigningKey(query string, now time.Time, keyId string) error {
+	result, err := s.DB.Exec(query, now, keyId)
+	if err != nil {
+		return translateDBError(err)
+	}
+	if updated, err := result.RowsAffected(); err == nil && updated == 0 {
+		return SigningKeyNotFoundErr
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package accounts
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jonfk/comment-server/events"
+)
+
+// LoginThrottle limits failed login attempts. Failures are counted per
+// email and per client. After FreeAttempts failures the next attempt has to
+// wait BaseDelay, doubling with every failure up to MaxDelay. An account is
+// locked for LockoutDuration once its email reaches LockoutThreshold
+// failures.
+//
+// Unknown emails are throttled like the emails of accounts so that the
+// throttle doesn't reveal which accounts exist.
+type LoginThrottle struct {
+	FreeAttempts     int
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+}
+
+var DefaultLoginThrottle = LoginThrottle{
+	FreeAttempts:     3,
+	BaseDelay:        time.Second,
+	MaxDelay:         5 * time.Minute,
+	LockoutThreshold: 10,
+	LockoutDuration:  30 * time.Minute,
+}
+
+// LoginFailures counts the consecutive failed login attempts of a key.
+type LoginFailures struct {
+	Key           string    `db:"key"`
+	Failures      int       `db:"failures"`
+	LastFailureOn time.Time `db:"last_failure_on"`
+}
+
+func emailLoginKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+func clientLoginKey(client string) string {
+	return "client:" + client
+}
+
+// delay returns how long to wait after the last failure of f before the
+// next attempt.
+func (t LoginThrottle) delay(f LoginFailures) time.Duration {
+	if f.Failures < t.FreeAttempts {
+		return 0
+	}
+	delay := t.BaseDelay
+	for i := t.FreeAttempts; i < f.Failures && delay < t.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > t.MaxDelay {
+		return t.MaxDelay
+	}
+	return delay
+}
+
+func (a *Accounts) loginThrottle() LoginThrottle {
+	if a.LoginThrottle != nil {
+		return *a.LoginThrottle
+	}
+	return DefaultLoginThrottle
+}
+
+func (a *Accounts) loginKeys(email, client string) []string {
+	keys := []string{emailLoginKey(email)}
+	if client != "" {
+		keys = append(keys, clientLoginKey(client))
+	}
+	return keys
+}
+
+// CheckLoginAttempt returns LoginThrottledErr if an attempt to log in with
+// email from client has to wait or the account is locked.
+func (a *Accounts) CheckLoginAttempt(email, client string) error {
+	now := a.now()
+	for _, key := range a.loginKeys(email, client) {
+		failures, err := a.store().GetLoginFailures(key)
+		if err != nil {
+			return err
+		}
+		delay := a.loginThrottle().delay(failures)
+		if delay > 0 && now.Before(failures.LastFailureOn.Add(delay)) {
+			return LoginThrottledErr
+		}
+	}
+
+	account, err := a.GetAccountByEmail(email)
+	if err == AccountNotFoundErr {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if account.Locked(now) {
+		return LoginThrottledErr
+	}
+	return nil
+}
+
+// RecordLoginFailure counts a failed attempt to log in with email from
+// client. It returns the AccountLocked event to handle when the account
+// of email reaches the LockoutThreshold, nil otherwise.
+func (a *Accounts) RecordLoginFailure(email, client string) (events.EventPayload, error) {
+	now := a.now()
+	var emailFailures LoginFailures
+	for _, key := range a.loginKeys(email, client) {
+		failures, err := a.store().RecordLoginFailure(key, now)
+		if err != nil {
+			return nil, err
+		}
+		if key == emailLoginKey(email) {
+			emailFailures = failures
+		}
+	}
+
+	throttle := a.loginThrottle()
+	if emailFailures.Failures < throttle.LockoutThreshold {
+		return nil, nil
+	}
+	account, err := a.GetAccountByEmail(email)
+	if err == AccountNotFoundErr {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return events.AccountLocked{AccountId: account.AccountId, LockedUntil: now.Add(throttle.LockoutDuration)}, nil
+}
+
+// ClearLoginFailures forgets the failed attempts of email and client after
+// a successful login.
+func (a *Accounts) ClearLoginFailures(email, client string) error {
+	for _, key := range a.loginKeys(email, client) {
+		if err := a.store().ClearLoginFailures(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
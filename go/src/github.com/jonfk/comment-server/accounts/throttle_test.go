@@ -0,0 +1,153 @@
+package accounts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/apperrors"
+	"github.com/jonfk/comment-server/commands"
+	"github.com/jonfk/comment-server/events"
+)
+
+func TestLoginThrottle_delay(t *testing.T) {
+	throttle := LoginThrottle{FreeAttempts: 2, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	expectedDelays := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for failures, expected := range expectedDelays {
+		if delay := throttle.delay(LoginFailures{Failures: failures}); delay != expected {
+			t.Fatalf("delay after %d failures should be %v but was %v", failures, expected, delay)
+		}
+	}
+}
+
+func TestLoginBackoffAndLockout(t *testing.T) {
+	handler, accountId := newSessionsTestHandler(t)
+	adminToken := adminSession(t, handler).AccessToken
+	now := time.Now().UTC()
+	handler.AccountsService.Now = func() time.Time { return now }
+	handler.AccountsService.LoginThrottle = &LoginThrottle{
+		FreeAttempts:     2,
+		BaseDelay:        time.Second,
+		MaxDelay:         time.Minute,
+		LockoutThreshold: 4,
+		LockoutDuration:  10 * time.Minute,
+	}
+	attempt := func(email, password, client string) error {
+		_, err := handler.HandleCommand(commands.CreateCommand(commands.LoginAccount{Email: email, Password: password, Client: client}))
+		return err
+	}
+	expect := func(err error, code apperrors.Code) {
+		if !apperrors.Is(err, code) {
+			t.Fatalf("expected error %s but got %v", code, err)
+		}
+	}
+
+	expect(attempt("email@example.com", "wrong password", "client"), apperrors.AuthInvalidCredentials)
+	expect(attempt("email@example.com", "wrong password", "client"), apperrors.AuthInvalidCredentials)
+	expect(attempt("email@example.com", "password", "other client"), apperrors.AuthTooManyAttempts)
+
+	now = now.Add(2 * time.Second)
+	expect(attempt("email@example.com", "wrong password", "client"), apperrors.AuthInvalidCredentials)
+	now = now.Add(3 * time.Second)
+	expect(attempt("email@example.com", "wrong password", "client"), apperrors.AuthInvalidCredentials)
+
+	account, err := handler.AccountsService.GetAccountByAccountId(accountId)
+	if err != nil {
+		t.Fatalf("GetAccountByAccountId failed : %v\n", err)
+	}
+	if !account.Locked(now) {
+		t.Fatal("account should be locked after LockoutThreshold failures")
+	}
+	now = now.Add(2 * time.Minute)
+	expect(attempt("email@example.com", "password", "other client"), apperrors.AuthTooManyAttempts)
+
+	// Locked accounts can't log in without their password either
+	loginLinkToken, err := handler.AccountsService.IssueToken(accountId, TokenPurposeLogin, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueToken failed : %v\n", err)
+	}
+	_, err = handler.HandleCommand(commands.CreateCommand(commands.ConfirmLoginLink{Token: loginLinkToken}))
+	expect(err, apperrors.AuthTooManyAttempts)
+
+	if _, err := handler.HandleCommand(commands.CreateCommand(commands.UnlockAccount{Token: adminToken, AccountId: accountId})); err != nil {
+		t.Fatalf("UnlockAccount failed : %v\n", err)
+	}
+	if err := attempt("email@example.com", "password", "other client"); err != nil {
+		t.Fatalf("LoginAccount should succeed once the account is unlocked : %v", err)
+	}
+}
+
+func TestUnlockAccountRequiresAdmin(t *testing.T) {
+	handler, accountId := newSessionsTestHandler(t)
+	err := handler.EventHandler.HandleEvent(events.NewEventNow(events.AccountLocked{AccountId: accountId, LockedUntil: time.Now().Add(time.Hour)}))
+	if err != nil {
+		t.Fatalf("HandleEvent failed : %v\n", err)
+	}
+	adminSession(t, handler)
+
+	otherId := uuid.NewV4()
+	err = handler.EventHandler.HandleEvent(events.NewEventNow(accountCreated(t, otherId, "other", "other@example.com", "password")))
+	if err != nil {
+		t.Fatalf("HandleEvent failed : %v\n", err)
+	}
+	session, err := handler.AccountsService.StartSession(otherId)
+	if err != nil {
+		t.Fatalf("StartSession failed : %v\n", err)
+	}
+	_, err = handler.HandleCommand(commands.CreateCommand(commands.UnlockAccount{Token: session.AccessToken, AccountId: accountId}))
+	if err != AdminRequiredErr {
+		t.Fatalf("UnlockAccount should fail with AdminRequiredErr for a non-admin but returned %v", err)
+	}
+	account, err := handler.AccountsService.GetAccountByAccountId(accountId)
+	if err != nil {
+		t.Fatalf("GetAccountByAccountId failed : %v\n", err)
+	}
+	if !account.Locked(time.Now()) {
+		t.Fatal("account should stay locked when a non-admin unlocks it")
+	}
+}
+
+// adminSession creates an administrator of handler and starts a session
+// for it.
+func adminSession(t *testing.T, handler *CommandHandler) SessionTokens {
+	adminId := uuid.NewV4()
+	err := handler.EventHandler.HandleEvent(events.NewEventNow(accountCreated(t, adminId, "admin", "admin@example.com", "password")))
+	if err != nil {
+		t.Fatalf("HandleEvent failed : %v\n", err)
+	}
+	handler.AccountsService.Admins = append(handler.AccountsService.Admins, adminId)
+	session, err := handler.AccountsService.StartSession(adminId)
+	if err != nil {
+		t.Fatalf("StartSession failed : %v\n", err)
+	}
+	return session
+}
+
+func TestLoginThrottleOfUnknownEmailsAndClients(t *testing.T) {
+	handler, _ := newSessionsTestHandler(t)
+	handler.AccountsService.LoginThrottle = &LoginThrottle{
+		FreeAttempts:     1,
+		BaseDelay:        time.Minute,
+		MaxDelay:         time.Hour,
+		LockoutThreshold: 10,
+		LockoutDuration:  time.Hour,
+	}
+	attempt := func(email, client string) error {
+		_, err := handler.HandleCommand(commands.CreateCommand(commands.LoginAccount{Email: email, Password: "wrong password", Client: client}))
+		return err
+	}
+
+	// Unknown emails are throttled like the emails of accounts
+	attempt("unknown@example.com", "client")
+	attempt("email@example.com", "other client")
+	unknownErr, knownErr := attempt("unknown@example.com", "third client"), attempt("email@example.com", "third client")
+	if !apperrors.Is(unknownErr, apperrors.AuthTooManyAttempts) || apperrors.ResponseOf(unknownErr) != apperrors.ResponseOf(knownErr) {
+		t.Fatalf("unknown emails should be throttled like known emails but got %v and %v", unknownErr, knownErr)
+	}
+
+	// A client is throttled whatever the email it tries
+	if err := attempt("another@example.com", "client"); !apperrors.Is(err, apperrors.AuthTooManyAttempts) {
+		t.Fatalf("client should be throttled after failed attempts but got %v", err)
+	}
+}
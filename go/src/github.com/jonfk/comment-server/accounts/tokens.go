@@ -0,0 +1,72 @@
+package accounts
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+const (
+	TokenPurposeLogin             = "login"
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+
+	tokenLength = 32
+)
+
+// AccountToken is a single-use token sent to the owner of an account,
+// e.g. in a login link. Only the hash of the token is stored.
+type AccountToken struct {
+	TokenHash []byte    `db:"token_hash"`
+	AccountId uuid.UUID `db:"account_id"`
+	Purpose   string    `db:"purpose"`
+	CreatedOn time.Time `db:"created_on"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
+
+// IssueToken stores a new token for purpose valid for lifetime and
+// returns it.
+func (a *Accounts) IssueToken(accountId uuid.UUID, purpose string, lifetime time.Duration) (string, error) {
+	token, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	now := a.now().Round(time.Second)
+	err = a.store().InsertToken(AccountToken{
+		TokenHash: hashToken(token),
+		AccountId: accountId,
+		Purpose:   purpose,
+		CreatedOn: now,
+		ExpiresAt: now.Add(lifetime),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ConsumeToken marks token as used and returns the account it was issued
+// for. It returns InvalidTokenErr if the token doesn't exist, was issued for
+// another purpose, has expired or was already used.
+func (a *Accounts) ConsumeToken(purpose, token string) (uuid.UUID, error) {
+	return a.store().ConsumeToken(purpose, hashToken(token), a.now())
+}
+
+func generateToken() (string, error) {
+	token := make([]byte, tokenLength)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// hashToken returns the hash under which token is stored. Tokens are long
+// random strings so a fast hash is enough to protect them from being used
+// by someone who can read the database.
+func hashToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
@@ -0,0 +1,70 @@
+package accounts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+func TestConsumeToken(t *testing.T) {
+	store := NewMemoryStore()
+	accountsService := &Accounts{Store: store, PasswordPolicy: &testPasswordPolicy}
+	account, err := accountsService.CreateNewAccount(Account{Username: "username", Email: "email@example.com"}, "password")
+	if err != nil {
+		t.Fatalf("CreateNewAccount failed : %v\n", err)
+	}
+
+	token, err := accountsService.IssueToken(account.AccountId, TokenPurposeLogin, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueToken failed : %v\n", err)
+	}
+	if _, err := accountsService.ConsumeToken("other_purpose", token); err != InvalidTokenErr {
+		t.Fatalf("ConsumeToken should reject a token issued for another purpose but returned %v", err)
+	}
+	accountId, err := accountsService.ConsumeToken(TokenPurposeLogin, token)
+	if err != nil || !uuid.Equal(accountId, account.AccountId) {
+		t.Fatalf("ConsumeToken returned %v, %v", accountId, err)
+	}
+
+	expiredToken, err := generateToken()
+	if err != nil {
+		t.Fatalf("generateToken failed : %v\n", err)
+	}
+	now := time.Now().UTC()
+	err = store.InsertToken(AccountToken{
+		TokenHash: hashToken(expiredToken),
+		AccountId: account.AccountId,
+		Purpose:   TokenPurposeLogin,
+		CreatedOn: now.Add(-2 * time.Hour),
+		ExpiresAt: now.Add(-time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("InsertToken failed : %v\n", err)
+	}
+	if _, err := accountsService.ConsumeToken(TokenPurposeLogin, expiredToken); err != InvalidTokenErr {
+		t.Fatalf("ConsumeToken should reject an expired token but returned %v", err)
+	}
+}
+
+func TestTokensFollowClock(t *testing.T) {
+	now := time.Now().UTC()
+	accountsService := &Accounts{
+		Store:          NewMemoryStore(),
+		PasswordPolicy: &testPasswordPolicy,
+		Now:            func() time.Time { return now },
+	}
+	account, err := accountsService.CreateNewAccount(Account{Username: "username", Email: "email@example.com"}, "password")
+	if err != nil {
+		t.Fatalf("CreateNewAccount failed : %v\n", err)
+	}
+
+	token, err := accountsService.IssueToken(account.AccountId, TokenPurposeLogin, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueToken failed : %v\n", err)
+	}
+	now = now.Add(2 * time.Hour)
+	if _, err := accountsService.ConsumeToken(TokenPurposeLogin, token); err != InvalidTokenErr {
+		t.Fatalf("ConsumeToken should reject a token that expired by the clock of the service but returned %v", err)
+	}
+}
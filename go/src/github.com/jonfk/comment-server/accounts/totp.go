@@ -0,0 +1,160 @@
+package accounts
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+const (
+	TokenPurposeTwoFactor = "two_factor"
+
+	// TwoFactorLoginLifetime is how long the second login step can be
+	// completed after the password was verified.
+	TwoFactorLoginLifetime = 5 * time.Minute
+
+	DefaultTOTPIssuer = "comment-server"
+
+	// TOTP parameters of RFC 6238 supported by authenticator apps
+	totpSecretLength = 20
+	totpPeriod       = 30
+	totpDigits       = 6
+	// totpSkew is the number of periods before and after the current one
+	// whose codes are accepted, to allow for clock drift.
+	totpSkew = 1
+
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpCode returns the code of secret for the period step (RFC 4226).
+func totpCode(secret []byte, step int64) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+func totpStep(now time.Time) int64 {
+	return now.Unix() / totpPeriod
+}
+
+// matchTOTP returns the step of the code among the steps accepted at now.
+func matchTOTP(secret []byte, code string, now time.Time) (int64, bool) {
+	current := totpStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// TOTPEnrollment is what the owner of an account needs to add it to an
+// authenticator app.
+type TOTPEnrollment struct {
+	Secret string
+	// URI is the otpauth URI, usually shown as a QR code.
+	URI string
+}
+
+func (a *Accounts) totpIssuer() string {
+	if a.TOTPIssuer != "" {
+		return a.TOTPIssuer
+	}
+	return DefaultTOTPIssuer
+}
+
+func (a *Accounts) totpURI(account Account, secret []byte) string {
+	label := url.PathEscape(a.totpIssuer() + ":" + account.Email)
+	params := url.Values{}
+	params.Set("secret", totpEncoding.EncodeToString(secret))
+	params.Set("issuer", a.totpIssuer())
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// StartTOTPEnrollment stores a new secret for the account which only
+// enables two-factor authentication once a code is confirmed.
+func (a *Accounts) StartTOTPEnrollment(account Account) (TOTPEnrollment, error) {
+	if account.TwoFactorEnabled() {
+		return TOTPEnrollment{}, TwoFactorEnabledErr
+	}
+	secret := make([]byte, totpSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return TOTPEnrollment{}, err
+	}
+	if err := a.store().SetTOTPSecret(account.AccountId, secret); err != nil {
+		return TOTPEnrollment{}, err
+	}
+	return TOTPEnrollment{
+		Secret: totpEncoding.EncodeToString(secret),
+		URI:    a.totpURI(account, secret),
+	}, nil
+}
+
+// VerifyTOTP checks a code of the authenticator app of the account. A code
+// is only accepted once.
+func (a *Accounts) VerifyTOTP(account Account, code string) error {
+	if len(account.TOTPSecret) == 0 {
+		return TwoFactorNotEnabledErr
+	}
+	step, ok := matchTOTP(account.TOTPSecret, strings.TrimSpace(code), a.now())
+	if !ok {
+		return InvalidCredentialsErr
+	}
+	return a.store().UseTOTPStep(account.AccountId, step)
+}
+
+// VerifySecondFactor accepts either a code of the authenticator app or an
+// unused recovery code of the account.
+func (a *Accounts) VerifySecondFactor(account Account, code string) error {
+	if !account.TwoFactorEnabled() {
+		return TwoFactorNotEnabledErr
+	}
+	if len(strings.TrimSpace(code)) == totpDigits {
+		return a.VerifyTOTP(account, code)
+	}
+	return a.store().UseRecoveryCode(account.AccountId, hashToken(normalizeRecoveryCode(code)), a.now())
+}
+
+// GenerateRecoveryCodes replaces the recovery codes of the account and
+// returns them. Only their hashes are stored.
+func (a *Accounts) GenerateRecoveryCodes(accountId uuid.UUID) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([][]byte, recoveryCodeCount)
+	for i := range codes {
+		random := make([]byte, recoveryCodeLength)
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(random))[:recoveryCodeLength]
+		codes[i] = code[:recoveryCodeLength/2] + "-" + code[recoveryCodeLength/2:]
+		hashes[i] = hashToken(code)
+	}
+	if err := a.store().InsertRecoveryCodes(accountId, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
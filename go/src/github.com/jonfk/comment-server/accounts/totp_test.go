@@ -0,0 +1,111 @@
+package accounts
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jonfk/comment-server/apperrors"
+	"github.com/jonfk/comment-server/commands"
+	"github.com/jonfk/comment-server/events"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// Test vectors of RFC 6238 truncated to 6 digits
+	secret := []byte("12345678901234567890")
+	expectedCodes := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, expected := range expectedCodes {
+		if code := totpCode(secret, totpStep(time.Unix(unix, 0))); code != expected {
+			t.Fatalf("code at %d should be %s but was %s", unix, expected, code)
+		}
+	}
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	handler, accountId := newSessionsTestHandler(t)
+	now := time.Now().UTC()
+	handler.AccountsService.Now = func() time.Time { return now }
+	handle := func(command commands.CommandPayload) (commands.Response, error) {
+		return handler.HandleCommand(commands.CreateCommand(command))
+	}
+	loggedIn := login(t, handler)
+
+	response, err := handle(commands.EnrollTOTP{Token: loggedIn.AccessToken})
+	if err != nil {
+		t.Fatalf("EnrollTOTP failed : %v\n", err)
+	}
+	if _, ok := response.Event.Payload.(events.TOTPEnrollmentStarted); !ok || response.Secrets == nil {
+		t.Fatalf("EnrollTOTP should return the secret of the enrollment but returned %#v", response)
+	}
+	enrollment := response.Secrets
+	if !strings.HasPrefix(enrollment.TOTPURI, "otpauth://totp/") || !strings.Contains(enrollment.TOTPURI, "secret="+enrollment.TOTPSecret) {
+		t.Fatalf("EnrollTOTP returned an invalid otpauth URI %s", enrollment.TOTPURI)
+	}
+	secret, err := totpEncoding.DecodeString(enrollment.TOTPSecret)
+	if err != nil {
+		t.Fatalf("EnrollTOTP returned an invalid secret : %v", err)
+	}
+
+	if _, err := handle(commands.ConfirmTOTP{Token: loggedIn.AccessToken, Code: totpCode(secret, totpStep(now)+5)}); !apperrors.Is(err, apperrors.AuthInvalidCredentials) {
+		t.Fatalf("ConfirmTOTP should reject a wrong code but returned %v", err)
+	}
+	response, err = handle(commands.ConfirmTOTP{Token: loggedIn.AccessToken, Code: totpCode(secret, totpStep(now))})
+	if err != nil {
+		t.Fatalf("ConfirmTOTP failed : %v\n", err)
+	}
+	if _, ok := response.Event.Payload.(events.TwoFactorEnabled); !ok || response.Secrets == nil {
+		t.Fatalf("ConfirmTOTP should enable two-factor authentication but returned %#v", response)
+	}
+	recoveryCodes := response.Secrets.RecoveryCodes
+	if len(recoveryCodes) != recoveryCodeCount {
+		t.Fatalf("ConfirmTOTP should return %d recovery codes but returned %v", recoveryCodeCount, recoveryCodes)
+	}
+
+	// The password is no longer enough to log in
+	if _, err := handler.AccountsService.VerifyAndGenerateJWT(accountId, "password"); !apperrors.Is(err, apperrors.AuthTwoFactorRequired) {
+		t.Fatalf("VerifyAndGenerateJWT should require a second factor but returned %v", err)
+	}
+	requireSecondFactor := func() string {
+		response, err := handle(commands.LoginAccount{Email: "email@example.com", Password: "password"})
+		if err != nil {
+			t.Fatalf("LoginAccount failed : %v\n", err)
+		}
+		if _, ok := response.Event.Payload.(events.TwoFactorRequired); !ok || response.Secrets == nil || response.Secrets.TwoFactorToken == "" {
+			t.Fatalf("LoginAccount should require a second factor but returned %#v", response)
+		}
+		return response.Secrets.TwoFactorToken
+	}
+
+	// Codes can't be replayed
+	_, err = handle(commands.LoginTwoFactor{Token: requireSecondFactor(), Code: totpCode(secret, totpStep(now))})
+	if !apperrors.Is(err, apperrors.AuthInvalidCredentials) {
+		t.Fatalf("LoginTwoFactor should reject a code already used but returned %v", err)
+	}
+	now = now.Add(totpPeriod * time.Second)
+	response, err = handle(commands.LoginTwoFactor{Token: requireSecondFactor(), Code: totpCode(secret, totpStep(now))})
+	if err != nil {
+		t.Fatalf("LoginTwoFactor failed : %v\n", err)
+	}
+	if _, ok := response.Event.Payload.(events.AccountLoggedIn); !ok || response.Secrets == nil || response.Secrets.AccessToken == "" {
+		t.Fatalf("LoginTwoFactor should log in but returned %#v", response)
+	}
+
+	// Recovery codes can be used once
+	if _, err := handle(commands.LoginTwoFactor{Token: requireSecondFactor(), Code: strings.ToUpper(recoveryCodes[0])}); err != nil {
+		t.Fatalf("LoginTwoFactor should accept a recovery code : %v", err)
+	}
+	_, err = handle(commands.LoginTwoFactor{Token: requireSecondFactor(), Code: recoveryCodes[0]})
+	if !apperrors.Is(err, apperrors.AuthInvalidCredentials) {
+		t.Fatalf("LoginTwoFactor should reject a used recovery code but returned %v", err)
+	}
+
+	if _, err := handle(commands.DisableTOTP{Token: loggedIn.AccessToken, Code: recoveryCodes[1]}); err != nil {
+		t.Fatalf("DisableTOTP failed : %v\n", err)
+	}
+	login(t, handler)
+}
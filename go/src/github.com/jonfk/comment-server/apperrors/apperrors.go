@@ -0,0 +1,131 @@
+package apperrors
+
+import (
+	"fmt"
+)
+
+// Code is a stable, machine-readable identifier for a class of error.
+// Codes are part of the public API: clients switch on them, so existing
+// codes must never be renamed.
+type Code string
+
+const (
+	Internal Code = "internal"
+
+	CommandInvalid     Code = "command.invalid"
+	CommandUnknownType Code = "command.unknown_type"
+	EventInvalid       Code = "event.invalid"
+	EventUnknownType   Code = "event.unknown_type"
+
+	AccountNotFound         Code = "account.not_found"
+	AccountUsernameTaken    Code = "account.username_taken"
+	AccountEmailTaken       Code = "account.email_taken"
+	AccountEmailNotVerified Code = "account.email_not_verified"
+	AccountBanned           Code = "account.banned"
+	BanNotFound             Code = "ban.not_found"
+	APITokenNotFound        Code = "api_token.not_found"
+
+	AuthInvalidCredentials Code = "auth.invalid_credentials"
+	AuthInvalidToken       Code = "auth.invalid_token"
+	AuthForbidden          Code = "auth.forbidden"
+	AuthTooManyAttempts    Code = "auth.too_many_attempts"
+	AuthTwoFactorRequired  Code = "auth.two_factor_required"
+
+	TwoFactorInvalidState Code = "two_factor.invalid_state"
+
+	SigningKeyNotFound     Code = "signing_key.not_found"
+	SigningKeyInvalidState Code = "signing_key.invalid_state"
+
+	CommentThreadNotFound Code = "comment_thread.not_found"
+	CommentNotFound       Code = "comment.not_found"
+	CommentInvalidParent  Code = "comment.invalid_parent"
+
+	WebsiteNotFound Code = "website.not_found"
+
+	ScheduledCommandNotFound   Code = "scheduled_command.not_found"
+	ScheduledCommandNotPending Code = "scheduled_command.not_pending"
+)
+
+// Error is the error type shared by every package of the comment-server.
+// Message is safe to show to end users, Cause is the underlying error
+// which may contain internal details and should only be logged.
+type Error struct {
+	Code    Code
+	Message string
+	Cause   error
+}
+
+func (e *Error) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Message, e.Cause)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Unwrap returns the underlying cause of the error, if any.
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// New returns an Error with the given code and message and no cause.
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Newf returns an Error with the given code and a formatted message.
+func Newf(code Code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Wrap returns an Error with the given code and message wrapping cause.
+// Wrap returns nil if cause is nil.
+func Wrap(cause error, code Code, message string) error {
+	if cause == nil {
+		return nil
+	}
+	return &Error{Code: code, Message: message, Cause: cause}
+}
+
+// CodeOf returns the Code of err. Errors that were not created by this
+// package are reported as Internal unless they wrap an Error, and a nil
+// error has an empty Code.
+func CodeOf(err error) Code {
+	if err == nil {
+		return ""
+	}
+	if e, ok := asError(err); ok {
+		return e.Code
+	}
+	return Internal
+}
+
+// Is reports whether err is an Error with the given code.
+func Is(err error, code Code) bool {
+	return err != nil && CodeOf(err) == code
+}
+
+// MessageOf returns the user facing message of err. Errors that were not
+// created by this package have their details hidden.
+func MessageOf(err error) string {
+	if e, ok := asError(err); ok {
+		return e.Message
+	}
+	return "internal error"
+}
+
+// asError returns the first Error found by unwrapping err.
+func asError(err error) (*Error, bool) {
+	for err != nil {
+		if e, ok := err.(*Error); ok {
+			return e, true
+		}
+		wrapper, ok := err.(interface {
+			Unwrap() error
+		})
+		if !ok {
+			return nil, false
+		}
+		err = wrapper.Unwrap()
+	}
+	return nil, false
+}
@@ -0,0 +1,79 @@
+package apperrors
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestCodeOf(t *testing.T) {
+	cause := fmt.Errorf("connection refused")
+
+	inputs := []struct {
+		err  error
+		code Code
+	}{
+		{nil, ""},
+		{cause, Internal},
+		{New(AccountNotFound, "not found"), AccountNotFound},
+		{Newf(CommandUnknownType, "unknown command type %s", "Foo"), CommandUnknownType},
+		{Wrap(cause, AccountUsernameTaken, "taken"), AccountUsernameTaken},
+	}
+
+	for _, input := range inputs {
+		if code := CodeOf(input.err); code != input.code {
+			t.Fatalf("CodeOf(%v) = %s, expected %s", input.err, code, input.code)
+		}
+	}
+}
+
+func TestWrap(t *testing.T) {
+	if err := Wrap(nil, Internal, "database error"); err != nil {
+		t.Fatalf("Wrap(nil) should return nil but returned %v", err)
+	}
+
+	cause := fmt.Errorf("connection refused")
+	err := Wrap(cause, Internal, "database error")
+	if err.(*Error).Unwrap() != cause {
+		t.Fatalf("Wrap did not keep the cause: %v", err)
+	}
+	if MessageOf(err) != "database error" {
+		t.Fatalf("MessageOf(%v) = %s", err, MessageOf(err))
+	}
+}
+
+func TestResponseOf(t *testing.T) {
+	inputs := []struct {
+		err      error
+		status   int
+		response Response
+	}{
+		{fmt.Errorf("pq: password authentication failed"), http.StatusInternalServerError,
+			Response{Code: Internal, Message: "internal error"}},
+		{New(AccountUsernameTaken, "Username is already taken"), http.StatusConflict,
+			Response{Code: AccountUsernameTaken, Message: "Username is already taken"}},
+		{New(AccountBanned, "The account is banned"), http.StatusForbidden,
+			Response{Code: AccountBanned, Message: "The account is banned"}},
+		{New(Code("unmapped.code"), "unmapped"), http.StatusInternalServerError,
+			Response{Code: Internal, Message: "unmapped"}},
+	}
+
+	for _, input := range inputs {
+		if status := HTTPStatusOf(input.err); status != input.status {
+			t.Fatalf("HTTPStatusOf(%v) = %d, expected %d", input.err, status, input.status)
+		}
+		if response := ResponseOf(input.err); response != input.response {
+			t.Fatalf("ResponseOf(%v) = %v, expected %v", input.err, response, input.response)
+		}
+	}
+
+	if _, ok := WebsocketCloseOf(New(AccountNotFound, "not found")); ok {
+		t.Fatal("account.not_found should not close the websocket connection")
+	}
+	if _, ok := WebsocketCloseOf(New(AccountBanned, "banned")); ok {
+		t.Fatal("account.banned should be answered without closing the websocket connection")
+	}
+	if closeCode, ok := WebsocketCloseOf(New(AuthInvalidToken, "invalid")); !ok || closeCode != WebsocketCloseUnauthorized {
+		t.Fatalf("auth.invalid_token should close the websocket connection with %d", WebsocketCloseUnauthorized)
+	}
+}
@@ -0,0 +1,85 @@
+package apperrors
+
+import (
+	"net/http"
+)
+
+// Websocket close codes in the 4000-4999 range reserved for applications
+// by RFC 6455. They are sent when an error requires the connection to be
+// closed, e.g. when the client's token is no longer valid.
+const (
+	WebsocketCloseInternal     = 4000
+	WebsocketCloseUnauthorized = 4401
+)
+
+// HTTPStatus maps each Code to the HTTP status of the response it should
+// produce. Codes missing from the table are answered with a 500.
+var HTTPStatus = map[Code]int{
+	Internal:                http.StatusInternalServerError,
+	CommandInvalid:          http.StatusBadRequest,
+	CommandUnknownType:      http.StatusBadRequest,
+	EventInvalid:            http.StatusBadRequest,
+	EventUnknownType:        http.StatusBadRequest,
+	AccountNotFound:         http.StatusNotFound,
+	AccountUsernameTaken:    http.StatusConflict,
+	AccountEmailTaken:       http.StatusConflict,
+	AccountEmailNotVerified: http.StatusForbidden,
+	AccountBanned:           http.StatusForbidden,
+	BanNotFound:             http.StatusNotFound,
+	APITokenNotFound:        http.StatusNotFound,
+	AuthInvalidCredentials:  http.StatusUnauthorized,
+	AuthInvalidToken:        http.StatusUnauthorized,
+	AuthForbidden:           http.StatusForbidden,
+	AuthTooManyAttempts:     http.StatusTooManyRequests,
+	AuthTwoFactorRequired:   http.StatusUnauthorized,
+	TwoFactorInvalidState:   http.StatusConflict,
+	CommentThreadNotFound:   http.StatusNotFound,
+	CommentNotFound:         http.StatusNotFound,
+	CommentInvalidParent:    http.StatusBadRequest,
+	WebsiteNotFound:         http.StatusNotFound,
+	SigningKeyNotFound:      http.StatusNotFound,
+	SigningKeyInvalidState:  http.StatusConflict,
+
+	ScheduledCommandNotFound:   http.StatusNotFound,
+	ScheduledCommandNotPending: http.StatusConflict,
+}
+
+// WebsocketClose maps the Codes that should terminate a websocket
+// connection to the close code sent to the peer. Errors with codes missing
+// from the table are sent as a Response message and the connection is
+// kept open.
+var WebsocketClose = map[Code]int{
+	Internal:         WebsocketCloseInternal,
+	AuthInvalidToken: WebsocketCloseUnauthorized,
+}
+
+// Response is the JSON representation of an error sent to clients, both
+// as an HTTP response body and as a websocket message.
+type Response struct {
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+}
+
+// HTTPStatusOf returns the HTTP status for err.
+func HTTPStatusOf(err error) int {
+	if status, ok := HTTPStatus[CodeOf(err)]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// WebsocketCloseOf returns the websocket close code for err and whether
+// the connection should be closed at all.
+func WebsocketCloseOf(err error) (int, bool) {
+	closeCode, ok := WebsocketClose[CodeOf(err)]
+	return closeCode, ok
+}
+
+// ResponseOf returns the Response to send to clients for err.
+func ResponseOf(err error) Response {
+	code := CodeOf(err)
+	if _, ok := HTTPStatus[code]; !ok {
+		code = Internal
+	}
+	return Response{Code: code, Message: MessageOf(err)}
+}
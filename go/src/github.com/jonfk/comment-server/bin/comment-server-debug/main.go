@@ -2,10 +2,20 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"net/http"
 	"os"
+	"strings"
 	"text/template"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/accounts"
+	"github.com/jonfk/comment-server/comments"
+	"github.com/jonfk/comment-server/events"
+	"github.com/jonfk/comment-server/export"
 )
 
 var (
@@ -32,6 +42,21 @@ func serveHome(w http.ResponseWriter, r *http.Request) {
 	homeTemplate.Execute(w, r.Host)
 }
 
+func parseAccountIds(value string) ([]uuid.UUID, error) {
+	accountIds := []uuid.UUID{}
+	for _, field := range strings.Split(value, ",") {
+		if strings.TrimSpace(field) == "" {
+			continue
+		}
+		accountId, err := uuid.FromString(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		accountIds = append(accountIds, accountId)
+	}
+	return accountIds, nil
+}
+
 func init() {
 	flag.Parse()
 }
@@ -44,11 +69,31 @@ func main() {
 		serveWs(hub, w, r)
 	})
 
+	db, err := sqlx.Open("postgres", fmt.Sprintf("user=%s dbname=%s password=%s sslmode=disable",
+		os.Getenv("DATABASE_USER"), os.Getenv("DATABASE_NAME"), os.Getenv("DATABASE_PASSWORD")))
+	if err != nil {
+		log.Fatal("sqlx.Open: ", err)
+	}
+	// ADMIN_ACCOUNT_IDS is a comma separated list of the ids of the
+	// accounts allowed to run administrative commands
+	admins, err := parseAccountIds(os.Getenv("ADMIN_ACCOUNT_IDS"))
+	if err != nil {
+		log.Fatal("ADMIN_ACCOUNT_IDS: ", err)
+	}
+	accountsService := &accounts.Accounts{DB: db, AvatarDir: os.Getenv("AVATAR_DIR"), Admins: admins}
+	http.Handle(accounts.JWKSPath, &accounts.JWKSHandler{AccountsService: accountsService})
+	http.Handle(accounts.AvatarPath, &accounts.AvatarHandler{AccountsService: accountsService})
+	http.Handle(export.Path, &export.Handler{Exporter: &export.Exporter{
+		AccountsService: accountsService,
+		CommentsService: &comments.Comments{DB: db, AccountsService: accountsService},
+		EventReader:     &events.Store{DB: db},
+	}})
+
 	log.WithFields(log.Fields{
 		"context": "main",
 		"addr":    *addr,
 	}).Info("http Listening and Serving")
-	err := http.ListenAndServe(*addr, nil)
+	err = http.ListenAndServe(*addr, nil)
 	if err != nil {
 		log.Fatal("ListenAndServe: ", err)
 	}
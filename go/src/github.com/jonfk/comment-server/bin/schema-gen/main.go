@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/jonfk/comment-server/schema"
+)
+
+var (
+	out = flag.String("out", ".", "directory the schema files are written to")
+)
+
+func main() {
+	flag.Parse()
+
+	files, err := schema.Files()
+	if err != nil {
+		log.Fatal("schema.Files: ", err)
+	}
+
+	for name, content := range files {
+		path := filepath.Join(*out, name)
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			log.Fatal("WriteFile: ", err)
+		}
+		log.WithFields(log.Fields{
+			"context": "main",
+			"path":    path,
+		}).Info("Schema written")
+	}
+}
@@ -2,19 +2,56 @@ package commands
 
 import (
 	"encoding/json"
-	"fmt"
-	_ "time"
+	"reflect"
+	"strings"
+	"time"
 
 	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/apperrors"
 )
 
 const (
-	CreateAccountTypeName       = "CreateAccount"
-	DeleteAccountTypeName       = "DeleteAccount"
-	LoginAccountTypeName        = "LoginAccount"
-	CreateCommentThreadTypeName = "CreateCommentThread"
-	CreateCommentTypeName       = "CreateComment"
-	DeleteCommentTypeName       = "DeleteComment"
+	CreateAccountTypeName            = "CreateAccount"
+	DeleteAccountTypeName            = "DeleteAccount"
+	LoginAccountTypeName             = "LoginAccount"
+	CreateCommentThreadTypeName      = "CreateCommentThread"
+	CreateCommentTypeName            = "CreateComment"
+	DeleteCommentTypeName            = "DeleteComment"
+	BatchCommandTypeName             = "BatchCommand"
+	RequestLoginLinkTypeName         = "RequestLoginLink"
+	ConfirmLoginLinkTypeName         = "ConfirmLoginLink"
+	RequestPasswordResetTypeName     = "RequestPasswordReset"
+	ResetPasswordTypeName            = "ResetPassword"
+	VerifyEmailTypeName              = "VerifyEmail"
+	RequestEmailVerificationTypeName = "RequestEmailVerification"
+	ConfigureWebsiteTypeName         = "ConfigureWebsite"
+	RefreshSessionTypeName           = "RefreshSession"
+	LogoutTypeName                   = "Logout"
+	LogoutEverywhereTypeName         = "LogoutEverywhere"
+	GenerateSigningKeyTypeName       = "GenerateSigningKey"
+	ActivateSigningKeyTypeName       = "ActivateSigningKey"
+	RetireSigningKeyTypeName         = "RetireSigningKey"
+	UnlockAccountTypeName            = "UnlockAccount"
+	EnrollTOTPTypeName               = "EnrollTOTP"
+	ConfirmTOTPTypeName              = "ConfirmTOTP"
+	DisableTOTPTypeName              = "DisableTOTP"
+	LoginTwoFactorTypeName           = "LoginTwoFactor"
+	StartOIDCLoginTypeName           = "StartOIDCLogin"
+	CompleteOIDCLoginTypeName        = "CompleteOIDCLogin"
+	CreateGuestAccountTypeName       = "CreateGuestAccount"
+	ClaimGuestIdentityTypeName       = "ClaimGuestIdentity"
+	ChangeUsernameTypeName           = "ChangeUsername"
+	ChangeEmailTypeName              = "ChangeEmail"
+	UpdateProfileTypeName            = "UpdateProfile"
+	UploadAvatarTypeName             = "UploadAvatar"
+	GrantRoleTypeName                = "GrantRole"
+	RevokeRoleTypeName               = "RevokeRole"
+	BanAccountTypeName               = "BanAccount"
+	SuspendAccountTypeName           = "SuspendAccount"
+	UnbanAccountTypeName             = "UnbanAccount"
+	CreateAPITokenTypeName           = "CreateAPIToken"
+	RevokeAPITokenTypeName           = "RevokeAPIToken"
 )
 
 type Command struct {
@@ -34,16 +71,27 @@ type CreateAccount struct {
 
 type DeleteAccount struct {
 	AccountId uuid.UUID `json:"accountId"`
+	// Mode is accounts.DeletionAnonymize, the default, or
+	// accounts.DeletionPurge.
+	Mode string `json:"mode,omitempty"`
 }
 
 type LoginAccount struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	// Client identifies where the attempt comes from, e.g. the remote
+	// address, to throttle failed attempts. It is set by the server.
+	Client string `json:"-"`
 }
 
 type CreateCommentThread struct {
-	PageUrl string `json:"pageUrl,omitempty"`
-	Title   string `json:"title,omitempty"`
+	// CommentThreadId is generated when it's not set. Setting it lets the
+	// later commands of a BatchCommand refer to the thread.
+	CommentThreadId uuid.UUID `json:"commentThreadId,omitempty"`
+	PageUrl         string    `json:"pageUrl,omitempty"`
+	Title           string    `json:"title,omitempty"`
+	// Website is the url of a configured website the page belongs to.
+	Website string `json:"website,omitempty"`
 }
 
 type CreateComment struct {
@@ -51,19 +99,414 @@ type CreateComment struct {
 	ParentId        *uuid.UUID `json:"parentId,omitempty"`
 	CommentThreadId uuid.UUID  `json:"commentThreadId,omitempty"`
 	AccountId       uuid.UUID  `json:"accountId,omitempty"`
+	// Token authenticates the author instead of AccountId when set.
+	Token string `json:"token,omitempty"`
 }
 
 type DeleteComment struct {
 	CommentId uuid.UUID `json:"commentId,omitempty"`
 	AccountId uuid.UUID `json:"accountId,omitempty"`
+	// Token authenticates the account instead of AccountId when set.
+	Token string `json:"token,omitempty"`
+}
+
+// BatchCommand groups commands that must all succeed or all fail.
+// Batches can't be nested and only group Batchable commands.
+type BatchCommand struct {
+	Commands []Command `json:"commands"`
+}
+
+// UnmarshalJSON decodes the child commands of the batch with the
+// package level UnmarshalJSON.
+func (c *BatchCommand) UnmarshalJSON(input []byte) error {
+	var batchRaw struct {
+		Commands []json.RawMessage `json:"commands"`
+	}
+	err := json.Unmarshal(input, &batchRaw)
+	if err != nil {
+		return err
+	}
+
+	c.Commands = []Command{}
+	for _, commandRaw := range batchRaw.Commands {
+		commandPayload, err := UnmarshalJSON(commandRaw)
+		if err != nil {
+			return err
+		}
+		if _, ok := commandPayload.(BatchCommand); ok {
+			return apperrors.New(apperrors.CommandInvalid, "batch commands can't be nested")
+		}
+		if !Batchable(commandPayload) {
+			return apperrors.Newf(apperrors.CommandInvalid, "%s commands can't be batched", commandPayload.CommandType())
+		}
+		c.Commands = append(c.Commands, CreateCommand(commandPayload))
+	}
+	return nil
+}
+
+// Batchable reports whether a command can be part of a BatchCommand. Only
+// commands on threads, comments, websites, roles and bans can be: the
+// effects of commands on accounts, such as starting a session, must not be
+// undone with a batch.
+func Batchable(commandPayload CommandPayload) bool {
+	switch commandPayload.(type) {
+	case CreateCommentThread, CreateComment, DeleteComment, ConfigureWebsite,
+		GrantRole, RevokeRole, BanAccount, SuspendAccount, UnbanAccount:
+		return true
+	}
+	return false
 }
 
-func (c CreateAccount) CommandType() string       { return CreateAccountTypeName }
-func (c DeleteAccount) CommandType() string       { return DeleteAccountTypeName }
-func (c LoginAccount) CommandType() string        { return LoginAccountTypeName }
-func (c CreateCommentThread) CommandType() string { return CreateCommentThreadTypeName }
-func (c CreateComment) CommandType() string       { return CreateCommentTypeName }
-func (c DeleteComment) CommandType() string       { return DeleteCommentTypeName }
+// secretFields are the JSON names of the fields of commands holding
+// credentials.
+var secretFields = map[string]bool{
+	"password":     true,
+	"newPassword":  true,
+	"token":        true,
+	"refreshToken": true,
+	"guestToken":   true,
+	"code":         true,
+}
+
+// CarriesSecrets reports whether a command, or a command of a batch, holds
+// credentials such as a password or a token. Such commands must not be
+// stored.
+func CarriesSecrets(commandPayload CommandPayload) bool {
+	if batch, ok := commandPayload.(BatchCommand); ok {
+		for _, command := range batch.Commands {
+			if CarriesSecrets(command.Payload) {
+				return true
+			}
+		}
+		return false
+	}
+	value := reflect.ValueOf(commandPayload)
+	if value.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < value.NumField(); i++ {
+		name := strings.Split(value.Type().Field(i).Tag.Get("json"), ",")[0]
+		if secretFields[name] && value.Field(i).Kind() == reflect.String && value.Field(i).String() != "" {
+			return true
+		}
+	}
+	return false
+}
+
+type RequestLoginLink struct {
+	Email string `json:"email"`
+}
+
+type ConfirmLoginLink struct {
+	Token string `json:"token"`
+}
+
+type RequestPasswordReset struct {
+	Email string `json:"email"`
+}
+
+type ResetPassword struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"newPassword"`
+}
+
+type VerifyEmail struct {
+	Token string `json:"token"`
+}
+
+type RequestEmailVerification struct {
+	Email string `json:"email"`
+}
+
+// ConfigureWebsite creates or replaces the settings of a website. The
+// account configuring a new website becomes its owner, and only owners
+// can configure websites that have one. Websites without owner can only
+// be configured by administrators.
+type ConfigureWebsite struct {
+	// Token is the access token of the account configuring the website.
+	Token                string `json:"token"`
+	Url                  string `json:"url"`
+	RequireVerifiedEmail bool   `json:"requireVerifiedEmail"`
+	AllowGuests          bool   `json:"allowGuests"`
+}
+
+type RefreshSession struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+type Logout struct {
+	// Token is the access token of the session to end.
+	Token string `json:"token"`
+}
+
+type LogoutEverywhere struct {
+	// Token is an access token of the account.
+	Token string `json:"token"`
+}
+
+// GenerateSigningKey, ActivateSigningKey and RetireSigningKey manage the keyring
+// signing access tokens. They are administrative commands, Token is the
+// access token of an administrator.
+type GenerateSigningKey struct {
+	Token string `json:"token"`
+	// Algorithm is one of HS256, RS256 or EdDSA and defaults to HS256.
+	Algorithm string `json:"algorithm,omitempty"`
+}
+
+type ActivateSigningKey struct {
+	Token string `json:"token"`
+	KeyId string `json:"keyId"`
+}
+
+type RetireSigningKey struct {
+	Token string `json:"token"`
+	KeyId string `json:"keyId"`
+}
+
+// UnlockAccount lifts the lockout of an account before it expires. Token
+// is the access token of an administrator.
+type UnlockAccount struct {
+	Token     string    `json:"token"`
+	AccountId uuid.UUID `json:"accountId"`
+}
+
+// EnrollTOTP starts adding an authenticator app to the account of the
+// access token Token.
+type EnrollTOTP struct {
+	Token string `json:"token"`
+}
+
+// ConfirmTOTP enables two-factor authentication with a Code of the
+// authenticator app being enrolled.
+type ConfirmTOTP struct {
+	Token string `json:"token"`
+	Code  string `json:"code"`
+}
+
+type DisableTOTP struct {
+	Token string `json:"token"`
+	// Code is a code of the authenticator app or a recovery code
+	Code string `json:"code"`
+}
+
+// LoginTwoFactor completes a login requiring a second factor.
+type LoginTwoFactor struct {
+	// Token is the token of the TwoFactorRequired event
+	Token string `json:"token"`
+	// Code is a code of the authenticator app or a recovery code
+	Code string `json:"code"`
+}
+
+// StartOIDCLogin starts logging in with an OpenID Connect provider.
+type StartOIDCLogin struct {
+	Provider string `json:"provider"`
+}
+
+// CompleteOIDCLogin completes a login with the State and Code the
+// provider redirected the user back with.
+type CompleteOIDCLogin struct {
+	State string `json:"state"`
+	Code  string `json:"code"`
+	// Token is the access token of the account to link the identity to,
+	// otherwise an account is created for a new identity.
+	Token string `json:"token,omitempty"`
+}
+
+// CreateGuestAccount creates an account commenting under DisplayName
+// without registering, and logs it in.
+type CreateGuestAccount struct {
+	DisplayName string `json:"displayName"`
+	Email       string `json:"email,omitempty"`
+}
+
+// ClaimGuestIdentity moves the comments of a guest to the registered
+// account of its owner.
+type ClaimGuestIdentity struct {
+	// Token is the access token of the registered account claiming the
+	// guest, GuestToken the access token of the guest.
+	Token      string `json:"token"`
+	GuestToken string `json:"guestToken"`
+}
+
+type ChangeUsername struct {
+	Token    string `json:"token"`
+	Username string `json:"username"`
+}
+
+// ChangeEmail replaces the email of an account, which has to be verified
+// again.
+type ChangeEmail struct {
+	Token string `json:"token"`
+	Email string `json:"email"`
+	// Password is required for accounts with a password.
+	Password string `json:"password,omitempty"`
+}
+
+type UpdateProfile struct {
+	Token       string `json:"token"`
+	DisplayName string `json:"displayName"`
+	AvatarURL   string `json:"avatarUrl,omitempty"`
+}
+
+type UploadAvatar struct {
+	Token string `json:"token"`
+	// Image is a PNG, JPEG or GIF image of at most accounts.MaxAvatarBytes.
+	Image []byte `json:"image"`
+}
+
+// GrantRole gives an account a role on a website, replacing its previous
+// role.
+type GrantRole struct {
+	// Token is the access token of the account granting the role, which
+	// has to own Website.
+	Token     string    `json:"token"`
+	Website   string    `json:"website"`
+	GranteeId uuid.UUID `json:"granteeId"`
+	Role      string    `json:"role"`
+}
+
+// RevokeRole makes an account a commenter of a website again.
+type RevokeRole struct {
+	Token     string    `json:"token"`
+	Website   string    `json:"website"`
+	GranteeId uuid.UUID `json:"granteeId"`
+}
+
+// BanAccount stops an account from commenting on a website, or from
+// logging in and commenting anywhere without Website.
+type BanAccount struct {
+	// Token is the access token of the moderator banning the account, or
+	// of an administrator for global bans without Website.
+	Token           string     `json:"token"`
+	BannedAccountId uuid.UUID  `json:"bannedAccountId"`
+	Website         string     `json:"website,omitempty"`
+	Reason          string     `json:"reason,omitempty"`
+	ExpiresAt       *time.Time `json:"expiresAt,omitempty"`
+	HideComments    bool       `json:"hideComments,omitempty"`
+}
+
+// SuspendAccount bans an account until ExpiresAt, keeping its comments.
+type SuspendAccount struct {
+	Token           string    `json:"token"`
+	BannedAccountId uuid.UUID `json:"bannedAccountId"`
+	Website         string    `json:"website,omitempty"`
+	Reason          string    `json:"reason,omitempty"`
+	ExpiresAt       time.Time `json:"expiresAt"`
+}
+
+// UnbanAccount lifts a ban before it expires. Global bans can only be
+// lifted by administrators.
+type UnbanAccount struct {
+	Token string    `json:"token"`
+	BanId uuid.UUID `json:"banId"`
+}
+
+// CreateAPIToken creates a named API token with the given scopes, see
+// accounts.APIToken.
+type CreateAPIToken struct {
+	// Token is a session access token of the account. API tokens can't
+	// create other tokens.
+	Token  string   `json:"token"`
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresAt is nil for tokens which are valid until revoked.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+type RevokeAPIToken struct {
+	// Token is a session access token of the account owning the API
+	// token.
+	Token      string    `json:"token"`
+	APITokenId uuid.UUID `json:"apiTokenId"`
+}
+
+func (c CreateAccount) CommandType() string            { return CreateAccountTypeName }
+func (c DeleteAccount) CommandType() string            { return DeleteAccountTypeName }
+func (c LoginAccount) CommandType() string             { return LoginAccountTypeName }
+func (c CreateCommentThread) CommandType() string      { return CreateCommentThreadTypeName }
+func (c CreateComment) CommandType() string            { return CreateCommentTypeName }
+func (c DeleteComment) CommandType() string            { return DeleteCommentTypeName }
+func (c BatchCommand) CommandType() string             { return BatchCommandTypeName }
+func (c RequestLoginLink) CommandType() string         { return RequestLoginLinkTypeName }
+func (c ConfirmLoginLink) CommandType() string         { return ConfirmLoginLinkTypeName }
+func (c RequestPasswordReset) CommandType() string     { return RequestPasswordResetTypeName }
+func (c ResetPassword) CommandType() string            { return ResetPasswordTypeName }
+func (c VerifyEmail) CommandType() string              { return VerifyEmailTypeName }
+func (c RequestEmailVerification) CommandType() string { return RequestEmailVerificationTypeName }
+func (c ConfigureWebsite) CommandType() string         { return ConfigureWebsiteTypeName }
+func (c RefreshSession) CommandType() string           { return RefreshSessionTypeName }
+func (c Logout) CommandType() string                   { return LogoutTypeName }
+func (c LogoutEverywhere) CommandType() string         { return LogoutEverywhereTypeName }
+func (c GenerateSigningKey) CommandType() string       { return GenerateSigningKeyTypeName }
+func (c ActivateSigningKey) CommandType() string       { return ActivateSigningKeyTypeName }
+func (c RetireSigningKey) CommandType() string         { return RetireSigningKeyTypeName }
+func (c UnlockAccount) CommandType() string            { return UnlockAccountTypeName }
+func (c EnrollTOTP) CommandType() string               { return EnrollTOTPTypeName }
+func (c ConfirmTOTP) CommandType() string              { return ConfirmTOTPTypeName }
+func (c DisableTOTP) CommandType() string              { return DisableTOTPTypeName }
+func (c LoginTwoFactor) CommandType() string           { return LoginTwoFactorTypeName }
+func (c StartOIDCLogin) CommandType() string           { return StartOIDCLoginTypeName }
+func (c CompleteOIDCLogin) CommandType() string        { return CompleteOIDCLoginTypeName }
+func (c CreateGuestAccount) CommandType() string       { return CreateGuestAccountTypeName }
+func (c ClaimGuestIdentity) CommandType() string       { return ClaimGuestIdentityTypeName }
+func (c ChangeUsername) CommandType() string           { return ChangeUsernameTypeName }
+func (c ChangeEmail) CommandType() string              { return ChangeEmailTypeName }
+func (c UpdateProfile) CommandType() string            { return UpdateProfileTypeName }
+func (c UploadAvatar) CommandType() string             { return UploadAvatarTypeName }
+func (c GrantRole) CommandType() string                { return GrantRoleTypeName }
+func (c RevokeRole) CommandType() string               { return RevokeRoleTypeName }
+func (c BanAccount) CommandType() string               { return BanAccountTypeName }
+func (c SuspendAccount) CommandType() string           { return SuspendAccountTypeName }
+func (c UnbanAccount) CommandType() string             { return UnbanAccountTypeName }
+func (c CreateAPIToken) CommandType() string           { return CreateAPITokenTypeName }
+func (c RevokeAPIToken) CommandType() string           { return RevokeAPITokenTypeName }
+
+// PayloadTypes returns a zero value of every CommandPayload that can be
+// unmarshalled by UnmarshalJSON.
+func PayloadTypes() []CommandPayload {
+	return []CommandPayload{
+		CreateAccount{},
+		DeleteAccount{},
+		LoginAccount{},
+		CreateCommentThread{},
+		CreateComment{},
+		DeleteComment{},
+		BatchCommand{},
+		RequestLoginLink{},
+		ConfirmLoginLink{},
+		RequestPasswordReset{},
+		ResetPassword{},
+		VerifyEmail{},
+		RequestEmailVerification{},
+		ConfigureWebsite{},
+		RefreshSession{},
+		Logout{},
+		LogoutEverywhere{},
+		GenerateSigningKey{},
+		ActivateSigningKey{},
+		RetireSigningKey{},
+		UnlockAccount{},
+		EnrollTOTP{},
+		ConfirmTOTP{},
+		DisableTOTP{},
+		LoginTwoFactor{},
+		StartOIDCLogin{},
+		CompleteOIDCLogin{},
+		CreateGuestAccount{},
+		ClaimGuestIdentity{},
+		ChangeUsername{},
+		ChangeEmail{},
+		UpdateProfile{},
+		UploadAvatar{},
+		GrantRole{},
+		RevokeRole{},
+		BanAccount{},
+		SuspendAccount{},
+		UnbanAccount{},
+		CreateAPIToken{},
+		RevokeAPIToken{},
+	}
+}
 
 type CommandJSON struct {
 	CommandType string          `json:"commandType"`
@@ -76,7 +519,7 @@ func UnmarshalJSON(input []byte) (CommandPayload, error) {
 	)
 	err := json.Unmarshal(input, &commandRaw)
 	if err != nil {
-		return CreateAccount{}, err
+		return CreateAccount{}, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command")
 	}
 
 	switch commandRaw.CommandType {
@@ -84,46 +527,287 @@ func UnmarshalJSON(input []byte) (CommandPayload, error) {
 		commandPayload := CreateAccount{}
 		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
 		if err != nil {
-			return commandPayload, err
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
 		}
 		return commandPayload, nil
 	case DeleteAccountTypeName:
 		commandPayload := DeleteAccount{}
 		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
 		if err != nil {
-			return commandPayload, err
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
 		}
 		return commandPayload, nil
 	case LoginAccountTypeName:
 		commandPayload := LoginAccount{}
 		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
 		if err != nil {
-			return commandPayload, err
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
 		}
 		return commandPayload, nil
 	case CreateCommentThreadTypeName:
 		commandPayload := CreateCommentThread{}
 		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
 		if err != nil {
-			return commandPayload, err
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
 		}
 		return commandPayload, nil
 	case CreateCommentTypeName:
 		commandPayload := CreateComment{}
 		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
 		if err != nil {
-			return commandPayload, err
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
 		}
 		return commandPayload, nil
 	case DeleteCommentTypeName:
 		commandPayload := DeleteComment{}
 		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
 		if err != nil {
-			return commandPayload, err
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case BatchCommandTypeName:
+		commandPayload := BatchCommand{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			if _, ok := err.(*apperrors.Error); ok {
+				return commandPayload, err
+			}
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case RequestLoginLinkTypeName:
+		commandPayload := RequestLoginLink{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case ConfirmLoginLinkTypeName:
+		commandPayload := ConfirmLoginLink{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case RequestPasswordResetTypeName:
+		commandPayload := RequestPasswordReset{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case ResetPasswordTypeName:
+		commandPayload := ResetPassword{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case VerifyEmailTypeName:
+		commandPayload := VerifyEmail{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case RequestEmailVerificationTypeName:
+		commandPayload := RequestEmailVerification{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case ConfigureWebsiteTypeName:
+		commandPayload := ConfigureWebsite{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case RefreshSessionTypeName:
+		commandPayload := RefreshSession{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case LogoutTypeName:
+		commandPayload := Logout{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case LogoutEverywhereTypeName:
+		commandPayload := LogoutEverywhere{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case GenerateSigningKeyTypeName:
+		commandPayload := GenerateSigningKey{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case ActivateSigningKeyTypeName:
+		commandPayload := ActivateSigningKey{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case RetireSigningKeyTypeName:
+		commandPayload := RetireSigningKey{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case UnlockAccountTypeName:
+		commandPayload := UnlockAccount{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case EnrollTOTPTypeName:
+		commandPayload := EnrollTOTP{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case ConfirmTOTPTypeName:
+		commandPayload := ConfirmTOTP{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case DisableTOTPTypeName:
+		commandPayload := DisableTOTP{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case LoginTwoFactorTypeName:
+		commandPayload := LoginTwoFactor{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case StartOIDCLoginTypeName:
+		commandPayload := StartOIDCLogin{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case CompleteOIDCLoginTypeName:
+		commandPayload := CompleteOIDCLogin{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case CreateGuestAccountTypeName:
+		commandPayload := CreateGuestAccount{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case ClaimGuestIdentityTypeName:
+		commandPayload := ClaimGuestIdentity{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case ChangeUsernameTypeName:
+		commandPayload := ChangeUsername{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case ChangeEmailTypeName:
+		commandPayload := ChangeEmail{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case UpdateProfileTypeName:
+		commandPayload := UpdateProfile{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case UploadAvatarTypeName:
+		commandPayload := UploadAvatar{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case GrantRoleTypeName:
+		commandPayload := GrantRole{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case RevokeRoleTypeName:
+		commandPayload := RevokeRole{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case BanAccountTypeName:
+		commandPayload := BanAccount{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case SuspendAccountTypeName:
+		commandPayload := SuspendAccount{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case UnbanAccountTypeName:
+		commandPayload := UnbanAccount{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case CreateAPITokenTypeName:
+		commandPayload := CreateAPIToken{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
+		}
+		return commandPayload, nil
+	case RevokeAPITokenTypeName:
+		commandPayload := RevokeAPIToken{}
+		err = json.Unmarshal(commandRaw.Payload, &commandPayload)
+		if err != nil {
+			return commandPayload, apperrors.Wrap(err, apperrors.CommandInvalid, "malformed command payload")
 		}
 		return commandPayload, nil
 	default:
-		return CreateAccount{}, fmt.Errorf("unknown command type %s", commandRaw.CommandType)
+		return CreateAccount{}, apperrors.Newf(apperrors.CommandUnknownType, "unknown command type %s", commandRaw.CommandType)
 	}
 }
 
@@ -43,6 +43,18 @@ func TestJsonMarshalCommands(t *testing.T) {
 			CommentId: uuid.NewV4(),
 			AccountId: uuid.NewV4(),
 		},
+		BatchCommand{
+			Commands: []Command{
+				CreateCommand(CreateCommentThread{
+					PageUrl: "pageUrl",
+					Title:   "title",
+				}),
+				CreateCommand(DeleteComment{
+					CommentId: uuid.NewV4(),
+					AccountId: uuid.NewV4(),
+				}),
+			},
+		},
 	}
 
 	encodedCommands := [][]byte{}
@@ -129,6 +141,20 @@ func TestUnmarshalJSON(t *testing.T) {
 		"commentId": "1c1b0d99-5108-458a-a93d-131cc00c717c",
 		"accountId": "81203854-0f6e-4b3e-88fd-4b6586cffa07"
 	}
+}`,
+		`{
+	"commandType": "BatchCommand",
+	"payload": {
+		"commands": [
+			{
+				"commandType": "DeleteComment",
+				"payload": {
+					"commentId": "1c1b0d99-5108-458a-a93d-131cc00c717c",
+					"accountId": "81203854-0f6e-4b3e-88fd-4b6586cffa07"
+				}
+			}
+		]
+	}
 }`,
 	}
 
@@ -141,6 +167,44 @@ func TestUnmarshalJSON(t *testing.T) {
 		"commentThreadId": "6c0904a0-901f-47a2-96f7-821fd6f800c1",
 		"accountId": "bce2547a-08b9-47bb-84f2-f90b9673bc6a"
 	}
+}`,
+		`{
+	"commandType": "BatchCommand",
+	"payload": {
+		"commands": [
+			{
+				"commandType": "BatchCommand",
+				"payload": {
+					"commands": []
+				}
+			}
+		]
+	}
+}`,
+		`{
+	"commandType": "BatchCommand",
+	"payload": {
+		"commands": [
+			{
+				"commandType": "UnknownCommand",
+				"payload": {}
+			}
+		]
+	}
+}`,
+		`{
+	"commandType": "BatchCommand",
+	"payload": {
+		"commands": [
+			{
+				"commandType": "LoginAccount",
+				"payload": {
+					"email": "email@email.com",
+					"password": "password"
+				}
+			}
+		]
+	}
 }`,
 	}
 
@@ -159,3 +223,55 @@ func TestUnmarshalJSON(t *testing.T) {
 	}
 
 }
+
+func TestPayloadTypes(t *testing.T) {
+	seen := map[string]bool{}
+	for _, payloadType := range PayloadTypes() {
+		if seen[payloadType.CommandType()] {
+			t.Fatalf("PayloadTypes contains %s twice", payloadType.CommandType())
+		}
+		seen[payloadType.CommandType()] = true
+
+		encodedCommand, err := MarshalJSON(CreateCommand(payloadType))
+		if err != nil {
+			t.Fatalf("MarshalJSON failed : %v\n", err)
+		}
+		decodedCommandPayload, err := UnmarshalJSON(encodedCommand)
+		if err != nil {
+			t.Fatalf("PayloadTypes contains %s which UnmarshalJSON can't decode : %v\n", payloadType.CommandType(), err)
+		}
+		if reflect.TypeOf(decodedCommandPayload) != reflect.TypeOf(payloadType) {
+			t.Fatalf("%s decoded as %T", payloadType.CommandType(), decodedCommandPayload)
+		}
+	}
+}
+
+func TestCarriesSecrets(t *testing.T) {
+	withSecrets := []CommandPayload{
+		LoginAccount{Email: "email@email.com", Password: "password"},
+		ResetPassword{Token: "token", NewPassword: "password"},
+		RefreshSession{RefreshToken: "refreshToken"},
+		BatchCommand{Commands: []Command{
+			CreateCommand(DeleteComment{CommentId: uuid.NewV4(), AccountId: uuid.NewV4()}),
+			CreateCommand(ConfirmLoginLink{Token: "token"}),
+		}},
+	}
+	withoutSecrets := []CommandPayload{
+		DeleteAccount{AccountId: uuid.NewV4()},
+		CreateAccount{Username: "username", Email: "email@email.com"},
+		CreateComment{Data: "data", CommentThreadId: uuid.NewV4(), AccountId: uuid.NewV4()},
+		BatchCommand{Commands: []Command{
+			CreateCommand(CreateCommentThread{PageUrl: "pageUrl", Title: "title"}),
+		}},
+	}
+	for _, commandPayload := range withSecrets {
+		if !CarriesSecrets(commandPayload) {
+			t.Fatalf("%s %v should carry secrets", commandPayload.CommandType(), commandPayload)
+		}
+	}
+	for _, commandPayload := range withoutSecrets {
+		if CarriesSecrets(commandPayload) {
+			t.Fatalf("%s %v should not carry secrets", commandPayload.CommandType(), commandPayload)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package commands
 
 import (
+	"fmt"
+
+	"github.com/jonfk/comment-server/apperrors"
 	"github.com/jonfk/comment-server/events"
 )
 
@@ -11,5 +14,130 @@ import (
 // Careful about how commands are logged. Commands can contain
 // sensitive information that shouldn't be store such as unhashedPasswords.
 type CommandHandler interface {
-	HandleCommand(Command) (events.Event, error)
+	HandleCommand(Command) (Response, error)
+}
+
+// Response is what the client that sent a command gets back: the event the
+// command resulted in and the secrets it issued. Secrets are kept out of
+// events so that they are never stored.
+type Response struct {
+	Event   events.Event `json:"event"`
+	Secrets *Secrets     `json:"secrets,omitempty"`
+}
+
+// Secrets are the credentials issued to the client by a command. Only the
+// fields issued by the command are set.
+type Secrets struct {
+	// AccessToken and RefreshToken are the tokens of a new or refreshed
+	// session.
+	AccessToken  string `json:"accessToken,omitempty"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+	// TwoFactorToken is exchanged with LoginTwoFactor for a session.
+	TwoFactorToken string `json:"twoFactorToken,omitempty"`
+	// TOTPSecret and TOTPURI are added to the authenticator app being
+	// enrolled.
+	TOTPSecret string `json:"totpSecret,omitempty"`
+	TOTPURI    string `json:"totpUri,omitempty"`
+	// RecoveryCodes can each replace a TOTP code once.
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+	// AuthorizationURL is where the user logs in with the identity provider.
+	AuthorizationURL string `json:"authorizationUrl,omitempty"`
+	// APIToken is the token of a new API token, only its hash is kept.
+	APIToken string `json:"apiToken,omitempty"`
+}
+
+// a CommandDecider interprets a Command against the current state and
+// returns the event it results in without handling that event.
+// A CommandDecider returns an empty event and a nil error for commands
+// it doesn't handle. A rejected command can return an event along with its
+// error, e.g. AccountLocked for a failed login, which should be handled
+// while the command still fails.
+type CommandDecider interface {
+	DecideCommand(Command) (events.Event, error)
+}
+
+// CommandDeciders is a CommandDecider that returns the result of the first
+// of its deciders to return an event or an error.
+type CommandDeciders []CommandDecider
+
+func (deciders CommandDeciders) DecideCommand(command Command) (events.Event, error) {
+	for _, decider := range deciders {
+		event, err := decider.DecideCommand(command)
+		if err != nil || event.Payload != nil {
+			return event, err
+		}
+	}
+	return events.Event{}, nil
+}
+
+// BatchError is returned when a command of a BatchCommand fails.
+// Index is the position of the failing command in the batch.
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("command %d of batch failed: %v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// A Transaction is the state a batch is handled in. Its decider sees the
+// events handled in the transaction so far, and nothing it decides, appends
+// or handles is kept unless it is committed.
+type Transaction interface {
+	CommandDecider
+	events.EventAppender
+	events.EventHandler
+	Commit() error
+	Rollback() error
+}
+
+// BatchHandler handles BatchCommands atomically. The commands of a batch are
+// decided, appended and handled one after the other in a single
+// Transaction, so that a command can rely on the events of the commands
+// before it, e.g. to comment on a thread created by the batch. Nothing is
+// kept unless every command of the batch succeeds.
+type BatchHandler struct {
+	// Begin starts the Transaction a batch is handled in.
+	Begin func() (Transaction, error)
+}
+
+// HandleBatch returns the events of every command of the batch, or a
+// *BatchError and no events if one of them fails.
+func (b *BatchHandler) HandleBatch(batch BatchCommand) ([]events.Event, error) {
+	tx, err := b.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	batchEvents := []events.Event{}
+	for i, command := range batch.Commands {
+		if !Batchable(command.Payload) {
+			return nil, &BatchError{Index: i, Err: apperrors.Newf(apperrors.CommandInvalid, "%s commands can't be batched", command.CommandType)}
+		}
+		event, err := tx.DecideCommand(command)
+		if err != nil {
+			return nil, &BatchError{Index: i, Err: err}
+		}
+		if event.Payload == nil {
+			continue
+		}
+		if err := tx.Append(event); err != nil {
+			return nil, &BatchError{Index: i, Err: err}
+		}
+		if err := tx.HandleEvent(event); err != nil {
+			return nil, &BatchError{Index: i, Err: err}
+		}
+		batchEvents = append(batchEvents, event)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, apperrors.Wrap(err, apperrors.Internal, "database error")
+	}
+	return batchEvents, nil
 }
@@ -0,0 +1,149 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/apperrors"
+	"github.com/jonfk/comment-server/events"
+)
+
+// memoryTransaction keeps the threads created by the events it handles and
+// only adds its events to the committed events of its store once committed.
+type memoryTransaction struct {
+	store   *memoryStore
+	threads map[uuid.UUID]bool
+	events  []events.Event
+}
+
+// DecideCommand decides CreateCommentThread and CreateComment commands,
+// comments on unknown threads and DeleteComment commands fail.
+func (m *memoryTransaction) DecideCommand(command Command) (events.Event, error) {
+	switch commandPayload := command.Payload.(type) {
+	case CreateCommentThread:
+		commentThreadId := commandPayload.CommentThreadId
+		if uuid.Equal(commentThreadId, uuid.Nil) {
+			commentThreadId = uuid.NewV4()
+		}
+		return events.NewEventNow(events.CommentThreadCreated{
+			CommentThreadId: commentThreadId,
+			PageUrl:         commandPayload.PageUrl,
+			Title:           commandPayload.Title,
+		}), nil
+	case CreateComment:
+		if !m.threads[commandPayload.CommentThreadId] {
+			return events.Event{}, apperrors.New(apperrors.CommentThreadNotFound, "Comment Thread Not Found")
+		}
+		return events.NewEventNow(events.CommentCreated{
+			CommentId:       uuid.NewV4(),
+			CommentThreadId: commandPayload.CommentThreadId,
+			Data:            commandPayload.Data,
+		}), nil
+	case DeleteComment:
+		return events.Event{}, apperrors.New(apperrors.CommentNotFound, "Comment Not Found")
+	}
+	return events.Event{}, nil
+}
+
+func (m *memoryTransaction) Append(appendedEvents ...events.Event) error {
+	m.events = append(m.events, appendedEvents...)
+	return nil
+}
+
+func (m *memoryTransaction) HandleEvent(event events.Event) error {
+	if eventPayload, ok := event.Payload.(events.CommentThreadCreated); ok {
+		m.threads[eventPayload.CommentThreadId] = true
+	}
+	return nil
+}
+
+func (m *memoryTransaction) Commit() error {
+	m.store.events = append(m.store.events, m.events...)
+	m.events = nil
+	return nil
+}
+
+func (m *memoryTransaction) Rollback() error {
+	m.events = nil
+	return nil
+}
+
+type memoryStore struct {
+	events []events.Event
+}
+
+func (m *memoryStore) Begin() (Transaction, error) {
+	return &memoryTransaction{store: m, threads: map[uuid.UUID]bool{}}, nil
+}
+
+func TestHandleBatch(t *testing.T) {
+	store := &memoryStore{}
+	batchHandler := &BatchHandler{Begin: store.Begin}
+
+	batchEvents, err := batchHandler.HandleBatch(BatchCommand{Commands: []Command{
+		CreateCommand(CreateCommentThread{PageUrl: "pageUrl1", Title: "title1"}),
+		CreateCommand(CreateCommentThread{PageUrl: "pageUrl2", Title: "title2"}),
+	}})
+	if err != nil {
+		t.Fatalf("HandleBatch failed : %v\n", err)
+	}
+	if len(batchEvents) != 2 || len(store.events) != 2 {
+		t.Fatalf("HandleBatch should have returned and committed 2 events\n (returned) %v (committed) %v",
+			batchEvents, store.events)
+	}
+
+	_, err = batchHandler.HandleBatch(BatchCommand{Commands: []Command{
+		CreateCommand(CreateCommentThread{PageUrl: "pageUrl3", Title: "title3"}),
+		CreateCommand(DeleteComment{CommentId: uuid.NewV4(), AccountId: uuid.NewV4()}),
+		CreateCommand(CreateCommentThread{PageUrl: "pageUrl4", Title: "title4"}),
+	}})
+	batchErr, ok := err.(*BatchError)
+	if !ok {
+		t.Fatalf("HandleBatch should have failed with a *BatchError but returned %v", err)
+	}
+	if batchErr.Index != 1 {
+		t.Fatalf("HandleBatch failed on command %d, expected 1", batchErr.Index)
+	}
+	if apperrors.CodeOf(err) != apperrors.CommentNotFound {
+		t.Fatalf("BatchError should have the code of the failing command but has %s", apperrors.CodeOf(err))
+	}
+	if len(store.events) != 2 {
+		t.Fatalf("failed batch should not commit events\n (committed) %v", store.events)
+	}
+}
+
+func TestHandleBatchSeesEarlierCommands(t *testing.T) {
+	store := &memoryStore{}
+	batchHandler := &BatchHandler{Begin: store.Begin}
+
+	threadId := uuid.NewV4()
+	batchEvents, err := batchHandler.HandleBatch(BatchCommand{Commands: []Command{
+		CreateCommand(CreateCommentThread{CommentThreadId: threadId, PageUrl: "pageUrl", Title: "title"}),
+		CreateCommand(CreateComment{CommentThreadId: threadId, Data: "data"}),
+	}})
+	if err != nil {
+		t.Fatalf("HandleBatch should have commented on the thread created by the batch but failed : %v\n", err)
+	}
+	if len(batchEvents) != 2 || len(store.events) != 2 {
+		t.Fatalf("HandleBatch should have returned and committed 2 events\n (returned) %v (committed) %v",
+			batchEvents, store.events)
+	}
+}
+
+func TestHandleBatchRejectsUnbatchableCommands(t *testing.T) {
+	store := &memoryStore{}
+	batchHandler := &BatchHandler{Begin: store.Begin}
+
+	_, err := batchHandler.HandleBatch(BatchCommand{Commands: []Command{
+		CreateCommand(CreateCommentThread{PageUrl: "pageUrl", Title: "title"}),
+		CreateCommand(LoginAccount{Email: "email@email.com", Password: "password"}),
+	}})
+	batchErr, ok := err.(*BatchError)
+	if !ok || batchErr.Index != 1 || apperrors.CodeOf(err) != apperrors.CommandInvalid {
+		t.Fatalf("HandleBatch should have rejected command 1 but returned %v", err)
+	}
+	if len(store.events) != 0 {
+		t.Fatalf("failed batch should not commit events\n (committed) %v", store.events)
+	}
+}
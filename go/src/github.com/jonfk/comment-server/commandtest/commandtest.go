@@ -0,0 +1,107 @@
+package commandtest
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/jonfk/comment-server/apperrors"
+	"github.com/jonfk/comment-server/commands"
+	"github.com/jonfk/comment-server/events"
+)
+
+// Scenario is a specification of a business rule: Given prior events,
+// When a command is decided, Then the expected events are emitted or
+// ThenError is returned. A Scenario with both ThenError and Then expects
+// a rejected command to emit events.
+//
+// Expected events are matched field by field and zero valued fields are
+// ignored, so that generated values such as ids, password hashes and
+// tokens don't have to be predicted.
+type Scenario struct {
+	Name      string
+	Given     []events.EventPayload
+	When      commands.CommandPayload
+	Then      []events.EventPayload
+	ThenError apperrors.Code
+}
+
+// System is the in-memory system under test. EventHandler projects the
+// given events into the state used by Decider.
+type System struct {
+	Decider      commands.CommandDecider
+	EventHandler events.EventHandler
+}
+
+// Run runs every scenario as a subtest against a fresh System returned by
+// newSystem.
+func Run(t *testing.T, newSystem func() System, scenarios ...Scenario) {
+	for _, scenario := range scenarios {
+		scenario := scenario
+		t.Run(scenario.Name, func(t *testing.T) {
+			if err := scenario.run(newSystem()); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func (s Scenario) run(system System) error {
+	for _, payload := range s.Given {
+		if err := system.EventHandler.HandleEvent(events.NewEventNow(payload)); err != nil {
+			return fmt.Errorf("given %s: %v", payload.EventType(), err)
+		}
+	}
+
+	event, err := system.Decider.DecideCommand(commands.CreateCommand(s.When))
+	if s.ThenError != "" {
+		if code := apperrors.CodeOf(err); code != s.ThenError {
+			return fmt.Errorf("when %s: expected error %s but got %v (code %s)", s.When.CommandType(), s.ThenError, err, code)
+		}
+		// Rejected commands can still emit events, see CommandDecider
+		if len(s.Then) == 0 {
+			return nil
+		}
+	} else if err != nil {
+		return fmt.Errorf("when %s: unexpected error %v", s.When.CommandType(), err)
+	}
+
+	emitted := []events.EventPayload{}
+	if event.Payload != nil {
+		emitted = append(emitted, event.Payload)
+	}
+	if len(emitted) != len(s.Then) {
+		return fmt.Errorf("when %s: expected events %v but got %v", s.When.CommandType(), s.Then, emitted)
+	}
+	for i, expected := range s.Then {
+		if !Match(expected, emitted[i]) {
+			return fmt.Errorf("when %s: expected event %d to match\n (expected) %#v\n (actual) %#v", s.When.CommandType(), i, expected, emitted[i])
+		}
+	}
+	return nil
+}
+
+// Match reports whether actual has the type of expected and the same value
+// for every field that is not zero in expected.
+func Match(expected, actual events.EventPayload) bool {
+	expectedValue, actualValue := reflect.ValueOf(expected), reflect.ValueOf(actual)
+	if expectedValue.Type() != actualValue.Type() {
+		return false
+	}
+	if expectedValue.Kind() != reflect.Struct {
+		return reflect.DeepEqual(expected, actual)
+	}
+	for i := 0; i < expectedValue.NumField(); i++ {
+		if expectedValue.Type().Field(i).PkgPath != "" {
+			continue
+		}
+		field := expectedValue.Field(i)
+		if reflect.DeepEqual(field.Interface(), reflect.Zero(field.Type()).Interface()) {
+			continue
+		}
+		if !reflect.DeepEqual(field.Interface(), actualValue.Field(i).Interface()) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,38 @@
+package commandtest
+
+import (
+	"testing"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/events"
+)
+
+func TestMatch(t *testing.T) {
+	accountId := uuid.NewV4()
+	actual := events.AccountCreated{
+		AccountId:      accountId,
+		Username:       "username",
+		Email:          "email@example.com",
+		HashedPassword: []byte("hashed_password"),
+		HashSalt:       []byte("salt"),
+	}
+
+	inputs := []struct {
+		expected events.EventPayload
+		match    bool
+	}{
+		{events.AccountCreated{}, true},
+		{events.AccountCreated{Username: "username"}, true},
+		{events.AccountCreated{AccountId: accountId, Email: "email@example.com"}, true},
+		{events.AccountCreated{Username: "other"}, false},
+		{events.AccountCreated{AccountId: uuid.NewV4()}, false},
+		{events.AccountDeleted{AccountId: accountId}, false},
+	}
+
+	for _, input := range inputs {
+		if Match(input.expected, actual) != input.match {
+			t.Fatalf("Match(%v, %v) should be %v", input.expected, actual, input.match)
+		}
+	}
+}
@@ -0,0 +1,552 @@
+package comments
+
+import (
+	"github.com/jonfk/comment-server/accounts"
+	"github.com/jonfk/comment-server/apperrors"
+	"github.com/jonfk/comment-server/commands"
+	"github.com/jonfk/comment-server/events"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/satori/go.uuid"
+)
+
+type CommandHandler struct {
+	EventHandler    events.EventHandler
+	CommentsService *Comments
+	AccountsService *accounts.Accounts
+}
+
+// HandleCommand decides the event resulting from command and handles it
+// with the EventHandler. BatchCommands are handled by HandleBatch and only
+// their last event is returned.
+func (c *CommandHandler) HandleCommand(command commands.Command) (commands.Response, error) {
+	if batch, ok := command.Payload.(commands.BatchCommand); ok {
+		batchEvents, err := c.HandleBatch(batch)
+		if err != nil || len(batchEvents) == 0 {
+			return commands.Response{}, err
+		}
+		return commands.Response{Event: batchEvents[len(batchEvents)-1]}, nil
+	}
+	event, err := c.DecideCommand(command)
+	if err != nil {
+		return commands.Response{}, err
+	}
+	if event.Payload != nil {
+		if err := c.EventHandler.HandleEvent(event); err != nil {
+			return commands.Response{}, err
+		}
+	}
+	return commands.Response{Event: event}, nil
+}
+
+func (c *CommandHandler) DecideCommand(command commands.Command) (events.Event, error) {
+	switch commandPayload := command.Payload.(type) {
+	case commands.CreateAccount:
+		// Command not handled by Comments
+	case commands.DeleteAccount:
+		// Command not handled by Comments
+	case commands.LoginAccount:
+		// Command not handled by Comments
+	case commands.CreateCommentThread:
+		if commandPayload.Website != "" {
+			if _, err := c.CommentsService.GetWebsite(commandPayload.Website); err != nil {
+				return events.Event{}, err
+			}
+		}
+
+		commentThreadId := commandPayload.CommentThreadId
+		if uuid.Equal(commentThreadId, uuid.Nil) {
+			commentThreadId = uuid.NewV4()
+		} else if _, err := c.CommentsService.GetThreadByThreadId(commentThreadId); err != CommentThreadNotFoundErr {
+			if err != nil {
+				return events.Event{}, err
+			}
+			return events.Event{}, apperrors.New(apperrors.CommandInvalid, "A thread with this id already exists")
+		}
+
+		eventPayload := events.CommentThreadCreated{
+			CommentThreadId: commentThreadId,
+			PageUrl:         commandPayload.PageUrl,
+			Title:           commandPayload.Title,
+			Website:         commandPayload.Website,
+		}
+		return events.NewEventNow(eventPayload), nil
+	case commands.CreateComment:
+		accountId, err := c.AuthenticateCommand(command)
+		if err != nil {
+			return events.Event{}, err
+		}
+		thread, err := c.CommentsService.GetThreadByThreadId(commandPayload.CommentThreadId)
+		if err != nil {
+			return events.Event{}, err
+		}
+		account, err := c.AccountsService.GetAccountByAccountId(accountId)
+		if err != nil {
+			return events.Event{}, err
+		}
+		if account.Deleted() {
+			return events.Event{}, accounts.AccountNotFoundErr
+		}
+		if err := c.AccountsService.CheckNotBanned(account.AccountId, thread.Website); err != nil {
+			return events.Event{}, err
+		}
+		if thread.Website != "" {
+			website, err := c.CommentsService.GetWebsite(thread.Website)
+			if err != nil {
+				return events.Event{}, err
+			}
+			if account.Guest() && !website.AllowGuests {
+				return events.Event{}, GuestsNotAllowedErr
+			}
+			if err := c.CommentsService.CheckPermission(website.Url, account.AccountId, PermissionComment); err != nil {
+				return events.Event{}, err
+			}
+			if website.RequireVerifiedEmail && !account.EmailVerified() {
+				return events.Event{}, accounts.EmailNotVerifiedErr
+			}
+		} else if account.Guest() {
+			return events.Event{}, GuestsNotAllowedErr
+		}
+		if commandPayload.ParentId != nil {
+			parent, err := c.CommentsService.GetCommentById(*commandPayload.ParentId)
+			if err != nil {
+				return events.Event{}, err
+			}
+			if !uuid.Equal(parent.CommentThreadId, commandPayload.CommentThreadId) {
+				return events.Event{}, InvalidParentErr
+			}
+		}
+
+		eventPayload := events.CommentCreated{
+			CommentId:       uuid.NewV4(),
+			Data:            commandPayload.Data,
+			ParentId:        commandPayload.ParentId,
+			CommentThreadId: commandPayload.CommentThreadId,
+			AccountId:       accountId,
+			AuthorName:      account.Name(),
+			AvatarURL:       c.AccountsService.AvatarURL(account, accounts.DefaultAvatarSize),
+		}
+		return events.NewEventNow(eventPayload), nil
+	case commands.DeleteComment:
+		accountId, err := c.AuthenticateCommand(command)
+		if err != nil {
+			return events.Event{}, err
+		}
+		comment, err := c.CommentsService.GetCommentById(commandPayload.CommentId)
+		if err != nil {
+			return events.Event{}, err
+		}
+		if !uuid.Equal(comment.AccountId, accountId) {
+			canModerate, err := c.canModerate(comment.CommentThreadId, accountId)
+			if err != nil {
+				return events.Event{}, err
+			}
+			if !canModerate {
+				return events.Event{}, NotCommentAuthorErr
+			}
+		}
+
+		return events.NewEventNow(events.CommentDeleted{CommentId: comment.CommentId}), nil
+	case commands.RequestLoginLink:
+		// Command not handled by Comments
+	case commands.ConfirmLoginLink:
+		// Command not handled by Comments
+	case commands.RequestPasswordReset:
+		// Command not handled by Comments
+	case commands.ResetPassword:
+		// Command not handled by Comments
+	case commands.VerifyEmail:
+		// Command not handled by Comments
+	case commands.RequestEmailVerification:
+		// Command not handled by Comments
+	case commands.ConfigureWebsite:
+		if commandPayload.Url == "" {
+			return events.Event{}, apperrors.New(apperrors.CommandInvalid, "website url is required")
+		}
+		accountId, err := c.AuthenticateCommand(command)
+		if err != nil {
+			return events.Event{}, err
+		}
+
+		eventPayload := events.WebsiteConfigured{
+			Url:                  commandPayload.Url,
+			RequireVerifiedEmail: commandPayload.RequireVerifiedEmail,
+			AllowGuests:          commandPayload.AllowGuests,
+		}
+		_, err = c.CommentsService.GetWebsite(commandPayload.Url)
+		switch {
+		case err == WebsiteNotFoundErr:
+			account, err := c.AccountsService.GetAccountByAccountId(accountId)
+			if err != nil {
+				return events.Event{}, err
+			}
+			if account.Guest() {
+				return events.Event{}, apperrors.New(apperrors.CommandInvalid, "Guests can't own websites")
+			}
+			eventPayload.OwnerId = &accountId
+		case err != nil:
+			return events.Event{}, err
+		default:
+			hasOwner, err := c.CommentsService.hasOwner(commandPayload.Url)
+			if err != nil {
+				return events.Event{}, err
+			}
+			if hasOwner {
+				err = c.CommentsService.CheckPermission(commandPayload.Url, accountId, PermissionConfigureWebsite)
+			} else {
+				err = c.AccountsService.CheckAdmin(accountId)
+			}
+			if err != nil {
+				return events.Event{}, err
+			}
+		}
+		return events.NewEventNow(eventPayload), nil
+	case commands.GrantRole:
+		if !ValidRole(commandPayload.Role) {
+			return events.Event{}, apperrors.Newf(apperrors.CommandInvalid, "unknown role %s", commandPayload.Role)
+		}
+		accountId, err := c.AuthenticateCommand(command)
+		if err != nil {
+			return events.Event{}, err
+		}
+		if err := c.checkRoleChange(accountId, commandPayload.Website, commandPayload.GranteeId, commandPayload.Role); err != nil {
+			return events.Event{}, err
+		}
+		grantee, err := c.AccountsService.GetAccountByAccountId(commandPayload.GranteeId)
+		if err != nil {
+			return events.Event{}, err
+		}
+		if grantee.Guest() && RoleAllows(commandPayload.Role, PermissionModerate) {
+			return events.Event{}, apperrors.New(apperrors.CommandInvalid, "Guests can't moderate websites")
+		}
+
+		eventPayload := events.RoleGranted{
+			Website:   commandPayload.Website,
+			AccountId: commandPayload.GranteeId,
+			Role:      commandPayload.Role,
+			GrantedBy: accountId,
+		}
+		return events.NewEventNow(eventPayload), nil
+	case commands.RevokeRole:
+		accountId, err := c.AuthenticateCommand(command)
+		if err != nil {
+			return events.Event{}, err
+		}
+		if err := c.checkRoleChange(accountId, commandPayload.Website, commandPayload.GranteeId, RoleCommenter); err != nil {
+			return events.Event{}, err
+		}
+
+		eventPayload := events.RoleRevoked{
+			Website:   commandPayload.Website,
+			AccountId: commandPayload.GranteeId,
+			RevokedBy: accountId,
+		}
+		return events.NewEventNow(eventPayload), nil
+	case commands.BanAccount:
+		accountId, err := c.AuthenticateCommand(command)
+		if err != nil {
+			return events.Event{}, err
+		}
+		if err := c.checkBan(accountId, commandPayload.Website, commandPayload.BannedAccountId); err != nil {
+			return events.Event{}, err
+		}
+		if commandPayload.ExpiresAt != nil && !commandPayload.ExpiresAt.After(c.CommentsService.now()) {
+			return events.Event{}, apperrors.New(apperrors.CommandInvalid, "A ban must expire in the future")
+		}
+
+		eventPayload := events.AccountBanned{
+			BanId:        uuid.NewV4(),
+			AccountId:    commandPayload.BannedAccountId,
+			Website:      commandPayload.Website,
+			Reason:       commandPayload.Reason,
+			HideComments: commandPayload.HideComments,
+			BannedBy:     &accountId,
+			ExpiresAt:    commandPayload.ExpiresAt,
+		}
+		return events.NewEventNow(eventPayload), nil
+	case commands.SuspendAccount:
+		accountId, err := c.AuthenticateCommand(command)
+		if err != nil {
+			return events.Event{}, err
+		}
+		if err := c.checkBan(accountId, commandPayload.Website, commandPayload.BannedAccountId); err != nil {
+			return events.Event{}, err
+		}
+		if !commandPayload.ExpiresAt.After(c.CommentsService.now()) {
+			return events.Event{}, apperrors.New(apperrors.CommandInvalid, "A suspension must expire in the future")
+		}
+
+		eventPayload := events.AccountBanned{
+			BanId:      uuid.NewV4(),
+			AccountId:  commandPayload.BannedAccountId,
+			Website:    commandPayload.Website,
+			Suspension: true,
+			Reason:     commandPayload.Reason,
+			BannedBy:   &accountId,
+			ExpiresAt:  &commandPayload.ExpiresAt,
+		}
+		return events.NewEventNow(eventPayload), nil
+	case commands.UnbanAccount:
+		accountId, err := c.AuthenticateCommand(command)
+		if err != nil {
+			return events.Event{}, err
+		}
+		ban, err := c.AccountsService.GetBan(commandPayload.BanId)
+		if err != nil {
+			return events.Event{}, err
+		}
+		if !ban.Active(c.CommentsService.now()) {
+			return events.Event{}, apperrors.New(apperrors.CommandInvalid, "The ban was already lifted or expired")
+		}
+		if ban.Global() {
+			err = c.AccountsService.CheckAdmin(accountId)
+		} else {
+			err = c.CommentsService.CheckPermission(ban.Website, accountId, PermissionModerate)
+		}
+		if err != nil {
+			return events.Event{}, err
+		}
+
+		eventPayload := events.AccountUnbanned{
+			BanId:     ban.BanId,
+			AccountId: ban.AccountId,
+			Website:   ban.Website,
+			LiftedBy:  &accountId,
+		}
+		return events.NewEventNow(eventPayload), nil
+	case commands.RefreshSession:
+		// Command not handled by Comments
+	case commands.Logout:
+		// Command not handled by Comments
+	case commands.LogoutEverywhere:
+		// Command not handled by Comments
+	case commands.GenerateSigningKey:
+		// Command not handled by Comments
+	case commands.ActivateSigningKey:
+		// Command not handled by Comments
+	case commands.RetireSigningKey:
+		// Command not handled by Comments
+	case commands.UnlockAccount:
+		// Command not handled by Comments
+	case commands.EnrollTOTP:
+		// Command not handled by Comments
+	case commands.ConfirmTOTP:
+		// Command not handled by Comments
+	case commands.DisableTOTP:
+		// Command not handled by Comments
+	case commands.LoginTwoFactor:
+		// Command not handled by Comments
+	case commands.StartOIDCLogin:
+		// Command not handled by Comments
+	case commands.CompleteOIDCLogin:
+		// Command not handled by Comments
+	case commands.CreateGuestAccount:
+		// Command not handled by Comments
+	case commands.ClaimGuestIdentity:
+		// Command not handled by Comments
+	case commands.ChangeUsername:
+		// Command not handled by Comments
+	case commands.ChangeEmail:
+		// Command not handled by Comments
+	case commands.UpdateProfile:
+		// Command not handled by Comments
+	case commands.UploadAvatar:
+		// Command not handled by Comments
+	case commands.CreateAPIToken:
+		// Command not handled by Comments
+	case commands.RevokeAPIToken:
+		// Command not handled by Comments
+	case commands.BatchCommand:
+		return events.Event{}, apperrors.New(apperrors.CommandInvalid, "Batch commands are handled by HandleBatch")
+	default:
+		return events.Event{}, apperrors.Newf(apperrors.CommandUnknownType, "unrecognized command type : %s", commandPayload.CommandType())
+	}
+	return events.Event{}, nil
+}
+
+// canModerate reports whether an account may moderate the comments of a
+// thread. Only the authors of comments on threads without website can
+// delete them.
+func (c *CommandHandler) canModerate(commentThreadId, accountId uuid.UUID) (bool, error) {
+	thread, err := c.CommentsService.GetThreadByThreadId(commentThreadId)
+	if err != nil {
+		return false, err
+	}
+	if thread.Website == "" {
+		return false, nil
+	}
+	return c.CommentsService.Can(thread.Website, accountId, PermissionModerate)
+}
+
+// checkRoleChange checks that accountId may change the role of granteeId
+// on website to role, and that the website keeps an owner.
+func (c *CommandHandler) checkRoleChange(accountId uuid.UUID, website string, granteeId uuid.UUID, role string) error {
+	if _, err := c.CommentsService.GetWebsite(website); err != nil {
+		return err
+	}
+	if err := c.CommentsService.CheckPermission(website, accountId, PermissionManageRoles); err != nil {
+		return err
+	}
+	if role == RoleOwner {
+		return nil
+	}
+	roles, err := c.CommentsService.ListRoles(website)
+	if err != nil {
+		return err
+	}
+	owners, granteeIsOwner := 0, false
+	for _, existing := range roles {
+		if existing.Role == RoleOwner {
+			owners++
+			granteeIsOwner = granteeIsOwner || uuid.Equal(existing.AccountId, granteeId)
+		}
+	}
+	if granteeIsOwner && owners == 1 {
+		return LastOwnerErr
+	}
+	return nil
+}
+
+// checkBan checks that accountId may ban bannedAccountId from website.
+// Global bans, without website, are reserved to administrators. Accounts
+// moderating a website can't be banned from it.
+func (c *CommandHandler) checkBan(accountId uuid.UUID, website string, bannedAccountId uuid.UUID) error {
+	if _, err := c.AccountsService.GetAccountByAccountId(bannedAccountId); err != nil {
+		return err
+	}
+	if website == "" {
+		return c.AccountsService.CheckAdmin(accountId)
+	}
+	if _, err := c.CommentsService.GetWebsite(website); err != nil {
+		return err
+	}
+	if err := c.CommentsService.CheckPermission(website, accountId, PermissionModerate); err != nil {
+		return err
+	}
+	moderator, err := c.CommentsService.Can(website, bannedAccountId, PermissionModerate)
+	if err != nil {
+		return err
+	}
+	if moderator {
+		return apperrors.New(apperrors.CommandInvalid, "Moderators of a website can't be banned from it")
+	}
+	return nil
+}
+
+// ScopeOf returns the scope an API token needs to act in a comments
+// command, or an empty scope for other commands.
+func ScopeOf(payload commands.CommandPayload) string {
+	switch payload.(type) {
+	case commands.CreateComment, commands.DeleteComment:
+		return accounts.ScopeComment
+	case commands.ConfigureWebsite, commands.GrantRole, commands.RevokeRole,
+		commands.BanAccount, commands.SuspendAccount, commands.UnbanAccount:
+		return accounts.ScopeModerate
+	}
+	return ""
+}
+
+// AuthenticateCommand returns the account acting in a comments command,
+// the account of its token: a session access token or an API token with
+// the scope of the command, see ScopeOf. CreateComment and DeleteComment
+// commands without token act as their AccountId.
+func (c *CommandHandler) AuthenticateCommand(command commands.Command) (uuid.UUID, error) {
+	var token string
+	switch commandPayload := command.Payload.(type) {
+	case commands.CreateComment:
+		if commandPayload.Token == "" {
+			return commandPayload.AccountId, nil
+		}
+		token = commandPayload.Token
+	case commands.DeleteComment:
+		if commandPayload.Token == "" {
+			return commandPayload.AccountId, nil
+		}
+		token = commandPayload.Token
+	case commands.ConfigureWebsite:
+		token = commandPayload.Token
+	case commands.GrantRole:
+		token = commandPayload.Token
+	case commands.RevokeRole:
+		token = commandPayload.Token
+	case commands.BanAccount:
+		token = commandPayload.Token
+	case commands.SuspendAccount:
+		token = commandPayload.Token
+	case commands.UnbanAccount:
+		token = commandPayload.Token
+	default:
+		return uuid.Nil, apperrors.Newf(apperrors.CommandInvalid, "%s isn't a comments command", command.CommandType)
+	}
+	return c.AccountsService.ValidateToken(token, ScopeOf(command.Payload))
+}
+
+// HandleBatch decides, appends to the events table and handles the
+// commands of batch in a single database transaction, see
+// commands.BatchHandler. Batches can only be handled by a CommandHandler
+// using a database since memory stores can't be rolled back.
+func (c *CommandHandler) HandleBatch(batch commands.BatchCommand) ([]events.Event, error) {
+	batchHandler := &commands.BatchHandler{Begin: c.beginBatch}
+	return batchHandler.HandleBatch(batch)
+}
+
+func (c *CommandHandler) beginBatch() (commands.Transaction, error) {
+	if c.CommentsService.DB == nil {
+		return nil, apperrors.New(apperrors.Internal, "batches can only be handled with a database")
+	}
+	tx, err := c.CommentsService.DB.Beginx()
+	if err != nil {
+		return nil, translateDBError(err, nil)
+	}
+
+	accountsService := *c.AccountsService
+	accountsService.Store = &accounts.DBStore{DB: tx}
+	commentsService := *c.CommentsService
+	commentsService.Store = &DBStore{DB: tx}
+	commentsService.AccountsService = &accountsService
+	eventStore := &events.Store{DB: tx}
+	handler := &CommandHandler{
+		CommentsService: &commentsService,
+		AccountsService: &accountsService,
+		EventHandler: events.EventHandlers{
+			&accounts.EventHandler{AccountsService: &accountsService},
+			&EventHandler{CommentsService: &commentsService},
+			&events.RedactionHandler{Redactor: eventStore},
+		},
+	}
+	return &batchTransaction{
+		tx:             tx,
+		CommandDecider: handler,
+		EventAppender:  eventStore,
+		EventHandler:   handler.EventHandler,
+	}, nil
+}
+
+// batchTransaction decides and handles the commands of a batch with
+// services bound to tx.
+type batchTransaction struct {
+	tx *sqlx.Tx
+	commands.CommandDecider
+	events.EventAppender
+	events.EventHandler
+}
+
+func (t *batchTransaction) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *batchTransaction) Rollback() error {
+	return t.tx.Rollback()
+}
+
+func NewCommandHandler(db *sqlx.DB) CommandHandler {
+	accountsService := &accounts.Accounts{DB: db}
+	commentsService := &Comments{DB: db, AccountsService: accountsService}
+	return CommandHandler{
+		CommentsService: commentsService,
+		AccountsService: accountsService,
+		EventHandler: &EventHandler{
+			CommentsService: commentsService,
+		},
+	}
+}
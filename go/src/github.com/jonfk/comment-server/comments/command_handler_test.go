@@ -0,0 +1,782 @@
+package comments
+
+import (
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/accounts"
+	"github.com/jonfk/comment-server/apperrors"
+	"github.com/jonfk/comment-server/commands"
+	"github.com/jonfk/comment-server/commandtest"
+	"github.com/jonfk/comment-server/events"
+)
+
+var testHMACSecretKey = []byte("secret_key")
+
+func newTestSystem() commandtest.System {
+	accountsService := &accounts.Accounts{Store: accounts.NewMemoryStore(), HMACSecretKey: testHMACSecretKey}
+	commentsService := &Comments{Store: NewMemoryStore()}
+	return commandtest.System{
+		Decider: &CommandHandler{
+			CommentsService: commentsService,
+			AccountsService: accountsService,
+		},
+		EventHandler: events.EventHandlers{
+			&accounts.EventHandler{AccountsService: accountsService},
+			&EventHandler{CommentsService: commentsService},
+		},
+	}
+}
+
+// testSessions issues the access tokens of scenarios, which are written
+// before the systems they run against exist. The sessions of the tokens
+// are started in these systems once the given events create their
+// accounts.
+type testSessions struct {
+	t        *testing.T
+	sessions []accounts.Session
+}
+
+// token returns an access token of a new session of accountId.
+func (s *testSessions) token(accountId uuid.UUID) string {
+	issuer := &accounts.Accounts{Store: accounts.NewMemoryStore(), HMACSecretKey: testHMACSecretKey, SessionLengthInHours: 24}
+	err := (&accounts.EventHandler{AccountsService: issuer}).HandleEvent(events.NewEventNow(events.AccountCreated{AccountId: accountId}))
+	if err != nil {
+		s.t.Fatalf("HandleEvent failed : %v\n", err)
+	}
+	tokens, err := issuer.StartSession(accountId)
+	if err != nil {
+		s.t.Fatalf("StartSession failed : %v\n", err)
+	}
+	session, err := issuer.Store.GetSession(tokens.SessionId)
+	if err != nil {
+		s.t.Fatalf("GetSession failed : %v\n", err)
+	}
+	s.sessions = append(s.sessions, session)
+	return tokens.AccessToken
+}
+
+// newSystem wraps newSystem to start the sessions of the tokens.
+func (s *testSessions) newSystem(newSystem func() commandtest.System) func() commandtest.System {
+	return func() commandtest.System {
+		system := newSystem()
+		system.EventHandler = events.EventHandlers{system.EventHandler, &sessionStarter{
+			store:    system.Decider.(*CommandHandler).AccountsService.Store,
+			sessions: s.sessions,
+		}}
+		return system
+	}
+}
+
+type sessionStarter struct {
+	store    accounts.Store
+	sessions []accounts.Session
+}
+
+func (h *sessionStarter) HandleEvent(event events.Event) error {
+	created, ok := event.Payload.(events.AccountCreated)
+	if !ok {
+		return nil
+	}
+	for _, session := range h.sessions {
+		if uuid.Equal(session.AccountId, created.AccountId) {
+			if err := h.store.InsertSession(session); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func TestCommandHandler(t *testing.T) {
+	accountId, otherAccountId := uuid.NewV4(), uuid.NewV4()
+	threadId, otherThreadId := uuid.NewV4(), uuid.NewV4()
+	commentId, otherThreadCommentId := uuid.NewV4(), uuid.NewV4()
+
+	given := []events.EventPayload{
+		events.AccountCreated{AccountId: accountId, Username: "username", Email: "email@example.com"},
+		events.AccountCreated{AccountId: otherAccountId, Username: "other", Email: "other@example.com"},
+		events.CommentThreadCreated{CommentThreadId: threadId, PageUrl: "pageUrl", Title: "title"},
+		events.CommentThreadCreated{CommentThreadId: otherThreadId, PageUrl: "otherPageUrl", Title: "title"},
+		events.CommentCreated{CommentId: commentId, Data: "comment", CommentThreadId: threadId, AccountId: accountId},
+		events.CommentCreated{CommentId: otherThreadCommentId, Data: "comment", CommentThreadId: otherThreadId, AccountId: accountId},
+	}
+
+	verifiedOnlyThreadId := uuid.NewV4()
+	givenVerifiedOnly := []events.EventPayload{
+		events.AccountCreated{AccountId: accountId, Username: "username", Email: "email@example.com"},
+		events.WebsiteConfigured{Url: "https://example.com", RequireVerifiedEmail: true},
+		events.CommentThreadCreated{CommentThreadId: verifiedOnlyThreadId, PageUrl: "pageUrl", Title: "title", Website: "https://example.com"},
+	}
+
+	guestId, guestThreadId := uuid.NewV4(), uuid.NewV4()
+	givenGuest := func(allowGuests bool) []events.EventPayload {
+		return []events.EventPayload{
+			events.AccountCreated{AccountId: guestId, AccountType: accounts.AccountTypeGuest, DisplayName: "guest"},
+			events.WebsiteConfigured{Url: "https://example.com", AllowGuests: allowGuests},
+			events.CommentThreadCreated{CommentThreadId: guestThreadId, PageUrl: "pageUrl", Title: "title", Website: "https://example.com"},
+			events.CommentThreadCreated{CommentThreadId: threadId, PageUrl: "otherPageUrl", Title: "title"},
+		}
+	}
+
+	commandtest.Run(t, newTestSystem,
+		commandtest.Scenario{
+			Name: "CreateCommentThread creates a thread",
+			When: commands.CreateCommentThread{PageUrl: "pageUrl", Title: "title"},
+			Then: []events.EventPayload{events.CommentThreadCreated{PageUrl: "pageUrl", Title: "title"}},
+		},
+		commandtest.Scenario{
+			Name:      "CreateCommentThread fails on an unknown website",
+			When:      commands.CreateCommentThread{PageUrl: "pageUrl", Title: "title", Website: "https://example.com"},
+			ThenError: apperrors.WebsiteNotFound,
+		},
+		commandtest.Scenario{
+			Name:      "CreateComment requires a verified email on websites requiring it",
+			Given:     givenVerifiedOnly,
+			When:      commands.CreateComment{Data: "comment", CommentThreadId: verifiedOnlyThreadId, AccountId: accountId},
+			ThenError: apperrors.AccountEmailNotVerified,
+		},
+		commandtest.Scenario{
+			Name:  "CreateComment accepts a verified email on websites requiring it",
+			Given: append(givenVerifiedOnly, events.EmailVerified{AccountId: accountId, Email: "email@example.com"}),
+			When:  commands.CreateComment{Data: "comment", CommentThreadId: verifiedOnlyThreadId, AccountId: accountId},
+			Then: []events.EventPayload{
+				events.CommentCreated{Data: "comment", CommentThreadId: verifiedOnlyThreadId, AccountId: accountId},
+			},
+		},
+		commandtest.Scenario{
+			Name:  "CreateComment accepts guests on websites allowing them",
+			Given: givenGuest(true),
+			When:  commands.CreateComment{Data: "comment", CommentThreadId: guestThreadId, AccountId: guestId},
+			Then: []events.EventPayload{
+				events.CommentCreated{Data: "comment", CommentThreadId: guestThreadId, AccountId: guestId},
+			},
+		},
+		commandtest.Scenario{
+			Name:      "CreateComment rejects guests on websites not allowing them",
+			Given:     givenGuest(false),
+			When:      commands.CreateComment{Data: "comment", CommentThreadId: guestThreadId, AccountId: guestId},
+			ThenError: apperrors.AuthForbidden,
+		},
+		commandtest.Scenario{
+			Name:      "CreateComment rejects guests on threads without a website",
+			Given:     givenGuest(true),
+			When:      commands.CreateComment{Data: "comment", CommentThreadId: threadId, AccountId: guestId},
+			ThenError: apperrors.AuthForbidden,
+		},
+		commandtest.Scenario{
+			Name: "Comments of a claimed guest belong to the account claiming it",
+			Given: append(givenGuest(true),
+				events.AccountCreated{AccountId: accountId, Username: "username", Email: "email@example.com"},
+				events.CommentCreated{CommentId: commentId, Data: "comment", CommentThreadId: guestThreadId, AccountId: guestId},
+				events.GuestIdentityClaimed{AccountId: accountId, GuestAccountId: guestId},
+			),
+			When: commands.DeleteComment{CommentId: commentId, AccountId: accountId},
+			Then: []events.EventPayload{events.CommentDeleted{CommentId: commentId}},
+		},
+		commandtest.Scenario{
+			Name:  "CreateComment returns the name and avatar of the author",
+			Given: append(given, events.ProfileUpdated{AccountId: accountId, DisplayName: "Name", AvatarURL: "https://example.com/avatar.png"}),
+			When:  commands.CreateComment{Data: "comment", CommentThreadId: threadId, AccountId: accountId},
+			Then: []events.EventPayload{
+				events.CommentCreated{Data: "comment", CommentThreadId: threadId, AccountId: accountId, AuthorName: "Name", AvatarURL: "https://example.com/avatar.png"},
+			},
+		},
+		commandtest.Scenario{
+			Name:  "CreateComment creates a reply",
+			Given: given,
+			When:  commands.CreateComment{Data: "reply", ParentId: &commentId, CommentThreadId: threadId, AccountId: otherAccountId},
+			Then: []events.EventPayload{
+				events.CommentCreated{Data: "reply", ParentId: &commentId, CommentThreadId: threadId, AccountId: otherAccountId},
+			},
+		},
+		commandtest.Scenario{
+			Name:      "CreateComment fails on an unknown thread",
+			Given:     given,
+			When:      commands.CreateComment{Data: "comment", CommentThreadId: uuid.NewV4(), AccountId: accountId},
+			ThenError: apperrors.CommentThreadNotFound,
+		},
+		commandtest.Scenario{
+			Name:      "CreateComment fails on an unknown account",
+			Given:     given,
+			When:      commands.CreateComment{Data: "comment", CommentThreadId: threadId, AccountId: uuid.NewV4()},
+			ThenError: apperrors.AccountNotFound,
+		},
+		commandtest.Scenario{
+			Name:      "CreateComment rejects a parent from another thread",
+			Given:     given,
+			When:      commands.CreateComment{Data: "reply", ParentId: &otherThreadCommentId, CommentThreadId: threadId, AccountId: accountId},
+			ThenError: apperrors.CommentInvalidParent,
+		},
+		commandtest.Scenario{
+			Name:  "DeleteComment deletes the author's comment",
+			Given: given,
+			When:  commands.DeleteComment{CommentId: commentId, AccountId: accountId},
+			Then:  []events.EventPayload{events.CommentDeleted{CommentId: commentId}},
+		},
+		commandtest.Scenario{
+			Name:      "DeleteComment forbids deleting another account's comment",
+			Given:     given,
+			When:      commands.DeleteComment{CommentId: commentId, AccountId: otherAccountId},
+			ThenError: apperrors.AuthForbidden,
+		},
+		commandtest.Scenario{
+			Name:      "DeleteComment fails on a deleted comment",
+			Given:     append(given, events.CommentDeleted{CommentId: commentId}),
+			When:      commands.DeleteComment{CommentId: commentId, AccountId: accountId},
+			ThenError: apperrors.CommentNotFound,
+		},
+	)
+}
+
+func TestRoles(t *testing.T) {
+	ownerId, moderatorId, commenterId := uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+	guestId, adminId := uuid.NewV4(), uuid.NewV4()
+	threadId, commentId := uuid.NewV4(), uuid.NewV4()
+	website := "https://example.com"
+
+	given := []events.EventPayload{
+		events.AccountCreated{AccountId: ownerId, Username: "owner", Email: "owner@example.com"},
+		events.AccountCreated{AccountId: moderatorId, Username: "moderator", Email: "moderator@example.com"},
+		events.AccountCreated{AccountId: commenterId, Username: "commenter", Email: "commenter@example.com"},
+		events.AccountCreated{AccountId: adminId, Username: "admin", Email: "admin@example.com"},
+		events.WebsiteConfigured{Url: website, OwnerId: &ownerId},
+		events.RoleGranted{Website: website, AccountId: moderatorId, Role: RoleModerator, GrantedBy: ownerId},
+		events.CommentThreadCreated{CommentThreadId: threadId, PageUrl: "pageUrl", Title: "title", Website: website},
+		events.CommentCreated{CommentId: commentId, Data: "comment", CommentThreadId: threadId, AccountId: commenterId},
+	}
+
+	sessions := &testSessions{t: t}
+	ownerToken, moderatorToken := sessions.token(ownerId), sessions.token(moderatorId)
+	guestToken, adminToken := sessions.token(guestId), sessions.token(adminId)
+	newSystem := func() commandtest.System {
+		system := newTestSystem()
+		system.Decider.(*CommandHandler).AccountsService.Admins = []uuid.UUID{adminId}
+		return system
+	}
+
+	commandtest.Run(t, sessions.newSystem(newSystem),
+		commandtest.Scenario{
+			Name: "ConfigureWebsite makes the account configuring a new website its owner",
+			Given: []events.EventPayload{
+				events.AccountCreated{AccountId: ownerId, Username: "owner", Email: "owner@example.com"},
+			},
+			When: commands.ConfigureWebsite{Token: ownerToken, Url: website},
+			Then: []events.EventPayload{events.WebsiteConfigured{Url: website, OwnerId: &ownerId}},
+		},
+		commandtest.Scenario{
+			Name:      "ConfigureWebsite requires an account",
+			When:      commands.ConfigureWebsite{Url: website},
+			ThenError: apperrors.AuthInvalidToken,
+		},
+		commandtest.Scenario{
+			Name: "ConfigureWebsite doesn't let guests own websites",
+			Given: []events.EventPayload{
+				events.AccountCreated{AccountId: guestId, AccountType: accounts.AccountTypeGuest, DisplayName: "guest"},
+			},
+			When:      commands.ConfigureWebsite{Token: guestToken, Url: website},
+			ThenError: apperrors.CommandInvalid,
+		},
+		commandtest.Scenario{
+			Name:      "ConfigureWebsite is reserved to the owners of a website",
+			Given:     given,
+			When:      commands.ConfigureWebsite{Token: moderatorToken, Url: website, AllowGuests: true},
+			ThenError: apperrors.AuthForbidden,
+		},
+		commandtest.Scenario{
+			Name:  "ConfigureWebsite lets owners change the settings",
+			Given: given,
+			When:  commands.ConfigureWebsite{Token: ownerToken, Url: website, AllowGuests: true},
+			Then:  []events.EventPayload{events.WebsiteConfigured{Url: website, AllowGuests: true}},
+		},
+		commandtest.Scenario{
+			Name:      "ConfigureWebsite reserves websites without owner to administrators",
+			Given:     append(given, events.WebsiteConfigured{Url: "https://other.example.com"}),
+			When:      commands.ConfigureWebsite{Token: ownerToken, Url: "https://other.example.com", AllowGuests: true},
+			ThenError: apperrors.AuthForbidden,
+		},
+		commandtest.Scenario{
+			Name:  "ConfigureWebsite lets administrators change the settings of websites without owner",
+			Given: append(given, events.WebsiteConfigured{Url: "https://other.example.com"}),
+			When:  commands.ConfigureWebsite{Token: adminToken, Url: "https://other.example.com", AllowGuests: true},
+			Then:  []events.EventPayload{events.WebsiteConfigured{Url: "https://other.example.com", AllowGuests: true}},
+		},
+		commandtest.Scenario{
+			Name:  "DeleteComment lets moderators delete the comments of others",
+			Given: given,
+			When:  commands.DeleteComment{CommentId: commentId, AccountId: moderatorId},
+			Then:  []events.EventPayload{events.CommentDeleted{CommentId: commentId}},
+		},
+		commandtest.Scenario{
+			Name:      "DeleteComment doesn't let former moderators delete the comments of others",
+			Given:     append(given, events.RoleRevoked{Website: website, AccountId: moderatorId, RevokedBy: ownerId}),
+			When:      commands.DeleteComment{CommentId: commentId, AccountId: moderatorId},
+			ThenError: apperrors.AuthForbidden,
+		},
+		commandtest.Scenario{
+			Name:  "GrantRole lets owners grant roles",
+			Given: given,
+			When:  commands.GrantRole{Token: ownerToken, Website: website, GranteeId: commenterId, Role: RoleModerator},
+			Then: []events.EventPayload{
+				events.RoleGranted{Website: website, AccountId: commenterId, Role: RoleModerator, GrantedBy: ownerId},
+			},
+		},
+		commandtest.Scenario{
+			Name:      "GrantRole is reserved to owners",
+			Given:     given,
+			When:      commands.GrantRole{Token: moderatorToken, Website: website, GranteeId: commenterId, Role: RoleModerator},
+			ThenError: apperrors.AuthForbidden,
+		},
+		commandtest.Scenario{
+			Name:      "GrantRole rejects unknown roles",
+			Given:     given,
+			When:      commands.GrantRole{Token: ownerToken, Website: website, GranteeId: commenterId, Role: "admin"},
+			ThenError: apperrors.CommandInvalid,
+		},
+		commandtest.Scenario{
+			Name:      "RevokeRole keeps the last owner of a website",
+			Given:     given,
+			When:      commands.RevokeRole{Token: ownerToken, Website: website, GranteeId: ownerId},
+			ThenError: apperrors.CommandInvalid,
+		},
+		commandtest.Scenario{
+			Name:      "CreateComment rejects accounts banned from the website",
+			Given:     append(given, events.RoleGranted{Website: website, AccountId: commenterId, Role: RoleBanned, GrantedBy: ownerId}),
+			When:      commands.CreateComment{Data: "comment", CommentThreadId: threadId, AccountId: commenterId},
+			ThenError: apperrors.AccountBanned,
+		},
+		commandtest.Scenario{
+			Name: "CreateComment accepts accounts whose ban was revoked",
+			Given: append(given,
+				events.RoleGranted{Website: website, AccountId: commenterId, Role: RoleBanned, GrantedBy: ownerId},
+				events.RoleRevoked{Website: website, AccountId: commenterId, RevokedBy: ownerId},
+			),
+			When: commands.CreateComment{Data: "comment", CommentThreadId: threadId, AccountId: commenterId},
+			Then: []events.EventPayload{
+				events.CommentCreated{Data: "comment", CommentThreadId: threadId, AccountId: commenterId},
+			},
+		},
+	)
+}
+
+func TestBans(t *testing.T) {
+	ownerId, moderatorId, commenterId := uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+	threadId, otherThreadId, banId := uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+	website := "https://example.com"
+	tomorrow := time.Now().Add(24 * time.Hour)
+	yesterday := time.Now().Add(-24 * time.Hour)
+
+	given := []events.EventPayload{
+		events.AccountCreated{AccountId: ownerId, Username: "owner", Email: "owner@example.com"},
+		events.AccountCreated{AccountId: moderatorId, Username: "moderator", Email: "moderator@example.com"},
+		events.AccountCreated{AccountId: commenterId, Username: "commenter", Email: "commenter@example.com"},
+		events.WebsiteConfigured{Url: website, OwnerId: &ownerId},
+		events.WebsiteConfigured{Url: "https://other.example.com"},
+		events.RoleGranted{Website: website, AccountId: moderatorId, Role: RoleModerator, GrantedBy: ownerId},
+		events.CommentThreadCreated{CommentThreadId: threadId, PageUrl: "pageUrl", Title: "title", Website: website},
+		events.CommentThreadCreated{CommentThreadId: otherThreadId, PageUrl: "pageUrl", Title: "title", Website: "https://other.example.com"},
+	}
+	banned := func(expiresAt *time.Time) []events.EventPayload {
+		return append(given, events.AccountBanned{BanId: banId, AccountId: commenterId, Website: website, BannedBy: &moderatorId, ExpiresAt: expiresAt})
+	}
+	sessions := &testSessions{t: t}
+	ownerToken, moderatorToken, commenterToken := sessions.token(ownerId), sessions.token(moderatorId), sessions.token(commenterId)
+
+	commandtest.Run(t, sessions.newSystem(newTestSystem),
+		commandtest.Scenario{
+			Name:  "BanAccount lets moderators ban accounts from their website",
+			Given: given,
+			When:  commands.BanAccount{Token: moderatorToken, BannedAccountId: commenterId, Website: website, Reason: "spam", HideComments: true},
+			Then: []events.EventPayload{
+				events.AccountBanned{AccountId: commenterId, Website: website, Reason: "spam", HideComments: true, BannedBy: &moderatorId},
+			},
+		},
+		commandtest.Scenario{
+			Name:      "BanAccount is reserved to moderators",
+			Given:     given,
+			When:      commands.BanAccount{Token: commenterToken, BannedAccountId: moderatorId, Website: website},
+			ThenError: apperrors.AuthForbidden,
+		},
+		commandtest.Scenario{
+			Name:      "BanAccount can't ban moderators",
+			Given:     given,
+			When:      commands.BanAccount{Token: ownerToken, BannedAccountId: moderatorId, Website: website},
+			ThenError: apperrors.CommandInvalid,
+		},
+		commandtest.Scenario{
+			Name:      "SuspendAccount requires an expiry in the future",
+			Given:     given,
+			When:      commands.SuspendAccount{Token: moderatorToken, BannedAccountId: commenterId, Website: website, ExpiresAt: yesterday},
+			ThenError: apperrors.CommandInvalid,
+		},
+		commandtest.Scenario{
+			Name:  "SuspendAccount bans accounts until the suspension expires",
+			Given: given,
+			When:  commands.SuspendAccount{Token: moderatorToken, BannedAccountId: commenterId, Website: website, ExpiresAt: tomorrow},
+			Then: []events.EventPayload{
+				events.AccountBanned{AccountId: commenterId, Website: website, Suspension: true, BannedBy: &moderatorId, ExpiresAt: &tomorrow},
+			},
+		},
+		commandtest.Scenario{
+			Name:      "CreateComment rejects accounts banned from the website",
+			Given:     banned(&tomorrow),
+			When:      commands.CreateComment{Data: "comment", CommentThreadId: threadId, AccountId: commenterId},
+			ThenError: apperrors.AccountBanned,
+		},
+		commandtest.Scenario{
+			Name:  "CreateComment accepts accounts banned from other websites",
+			Given: banned(nil),
+			When:  commands.CreateComment{Data: "comment", CommentThreadId: otherThreadId, AccountId: commenterId},
+			Then: []events.EventPayload{
+				events.CommentCreated{Data: "comment", CommentThreadId: otherThreadId, AccountId: commenterId},
+			},
+		},
+		commandtest.Scenario{
+			Name:      "CreateComment rejects globally banned accounts on every website",
+			Given:     append(given, events.AccountBanned{BanId: banId, AccountId: commenterId}),
+			When:      commands.CreateComment{Data: "comment", CommentThreadId: otherThreadId, AccountId: commenterId},
+			ThenError: apperrors.AccountBanned,
+		},
+		commandtest.Scenario{
+			Name:  "CreateComment accepts accounts whose ban expired",
+			Given: banned(&yesterday),
+			When:  commands.CreateComment{Data: "comment", CommentThreadId: threadId, AccountId: commenterId},
+			Then: []events.EventPayload{
+				events.CommentCreated{Data: "comment", CommentThreadId: threadId, AccountId: commenterId},
+			},
+		},
+		commandtest.Scenario{
+			Name:  "UnbanAccount lets moderators lift bans",
+			Given: banned(nil),
+			When:  commands.UnbanAccount{Token: ownerToken, BanId: banId},
+			Then: []events.EventPayload{
+				events.AccountUnbanned{BanId: banId, AccountId: commenterId, Website: website, LiftedBy: &ownerId},
+			},
+		},
+		commandtest.Scenario{
+			Name:      "UnbanAccount rejects lifted bans",
+			Given:     append(banned(nil), events.AccountUnbanned{BanId: banId, AccountId: commenterId, Website: website}),
+			When:      commands.UnbanAccount{Token: ownerToken, BanId: banId},
+			ThenError: apperrors.CommandInvalid,
+		},
+		commandtest.Scenario{
+			Name:  "CreateComment accepts accounts whose ban was lifted",
+			Given: append(banned(nil), events.AccountUnbanned{BanId: banId, AccountId: commenterId, Website: website}),
+			When:  commands.CreateComment{Data: "comment", CommentThreadId: threadId, AccountId: commenterId},
+			Then: []events.EventPayload{
+				events.CommentCreated{Data: "comment", CommentThreadId: threadId, AccountId: commenterId},
+			},
+		},
+	)
+}
+
+func TestGlobalBans(t *testing.T) {
+	adminId, moderatorId, commenterId, banId := uuid.NewV4(), uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+	website := "https://example.com"
+	tomorrow := time.Now().Add(24 * time.Hour)
+
+	newSystem := func() commandtest.System {
+		system := newTestSystem()
+		system.Decider.(*CommandHandler).AccountsService.Admins = []uuid.UUID{adminId}
+		return system
+	}
+	given := []events.EventPayload{
+		events.AccountCreated{AccountId: adminId, Username: "admin", Email: "admin@example.com"},
+		events.AccountCreated{AccountId: moderatorId, Username: "moderator", Email: "moderator@example.com"},
+		events.AccountCreated{AccountId: commenterId, Username: "commenter", Email: "commenter@example.com"},
+		events.WebsiteConfigured{Url: website, OwnerId: &moderatorId},
+	}
+	banned := append(given, events.AccountBanned{BanId: banId, AccountId: commenterId, BannedBy: &adminId})
+	sessions := &testSessions{t: t}
+	adminToken, moderatorToken := sessions.token(adminId), sessions.token(moderatorId)
+
+	commandtest.Run(t, sessions.newSystem(newSystem),
+		commandtest.Scenario{
+			Name:  "BanAccount lets administrators ban accounts from every website",
+			Given: given,
+			When:  commands.BanAccount{Token: adminToken, BannedAccountId: commenterId, Reason: "spam"},
+			Then: []events.EventPayload{
+				events.AccountBanned{AccountId: commenterId, Reason: "spam", BannedBy: &adminId},
+			},
+		},
+		commandtest.Scenario{
+			Name:      "BanAccount reserves global bans to administrators",
+			Given:     given,
+			When:      commands.BanAccount{Token: moderatorToken, BannedAccountId: commenterId},
+			ThenError: apperrors.AuthForbidden,
+		},
+		commandtest.Scenario{
+			Name:      "BanAccount requires an account",
+			Given:     given,
+			When:      commands.BanAccount{BannedAccountId: commenterId},
+			ThenError: apperrors.AuthInvalidToken,
+		},
+		commandtest.Scenario{
+			Name:      "SuspendAccount reserves global suspensions to administrators",
+			Given:     given,
+			When:      commands.SuspendAccount{Token: moderatorToken, BannedAccountId: commenterId, ExpiresAt: tomorrow},
+			ThenError: apperrors.AuthForbidden,
+		},
+		commandtest.Scenario{
+			Name:  "UnbanAccount lets administrators lift global bans",
+			Given: banned,
+			When:  commands.UnbanAccount{Token: adminToken, BanId: banId},
+			Then: []events.EventPayload{
+				events.AccountUnbanned{BanId: banId, AccountId: commenterId, LiftedBy: &adminId},
+			},
+		},
+		commandtest.Scenario{
+			Name:      "UnbanAccount reserves global bans to administrators",
+			Given:     banned,
+			When:      commands.UnbanAccount{Token: moderatorToken, BanId: banId},
+			ThenError: apperrors.AuthForbidden,
+		},
+	)
+}
+
+func TestBansFollowClock(t *testing.T) {
+	ownerId, commenterId := uuid.NewV4(), uuid.NewV4()
+	website := "https://example.com"
+	lastWeek := time.Now().UTC().Add(-7 * 24 * time.Hour)
+	yesterday := time.Now().UTC().Add(-24 * time.Hour)
+
+	newSystem := func() commandtest.System {
+		system := newTestSystem()
+		system.Decider.(*CommandHandler).CommentsService.Now = func() time.Time { return lastWeek }
+		return system
+	}
+	sessions := &testSessions{t: t}
+	ownerToken := sessions.token(ownerId)
+	commandtest.Run(t, sessions.newSystem(newSystem),
+		commandtest.Scenario{
+			Name: "SuspendAccount checks the expiry against the clock of the service",
+			Given: []events.EventPayload{
+				events.AccountCreated{AccountId: ownerId, Username: "owner", Email: "owner@example.com"},
+				events.AccountCreated{AccountId: commenterId, Username: "commenter", Email: "commenter@example.com"},
+				events.WebsiteConfigured{Url: website, OwnerId: &ownerId},
+			},
+			When: commands.SuspendAccount{Token: ownerToken, BannedAccountId: commenterId, Website: website, ExpiresAt: yesterday},
+			Then: []events.EventPayload{
+				events.AccountBanned{AccountId: commenterId, Website: website, Suspension: true, BannedBy: &ownerId, ExpiresAt: &yesterday},
+			},
+		},
+	)
+}
+
+func TestBanHidesComments(t *testing.T) {
+	system := newTestSystem()
+	accountId, threadId, otherThreadId := uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+	commentId, otherCommentId := uuid.NewV4(), uuid.NewV4()
+	banId, globalBanId, suspensionId := uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+	now := time.Now().UTC()
+	tomorrow := now.Add(24 * time.Hour)
+	handleEvents := func(payloads ...events.EventPayload) {
+		for _, payload := range payloads {
+			if err := system.EventHandler.HandleEvent(events.NewEventNow(payload)); err != nil {
+				t.Fatalf("HandleEvent failed : %v\n", err)
+			}
+		}
+	}
+	handleEvents(
+		events.AccountCreated{AccountId: accountId, Username: "username", Email: "email@example.com"},
+		events.WebsiteConfigured{Url: "https://example.com"},
+		events.CommentThreadCreated{CommentThreadId: threadId, PageUrl: "pageUrl", Title: "title", Website: "https://example.com"},
+		events.CommentThreadCreated{CommentThreadId: otherThreadId, PageUrl: "otherPageUrl", Title: "title"},
+		events.CommentCreated{CommentId: commentId, Data: "comment", CommentThreadId: threadId, AccountId: accountId},
+		events.CommentCreated{CommentId: otherCommentId, Data: "comment", CommentThreadId: otherThreadId, AccountId: accountId},
+		events.AccountBanned{BanId: banId, AccountId: accountId, Website: "https://example.com", HideComments: true},
+	)
+	commentsService := system.Decider.(*CommandHandler).CommentsService
+	commentsService.AccountsService = system.Decider.(*CommandHandler).AccountsService
+	commentsService.Now = func() time.Time { return now }
+	hidden := func(commentId uuid.UUID) bool {
+		comment, err := commentsService.GetCommentById(commentId)
+		if err != nil {
+			t.Fatalf("GetCommentById failed : %v\n", err)
+		}
+		return comment.Hidden
+	}
+
+	if !hidden(commentId) || hidden(otherCommentId) {
+		t.Fatal("a ban should only hide the comments on the threads of its website")
+	}
+	shown, err := commentsService.CommentsOfThread(threadId)
+	if err != nil || len(shown) != 0 {
+		t.Fatalf("CommentsOfThread should leave hidden comments out but returned %v (%v)", shown, err)
+	}
+	accountComments, err := commentsService.CommentsOfAccount(accountId)
+	if err != nil || len(accountComments) != 2 {
+		t.Fatalf("CommentsOfAccount should return every comment but returned %v (%v)", accountComments, err)
+	}
+	for _, comment := range accountComments {
+		if comment.Hidden != uuid.Equal(comment.CommentId, commentId) {
+			t.Fatalf("CommentsOfAccount should return the visibility of the comments but returned %v", accountComments)
+		}
+	}
+
+	handleEvents(
+		events.AccountBanned{BanId: globalBanId, AccountId: accountId, HideComments: true},
+		events.AccountUnbanned{BanId: banId, AccountId: accountId, Website: "https://example.com"},
+	)
+	if !hidden(commentId) || !hidden(otherCommentId) {
+		t.Fatal("lifting a ban should keep the comments hidden by another ban hidden")
+	}
+	handleEvents(events.AccountUnbanned{BanId: globalBanId, AccountId: accountId})
+	if hidden(commentId) || hidden(otherCommentId) {
+		t.Fatal("lifting the last ban hiding the comments should show them")
+	}
+	shown, err = commentsService.CommentsOfThread(threadId)
+	if err != nil || len(shown) != 1 || !uuid.Equal(shown[0].CommentId, commentId) {
+		t.Fatalf("CommentsOfThread should return the comments shown again but returned %v (%v)", shown, err)
+	}
+
+	handleEvents(events.AccountBanned{BanId: suspensionId, AccountId: accountId, Website: "https://example.com", HideComments: true, ExpiresAt: &tomorrow})
+	if !hidden(commentId) {
+		t.Fatal("a ban should hide the comments until it expires")
+	}
+	commentsService.Now = func() time.Time { return tomorrow }
+	if hidden(commentId) {
+		t.Fatal("an expired ban should show the comments it hid")
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	deletedId, otherId, threadId := uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+	// root (deleted) <- reply (other) <- nested (deleted)
+	// leaf (deleted)
+	// parent (deleted) <- child (deleted)
+	rootId, replyId, nestedId := uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+	leafId, parentId, childId := uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+	given := []events.EventPayload{
+		events.AccountCreated{AccountId: deletedId, Username: "deleted", Email: "deleted@example.com"},
+		events.AccountCreated{AccountId: otherId, Username: "other", Email: "other@example.com"},
+		events.CommentThreadCreated{CommentThreadId: threadId, PageUrl: "pageUrl", Title: "title"},
+		events.CommentCreated{CommentId: rootId, Data: "root", CommentThreadId: threadId, AccountId: deletedId},
+		events.CommentCreated{CommentId: replyId, Data: "reply", CommentThreadId: threadId, AccountId: otherId, ParentId: &rootId},
+		events.CommentCreated{CommentId: nestedId, Data: "nested", CommentThreadId: threadId, AccountId: deletedId, ParentId: &replyId},
+		events.CommentCreated{CommentId: leafId, Data: "leaf", CommentThreadId: threadId, AccountId: deletedId},
+		events.CommentCreated{CommentId: parentId, Data: "parent", CommentThreadId: threadId, AccountId: deletedId},
+		events.CommentCreated{CommentId: childId, Data: "child", CommentThreadId: threadId, AccountId: deletedId, ParentId: &parentId},
+		events.WebsiteConfigured{Url: "https://example.com", OwnerId: &deletedId},
+		events.RoleGranted{Website: "https://example.com", AccountId: otherId, Role: RoleModerator, GrantedBy: deletedId},
+	}
+	deleteAccount := func(t *testing.T, mode string) *Comments {
+		system := newTestSystem()
+		commentsService := system.Decider.(*CommandHandler).CommentsService
+		commentsService.AccountsService = system.Decider.(*CommandHandler).AccountsService
+		for _, payload := range append(given, events.AccountDeleted{AccountId: deletedId, Mode: mode}) {
+			if err := system.EventHandler.HandleEvent(events.NewEventNow(payload)); err != nil {
+				t.Fatalf("HandleEvent failed : %v\n", err)
+			}
+		}
+		return commentsService
+	}
+
+	t.Run("anonymize keeps the comments without their author", func(t *testing.T) {
+		commentsService := deleteAccount(t, accounts.DeletionAnonymize)
+		for _, commentId := range []uuid.UUID{rootId, nestedId, leafId, parentId, childId} {
+			comment, err := commentsService.GetCommentById(commentId)
+			if err != nil {
+				t.Fatalf("GetCommentById failed : %v\n", err)
+			}
+			if comment.Deleted || comment.Data == "" || comment.AuthorName != accounts.DeletedAccountName {
+				t.Fatalf("the comment should be kept and anonymized but got %v", comment)
+			}
+		}
+	})
+
+	t.Run("deletion revokes the roles of the account", func(t *testing.T) {
+		commentsService := deleteAccount(t, accounts.DeletionAnonymize)
+		roles, err := commentsService.store().ListRolesOfAccount(deletedId)
+		if err != nil || len(roles) != 0 {
+			t.Fatalf("a deleted account should have no roles but got %v (%v)", roles, err)
+		}
+		roles, err = commentsService.store().ListRolesOfAccount(otherId)
+		if err != nil || len(roles) != 1 {
+			t.Fatalf("the roles granted by a deleted account should be kept but got %v (%v)", roles, err)
+		}
+	})
+
+	t.Run("purge removes the comments and keeps the replies of others", func(t *testing.T) {
+		commentsService := deleteAccount(t, accounts.DeletionPurge)
+		root, err := commentsService.GetCommentById(rootId)
+		if err != nil || !root.Deleted || root.Data != "" {
+			t.Fatalf("a comment with replies should be replaced by a tombstone but got %v (%v)", root, err)
+		}
+		reply, err := commentsService.GetCommentById(replyId)
+		if err != nil || reply.Deleted || reply.Data != "reply" || !uuid.Equal(reply.ParentId.UUID, rootId) {
+			t.Fatalf("the replies of other accounts should be kept but got %v (%v)", reply, err)
+		}
+		for _, commentId := range []uuid.UUID{nestedId, leafId, parentId, childId} {
+			if _, err := commentsService.GetCommentById(commentId); err != CommentNotFoundErr {
+				t.Fatalf("comments without replies of other accounts should be removed but got %v", err)
+			}
+		}
+	})
+}
+
+func TestAuthenticateCommand(t *testing.T) {
+	system := newTestSystem()
+	handler := system.Decider.(*CommandHandler)
+	handler.AccountsService.SessionLengthInHours = 1
+	accountId, threadId, commentId := uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+	for _, payload := range []events.EventPayload{
+		events.AccountCreated{AccountId: accountId, Username: "username", Email: "email@example.com"},
+		events.CommentThreadCreated{CommentThreadId: threadId, PageUrl: "pageUrl", Title: "title"},
+		events.CommentCreated{CommentId: commentId, Data: "comment", CommentThreadId: threadId, AccountId: accountId},
+	} {
+		if err := system.EventHandler.HandleEvent(events.NewEventNow(payload)); err != nil {
+			t.Fatalf("HandleEvent failed : %v\n", err)
+		}
+	}
+	session, err := handler.AccountsService.StartSession(accountId)
+	if err != nil {
+		t.Fatalf("StartSession failed : %v\n", err)
+	}
+	accountsHandler := &accounts.CommandHandler{
+		AccountsService: handler.AccountsService,
+		EventHandler:    &accounts.EventHandler{AccountsService: handler.AccountsService},
+	}
+	response, err := accountsHandler.HandleCommand(commands.CreateCommand(
+		commands.CreateAPIToken{Token: session.AccessToken, Name: "import", Scopes: []string{accounts.ScopeComment}}))
+	if err != nil {
+		t.Fatalf("CreateAPIToken failed : %v\n", err)
+	}
+	apiToken := response.Secrets.APIToken
+
+	event, err := handler.DecideCommand(commands.CreateCommand(commands.DeleteComment{CommentId: commentId, AccountId: uuid.NewV4(), Token: apiToken}))
+	if err != nil {
+		t.Fatalf("DeleteComment should accept an API token with the comment scope : %v", err)
+	}
+	if _, ok := event.Payload.(events.CommentDeleted); !ok {
+		t.Fatalf("DeleteComment should act as the account of the token but returned %v", event)
+	}
+
+	for _, payload := range []commands.CommandPayload{
+		commands.BanAccount{Token: apiToken, BannedAccountId: uuid.NewV4(), Website: "https://example.com"},
+		commands.GrantRole{Token: apiToken, Website: "https://example.com", GranteeId: uuid.NewV4(), Role: RoleModerator},
+		commands.UnbanAccount{Token: apiToken, BanId: uuid.NewV4()},
+	} {
+		_, err := handler.DecideCommand(commands.CreateCommand(payload))
+		if err != accounts.InsufficientScopeErr {
+			t.Fatalf("%s needs the moderate scope but DecideCommand returned %v", payload.CommandType(), err)
+		}
+	}
+	for _, payload := range []commands.CommandPayload{
+		commands.DeleteComment{CommentId: commentId, Token: session.AccessToken},
+		commands.BanAccount{Token: session.AccessToken, BannedAccountId: uuid.NewV4(), Website: "https://example.com"},
+	} {
+		if _, err := handler.AuthenticateCommand(commands.CreateCommand(payload)); err != nil {
+			t.Fatalf("session access tokens should have every scope but AuthenticateCommand returned %v", err)
+		}
+	}
+	if _, err := handler.AuthenticateCommand(commands.CreateCommand(commands.UnbanAccount{BanId: uuid.NewV4()})); !apperrors.Is(err, apperrors.AuthInvalidToken) {
+		t.Fatalf("AuthenticateCommand should require a token but returned %v", err)
+	}
+	_, err = handler.AuthenticateCommand(commands.CreateCommand(commands.LogoutEverywhere{Token: session.AccessToken}))
+	if !apperrors.Is(err, apperrors.CommandInvalid) {
+		t.Fatalf("AuthenticateCommand should only authenticate comments commands but returned %v", err)
+	}
+}
@@ -7,6 +7,8 @@ import (
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
+
+	"github.com/jonfk/comment-server/accounts"
 )
 
 type Comment struct {
@@ -16,6 +18,18 @@ type Comment struct {
 	ParentId        uuid.NullUUID `db:"parent_id"`
 	CommentThreadId uuid.UUID     `db:"comment_thread_id"`
 	AccountId       uuid.UUID     `db:"account_id"`
+	// Hidden is set while an active ban of the author hides its comments
+	// on the website of the thread, when the Comments have an
+	// AccountsService.
+	Hidden bool `db:"-"`
+	// Deleted is set on the tombstones left in place of the purged
+	// comments which had replies. Their Data is empty.
+	Deleted bool `db:"deleted"`
+	// AuthorName and AvatarURL describe the account of the comment. They
+	// are filled by GetCommentById and CommentsOfThread when the Comments
+	// have an AccountsService.
+	AuthorName string `db:"-"`
+	AvatarURL  string `db:"-"`
 }
 
 type CommentThread struct {
@@ -30,58 +44,183 @@ func (a CommentThread) Equal(b CommentThread) bool {
 	if !uuid.Equal(a.CommentThreadId, b.CommentThreadId) ||
 		!a.CreatedOn.Equal(b.CreatedOn) ||
 		a.PageUrl != b.PageUrl ||
-		a.Title != b.Title {
+		a.Title != b.Title ||
+		a.Website != b.Website {
 		return false
 	}
 	return true
 }
 
+// Website holds the settings of a website embedding comment threads.
+type Website struct {
+	Url string `db:"url"`
+	// RequireVerifiedEmail only allows accounts with a verified email to
+	// comment on the website.
+	RequireVerifiedEmail bool `db:"require_verified_email"`
+	// AllowGuests allows guest accounts to comment on the website. Guests
+	// can't comment on threads without a website.
+	AllowGuests bool `db:"allow_guests"`
+}
+
 type Comments struct {
 	DB *sqlx.DB
+	// Store defaults to a DBStore using DB when nil.
+	Store Store
+	// AccountsService describes the authors of comments when set.
+	AccountsService *accounts.Accounts
+	// Now returns the current time. It defaults to time.Now.
+	Now func() time.Time
 }
 
-func (t *Comments) CreateNewThread(pageUrl, title string, createdOn time.Time) (CommentThread, error) {
-	var (
-		newThread CommentThread
-	)
+func (t *Comments) store() Store {
+	if t.Store != nil {
+		return t.Store
+	}
+	return &DBStore{DB: t.DB}
+}
 
-	err := t.DB.QueryRowx("INSERT INTO comment_threads (comment_thread_id,created_on,page_url,title) VALUES ($1,$2,$3,$4) RETURNING comment_thread_id,created_on,page_url,title",
-		uuid.NewV4().String(), createdOn, pageUrl, title).StructScan(&newThread)
+func (t *Comments) now() time.Time {
+	if t.Now != nil {
+		return t.Now().UTC()
+	}
+	return time.Now().UTC()
+}
 
-	return newThread, err
+func (t *Comments) CreateNewThread(pageUrl, title string, createdOn time.Time) (CommentThread, error) {
+	return t.store().InsertThread(CommentThread{
+		CommentThreadId: uuid.NewV4(),
+		CreatedOn:       createdOn,
+		PageUrl:         pageUrl,
+		Title:           title,
+	})
+}
+
+func (t *Comments) GetWebsite(url string) (Website, error) {
+	return t.store().GetWebsite(url)
 }
 
 func (t *Comments) DeleteThreadById(commentThreadId uuid.UUID) (uuid.UUID, error) {
-	var deletedId uuid.UUID
-	err := t.DB.QueryRowx("DELETE FROM comment_threads where comment_thread_id = $1 RETURNING comment_thread_id", commentThreadId).Scan(&deletedId)
-	return deletedId, err
+	return t.store().DeleteThreadById(commentThreadId)
 }
 
 func (t *Comments) GetThreadByThreadId(commentThreadId uuid.UUID) (CommentThread, error) {
-	var thread CommentThread
-	err := t.DB.Get(&thread, "SELECT comment_thread_id,created_on,page_url,title FROM comment_threads where comment_thread_id = $1",
-		commentThreadId)
-	return thread, err
+	return t.store().GetThreadByThreadId(commentThreadId)
 }
 
 func (t *Comments) CreateNewComment(comment Comment) (Comment, error) {
-	var (
-		newComment Comment
-	)
+	comment.CommentId = uuid.NewV4()
+	return t.store().InsertComment(comment)
+}
+
+func (t *Comments) GetCommentById(commentId uuid.UUID) (Comment, error) {
+	comment, err := t.store().GetCommentById(commentId)
+	if err != nil || t.AccountsService == nil {
+		return comment, err
+	}
+	thread, err := t.GetThreadByThreadId(comment.CommentThreadId)
+	if err != nil {
+		return Comment{}, err
+	}
+	return t.describeAuthor(comment, thread.Website)
+}
+
+// CommentsOfAccount returns the comments of an account, oldest first,
+// including the hidden ones.
+func (t *Comments) CommentsOfAccount(accountId uuid.UUID) ([]Comment, error) {
+	comments, err := t.store().ListCommentsOfAccount(accountId)
+	if err != nil || t.AccountsService == nil {
+		return comments, err
+	}
+	bans, err := t.hidingBans(accountId)
+	if err != nil || len(bans) == 0 {
+		return comments, err
+	}
+	websites := map[uuid.UUID]string{}
+	for i, comment := range comments {
+		website, ok := websites[comment.CommentThreadId]
+		if !ok {
+			thread, err := t.GetThreadByThreadId(comment.CommentThreadId)
+			if err != nil {
+				return nil, err
+			}
+			website = thread.Website
+			websites[comment.CommentThreadId] = website
+		}
+		comments[i].Hidden = hides(bans, website)
+	}
+	return comments, nil
+}
 
-	err := t.DB.QueryRowx("INSERT INTO comments (comment_id,timestamp,data,parent_id,comment_thread_id,account_id) VALUES ($1,$2,$3,$4,$5,$6) RETURNING comment_id,timestamp,data,parent_id,comment_thread_id,account_id",
-		uuid.NewV4().String(),
-		comment.Timestamp,
-		comment.Data,
-		comment.ParentId,
-		comment.CommentThreadId,
-		comment.AccountId).StructScan(&newComment)
+// CommentsOfThread returns the comments shown on a thread, oldest first.
+// The comments hidden by a ban of their author are left out.
+func (t *Comments) CommentsOfThread(commentThreadId uuid.UUID) ([]Comment, error) {
+	thread, err := t.GetThreadByThreadId(commentThreadId)
+	if err != nil {
+		return nil, err
+	}
+	comments, err := t.store().ListCommentsOfThread(commentThreadId)
+	if err != nil || t.AccountsService == nil {
+		return comments, err
+	}
+	shown := []Comment{}
+	for _, comment := range comments {
+		comment, err := t.describeAuthor(comment, thread.Website)
+		if err != nil {
+			return nil, err
+		}
+		if !comment.Hidden {
+			shown = append(shown, comment)
+		}
+	}
+	return shown, nil
+}
 
-	return newComment, err
+// describeAuthor fills the fields of comment describing its author, for a
+// comment on a thread of website.
+func (t *Comments) describeAuthor(comment Comment, website string) (Comment, error) {
+	author, err := t.AccountsService.GetAccountByAccountId(comment.AccountId)
+	if err != nil {
+		return Comment{}, err
+	}
+	bans, err := t.hidingBans(comment.AccountId)
+	if err != nil {
+		return Comment{}, err
+	}
+	comment.Hidden = hides(bans, website)
+	comment.AuthorName = author.Name()
+	comment.AvatarURL = t.AccountsService.AvatarURL(author, accounts.DefaultAvatarSize)
+	return comment, nil
+}
+
+// hidingBans returns the active bans of an account which hide its
+// comments. Comments are shown again once every such ban is lifted or
+// expired.
+func (t *Comments) hidingBans(accountId uuid.UUID) ([]accounts.Ban, error) {
+	bans, err := t.AccountsService.ListBans(accountId)
+	if err != nil {
+		return nil, err
+	}
+	now := t.now()
+	hiding := []accounts.Ban{}
+	for _, ban := range bans {
+		if ban.HideComments && ban.Active(now) {
+			hiding = append(hiding, ban)
+		}
+	}
+	return hiding, nil
+}
+
+// hides reports whether one of bans hides the comments on the threads of
+// website.
+func hides(bans []accounts.Ban, website string) bool {
+	for _, ban := range bans {
+		if ban.Global() || ban.Website == website {
+			return true
+		}
+	}
+	return false
 }
 
 func (t *Comments) DeleteCommentById(commentId uuid.UUID) (uuid.UUID, error) {
-	var deletedId uuid.UUID
-	err := t.DB.QueryRowx("DELETE FROM comments where comment_id = $1 RETURNING comment_id", commentId).Scan(&deletedId)
-	return deletedId, err
+	return t.store().DeleteCommentById(commentId)
 }
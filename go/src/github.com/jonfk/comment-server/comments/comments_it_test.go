@@ -12,6 +12,8 @@ import (
 	"github.com/satori/go.uuid"
 
 	"github.com/jonfk/comment-server/accounts"
+	"github.com/jonfk/comment-server/commands"
+	"github.com/jonfk/comment-server/events"
 )
 
 var (
@@ -139,6 +141,55 @@ func TestCreateNewComment(t *testing.T) {
 	cleanUp(createdComment.CommentId)
 }
 
+func TestHandleBatch(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode.")
+	}
+
+	db, err := sqlx.Connect("postgres", fmt.Sprintf("user=%s dbname=%s password=%s sslmode=disable", DBUser, DBName, DBPassword))
+	if err != nil {
+		t.Fatalf("sqlx.Connect failed : %v\n", err)
+	}
+
+	commandHandler := NewCommandHandler(db)
+	account, err := commandHandler.AccountsService.CreateNewAccount(accounts.Account{
+		Username:  "TestHandleBatchusername",
+		Email:     "TestHandleBatchemail",
+		CreatedOn: time.Now().UTC().Round(time.Second),
+	}, "password")
+	if err != nil {
+		t.Fatalf("CreateNewAccount failed : %v\n", err)
+	}
+	defer commandHandler.AccountsService.DeleteById(account.AccountId)
+
+	threadId := uuid.NewV4()
+	batchEvents, err := commandHandler.HandleBatch(commands.BatchCommand{Commands: []commands.Command{
+		commands.CreateCommand(commands.CreateCommentThread{CommentThreadId: threadId, PageUrl: "pageUrl", Title: "title"}),
+		commands.CreateCommand(commands.CreateComment{CommentThreadId: threadId, AccountId: account.AccountId, Data: "pinned"}),
+	}})
+	if err != nil {
+		t.Fatalf("HandleBatch should have commented on the thread created by the batch but failed : %v\n", err)
+	}
+	commentId := batchEvents[1].Payload.(events.CommentCreated).CommentId
+	if _, err := commandHandler.CommentsService.GetCommentById(commentId); err != nil {
+		t.Fatalf("the comment of the batch should have been committed but GetCommentById failed : %v\n", err)
+	}
+	commandHandler.CommentsService.DeleteCommentById(commentId)
+	commandHandler.CommentsService.DeleteThreadById(threadId)
+
+	rolledBackThreadId := uuid.NewV4()
+	_, err = commandHandler.HandleBatch(commands.BatchCommand{Commands: []commands.Command{
+		commands.CreateCommand(commands.CreateCommentThread{CommentThreadId: rolledBackThreadId, PageUrl: "pageUrl", Title: "title"}),
+		commands.CreateCommand(commands.CreateComment{CommentThreadId: uuid.NewV4(), AccountId: account.AccountId, Data: "data"}),
+	}})
+	if batchErr, ok := err.(*commands.BatchError); !ok || batchErr.Index != 1 {
+		t.Fatalf("HandleBatch should have failed on command 1 but returned %v", err)
+	}
+	if _, err := commandHandler.CommentsService.GetThreadByThreadId(rolledBackThreadId); err != CommentThreadNotFoundErr {
+		t.Fatalf("the thread of a failed batch should have been rolled back but GetThreadByThreadId returned %v", err)
+	}
+}
+
 func CreateAccountAndCommentThread(accountsModule *accounts.Accounts, commentsModule *Comments) (accounts.Account, CommentThread, error) {
 	var (
 		account       accounts.Account
@@ -0,0 +1,31 @@
+package comments
+
+import (
+	"database/sql"
+
+	"github.com/jonfk/comment-server/apperrors"
+)
+
+var (
+	CommentThreadNotFoundErr = apperrors.New(apperrors.CommentThreadNotFound, "Comment Thread Not Found")
+	CommentNotFoundErr       = apperrors.New(apperrors.CommentNotFound, "Comment Not Found")
+	InvalidParentErr         = apperrors.New(apperrors.CommentInvalidParent, "Parent comment is not in the comment thread")
+	NotCommentAuthorErr      = apperrors.New(apperrors.AuthForbidden, "Only the author can delete a comment")
+	WebsiteNotFoundErr       = apperrors.New(apperrors.WebsiteNotFound, "Website Not Found")
+	GuestsNotAllowedErr      = apperrors.New(apperrors.AuthForbidden, "Guests can't comment on this website")
+	PermissionDeniedErr      = apperrors.New(apperrors.AuthForbidden, "Your role on this website doesn't allow this")
+	LastOwnerErr             = apperrors.New(apperrors.CommandInvalid, "A website can't lose its last owner")
+)
+
+// translateDBError maps errors returned by the database to the errors
+// of this package. sql.ErrNoRows is mapped to notFoundErr and unknown
+// errors are wrapped as internal errors.
+func translateDBError(err error, notFoundErr error) error {
+	if err == nil {
+		return nil
+	}
+	if err == sql.ErrNoRows && notFoundErr != nil {
+		return notFoundErr
+	}
+	return apperrors.Wrap(err, apperrors.Internal, "database error")
+}
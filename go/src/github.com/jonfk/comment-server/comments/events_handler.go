@@ -0,0 +1,79 @@
+package comments
+
+import (
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/accounts"
+	"github.com/jonfk/comment-server/events"
+)
+
+// EventHandler projects comment events into the CommentsService's Store.
+type EventHandler struct {
+	CommentsService *Comments
+}
+
+func (e *EventHandler) HandleEvent(event events.Event) error {
+	switch eventPayload := event.Payload.(type) {
+	case events.CommentThreadCreated:
+		_, err := e.CommentsService.store().InsertThread(CommentThread{
+			CommentThreadId: eventPayload.CommentThreadId,
+			CreatedOn:       event.Timestamp,
+			PageUrl:         eventPayload.PageUrl,
+			Title:           eventPayload.Title,
+			Website:         eventPayload.Website,
+		})
+		return err
+	case events.CommentCreated:
+		parentId := uuid.NullUUID{}
+		if eventPayload.ParentId != nil {
+			parentId = uuid.NullUUID{UUID: *eventPayload.ParentId, Valid: true}
+		}
+		_, err := e.CommentsService.store().InsertComment(Comment{
+			CommentId:       eventPayload.CommentId,
+			Timestamp:       event.Timestamp,
+			Data:            eventPayload.Data,
+			ParentId:        parentId,
+			CommentThreadId: eventPayload.CommentThreadId,
+			AccountId:       eventPayload.AccountId,
+		})
+		return err
+	case events.CommentDeleted:
+		_, err := e.CommentsService.DeleteCommentById(eventPayload.CommentId)
+		return err
+	case events.AccountDeleted:
+		// Deleted accounts keep no say over websites
+		if err := e.CommentsService.store().RevokeRolesOfAccount(eventPayload.AccountId); err != nil {
+			return err
+		}
+		if eventPayload.Mode == accounts.DeletionPurge {
+			return e.CommentsService.store().PurgeComments(eventPayload.AccountId)
+		}
+	case events.GuestIdentityClaimed:
+		return e.CommentsService.store().ReassignComments(eventPayload.GuestAccountId, eventPayload.AccountId)
+	case events.WebsiteConfigured:
+		err := e.CommentsService.store().UpsertWebsite(Website{
+			Url:                  eventPayload.Url,
+			RequireVerifiedEmail: eventPayload.RequireVerifiedEmail,
+			AllowGuests:          eventPayload.AllowGuests,
+		})
+		if err != nil || eventPayload.OwnerId == nil {
+			return err
+		}
+		return e.CommentsService.store().GrantRole(WebsiteRole{
+			Website:   eventPayload.Url,
+			AccountId: *eventPayload.OwnerId,
+			Role:      RoleOwner,
+			GrantedOn: event.Timestamp,
+		})
+	case events.RoleGranted:
+		return e.CommentsService.store().GrantRole(WebsiteRole{
+			Website:   eventPayload.Website,
+			AccountId: eventPayload.AccountId,
+			Role:      eventPayload.Role,
+			GrantedOn: event.Timestamp,
+		})
+	case events.RoleRevoked:
+		return e.CommentsService.store().RevokeRole(eventPayload.Website, eventPayload.AccountId)
+	}
+	return nil
+}
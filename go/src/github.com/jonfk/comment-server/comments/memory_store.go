@@ -0,0 +1,239 @@
+package comments
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/satori/go.uuid"
+)
+
+// MemoryStore is a Store kept in memory, used to test the comments
+// handlers without a database.
+type MemoryStore struct {
+	mutex    sync.Mutex
+	threads  map[uuid.UUID]CommentThread
+	comments map[uuid.UUID]Comment
+	websites map[string]Website
+	roles    []WebsiteRole
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		threads:  map[uuid.UUID]CommentThread{},
+		comments: map[uuid.UUID]Comment{},
+		websites: map[string]Website{},
+	}
+}
+
+func (s *MemoryStore) InsertThread(thread CommentThread) (CommentThread, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.threads[thread.CommentThreadId] = thread
+	return thread, nil
+}
+
+func (s *MemoryStore) DeleteThreadById(commentThreadId uuid.UUID) (uuid.UUID, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.threads[commentThreadId]; !ok {
+		return uuid.Nil, CommentThreadNotFoundErr
+	}
+	delete(s.threads, commentThreadId)
+	return commentThreadId, nil
+}
+
+func (s *MemoryStore) GetThreadByThreadId(commentThreadId uuid.UUID) (CommentThread, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	thread, ok := s.threads[commentThreadId]
+	if !ok {
+		return CommentThread{}, CommentThreadNotFoundErr
+	}
+	return thread, nil
+}
+
+func (s *MemoryStore) InsertComment(comment Comment) (Comment, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.comments[comment.CommentId] = comment
+	return comment, nil
+}
+
+func (s *MemoryStore) GetCommentById(commentId uuid.UUID) (Comment, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	comment, ok := s.comments[commentId]
+	if !ok {
+		return Comment{}, CommentNotFoundErr
+	}
+	return comment, nil
+}
+
+func (s *MemoryStore) ListCommentsOfAccount(accountId uuid.UUID) ([]Comment, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	comments := []Comment{}
+	for _, comment := range s.comments {
+		if uuid.Equal(comment.AccountId, accountId) {
+			comments = append(comments, comment)
+		}
+	}
+	sort.Slice(comments, func(i, j int) bool { return comments[i].Timestamp.Before(comments[j].Timestamp) })
+	return comments, nil
+}
+
+func (s *MemoryStore) ListCommentsOfThread(commentThreadId uuid.UUID) ([]Comment, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	comments := []Comment{}
+	for _, comment := range s.comments {
+		if uuid.Equal(comment.CommentThreadId, commentThreadId) {
+			comments = append(comments, comment)
+		}
+	}
+	sort.Slice(comments, func(i, j int) bool { return comments[i].Timestamp.Before(comments[j].Timestamp) })
+	return comments, nil
+}
+
+func (s *MemoryStore) DeleteCommentById(commentId uuid.UUID) (uuid.UUID, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.comments[commentId]; !ok {
+		return uuid.Nil, CommentNotFoundErr
+	}
+	delete(s.comments, commentId)
+	return commentId, nil
+}
+
+func (s *MemoryStore) UpsertWebsite(website Website) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.websites[website.Url] = website
+	return nil
+}
+
+func (s *MemoryStore) GetWebsite(url string) (Website, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	website, ok := s.websites[url]
+	if !ok {
+		return Website{}, WebsiteNotFoundErr
+	}
+	return website, nil
+}
+
+func (s *MemoryStore) ReassignComments(fromAccountId, toAccountId uuid.UUID) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for commentId, comment := range s.comments {
+		if uuid.Equal(comment.AccountId, fromAccountId) {
+			comment.AccountId = toAccountId
+			s.comments[commentId] = comment
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) PurgeComments(accountId uuid.UUID) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for commentId, comment := range s.comments {
+		if uuid.Equal(comment.AccountId, accountId) {
+			comment.Data, comment.Deleted = "", true
+			s.comments[commentId] = comment
+		}
+	}
+	for removed := true; removed; {
+		removed = false
+		for commentId, comment := range s.comments {
+			if comment.Deleted && !s.hasReplies(commentId) {
+				delete(s.comments, commentId)
+				removed = true
+			}
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) hasReplies(commentId uuid.UUID) bool {
+	for _, comment := range s.comments {
+		if comment.ParentId.Valid && uuid.Equal(comment.ParentId.UUID, commentId) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemoryStore) GetRole(website string, accountId uuid.UUID) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, role := range s.roles {
+		if role.Website == website && uuid.Equal(role.AccountId, accountId) {
+			return role.Role, nil
+		}
+	}
+	return "", nil
+}
+
+func (s *MemoryStore) GrantRole(role WebsiteRole) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, existing := range s.roles {
+		if existing.Website == role.Website && uuid.Equal(existing.AccountId, role.AccountId) {
+			s.roles[i] = role
+			return nil
+		}
+	}
+	s.roles = append(s.roles, role)
+	return nil
+}
+
+func (s *MemoryStore) RevokeRole(website string, accountId uuid.UUID) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	roles := s.roles[:0]
+	for _, role := range s.roles {
+		if role.Website != website || !uuid.Equal(role.AccountId, accountId) {
+			roles = append(roles, role)
+		}
+	}
+	s.roles = roles
+	return nil
+}
+
+func (s *MemoryStore) RevokeRolesOfAccount(accountId uuid.UUID) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	roles := s.roles[:0]
+	for _, role := range s.roles {
+		if !uuid.Equal(role.AccountId, accountId) {
+			roles = append(roles, role)
+		}
+	}
+	s.roles = roles
+	return nil
+}
+
+func (s *MemoryStore) ListRoles(website string) ([]WebsiteRole, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	roles := []WebsiteRole{}
+	for _, role := range s.roles {
+		if role.Website == website {
+			roles = append(roles, role)
+		}
+	}
+	return roles, nil
+}
+
+func (s *MemoryStore) ListRolesOfAccount(accountId uuid.UUID) ([]WebsiteRole, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	roles := []WebsiteRole{}
+	for _, role := range s.roles {
+		if uuid.Equal(role.AccountId, accountId) {
+			roles = append(roles, role)
+		}
+	}
+	return roles, nil
+}
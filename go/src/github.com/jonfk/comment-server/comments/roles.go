@@ -0,0 +1,125 @@
+package comments
+
+import (
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/accounts"
+)
+
+// Roles of accounts on a website. Accounts without a role on a website are
+// commenters.
+const (
+	RoleOwner     = "owner"
+	RoleModerator = "moderator"
+	RoleCommenter = "commenter"
+	RoleBanned    = "banned"
+)
+
+// Permission is something a role allows on a website.
+type Permission string
+
+const (
+	PermissionComment Permission = "comment"
+	// PermissionModerate allows deleting the comments of other accounts.
+	PermissionModerate         Permission = "moderate"
+	PermissionManageRoles      Permission = "manage_roles"
+	PermissionConfigureWebsite Permission = "configure_website"
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleOwner:     {PermissionComment, PermissionModerate, PermissionManageRoles, PermissionConfigureWebsite},
+	RoleModerator: {PermissionComment, PermissionModerate},
+	RoleCommenter: {PermissionComment},
+	RoleBanned:    {},
+}
+
+// ValidRole reports whether role is one of the roles of this package.
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// RoleAllows reports whether role grants permission.
+func RoleAllows(role string, permission Permission) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// WebsiteRole is the role of an account on a website.
+type WebsiteRole struct {
+	Website   string    `db:"website" json:"website"`
+	AccountId uuid.UUID `db:"account_id" json:"accountId"`
+	Role      string    `db:"role" json:"role"`
+	GrantedOn time.Time `db:"granted_on" json:"grantedOn"`
+}
+
+// RoleOf returns the role of an account on website.
+func (t *Comments) RoleOf(website string, accountId uuid.UUID) (string, error) {
+	role, err := t.store().GetRole(website, accountId)
+	if err != nil {
+		return "", err
+	}
+	if role == "" {
+		return RoleCommenter, nil
+	}
+	return role, nil
+}
+
+// Can reports whether the role of an account on website grants
+// permission.
+func (t *Comments) Can(website string, accountId uuid.UUID, permission Permission) (bool, error) {
+	role, err := t.RoleOf(website, accountId)
+	if err != nil {
+		return false, err
+	}
+	return RoleAllows(role, permission), nil
+}
+
+// CheckPermission returns an AuthForbidden error unless the role of an
+// account on website grants permission, or accounts.AccountBannedErr when
+// the account has the banned role, like accounts banned with BanAccount.
+func (t *Comments) CheckPermission(website string, accountId uuid.UUID, permission Permission) error {
+	role, err := t.RoleOf(website, accountId)
+	if err != nil {
+		return err
+	}
+	if RoleAllows(role, permission) {
+		return nil
+	}
+	if role == RoleBanned {
+		return accounts.AccountBannedErr
+	}
+	return PermissionDeniedErr
+}
+
+// ListRoles returns the accounts with a role on website other than
+// commenter.
+func (t *Comments) ListRoles(website string) ([]WebsiteRole, error) {
+	return t.store().ListRoles(website)
+}
+
+// RolesOfAccount returns the websites on which an account has a role
+// other than commenter.
+func (t *Comments) RolesOfAccount(accountId uuid.UUID) ([]WebsiteRole, error) {
+	return t.store().ListRolesOfAccount(accountId)
+}
+
+// hasOwner reports whether an account owns website.
+func (t *Comments) hasOwner(website string) (bool, error) {
+	roles, err := t.ListRoles(website)
+	if err != nil {
+		return false, err
+	}
+	for _, role := range roles {
+		if role.Role == RoleOwner {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,202 @@
+package comments
+
+import (
+	"database/sql"
+
+	_ "github.com/lib/pq"
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/database"
+)
+
+// Store persists comment threads and comments. Implementations return
+// CommentThreadNotFoundErr, CommentNotFoundErr and WebsiteNotFoundErr
+// rather than their own errors.
+type Store interface {
+	InsertThread(CommentThread) (CommentThread, error)
+	DeleteThreadById(commentThreadId uuid.UUID) (uuid.UUID, error)
+	GetThreadByThreadId(commentThreadId uuid.UUID) (CommentThread, error)
+	InsertComment(Comment) (Comment, error)
+	GetCommentById(commentId uuid.UUID) (Comment, error)
+	// ListCommentsOfAccount returns the comments of an account, oldest
+	// first.
+	ListCommentsOfAccount(accountId uuid.UUID) ([]Comment, error)
+	// ListCommentsOfThread returns the comments of a thread, oldest first.
+	ListCommentsOfThread(commentThreadId uuid.UUID) ([]Comment, error)
+	DeleteCommentById(commentId uuid.UUID) (uuid.UUID, error)
+	// UpsertWebsite creates or replaces the settings of a website.
+	UpsertWebsite(Website) error
+	GetWebsite(url string) (Website, error)
+	// GetRole returns the role of an account on website, or an empty role.
+	GetRole(website string, accountId uuid.UUID) (string, error)
+	// GrantRole replaces the role of an account on a website.
+	GrantRole(WebsiteRole) error
+	RevokeRole(website string, accountId uuid.UUID) error
+	// RevokeRolesOfAccount removes the roles of an account on every
+	// website.
+	RevokeRolesOfAccount(accountId uuid.UUID) error
+	ListRoles(website string) ([]WebsiteRole, error)
+	ListRolesOfAccount(accountId uuid.UUID) ([]WebsiteRole, error)
+	// ReassignComments moves the comments of an account to another one.
+	ReassignComments(fromAccountId, toAccountId uuid.UUID) error
+	// PurgeComments removes the comments of an account. Comments with
+	// replies are replaced by tombstones, which are removed once their
+	// last reply is.
+	PurgeComments(accountId uuid.UUID) error
+}
+
+// Threads without a website have a NULL website column.
+const threadColumns = "comment_thread_id,created_on,page_url,title,COALESCE(website,'') AS website"
+
+// DBStore is a Store backed by the comment_threads, comments and websites
+// tables.
+type DBStore struct {
+	// DB is a *sqlx.DB, or a *sqlx.Tx to bind the store to a transaction.
+	DB database.Handle
+}
+
+func (s *DBStore) InsertThread(thread CommentThread) (CommentThread, error) {
+	var newThread CommentThread
+	err := s.DB.QueryRowx("INSERT INTO comment_threads (comment_thread_id,created_on,page_url,title,website) VALUES ($1,$2,$3,$4,NULLIF($5,'')) RETURNING "+threadColumns,
+		thread.CommentThreadId, thread.CreatedOn, thread.PageUrl, thread.Title, thread.Website).StructScan(&newThread)
+
+	return newThread, translateDBError(err, nil)
+}
+
+func (s *DBStore) DeleteThreadById(commentThreadId uuid.UUID) (uuid.UUID, error) {
+	var deletedId uuid.UUID
+	err := s.DB.QueryRowx("DELETE FROM comment_threads where comment_thread_id = $1 RETURNING comment_thread_id", commentThreadId).Scan(&deletedId)
+	return deletedId, translateDBError(err, CommentThreadNotFoundErr)
+}
+
+func (s *DBStore) GetThreadByThreadId(commentThreadId uuid.UUID) (CommentThread, error) {
+	var thread CommentThread
+	err := s.DB.Get(&thread, "SELECT "+threadColumns+" FROM comment_threads where comment_thread_id = $1",
+		commentThreadId)
+	return thread, translateDBError(err, CommentThreadNotFoundErr)
+}
+
+func (s *DBStore) InsertComment(comment Comment) (Comment, error) {
+	var newComment Comment
+	err := s.DB.QueryRowx("INSERT INTO comments (comment_id,timestamp,data,parent_id,comment_thread_id,account_id) VALUES ($1,$2,$3,$4,$5,$6) RETURNING comment_id,timestamp,data,parent_id,comment_thread_id,account_id,deleted",
+		comment.CommentId,
+		comment.Timestamp,
+		comment.Data,
+		comment.ParentId,
+		comment.CommentThreadId,
+		comment.AccountId).StructScan(&newComment)
+
+	return newComment, translateDBError(err, nil)
+}
+
+func (s *DBStore) GetCommentById(commentId uuid.UUID) (Comment, error) {
+	var comment Comment
+	err := s.DB.Get(&comment, "SELECT comment_id,timestamp,data,parent_id,comment_thread_id,account_id,deleted FROM comments where comment_id = $1",
+		commentId)
+	return comment, translateDBError(err, CommentNotFoundErr)
+}
+
+func (s *DBStore) ListCommentsOfAccount(accountId uuid.UUID) ([]Comment, error) {
+	comments := []Comment{}
+	err := s.DB.Select(&comments, "SELECT comment_id,timestamp,data,parent_id,comment_thread_id,account_id,deleted FROM comments where account_id = $1 ORDER BY timestamp",
+		accountId)
+	return comments, translateDBError(err, nil)
+}
+
+func (s *DBStore) ListCommentsOfThread(commentThreadId uuid.UUID) ([]Comment, error) {
+	comments := []Comment{}
+	err := s.DB.Select(&comments, "SELECT comment_id,timestamp,data,parent_id,comment_thread_id,account_id,deleted FROM comments where comment_thread_id = $1 ORDER BY timestamp",
+		commentThreadId)
+	return comments, translateDBError(err, nil)
+}
+
+func (s *DBStore) DeleteCommentById(commentId uuid.UUID) (uuid.UUID, error) {
+	var deletedId uuid.UUID
+	err := s.DB.QueryRowx("DELETE FROM comments where comment_id = $1 RETURNING comment_id", commentId).Scan(&deletedId)
+	return deletedId, translateDBError(err, CommentNotFoundErr)
+}
+
+func (s *DBStore) UpsertWebsite(website Website) error {
+	_, err := s.DB.Exec(`INSERT INTO websites (url,require_verified_email,allow_guests) VALUES ($1,$2,$3)
+ON CONFLICT (url) DO UPDATE SET require_verified_email = EXCLUDED.require_verified_email, allow_guests = EXCLUDED.allow_guests`,
+		website.Url, website.RequireVerifiedEmail, website.AllowGuests)
+	return translateDBError(err, nil)
+}
+
+func (s *DBStore) GetWebsite(url string) (Website, error) {
+	var website Website
+	err := s.DB.Get(&website, "SELECT url,require_verified_email,allow_guests FROM websites where url = $1", url)
+	return website, translateDBError(err, WebsiteNotFoundErr)
+}
+
+func (s *DBStore) ReassignComments(fromAccountId, toAccountId uuid.UUID) error {
+	_, err := s.DB.Exec("UPDATE comments SET account_id = $1 where account_id = $2", toAccountId, fromAccountId)
+	return translateDBError(err, nil)
+}
+
+func (s *DBStore) PurgeComments(accountId uuid.UUID) error {
+	tx, err := database.Begin(s.DB)
+	if err != nil {
+		return translateDBError(err, nil)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE comments SET data = '', deleted = TRUE where account_id = $1", accountId)
+	if err != nil {
+		return translateDBError(err, nil)
+	}
+	// Removing a tombstone may leave its parent without replies
+	for {
+		result, err := tx.Exec(`DELETE FROM comments c where c.deleted AND
+NOT EXISTS (SELECT 1 FROM comments reply where reply.parent_id = c.comment_id)`)
+		if err != nil {
+			return translateDBError(err, nil)
+		}
+		removed, err := result.RowsAffected()
+		if err != nil {
+			return translateDBError(err, nil)
+		}
+		if removed == 0 {
+			break
+		}
+	}
+	return translateDBError(tx.Commit(), nil)
+}
+
+func (s *DBStore) GetRole(website string, accountId uuid.UUID) (string, error) {
+	var role string
+	err := s.DB.Get(&role, "SELECT role FROM website_roles where website = $1 AND account_id = $2", website, accountId)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, translateDBError(err, nil)
+}
+
+func (s *DBStore) GrantRole(role WebsiteRole) error {
+	_, err := s.DB.Exec(`INSERT INTO website_roles (website,account_id,role,granted_on) VALUES ($1,$2,$3,$4)
+ON CONFLICT (website, account_id) DO UPDATE SET role = EXCLUDED.role, granted_on = EXCLUDED.granted_on`,
+		role.Website, role.AccountId, role.Role, role.GrantedOn)
+	return translateDBError(err, nil)
+}
+
+func (s *DBStore) RevokeRole(website string, accountId uuid.UUID) error {
+	_, err := s.DB.Exec("DELETE FROM website_roles where website = $1 AND account_id = $2", website, accountId)
+	return translateDBError(err, nil)
+}
+
+func (s *DBStore) RevokeRolesOfAccount(accountId uuid.UUID) error {
+	_, err := s.DB.Exec("DELETE FROM website_roles where account_id = $1", accountId)
+	return translateDBError(err, nil)
+}
+
+func (s *DBStore) ListRoles(website string) ([]WebsiteRole, error) {
+	roles := []WebsiteRole{}
+	err := s.DB.Select(&roles, "SELECT website,account_id,role,granted_on FROM website_roles where website = $1 ORDER BY granted_on", website)
+	return roles, translateDBError(err, nil)
+}
+
+func (s *DBStore) ListRolesOfAccount(accountId uuid.UUID) ([]WebsiteRole, error) {
+	roles := []WebsiteRole{}
+	err := s.DB.Select(&roles, "SELECT website,account_id,role,granted_on FROM website_roles where account_id = $1 ORDER BY granted_on", accountId)
+	return roles, translateDBError(err, nil)
+}
@@ -0,0 +1,57 @@
+// Package database lets the DB stores of several packages share a
+// transaction.
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Handle runs queries. It is either a *sqlx.DB or a *sqlx.Tx, so that a
+// store keeping a Handle can be bound to a transaction spanning several
+// stores.
+type Handle interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Get(dest interface{}, query string, args ...interface{}) error
+	Select(dest interface{}, query string, args ...interface{}) error
+	QueryRowx(query string, args ...interface{}) *sqlx.Row
+}
+
+// Tx is a transaction started by Begin.
+type Tx struct {
+	Handle
+	tx *sqlx.Tx
+}
+
+// Begin starts a transaction on handle. When handle already is a
+// transaction, the queries of the Tx run in it and committing or rolling
+// it back is left to whoever started it.
+func Begin(handle Handle) (*Tx, error) {
+	switch h := handle.(type) {
+	case *sqlx.DB:
+		tx, err := h.Beginx()
+		if err != nil {
+			return nil, err
+		}
+		return &Tx{Handle: tx, tx: tx}, nil
+	case *sqlx.Tx:
+		return &Tx{Handle: h}, nil
+	}
+	return nil, fmt.Errorf("database: cannot begin a transaction on %T", handle)
+}
+
+func (t *Tx) Commit() error {
+	if t.tx == nil {
+		return nil
+	}
+	return t.tx.Commit()
+}
+
+func (t *Tx) Rollback() error {
+	if t.tx == nil {
+		return nil
+	}
+	return t.tx.Rollback()
+}
@@ -2,19 +2,51 @@ package events
 
 import (
 	"encoding/json"
-	"fmt"
 	"time"
 
 	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/apperrors"
 )
 
 const (
-	AccountCreatedTypeName       = "AccountCreatedEvent"
-	AccountDeletedTypeName       = "AccountDeleted"
-	AccountLoggedInTypeName      = "AccountLoggedIn"
-	CommentThreadCreatedTypeName = "CommentThreadCreated"
-	CommentCreatedTypeName       = "CommentCreated"
-	CommentDeletedTypeName       = "CommentDeleted"
+	AccountCreatedTypeName             = "AccountCreatedEvent"
+	AccountDeletedTypeName             = "AccountDeleted"
+	AccountLoggedInTypeName            = "AccountLoggedIn"
+	CommentThreadCreatedTypeName       = "CommentThreadCreated"
+	CommentCreatedTypeName             = "CommentCreated"
+	CommentDeletedTypeName             = "CommentDeleted"
+	LoginLinkRequestedTypeName         = "LoginLinkRequested"
+	PasswordResetRequestedTypeName     = "PasswordResetRequested"
+	PasswordChangedTypeName            = "PasswordChanged"
+	EmailVerificationRequestedTypeName = "EmailVerificationRequested"
+	EmailVerifiedTypeName              = "EmailVerified"
+	WebsiteConfiguredTypeName          = "WebsiteConfigured"
+	SessionRefreshedTypeName           = "SessionRefreshed"
+	LoggedOutTypeName                  = "LoggedOut"
+	LoggedOutEverywhereTypeName        = "LoggedOutEverywhere"
+	SigningKeyGeneratedTypeName        = "SigningKeyGenerated"
+	SigningKeyActivatedTypeName        = "SigningKeyActivated"
+	SigningKeyRetiredTypeName          = "SigningKeyRetired"
+	AccountLockedTypeName              = "AccountLocked"
+	AccountUnlockedTypeName            = "AccountUnlocked"
+	TOTPEnrollmentStartedTypeName      = "TOTPEnrollmentStarted"
+	TwoFactorEnabledTypeName           = "TwoFactorEnabled"
+	TwoFactorDisabledTypeName          = "TwoFactorDisabled"
+	TwoFactorRequiredTypeName          = "TwoFactorRequired"
+	OIDCLoginStartedTypeName           = "OIDCLoginStarted"
+	ExternalIdentityLinkedTypeName     = "ExternalIdentityLinked"
+	GuestIdentityClaimedTypeName       = "GuestIdentityClaimed"
+	UsernameChangedTypeName            = "UsernameChanged"
+	EmailChangedTypeName               = "EmailChanged"
+	ProfileUpdatedTypeName             = "ProfileUpdated"
+	AvatarUploadedTypeName             = "AvatarUploaded"
+	RoleGrantedTypeName                = "RoleGranted"
+	RoleRevokedTypeName                = "RoleRevoked"
+	AccountBannedTypeName              = "AccountBanned"
+	AccountUnbannedTypeName            = "AccountUnbanned"
+	APITokenCreatedTypeName            = "APITokenCreated"
+	APITokenRevokedTypeName            = "APITokenRevoked"
 )
 
 type Event struct {
@@ -29,26 +61,37 @@ type EventPayload interface {
 }
 
 type AccountCreated struct {
-	AccountId      uuid.UUID `json:"accountId"`
-	Username       string    `json:"username"`
-	Email          string    `json:"email"`
-	HashedPassword []byte    `json:"hashedPassword"`
-	HashSalt       []byte    `json:"hashSalt"`
+	AccountId uuid.UUID `json:"accountId"`
+	// AccountType is empty for registered accounts created before guests
+	// existed.
+	AccountType  string `json:"accountType,omitempty"`
+	Username     string `json:"username"`
+	DisplayName  string `json:"displayName,omitempty"`
+	Email        string `json:"email"`
+	PasswordHash string `json:"passwordHash,omitempty"`
+	// HashedPassword and HashSalt are set instead of PasswordHash by events
+	// stored before passwords were hashed as PHC strings.
+	HashedPassword []byte `json:"hashedPassword,omitempty"`
+	HashSalt       []byte `json:"hashSalt,omitempty"`
 }
 
 type AccountDeleted struct {
 	AccountId uuid.UUID `json:"accountId"`
+	// Mode is empty for accounts deleted before deletion modes, whose
+	// comments were kept.
+	Mode string `json:"mode,omitempty"`
 }
 
 type AccountLoggedIn struct {
 	AccountId uuid.UUID `json:"accountId"`
-	JWT       string    `json:"jwt"`
+	SessionId uuid.UUID `json:"sessionId"`
 }
 
 type CommentThreadCreated struct {
 	CommentThreadId uuid.UUID `json:"commentThreadId"`
 	PageUrl         string    `json:"pageUrl"`
 	Title           string    `json:"title"`
+	Website         string    `json:"website,omitempty"`
 }
 
 type CommentCreated struct {
@@ -57,18 +100,279 @@ type CommentCreated struct {
 	ParentId        *uuid.UUID `json:"parentId"`
 	CommentThreadId uuid.UUID  `json:"commentThreadId"`
 	AccountId       uuid.UUID  `json:"accountId"`
+	// AuthorName and AvatarURL are returned with the new comment but not
+	// stored, they can change after the comment is created.
+	AuthorName string `json:"-"`
+	AvatarURL  string `json:"-"`
 }
 
 type CommentDeleted struct {
 	CommentId uuid.UUID `json:"commentId"`
 }
 
-func (e AccountCreated) EventType() string       { return AccountCreatedTypeName }
-func (e AccountDeleted) EventType() string       { return AccountDeletedTypeName }
-func (e AccountLoggedIn) EventType() string      { return AccountLoggedInTypeName }
-func (e CommentThreadCreated) EventType() string { return CommentThreadCreatedTypeName }
-func (e CommentCreated) EventType() string       { return CommentCreatedTypeName }
-func (e CommentDeleted) EventType() string       { return CommentDeletedTypeName }
+type LoginLinkRequested struct {
+	AccountId uuid.UUID `json:"accountId"`
+}
+
+type PasswordResetRequested struct {
+	AccountId uuid.UUID `json:"accountId"`
+}
+
+type PasswordChanged struct {
+	AccountId    uuid.UUID `json:"accountId"`
+	PasswordHash string    `json:"passwordHash,omitempty"`
+	// HashedPassword and HashSalt are set instead of PasswordHash by events
+	// stored before passwords were hashed as PHC strings.
+	HashedPassword []byte `json:"hashedPassword,omitempty"`
+	HashSalt       []byte `json:"hashSalt,omitempty"`
+}
+
+type EmailVerificationRequested struct {
+	AccountId uuid.UUID `json:"accountId"`
+}
+
+type EmailVerified struct {
+	AccountId uuid.UUID `json:"accountId"`
+	Email     string    `json:"email"`
+}
+
+type WebsiteConfigured struct {
+	Url                  string `json:"url"`
+	RequireVerifiedEmail bool   `json:"requireVerifiedEmail"`
+	AllowGuests          bool   `json:"allowGuests"`
+	// OwnerId is the owner of a new website.
+	OwnerId *uuid.UUID `json:"ownerId,omitempty"`
+}
+
+type SessionRefreshed struct {
+	AccountId uuid.UUID `json:"accountId"`
+	SessionId uuid.UUID `json:"sessionId"`
+}
+
+type LoggedOut struct {
+	AccountId uuid.UUID `json:"accountId"`
+	SessionId uuid.UUID `json:"sessionId"`
+}
+
+type LoggedOutEverywhere struct {
+	AccountId uuid.UUID `json:"accountId"`
+}
+
+type SigningKeyGenerated struct {
+	KeyId     string `json:"keyId"`
+	Algorithm string `json:"algorithm"`
+}
+
+type SigningKeyActivated struct {
+	KeyId string `json:"keyId"`
+}
+
+type SigningKeyRetired struct {
+	KeyId string `json:"keyId"`
+}
+
+type AccountLocked struct {
+	AccountId   uuid.UUID `json:"accountId"`
+	LockedUntil time.Time `json:"lockedUntil"`
+}
+
+type AccountUnlocked struct {
+	AccountId uuid.UUID `json:"accountId"`
+}
+
+type TOTPEnrollmentStarted struct {
+	AccountId uuid.UUID `json:"accountId"`
+}
+
+type TwoFactorEnabled struct {
+	AccountId uuid.UUID `json:"accountId"`
+}
+
+type TwoFactorDisabled struct {
+	AccountId uuid.UUID `json:"accountId"`
+}
+
+// TwoFactorRequired is returned instead of AccountLoggedIn when the
+// account has two-factor authentication enabled.
+type TwoFactorRequired struct {
+	AccountId uuid.UUID `json:"accountId"`
+}
+
+type OIDCLoginStarted struct {
+	Provider string `json:"provider"`
+}
+
+type ExternalIdentityLinked struct {
+	AccountId uuid.UUID `json:"accountId"`
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	// NewAccount is set when the account is created for the identity with
+	// Username and Email.
+	NewAccount    bool   `json:"newAccount,omitempty"`
+	Username      string `json:"username,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"emailVerified,omitempty"`
+}
+
+// GuestIdentityClaimed moves the comments of GuestAccountId to AccountId.
+type GuestIdentityClaimed struct {
+	AccountId      uuid.UUID `json:"accountId"`
+	GuestAccountId uuid.UUID `json:"guestAccountId"`
+}
+
+type UsernameChanged struct {
+	AccountId   uuid.UUID `json:"accountId"`
+	OldUsername string    `json:"oldUsername"`
+	Username    string    `json:"username"`
+}
+
+// EmailChanged replaces the email of an account, which is no longer
+// verified.
+type EmailChanged struct {
+	AccountId uuid.UUID `json:"accountId"`
+	OldEmail  string    `json:"oldEmail"`
+	Email     string    `json:"email"`
+}
+
+type ProfileUpdated struct {
+	AccountId   uuid.UUID `json:"accountId"`
+	DisplayName string    `json:"displayName"`
+	AvatarURL   string    `json:"avatarUrl,omitempty"`
+}
+
+type AvatarUploaded struct {
+	AccountId uuid.UUID `json:"accountId"`
+	// AvatarKey identifies the thumbnails of the avatar.
+	AvatarKey string `json:"avatarKey"`
+}
+
+type RoleGranted struct {
+	Website   string    `json:"website"`
+	AccountId uuid.UUID `json:"accountId"`
+	Role      string    `json:"role"`
+	GrantedBy uuid.UUID `json:"grantedBy"`
+}
+
+type RoleRevoked struct {
+	Website   string    `json:"website"`
+	AccountId uuid.UUID `json:"accountId"`
+	RevokedBy uuid.UUID `json:"revokedBy"`
+}
+
+type AccountBanned struct {
+	BanId        uuid.UUID  `json:"banId"`
+	AccountId    uuid.UUID  `json:"accountId"`
+	Website      string     `json:"website,omitempty"`
+	Suspension   bool       `json:"suspension"`
+	Reason       string     `json:"reason,omitempty"`
+	HideComments bool       `json:"hideComments"`
+	BannedBy     *uuid.UUID `json:"bannedBy,omitempty"`
+	ExpiresAt    *time.Time `json:"expiresAt,omitempty"`
+}
+
+type AccountUnbanned struct {
+	BanId     uuid.UUID  `json:"banId"`
+	AccountId uuid.UUID  `json:"accountId"`
+	Website   string     `json:"website,omitempty"`
+	LiftedBy  *uuid.UUID `json:"liftedBy,omitempty"`
+}
+
+type APITokenCreated struct {
+	APITokenId uuid.UUID  `json:"apiTokenId"`
+	AccountId  uuid.UUID  `json:"accountId"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	TokenHash  []byte     `json:"tokenHash"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+}
+
+type APITokenRevoked struct {
+	APITokenId uuid.UUID `json:"apiTokenId"`
+	AccountId  uuid.UUID `json:"accountId"`
+}
+
+func (e AccountCreated) EventType() string             { return AccountCreatedTypeName }
+func (e AccountDeleted) EventType() string             { return AccountDeletedTypeName }
+func (e AccountLoggedIn) EventType() string            { return AccountLoggedInTypeName }
+func (e CommentThreadCreated) EventType() string       { return CommentThreadCreatedTypeName }
+func (e CommentCreated) EventType() string             { return CommentCreatedTypeName }
+func (e CommentDeleted) EventType() string             { return CommentDeletedTypeName }
+func (e LoginLinkRequested) EventType() string         { return LoginLinkRequestedTypeName }
+func (e PasswordResetRequested) EventType() string     { return PasswordResetRequestedTypeName }
+func (e PasswordChanged) EventType() string            { return PasswordChangedTypeName }
+func (e EmailVerificationRequested) EventType() string { return EmailVerificationRequestedTypeName }
+func (e EmailVerified) EventType() string              { return EmailVerifiedTypeName }
+func (e WebsiteConfigured) EventType() string          { return WebsiteConfiguredTypeName }
+func (e SessionRefreshed) EventType() string           { return SessionRefreshedTypeName }
+func (e LoggedOut) EventType() string                  { return LoggedOutTypeName }
+func (e LoggedOutEverywhere) EventType() string        { return LoggedOutEverywhereTypeName }
+func (e SigningKeyGenerated) EventType() string        { return SigningKeyGeneratedTypeName }
+func (e SigningKeyActivated) EventType() string        { return SigningKeyActivatedTypeName }
+func (e SigningKeyRetired) EventType() string          { return SigningKeyRetiredTypeName }
+func (e AccountLocked) EventType() string              { return AccountLockedTypeName }
+func (e AccountUnlocked) EventType() string            { return AccountUnlockedTypeName }
+func (e TOTPEnrollmentStarted) EventType() string      { return TOTPEnrollmentStartedTypeName }
+func (e TwoFactorEnabled) EventType() string           { return TwoFactorEnabledTypeName }
+func (e TwoFactorDisabled) EventType() string          { return TwoFactorDisabledTypeName }
+func (e TwoFactorRequired) EventType() string          { return TwoFactorRequiredTypeName }
+func (e OIDCLoginStarted) EventType() string           { return OIDCLoginStartedTypeName }
+func (e ExternalIdentityLinked) EventType() string     { return ExternalIdentityLinkedTypeName }
+func (e GuestIdentityClaimed) EventType() string       { return GuestIdentityClaimedTypeName }
+func (e UsernameChanged) EventType() string            { return UsernameChangedTypeName }
+func (e EmailChanged) EventType() string               { return EmailChangedTypeName }
+func (e ProfileUpdated) EventType() string             { return ProfileUpdatedTypeName }
+func (e AvatarUploaded) EventType() string             { return AvatarUploadedTypeName }
+func (e RoleGranted) EventType() string                { return RoleGrantedTypeName }
+func (e RoleRevoked) EventType() string                { return RoleRevokedTypeName }
+func (e AccountBanned) EventType() string              { return AccountBannedTypeName }
+func (e AccountUnbanned) EventType() string            { return AccountUnbannedTypeName }
+func (e APITokenCreated) EventType() string            { return APITokenCreatedTypeName }
+func (e APITokenRevoked) EventType() string            { return APITokenRevokedTypeName }
+
+// PayloadTypes returns a zero value of every EventPayload that can be
+// unmarshalled by UnmarshalJSON.
+func PayloadTypes() []EventPayload {
+	return []EventPayload{
+		AccountCreated{},
+		AccountDeleted{},
+		AccountLoggedIn{},
+		CommentThreadCreated{},
+		CommentCreated{},
+		CommentDeleted{},
+		LoginLinkRequested{},
+		PasswordResetRequested{},
+		PasswordChanged{},
+		EmailVerificationRequested{},
+		EmailVerified{},
+		WebsiteConfigured{},
+		SessionRefreshed{},
+		LoggedOut{},
+		LoggedOutEverywhere{},
+		SigningKeyGenerated{},
+		SigningKeyActivated{},
+		SigningKeyRetired{},
+		AccountLocked{},
+		AccountUnlocked{},
+		TOTPEnrollmentStarted{},
+		TwoFactorEnabled{},
+		TwoFactorDisabled{},
+		TwoFactorRequired{},
+		OIDCLoginStarted{},
+		ExternalIdentityLinked{},
+		GuestIdentityClaimed{},
+		UsernameChanged{},
+		EmailChanged{},
+		ProfileUpdated{},
+		AvatarUploaded{},
+		RoleGranted{},
+		RoleRevoked{},
+		AccountBanned{},
+		AccountUnbanned{},
+		APITokenCreated{},
+		APITokenRevoked{},
+	}
+}
 
 type EventJSON struct {
 	EventType string          `json:"eventType"`
@@ -99,7 +403,7 @@ func UnmarshalJSON(input []byte) (Event, error) {
 	rawEvent := EventJSON{}
 	err := json.Unmarshal(input, &rawEvent)
 	if err != nil {
-		return Event{}, err
+		return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event")
 	}
 
 	switch rawEvent.EventType {
@@ -107,7 +411,7 @@ func UnmarshalJSON(input []byte) (Event, error) {
 		eventPayload := AccountCreated{}
 		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
 		if err != nil {
-			return Event{}, err
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
 		}
 		return Event{EventType: eventPayload.EventType(),
 			Timestamp: rawEvent.Timestamp,
@@ -117,7 +421,7 @@ func UnmarshalJSON(input []byte) (Event, error) {
 		eventPayload := AccountDeleted{}
 		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
 		if err != nil {
-			return Event{}, err
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
 		}
 		return Event{EventType: eventPayload.EventType(),
 			Timestamp: rawEvent.Timestamp,
@@ -127,7 +431,7 @@ func UnmarshalJSON(input []byte) (Event, error) {
 		eventPayload := AccountLoggedIn{}
 		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
 		if err != nil {
-			return Event{}, err
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
 		}
 		return Event{EventType: eventPayload.EventType(),
 			Timestamp: rawEvent.Timestamp,
@@ -137,7 +441,7 @@ func UnmarshalJSON(input []byte) (Event, error) {
 		eventPayload := CommentThreadCreated{}
 		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
 		if err != nil {
-			return Event{}, err
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
 		}
 		return Event{EventType: eventPayload.EventType(),
 			Timestamp: rawEvent.Timestamp,
@@ -147,7 +451,7 @@ func UnmarshalJSON(input []byte) (Event, error) {
 		eventPayload := CommentCreated{}
 		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
 		if err != nil {
-			return Event{}, err
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
 		}
 		return Event{EventType: eventPayload.EventType(),
 			Timestamp: rawEvent.Timestamp,
@@ -157,13 +461,323 @@ func UnmarshalJSON(input []byte) (Event, error) {
 		eventPayload := CommentDeleted{}
 		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
 		if err != nil {
-			return Event{}, err
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case LoginLinkRequestedTypeName:
+		eventPayload := LoginLinkRequested{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case PasswordResetRequestedTypeName:
+		eventPayload := PasswordResetRequested{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case PasswordChangedTypeName:
+		eventPayload := PasswordChanged{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case EmailVerificationRequestedTypeName:
+		eventPayload := EmailVerificationRequested{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case EmailVerifiedTypeName:
+		eventPayload := EmailVerified{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case WebsiteConfiguredTypeName:
+		eventPayload := WebsiteConfigured{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case SessionRefreshedTypeName:
+		eventPayload := SessionRefreshed{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case LoggedOutTypeName:
+		eventPayload := LoggedOut{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case LoggedOutEverywhereTypeName:
+		eventPayload := LoggedOutEverywhere{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case SigningKeyGeneratedTypeName:
+		eventPayload := SigningKeyGenerated{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case SigningKeyActivatedTypeName:
+		eventPayload := SigningKeyActivated{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case SigningKeyRetiredTypeName:
+		eventPayload := SigningKeyRetired{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case AccountLockedTypeName:
+		eventPayload := AccountLocked{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case AccountUnlockedTypeName:
+		eventPayload := AccountUnlocked{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case TOTPEnrollmentStartedTypeName:
+		eventPayload := TOTPEnrollmentStarted{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case TwoFactorEnabledTypeName:
+		eventPayload := TwoFactorEnabled{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case TwoFactorDisabledTypeName:
+		eventPayload := TwoFactorDisabled{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case TwoFactorRequiredTypeName:
+		eventPayload := TwoFactorRequired{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case OIDCLoginStartedTypeName:
+		eventPayload := OIDCLoginStarted{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case ExternalIdentityLinkedTypeName:
+		eventPayload := ExternalIdentityLinked{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case GuestIdentityClaimedTypeName:
+		eventPayload := GuestIdentityClaimed{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case UsernameChangedTypeName:
+		eventPayload := UsernameChanged{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case EmailChangedTypeName:
+		eventPayload := EmailChanged{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case ProfileUpdatedTypeName:
+		eventPayload := ProfileUpdated{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case AvatarUploadedTypeName:
+		eventPayload := AvatarUploaded{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case RoleGrantedTypeName:
+		eventPayload := RoleGranted{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case RoleRevokedTypeName:
+		eventPayload := RoleRevoked{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case AccountBannedTypeName:
+		eventPayload := AccountBanned{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case AccountUnbannedTypeName:
+		eventPayload := AccountUnbanned{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case APITokenCreatedTypeName:
+		eventPayload := APITokenCreated{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
+		}
+		return Event{EventType: eventPayload.EventType(),
+			Timestamp: rawEvent.Timestamp,
+			EventId:   rawEvent.EventId,
+			Payload:   eventPayload}, nil
+	case APITokenRevokedTypeName:
+		eventPayload := APITokenRevoked{}
+		err = json.Unmarshal(rawEvent.Payload, &eventPayload)
+		if err != nil {
+			return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event payload")
 		}
 		return Event{EventType: eventPayload.EventType(),
 			Timestamp: rawEvent.Timestamp,
 			EventId:   rawEvent.EventId,
 			Payload:   eventPayload}, nil
 	default:
-		return Event{}, fmt.Errorf("unknown event type %s", rawEvent.EventType)
+		return Event{}, apperrors.Newf(apperrors.EventUnknownType, "unknown event type %s", rawEvent.EventType)
 	}
 }
@@ -16,7 +16,7 @@ func TestMarshalJSON(t *testing.T) {
 			HashedPassword: []byte("hashed_password"),
 			HashSalt:       []byte("scrypt salt")},
 		AccountDeleted{AccountId: uuid.NewV4()},
-		AccountLoggedIn{AccountId: uuid.NewV4(), JWT: "invalid_token"},
+		AccountLoggedIn{AccountId: uuid.NewV4(), SessionId: uuid.NewV4()},
 		CommentThreadCreated{CommentThreadId: uuid.NewV4(),
 			PageUrl: "pageurl.com",
 			Title:   "title"},
@@ -61,3 +61,25 @@ func TestMarshalJSON(t *testing.T) {
 		}
 	}
 }
+
+func TestPayloadTypes(t *testing.T) {
+	seen := map[string]bool{}
+	for _, payloadType := range PayloadTypes() {
+		if seen[payloadType.EventType()] {
+			t.Fatalf("PayloadTypes contains %s twice", payloadType.EventType())
+		}
+		seen[payloadType.EventType()] = true
+
+		encodedEvent, err := MarshalJSON(NewEventNow(payloadType))
+		if err != nil {
+			t.Fatalf("MarshalJSON failed : %v", err)
+		}
+		decodedEvent, err := UnmarshalJSON(encodedEvent)
+		if err != nil {
+			t.Fatalf("PayloadTypes contains %s which UnmarshalJSON can't decode : %v", payloadType.EventType(), err)
+		}
+		if reflect.TypeOf(decodedEvent.Payload) != reflect.TypeOf(payloadType) {
+			t.Fatalf("%s decoded as %T", payloadType.EventType(), decodedEvent.Payload)
+		}
+	}
+}
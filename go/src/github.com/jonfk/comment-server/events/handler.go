@@ -19,3 +19,29 @@ func (handler LogEventHandler) HandleEvent(event Event) error {
 	}).Info("Event Handled")
 	return nil
 }
+
+// EventHandlers is an EventHandler that hands every event to each of its
+// handlers in order and stops at the first error.
+type EventHandlers []EventHandler
+
+func (handlers EventHandlers) HandleEvent(event Event) error {
+	for _, handler := range handlers {
+		if err := handler.HandleEvent(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RedactionHandler is an EventHandler redacting the events about deleted
+// accounts.
+type RedactionHandler struct {
+	Redactor EventRedactor
+}
+
+func (handler *RedactionHandler) HandleEvent(event Event) error {
+	if eventPayload, ok := event.Payload.(AccountDeleted); ok {
+		return handler.Redactor.RedactAccount(eventPayload.AccountId)
+	}
+	return nil
+}
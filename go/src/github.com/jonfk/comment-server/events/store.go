@@ -0,0 +1,197 @@
+package events
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/apperrors"
+	"github.com/jonfk/comment-server/database"
+)
+
+// An EventAppender durably records events. Append must either record
+// every event or none of them.
+type EventAppender interface {
+	Append(events ...Event) error
+}
+
+// An EventReader returns the events recorded about an account, oldest
+// first. Events are about an account when the accountId field of their
+// payload is its id, events where it only granted a role, banned or
+// unbanned another account aren't.
+type EventReader interface {
+	EventsOfAccount(accountId uuid.UUID) ([]Event, error)
+}
+
+// An EventRedactor removes the personal data of an account from the
+// events about it, see EventReader, once the account is deleted.
+type EventRedactor interface {
+	RedactAccount(accountId uuid.UUID) error
+}
+
+// personalFields are the fields of event payloads holding personal data or
+// credentials, removed by RedactAccount.
+var personalFields = []string{
+	"username", "oldUsername", "displayName", "email", "oldEmail",
+	"passwordHash", "hashedPassword", "hashSalt",
+	"subject", "avatarUrl", "avatarKey",
+}
+
+// Store is an EventAppender, EventReader and EventRedactor backed by the
+// events table.
+type Store struct {
+	// DB is a *sqlx.DB, or a *sqlx.Tx to bind the store to a transaction.
+	DB database.Handle
+}
+
+func (s *Store) Append(events ...Event) error {
+	tx, err := database.Begin(s.DB)
+	if err != nil {
+		return apperrors.Wrap(err, apperrors.Internal, "database error")
+	}
+
+	for _, event := range events {
+		data, err := json.Marshal(event.Payload)
+		if err != nil {
+			tx.Rollback()
+			return apperrors.Wrap(err, apperrors.Internal, "cannot encode event")
+		}
+		_, err = tx.Exec("INSERT INTO events (eventId,event_type,timestamp,data) VALUES ($1,$2,$3,$4)",
+			event.EventId, event.EventType, event.Timestamp, data)
+		if err != nil {
+			tx.Rollback()
+			return apperrors.Wrap(err, apperrors.Internal, "database error")
+		}
+	}
+
+	return apperrors.Wrap(tx.Commit(), apperrors.Internal, "database error")
+}
+
+func (s *Store) EventsOfAccount(accountId uuid.UUID) ([]Event, error) {
+	var rows []struct {
+		EventId   uuid.UUID       `db:"eventid"`
+		EventType string          `db:"event_type"`
+		Timestamp time.Time       `db:"timestamp"`
+		Data      json.RawMessage `db:"data"`
+	}
+	err := s.DB.Select(&rows, `SELECT eventId,event_type,timestamp,data FROM events
+where data->>'accountId' = $1 ORDER BY timestamp`, accountId.String())
+	if err != nil {
+		return nil, apperrors.Wrap(err, apperrors.Internal, "database error")
+	}
+
+	events := make([]Event, 0, len(rows))
+	for _, row := range rows {
+		event, err := decodeEvent(EventJSON{EventType: row.EventType, Timestamp: row.Timestamp, EventId: row.EventId, Payload: row.Data})
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (s *Store) RedactAccount(accountId uuid.UUID) error {
+	// $1 is the array literal of the personalFields
+	_, err := s.DB.Exec("UPDATE events SET data = data - $1::text[] where data->>'accountId' = $2",
+		"{"+strings.Join(personalFields, ",")+"}", accountId.String())
+	return apperrors.Wrap(err, apperrors.Internal, "database error")
+}
+
+func decodeEvent(rawEvent EventJSON) (Event, error) {
+	input, err := json.Marshal(rawEvent)
+	if err != nil {
+		return Event{}, apperrors.Wrap(err, apperrors.EventInvalid, "malformed event")
+	}
+	return UnmarshalJSON(input)
+}
+
+// MemoryStore is an EventAppender, EventReader and EventRedactor keeping
+// events in memory, for tests.
+type MemoryStore struct {
+	mutex  sync.Mutex
+	events []Event
+}
+
+func (s *MemoryStore) Append(events ...Event) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func (s *MemoryStore) EventsOfAccount(accountId uuid.UUID) ([]Event, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var events []Event
+	for _, event := range s.events {
+		about, err := isAbout(event.Payload, accountId)
+		if err != nil {
+			return nil, err
+		}
+		if about {
+			events = append(events, event)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Timestamp.Before(events[j].Timestamp) })
+	return events, nil
+}
+
+func (s *MemoryStore) RedactAccount(accountId uuid.UUID) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, event := range s.events {
+		about, err := isAbout(event.Payload, accountId)
+		if err != nil {
+			return err
+		}
+		if !about {
+			continue
+		}
+		if s.events[i], err = redact(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redact removes the personalFields from the payload of event, like the
+// query of Store.RedactAccount.
+func redact(event Event) (Event, error) {
+	data, err := json.Marshal(event.Payload)
+	if err != nil {
+		return Event{}, apperrors.Wrap(err, apperrors.Internal, "cannot encode event")
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return Event{}, apperrors.Wrap(err, apperrors.Internal, "cannot decode event")
+	}
+	for _, field := range personalFields {
+		delete(fields, field)
+	}
+	if data, err = json.Marshal(fields); err != nil {
+		return Event{}, apperrors.Wrap(err, apperrors.Internal, "cannot encode event")
+	}
+	return decodeEvent(EventJSON{EventType: event.EventType, Timestamp: event.Timestamp, EventId: event.EventId, Payload: data})
+}
+
+// isAbout reports whether the accountId field of the JSON encoding of
+// payload is accountId, like the query of Store.EventsOfAccount.
+func isAbout(payload EventPayload, accountId uuid.UUID) (bool, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return false, apperrors.Wrap(err, apperrors.Internal, "cannot encode event")
+	}
+	var fields struct {
+		AccountId string `json:"accountId"`
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return false, apperrors.Wrap(err, apperrors.Internal, "cannot decode event")
+	}
+	return fields.AccountId == accountId.String(), nil
+}
@@ -0,0 +1,158 @@
+package events
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/joho/godotenv"
+	"github.com/satori/go.uuid"
+)
+
+var (
+	DBUser, DBName, DBPassword string
+)
+
+func TestMain(m *testing.M) {
+	err := godotenv.Load("../.env")
+	if err != nil {
+		log.Fatal("Error loading .env file")
+	}
+
+	DBUser = os.Getenv("DATABASE_USER")
+	DBName = os.Getenv("DATABASE_NAME")
+	DBPassword = os.Getenv("DATABASE_PASSWORD")
+
+	os.Exit(m.Run())
+}
+
+func TestAppend(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode.")
+	}
+
+	db, err := sqlx.Connect("postgres", fmt.Sprintf("user=%s dbname=%s password=%s sslmode=disable", DBUser, DBName, DBPassword))
+	if err != nil {
+		t.Fatalf("sqlx.Connect failed : %v\n", err)
+	}
+
+	store := &Store{DB: db}
+
+	appendedEvents := []Event{
+		NewEventNow(AccountDeleted{AccountId: uuid.NewV4()}),
+		NewEventNow(CommentDeleted{CommentId: uuid.NewV4()}),
+	}
+	defer func() {
+		for _, event := range appendedEvents {
+			db.Exec("DELETE FROM events where eventId = $1", event.EventId)
+		}
+	}()
+
+	err = store.Append(appendedEvents...)
+	if err != nil {
+		t.Fatalf("store.Append failed : %v\n", err)
+	}
+
+	var count int
+	err = db.Get(&count, "SELECT count(*) FROM events where eventId = $1 OR eventId = $2",
+		appendedEvents[0].EventId, appendedEvents[1].EventId)
+	if err != nil {
+		t.Fatalf("db.Get failed : %v\n", err)
+	}
+	if count != 2 {
+		t.Fatalf("expected 2 appended events but found %d", count)
+	}
+
+	err = store.Append(NewEventNow(CommentDeleted{CommentId: uuid.NewV4()}), appendedEvents[0])
+	if err == nil {
+		t.Fatal("store.Append should fail when an event was already appended")
+	}
+}
+
+func TestEventsOfAccount(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode.")
+	}
+
+	db, err := sqlx.Connect("postgres", fmt.Sprintf("user=%s dbname=%s password=%s sslmode=disable", DBUser, DBName, DBPassword))
+	if err != nil {
+		t.Fatalf("sqlx.Connect failed : %v\n", err)
+	}
+
+	store := &Store{DB: db}
+	accountId := uuid.NewV4()
+	appendedEvents := []Event{
+		NewEventNow(AccountDeleted{AccountId: accountId}),
+		NewEventNow(AccountDeleted{AccountId: uuid.NewV4()}),
+		NewEventNow(RoleGranted{Website: "https://example.com", AccountId: uuid.NewV4(), GrantedBy: accountId}),
+		NewEventNow(AccountBanned{BanId: uuid.NewV4(), AccountId: accountId}),
+	}
+	defer func() {
+		for _, event := range appendedEvents {
+			db.Exec("DELETE FROM events where eventId = $1", event.EventId)
+		}
+	}()
+
+	if err := store.Append(appendedEvents...); err != nil {
+		t.Fatalf("store.Append failed : %v\n", err)
+	}
+	accountEvents, err := store.EventsOfAccount(accountId)
+	if err != nil {
+		t.Fatalf("store.EventsOfAccount failed : %v\n", err)
+	}
+	if len(accountEvents) != 2 || !uuid.Equal(accountEvents[0].EventId, appendedEvents[0].EventId) ||
+		!uuid.Equal(accountEvents[1].EventId, appendedEvents[3].EventId) {
+		t.Fatalf("expected the 2 events with the accountId of the account but found %v", accountEvents)
+	}
+}
+
+func TestRedactAccount(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode.")
+	}
+
+	db, err := sqlx.Connect("postgres", fmt.Sprintf("user=%s dbname=%s password=%s sslmode=disable", DBUser, DBName, DBPassword))
+	if err != nil {
+		t.Fatalf("sqlx.Connect failed : %v\n", err)
+	}
+
+	store := &Store{DB: db}
+	accountId := uuid.NewV4()
+	appendedEvents := []Event{
+		NewEventNow(AccountCreated{AccountId: accountId, Username: "username", Email: "email@example.com", PasswordHash: "$argon2id$hash"}),
+		NewEventNow(AccountCreated{AccountId: uuid.NewV4(), Username: "other", Email: "other@example.com"}),
+	}
+	defer func() {
+		for _, event := range appendedEvents {
+			db.Exec("DELETE FROM events where eventId = $1", event.EventId)
+		}
+	}()
+
+	if err := store.Append(appendedEvents...); err != nil {
+		t.Fatalf("store.Append failed : %v\n", err)
+	}
+	if err := store.RedactAccount(accountId); err != nil {
+		t.Fatalf("store.RedactAccount failed : %v\n", err)
+	}
+	accountEvents, err := store.EventsOfAccount(accountId)
+	if err != nil {
+		t.Fatalf("store.EventsOfAccount failed : %v\n", err)
+	}
+	if len(accountEvents) != 1 {
+		t.Fatalf("redacted events should be kept but found %v", accountEvents)
+	}
+	if created := accountEvents[0].Payload.(AccountCreated); created.Username != "" || created.Email != "" || created.PasswordHash != "" {
+		t.Fatalf("RedactAccount should remove the personal data of the account but got %v", created)
+	}
+
+	var otherData string
+	if err := db.Get(&otherData, "SELECT data::text FROM events where eventId = $1", appendedEvents[1].EventId); err != nil {
+		t.Fatalf("db.Get failed : %v\n", err)
+	}
+	if !strings.Contains(otherData, "other@example.com") {
+		t.Fatalf("RedactAccount should keep the events of other accounts but got %s", otherData)
+	}
+}
@@ -0,0 +1,56 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/satori/go.uuid"
+)
+
+func TestMemoryStoreRedactAccount(t *testing.T) {
+	store := &MemoryStore{}
+	accountId, otherAccountId := uuid.NewV4(), uuid.NewV4()
+	err := store.Append(
+		NewEventNow(AccountCreated{AccountId: accountId, Username: "username", Email: "email@example.com", PasswordHash: "$argon2id$hash"}),
+		NewEventNow(EmailChanged{AccountId: accountId, OldEmail: "email@example.com", Email: "new@example.com"}),
+		NewEventNow(AccountCreated{AccountId: otherAccountId, Username: "other", Email: "other@example.com"}),
+	)
+	if err != nil {
+		t.Fatalf("store.Append failed : %v\n", err)
+	}
+
+	if err := store.RedactAccount(accountId); err != nil {
+		t.Fatalf("store.RedactAccount failed : %v\n", err)
+	}
+	created := store.events[0].Payload.(AccountCreated)
+	if !uuid.Equal(created.AccountId, accountId) || created.Username != "" || created.Email != "" || created.PasswordHash != "" {
+		t.Fatalf("RedactAccount should remove the personal data of the account but got %v", created)
+	}
+	if changed := store.events[1].Payload.(EmailChanged); changed.OldEmail != "" || changed.Email != "" {
+		t.Fatalf("RedactAccount should remove the emails of the account but got %v", changed)
+	}
+	if other := store.events[2].Payload.(AccountCreated); other.Username != "other" || other.Email != "other@example.com" {
+		t.Fatalf("RedactAccount should keep the events of other accounts but got %v", other)
+	}
+}
+
+func TestRedactionHandler(t *testing.T) {
+	store := &MemoryStore{}
+	accountId := uuid.NewV4()
+	if err := store.Append(NewEventNow(UsernameChanged{AccountId: accountId, OldUsername: "old", Username: "new"})); err != nil {
+		t.Fatalf("store.Append failed : %v\n", err)
+	}
+	handler := &RedactionHandler{Redactor: store}
+
+	if err := handler.HandleEvent(NewEventNow(LoggedOutEverywhere{AccountId: accountId})); err != nil {
+		t.Fatalf("HandleEvent failed : %v\n", err)
+	}
+	if changed := store.events[0].Payload.(UsernameChanged); changed.Username != "new" {
+		t.Fatalf("only AccountDeleted should redact events but got %v", changed)
+	}
+	if err := handler.HandleEvent(NewEventNow(AccountDeleted{AccountId: accountId})); err != nil {
+		t.Fatalf("HandleEvent failed : %v\n", err)
+	}
+	if changed := store.events[0].Payload.(UsernameChanged); changed.OldUsername != "" || changed.Username != "" {
+		t.Fatalf("AccountDeleted should redact the events of the account but got %v", changed)
+	}
+}
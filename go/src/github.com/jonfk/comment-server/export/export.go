@@ -0,0 +1,168 @@
+// Package export packages the personal data of an account into a JSON
+// archive, to answer the access requests of data subjects.
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/accounts"
+	"github.com/jonfk/comment-server/apperrors"
+	"github.com/jonfk/comment-server/comments"
+	"github.com/jonfk/comment-server/events"
+)
+
+// Path is where the Handler is conventionally served.
+const Path = "/export"
+
+// Archive is everything stored about an account.
+type Archive struct {
+	ExportedOn time.Time        `json:"exportedOn"`
+	Account    accounts.Account `json:"account"`
+	// AvatarURL is the largest version of the avatar shown with the
+	// comments of the account.
+	AvatarURL       string                    `json:"avatarUrl"`
+	UsernameHistory []accounts.UsernameChange `json:"usernameHistory"`
+	Bans            []accounts.Ban            `json:"bans"`
+	APITokens       []accounts.APIToken       `json:"apiTokens"`
+	Roles           []comments.WebsiteRole    `json:"roles"`
+	Comments        []Comment                 `json:"comments"`
+	// Events are the events about the account, without the password and
+	// token hashes they may contain.
+	Events []events.Event `json:"events"`
+}
+
+// Comment is a comment of the account in an Archive.
+type Comment struct {
+	CommentId       uuid.UUID  `json:"commentId"`
+	CommentThreadId uuid.UUID  `json:"commentThreadId"`
+	ParentId        *uuid.UUID `json:"parentId,omitempty"`
+	Timestamp       time.Time  `json:"timestamp"`
+	Data            string     `json:"data"`
+	Hidden          bool       `json:"hidden"`
+}
+
+type Exporter struct {
+	AccountsService *accounts.Accounts
+	CommentsService *comments.Comments
+	EventReader     events.EventReader
+}
+
+// ExportAccountData returns the Archive of an account.
+func (e *Exporter) ExportAccountData(accountId uuid.UUID) (Archive, error) {
+	account, err := e.AccountsService.GetAccountByAccountId(accountId)
+	if err != nil {
+		return Archive{}, err
+	}
+	archive := Archive{
+		ExportedOn: time.Now().UTC(),
+		Account:    account,
+		AvatarURL:  e.AccountsService.AvatarURL(account, accounts.AvatarSizes[len(accounts.AvatarSizes)-1]),
+	}
+
+	if archive.UsernameHistory, err = e.AccountsService.UsernameHistory(accountId); err != nil {
+		return Archive{}, err
+	}
+	if archive.Bans, err = e.AccountsService.ListBans(accountId); err != nil {
+		return Archive{}, err
+	}
+	if archive.APITokens, err = e.AccountsService.ListAPITokens(accountId); err != nil {
+		return Archive{}, err
+	}
+	if archive.Roles, err = e.CommentsService.RolesOfAccount(accountId); err != nil {
+		return Archive{}, err
+	}
+
+	accountComments, err := e.CommentsService.CommentsOfAccount(accountId)
+	if err != nil {
+		return Archive{}, err
+	}
+	archive.Comments = make([]Comment, 0, len(accountComments))
+	for _, comment := range accountComments {
+		exported := Comment{
+			CommentId:       comment.CommentId,
+			CommentThreadId: comment.CommentThreadId,
+			Timestamp:       comment.Timestamp,
+			Data:            comment.Data,
+			Hidden:          comment.Hidden,
+		}
+		if comment.ParentId.Valid {
+			parentId := comment.ParentId.UUID
+			exported.ParentId = &parentId
+		}
+		archive.Comments = append(archive.Comments, exported)
+	}
+
+	accountEvents, err := e.EventReader.EventsOfAccount(accountId)
+	if err != nil {
+		return Archive{}, err
+	}
+	archive.Events = make([]events.Event, 0, len(accountEvents))
+	for _, event := range accountEvents {
+		event.Payload = withoutSecrets(event.Payload)
+		archive.Events = append(archive.Events, event)
+	}
+	return archive, nil
+}
+
+// withoutSecrets removes the password and token hashes of event payloads.
+func withoutSecrets(payload events.EventPayload) events.EventPayload {
+	switch payload := payload.(type) {
+	case events.AccountCreated:
+		payload.PasswordHash, payload.HashedPassword, payload.HashSalt = "", nil, nil
+		return payload
+	case events.PasswordChanged:
+		payload.PasswordHash, payload.HashedPassword, payload.HashSalt = "", nil, nil
+		return payload
+	case events.APITokenCreated:
+		payload.TokenHash = nil
+		return payload
+	}
+	return payload
+}
+
+// Handler serves the Archive of the account authenticated by the bearer
+// access token of requests as a JSON download.
+type Handler struct {
+	Exporter *Exporter
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	archive, err := h.archiveOf(r)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"context": "export.Handler",
+			"error":   err,
+		}).Error("Exporting account data failed")
+		w.WriteHeader(apperrors.HTTPStatusOf(err))
+		json.NewEncoder(w).Encode(apperrors.ResponseOf(err))
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="account-%s.json"`, archive.Account.AccountId))
+	json.NewEncoder(w).Encode(archive)
+}
+
+func (h *Handler) archiveOf(r *http.Request) (Archive, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return Archive{}, apperrors.New(apperrors.AuthInvalidToken, "An access token is required")
+	}
+	accountId, err := h.Exporter.AccountsService.ValidateToken(token, accounts.ScopeExport)
+	if err != nil {
+		return Archive{}, err
+	}
+	return h.Exporter.ExportAccountData(accountId)
+}
@@ -0,0 +1,116 @@
+package export
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/accounts"
+	"github.com/jonfk/comment-server/comments"
+	"github.com/jonfk/comment-server/events"
+)
+
+func TestExportAccountData(t *testing.T) {
+	accountsService := &accounts.Accounts{Store: accounts.NewMemoryStore(), HMACSecretKey: []byte("secret_key"), SessionLengthInHours: 1}
+	commentsService := &comments.Comments{Store: comments.NewMemoryStore()}
+	eventStore := &events.MemoryStore{}
+	handler := events.EventHandlers{
+		&accounts.EventHandler{AccountsService: accountsService},
+		&comments.EventHandler{CommentsService: commentsService},
+	}
+	exporter := &Exporter{AccountsService: accountsService, CommentsService: commentsService, EventReader: eventStore}
+
+	accountId, otherAccountId := uuid.NewV4(), uuid.NewV4()
+	threadId, commentId, replyId := uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+	for _, payload := range []events.EventPayload{
+		events.AccountCreated{AccountId: accountId, Username: "username", Email: "email@example.com", PasswordHash: "$argon2id$hash"},
+		events.AccountCreated{AccountId: otherAccountId, Username: "other", Email: "other@example.com"},
+		events.CommentThreadCreated{CommentThreadId: threadId, PageUrl: "pageUrl", Title: "title"},
+		events.CommentCreated{CommentId: commentId, Data: "comment", CommentThreadId: threadId, AccountId: otherAccountId},
+		events.CommentCreated{CommentId: replyId, Data: "reply", CommentThreadId: threadId, AccountId: accountId, ParentId: &commentId},
+		events.UsernameChanged{AccountId: accountId, OldUsername: "username", Username: "renamed"},
+		events.RoleGranted{Website: "https://example.com", AccountId: otherAccountId, Role: comments.RoleModerator, GrantedBy: accountId},
+		events.AccountBanned{BanId: uuid.NewV4(), AccountId: otherAccountId, Website: "https://example.com", BannedBy: &accountId},
+	} {
+		event := events.NewEventNow(payload)
+		if err := handler.HandleEvent(event); err != nil {
+			t.Fatalf("HandleEvent failed : %v\n", err)
+		}
+		if err := eventStore.Append(event); err != nil {
+			t.Fatalf("Append failed : %v\n", err)
+		}
+	}
+
+	archive, err := exporter.ExportAccountData(accountId)
+	if err != nil {
+		t.Fatalf("ExportAccountData failed : %v\n", err)
+	}
+	if archive.Account.Username != "renamed" || len(archive.UsernameHistory) != 1 {
+		t.Fatalf("the archive should contain the profile and its history but got %v", archive)
+	}
+	if len(archive.Comments) != 1 || !uuid.Equal(archive.Comments[0].CommentId, replyId) ||
+		archive.Comments[0].ParentId == nil || !uuid.Equal(*archive.Comments[0].ParentId, commentId) {
+		t.Fatalf("the archive should only contain the comments of the account but got %v", archive.Comments)
+	}
+	// AccountCreated, CommentCreated and UsernameChanged, but not the
+	// RoleGranted and AccountBanned events about the other account
+	if len(archive.Events) != 3 {
+		t.Fatalf("the archive should contain the 3 events about the account but got %v", archive.Events)
+	}
+	encoded, err := json.Marshal(archive)
+	if err != nil {
+		t.Fatalf("json.Marshal failed : %v\n", err)
+	}
+	if strings.Contains(string(encoded), "argon2id") {
+		t.Fatalf("the archive shouldn't contain password hashes : %s", encoded)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	accountsService := &accounts.Accounts{Store: accounts.NewMemoryStore(), HMACSecretKey: []byte("secret_key"), SessionLengthInHours: 1}
+	exporter := &Exporter{
+		AccountsService: accountsService,
+		CommentsService: &comments.Comments{Store: comments.NewMemoryStore()},
+		EventReader:     &events.MemoryStore{},
+	}
+	accountId := uuid.NewV4()
+	err := (&accounts.EventHandler{AccountsService: accountsService}).HandleEvent(events.NewEventNow(
+		events.AccountCreated{AccountId: accountId, Username: "username", Email: "email@example.com"}))
+	if err != nil {
+		t.Fatalf("HandleEvent failed : %v\n", err)
+	}
+	tokens, err := accountsService.StartSession(accountId)
+	if err != nil {
+		t.Fatalf("StartSession failed : %v\n", err)
+	}
+	server := httptest.NewServer(&Handler{Exporter: exporter})
+	defer server.Close()
+	get := func(token string) *http.Response {
+		request, _ := http.NewRequest("GET", server.URL+Path, nil)
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("GET failed : %v\n", err)
+		}
+		return response
+	}
+
+	if response := get(""); response.StatusCode != 401 {
+		t.Fatalf("exports should require an access token but got %d", response.StatusCode)
+	}
+	response := get(tokens.AccessToken)
+	defer response.Body.Close()
+	if response.StatusCode != 200 || !strings.HasPrefix(response.Header.Get("Content-Disposition"), "attachment") {
+		t.Fatalf("the export should be downloaded but got %d %v", response.StatusCode, response.Header)
+	}
+	var archive Archive
+	if err := json.NewDecoder(response.Body).Decode(&archive); err != nil || !uuid.Equal(archive.Account.AccountId, accountId) {
+		t.Fatalf("the export should be the archive of the account but got %v (%v)", archive, err)
+	}
+}
@@ -0,0 +1,97 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email.
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails.
+type Mailer interface {
+	Send(Message) error
+}
+
+// Bytes returns the message formatted as RFC 5322 with date.
+func (m Message) Bytes(date time.Time) []byte {
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	fmt.Fprintf(&buffer, "From: %s\r\n", sanitizeHeader(m.From))
+	fmt.Fprintf(&buffer, "To: %s\r\n", sanitizeHeader(m.To))
+	fmt.Fprintf(&buffer, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", sanitizeHeader(m.Subject)))
+	buffer.WriteString("MIME-Version: 1.0\r\n")
+	buffer.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buffer.WriteString("\r\n")
+	buffer.WriteString(strings.Replace(m.Body, "\n", "\r\n", -1))
+	return buffer.Bytes()
+}
+
+// sanitizeHeader removes line breaks that would allow a value to inject
+// headers into a message.
+func sanitizeHeader(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
+
+// SMTPMailer sends emails through an SMTP server. Messages without a From
+// are sent from From.
+type SMTPMailer struct {
+	Addr string
+	Auth smtp.Auth
+	From string
+}
+
+func (m *SMTPMailer) Send(message Message) error {
+	if message.From == "" {
+		message.From = m.From
+	}
+	return smtp.SendMail(m.Addr, m.Auth, message.From, []string{message.To}, message.Bytes(time.Now()))
+}
+
+// MaildirMailer delivers emails to a local maildir instead of sending them,
+// so that the emails sent during development and tests can be read with
+// any mail client. Messages without a From are sent from From.
+type MaildirMailer struct {
+	Dir  string
+	From string
+}
+
+func (m *MaildirMailer) Send(message Message) error {
+	if message.From == "" {
+		message.From = m.From
+	}
+	for _, subdir := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(m.Dir, subdir), 0700); err != nil {
+			return err
+		}
+	}
+
+	unique := make([]byte, 8)
+	if _, err := rand.Read(unique); err != nil {
+		return err
+	}
+	now := time.Now()
+	name := fmt.Sprintf("%d.%s.comment-server", now.UnixNano(), hex.EncodeToString(unique))
+
+	// Maildir delivery: write to tmp then move to new so that readers never
+	// see partial messages.
+	tmpPath := filepath.Join(m.Dir, "tmp", name)
+	if err := ioutil.WriteFile(tmpPath, message.Bytes(now), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(m.Dir, "new", name))
+}
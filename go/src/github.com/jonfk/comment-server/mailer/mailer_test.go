@@ -0,0 +1,64 @@
+package mailer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessageBytes(t *testing.T) {
+	message := Message{
+		From:    "comment-server@example.com",
+		To:      "user@example.com\r\nBcc: attacker@example.com",
+		Subject: "Your login link",
+		Body:    "line 1\nline 2",
+	}
+
+	formatted := string(message.Bytes(time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)))
+
+	expected := "Date: Mon, 02 Jan 2017 03:04:05 +0000\r\n" +
+		"From: comment-server@example.com\r\n" +
+		"To: user@example.comBcc: attacker@example.com\r\n" +
+		"Subject: Your login link\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		"line 1\r\nline 2"
+	if formatted != expected {
+		t.Fatalf("Message.Bytes returned\n%q\nexpected\n%q", formatted, expected)
+	}
+}
+
+func TestMaildirMailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maildir")
+	if err != nil {
+		t.Fatalf("TempDir failed : %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	mailer := &MaildirMailer{Dir: dir, From: "comment-server@example.com"}
+	err = mailer.Send(Message{To: "user@example.com", Subject: "subject", Body: "body"})
+	if err != nil {
+		t.Fatalf("MaildirMailer.Send failed : %v\n", err)
+	}
+
+	delivered, err := ioutil.ReadDir(filepath.Join(dir, "new"))
+	if err != nil {
+		t.Fatalf("ReadDir failed : %v\n", err)
+	}
+	if len(delivered) != 1 {
+		t.Fatalf("expected 1 delivered message but found %d", len(delivered))
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "new", delivered[0].Name()))
+	if err != nil {
+		t.Fatalf("ReadFile failed : %v\n", err)
+	}
+	if !strings.Contains(string(content), "From: comment-server@example.com\r\n") ||
+		!strings.HasSuffix(string(content), "\r\n\r\nbody") {
+		t.Fatalf("unexpected message delivered\n%s", content)
+	}
+}
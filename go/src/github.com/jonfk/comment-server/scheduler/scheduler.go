@@ -0,0 +1,180 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/apperrors"
+	"github.com/jonfk/comment-server/commands"
+)
+
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusDone      = "done"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+
+	DefaultPollInterval = 10 * time.Second
+	DefaultLease        = 10 * time.Minute
+	dueBatchSize        = 100
+)
+
+var (
+	ScheduledCommandNotFoundErr   = apperrors.New(apperrors.ScheduledCommandNotFound, "Scheduled Command Not Found")
+	ScheduledCommandNotPendingErr = apperrors.New(apperrors.ScheduledCommandNotPending, "Scheduled Command is not pending")
+)
+
+// ScheduledCommand is a command that is handled once ExecuteAt is reached.
+// Error holds the code and message of the error a command failed with when
+// Status is StatusFailed. ClaimedOn is when the command was last claimed to
+// be handled.
+type ScheduledCommand struct {
+	ScheduledCommandId uuid.UUID        `json:"scheduledCommandId"`
+	ExecuteAt          time.Time        `json:"executeAt"`
+	Command            commands.Command `json:"command"`
+	Status             string           `json:"status"`
+	Error              string           `json:"error,omitempty"`
+	CreatedOn          time.Time        `json:"createdOn"`
+	ClaimedOn          *time.Time       `json:"claimedOn,omitempty"`
+}
+
+// Store durably keeps scheduled commands.
+type Store interface {
+	Insert(ScheduledCommand) error
+	// Cancel cancels a pending scheduled command.
+	Cancel(scheduledCommandId uuid.UUID) error
+	// ListPending returns the pending scheduled commands ordered by ExecuteAt.
+	ListPending() ([]ScheduledCommand, error)
+	// ClaimDue marks at most limit commands as running, claimed on now, and
+	// returns them. It claims the pending commands due at now and the
+	// running commands claimed before staleBefore, whose scheduler is
+	// assumed to have stopped before completing them. A command is only
+	// claimed again once its claim is stale.
+	ClaimDue(now, staleBefore time.Time, limit int) ([]ScheduledCommand, error)
+	// Complete records the final status of a claimed command.
+	Complete(scheduledCommandId uuid.UUID, status, errorMessage string) error
+}
+
+// Scheduler stores commands to be handled later and handles them once they
+// are due. Commands are handled at least once: a command whose handling
+// outlasts the Lease is handled again.
+type Scheduler struct {
+	Store        Store
+	Handler      commands.CommandHandler
+	PollInterval time.Duration
+	// Lease is how long a claimed command may take to be handled before it
+	// is claimed again, e.g. after a crash. It defaults to DefaultLease.
+	Lease time.Duration
+	// Now returns the current time. It defaults to time.Now.
+	Now func() time.Time
+}
+
+func (s *Scheduler) now() time.Time {
+	if s.Now != nil {
+		return s.Now().UTC()
+	}
+	return time.Now().UTC()
+}
+
+func (s *Scheduler) lease() time.Duration {
+	if s.Lease > 0 {
+		return s.Lease
+	}
+	return DefaultLease
+}
+
+// Schedule stores command to be handled at executeAt. Commands carrying
+// credentials, such as passwords or tokens, can't be scheduled as they
+// would be stored.
+func (s *Scheduler) Schedule(command commands.Command, executeAt time.Time) (ScheduledCommand, error) {
+	if commands.CarriesSecrets(command.Payload) {
+		return ScheduledCommand{}, apperrors.New(apperrors.CommandInvalid, "Commands carrying credentials can't be scheduled")
+	}
+	scheduledCommand := ScheduledCommand{
+		ScheduledCommandId: uuid.NewV4(),
+		ExecuteAt:          executeAt.UTC().Round(time.Second),
+		Command:            command,
+		Status:             StatusPending,
+		CreatedOn:          s.now().Round(time.Second),
+	}
+	err := s.Store.Insert(scheduledCommand)
+	if err != nil {
+		return ScheduledCommand{}, err
+	}
+	return scheduledCommand, nil
+}
+
+// ScheduleAfter stores command to be handled once delay has elapsed.
+func (s *Scheduler) ScheduleAfter(command commands.Command, delay time.Duration) (ScheduledCommand, error) {
+	return s.Schedule(command, s.now().Add(delay))
+}
+
+// Cancel prevents a pending scheduled command from being handled.
+func (s *Scheduler) Cancel(scheduledCommandId uuid.UUID) error {
+	return s.Store.Cancel(scheduledCommandId)
+}
+
+// List returns the scheduled commands that are still pending.
+func (s *Scheduler) List() ([]ScheduledCommand, error) {
+	return s.Store.ListPending()
+}
+
+// RunDue handles every scheduled command that is due and returns the
+// number of commands handled.
+func (s *Scheduler) RunDue() (int, error) {
+	handled := 0
+	for {
+		now := s.now()
+		dueCommands, err := s.Store.ClaimDue(now, now.Add(-s.lease()), dueBatchSize)
+		if err != nil {
+			return handled, err
+		}
+		for _, scheduledCommand := range dueCommands {
+			status, errorMessage := StatusDone, ""
+			if _, err := s.Handler.HandleCommand(scheduledCommand.Command); err != nil {
+				// Only the public part of the error is stored, its cause is logged
+				status, errorMessage = StatusFailed, fmt.Sprintf("%s: %s", apperrors.CodeOf(err), apperrors.MessageOf(err))
+				log.WithFields(log.Fields{
+					"context":            "Scheduler",
+					"scheduledCommandId": scheduledCommand.ScheduledCommandId,
+					"commandType":        scheduledCommand.Command.CommandType,
+					"error":              err,
+				}).Error("Scheduled command failed")
+			}
+			if err := s.Store.Complete(scheduledCommand.ScheduledCommandId, status, errorMessage); err != nil {
+				return handled, err
+			}
+			handled++
+		}
+		if len(dueCommands) < dueBatchSize {
+			return handled, nil
+		}
+	}
+}
+
+// Run handles due commands every PollInterval until stop is closed.
+func (s *Scheduler) Run(stop <-chan struct{}) {
+	pollInterval := s.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = DefaultPollInterval
+	}
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.RunDue(); err != nil {
+			log.WithFields(log.Fields{
+				"context": "Scheduler",
+				"error":   err,
+			}).Error("Running due commands failed")
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
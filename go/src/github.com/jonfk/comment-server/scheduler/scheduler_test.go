@@ -0,0 +1,185 @@
+package scheduler
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/apperrors"
+	"github.com/jonfk/comment-server/commands"
+)
+
+// recordingHandler records the commands it handles, fails on DeleteAccount
+// commands and fails with an internal error on CreateComment commands.
+type recordingHandler struct {
+	handled []commands.Command
+}
+
+func (h *recordingHandler) HandleCommand(command commands.Command) (commands.Response, error) {
+	h.handled = append(h.handled, command)
+	switch command.Payload.(type) {
+	case commands.DeleteAccount:
+		return commands.Response{}, apperrors.New(apperrors.AccountNotFound, "Account Not Found")
+	case commands.CreateComment:
+		return commands.Response{}, apperrors.Wrap(errors.New("pq: password authentication failed for user \"comments\""), apperrors.Internal, "database error")
+	}
+	return commands.Response{}, nil
+}
+
+func TestScheduler(t *testing.T) {
+	now := time.Now().UTC().Round(time.Second)
+	store := NewMemoryStore()
+	handler := &recordingHandler{}
+	scheduler := &Scheduler{
+		Store:   store,
+		Handler: handler,
+		Now:     func() time.Time { return now },
+	}
+
+	closeThread := commands.CreateCommand(commands.CreateCommentThread{PageUrl: "pageUrl", Title: "title"})
+	deleteAccount := commands.CreateCommand(commands.DeleteAccount{AccountId: uuid.NewV4()})
+	cancelledComment := commands.CreateCommand(commands.DeleteComment{CommentId: uuid.NewV4(), AccountId: uuid.NewV4()})
+
+	scheduledThread, err := scheduler.ScheduleAfter(closeThread, time.Hour)
+	if err != nil {
+		t.Fatalf("scheduler.ScheduleAfter failed : %v\n", err)
+	}
+	scheduledDeletion, err := scheduler.ScheduleAfter(deleteAccount, 2*time.Hour)
+	if err != nil {
+		t.Fatalf("scheduler.ScheduleAfter failed : %v\n", err)
+	}
+	scheduledComment, err := scheduler.ScheduleAfter(cancelledComment, time.Minute)
+	if err != nil {
+		t.Fatalf("scheduler.ScheduleAfter failed : %v\n", err)
+	}
+
+	if err := scheduler.Cancel(scheduledComment.ScheduledCommandId); err != nil {
+		t.Fatalf("scheduler.Cancel failed : %v\n", err)
+	}
+	if err := scheduler.Cancel(scheduledComment.ScheduledCommandId); !apperrors.Is(err, apperrors.ScheduledCommandNotPending) {
+		t.Fatalf("cancelling twice should fail with %s but returned %v", apperrors.ScheduledCommandNotPending, err)
+	}
+	if err := scheduler.Cancel(uuid.NewV4()); !apperrors.Is(err, apperrors.ScheduledCommandNotFound) {
+		t.Fatalf("cancelling an unknown command should fail with %s but returned %v", apperrors.ScheduledCommandNotFound, err)
+	}
+
+	pending, err := scheduler.List()
+	if err != nil {
+		t.Fatalf("scheduler.List failed : %v\n", err)
+	}
+	if len(pending) != 2 ||
+		!uuid.Equal(pending[0].ScheduledCommandId, scheduledThread.ScheduledCommandId) ||
+		!uuid.Equal(pending[1].ScheduledCommandId, scheduledDeletion.ScheduledCommandId) {
+		t.Fatalf("scheduler.List should return the 2 pending commands ordered by execution time but returned %v", pending)
+	}
+
+	if handled, err := scheduler.RunDue(); err != nil || handled != 0 {
+		t.Fatalf("no command should be due yet, handled %d, err %v", handled, err)
+	}
+
+	now = now.Add(90 * time.Minute)
+	if handled, err := scheduler.RunDue(); err != nil || handled != 1 {
+		t.Fatalf("1 command should be due, handled %d, err %v", handled, err)
+	}
+
+	now = now.Add(time.Hour)
+	if handled, err := scheduler.RunDue(); err != nil || handled != 1 {
+		t.Fatalf("1 command should be due, handled %d, err %v", handled, err)
+	}
+	if handled, err := scheduler.RunDue(); err != nil || handled != 0 {
+		t.Fatalf("commands should only be handled once, handled %d, err %v", handled, err)
+	}
+
+	if len(handler.handled) != 2 ||
+		handler.handled[0].CommandType != commands.CreateCommentThreadTypeName ||
+		handler.handled[1].CommandType != commands.DeleteAccountTypeName {
+		t.Fatalf("unexpected commands handled %v", handler.handled)
+	}
+
+	expectedStatuses := map[uuid.UUID]string{
+		scheduledThread.ScheduledCommandId:   StatusDone,
+		scheduledDeletion.ScheduledCommandId: StatusFailed,
+		scheduledComment.ScheduledCommandId:  StatusCancelled,
+	}
+	for scheduledCommandId, expectedStatus := range expectedStatuses {
+		scheduledCommand, err := store.Get(scheduledCommandId)
+		if err != nil {
+			t.Fatalf("store.Get failed : %v\n", err)
+		}
+		if scheduledCommand.Status != expectedStatus {
+			t.Fatalf("scheduled command %v has status %s, expected %s", scheduledCommandId, scheduledCommand.Status, expectedStatus)
+		}
+	}
+}
+
+func TestSchedulerReclaimsAbandonedCommands(t *testing.T) {
+	now := time.Now().UTC().Round(time.Second)
+	store := NewMemoryStore()
+	handler := &recordingHandler{}
+	scheduler := &Scheduler{
+		Store:   store,
+		Handler: handler,
+		Lease:   time.Minute,
+		Now:     func() time.Time { return now },
+	}
+
+	scheduledThread, err := scheduler.Schedule(commands.CreateCommand(commands.CreateCommentThread{PageUrl: "pageUrl", Title: "title"}), now)
+	if err != nil {
+		t.Fatalf("scheduler.Schedule failed : %v\n", err)
+	}
+	// A scheduler claims the command and crashes before completing it
+	if claimed, err := store.ClaimDue(now, now.Add(-time.Minute), dueBatchSize); err != nil || len(claimed) != 1 {
+		t.Fatalf("store.ClaimDue should have claimed 1 command, claimed %v, err %v", claimed, err)
+	}
+
+	if handled, err := scheduler.RunDue(); err != nil || handled != 0 {
+		t.Fatalf("a command should not be claimed again during its lease, handled %d, err %v", handled, err)
+	}
+	now = now.Add(2 * time.Minute)
+	if handled, err := scheduler.RunDue(); err != nil || handled != 1 {
+		t.Fatalf("an abandoned command should be claimed again once its lease expired, handled %d, err %v", handled, err)
+	}
+	scheduledCommand, err := store.Get(scheduledThread.ScheduledCommandId)
+	if err != nil {
+		t.Fatalf("store.Get failed : %v\n", err)
+	}
+	if scheduledCommand.Status != StatusDone {
+		t.Fatalf("reclaimed command has status %s, expected %s", scheduledCommand.Status, StatusDone)
+	}
+}
+
+func TestSchedulerStoresNoSecrets(t *testing.T) {
+	now := time.Now().UTC().Round(time.Second)
+	store := NewMemoryStore()
+	scheduler := &Scheduler{
+		Store:   store,
+		Handler: &recordingHandler{},
+		Now:     func() time.Time { return now },
+	}
+
+	_, err := scheduler.Schedule(commands.CreateCommand(commands.LoginAccount{Email: "email@email.com", Password: "password"}), now)
+	if !apperrors.Is(err, apperrors.CommandInvalid) {
+		t.Fatalf("scheduling a command with a password should fail with %s but returned %v", apperrors.CommandInvalid, err)
+	}
+	if pending, _ := scheduler.List(); len(pending) != 0 {
+		t.Fatalf("a command with a password should not be stored but found %v", pending)
+	}
+
+	scheduledComment, err := scheduler.Schedule(commands.CreateCommand(commands.CreateComment{Data: "data", CommentThreadId: uuid.NewV4(), AccountId: uuid.NewV4()}), now)
+	if err != nil {
+		t.Fatalf("scheduler.Schedule failed : %v\n", err)
+	}
+	if _, err := scheduler.RunDue(); err != nil {
+		t.Fatalf("scheduler.RunDue failed : %v\n", err)
+	}
+	scheduledCommand, err := store.Get(scheduledComment.ScheduledCommandId)
+	if err != nil {
+		t.Fatalf("store.Get failed : %v\n", err)
+	}
+	if scheduledCommand.Status != StatusFailed || scheduledCommand.Error != "internal: database error" || strings.Contains(scheduledCommand.Error, "pq") {
+		t.Fatalf("failed command should only record the public error but has %s %q", scheduledCommand.Status, scheduledCommand.Error)
+	}
+}
@@ -0,0 +1,234 @@
+package scheduler
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/apperrors"
+	"github.com/jonfk/comment-server/commands"
+)
+
+// DBStore is a Store backed by the scheduled_commands table.
+type DBStore struct {
+	DB *sqlx.DB
+}
+
+type scheduledCommandRow struct {
+	ScheduledCommandId uuid.UUID      `db:"scheduled_command_id"`
+	ExecuteAt          time.Time      `db:"execute_at"`
+	Command            []byte         `db:"command"`
+	Status             string         `db:"status"`
+	Error              sql.NullString `db:"error"`
+	CreatedOn          time.Time      `db:"created_on"`
+	ClaimedOn          *time.Time     `db:"claimed_on"`
+}
+
+const scheduledCommandColumns = "scheduled_command_id,execute_at,command,status,error,created_on,claimed_on"
+
+func (r scheduledCommandRow) toScheduledCommand() (ScheduledCommand, error) {
+	commandPayload, err := commands.UnmarshalJSON(r.Command)
+	if err != nil {
+		return ScheduledCommand{}, err
+	}
+	return ScheduledCommand{
+		ScheduledCommandId: r.ScheduledCommandId,
+		ExecuteAt:          r.ExecuteAt,
+		Command:            commands.CreateCommand(commandPayload),
+		Status:             r.Status,
+		Error:              r.Error.String,
+		CreatedOn:          r.CreatedOn,
+		ClaimedOn:          r.ClaimedOn,
+	}, nil
+}
+
+func toScheduledCommands(rows []scheduledCommandRow) ([]ScheduledCommand, error) {
+	scheduledCommands := []ScheduledCommand{}
+	for _, row := range rows {
+		scheduledCommand, err := row.toScheduledCommand()
+		if err != nil {
+			return nil, err
+		}
+		scheduledCommands = append(scheduledCommands, scheduledCommand)
+	}
+	return scheduledCommands, nil
+}
+
+func (s *DBStore) Insert(scheduledCommand ScheduledCommand) error {
+	command, err := commands.MarshalJSON(scheduledCommand.Command)
+	if err != nil {
+		return apperrors.Wrap(err, apperrors.CommandInvalid, "cannot encode command")
+	}
+	_, err = s.DB.Exec("INSERT INTO scheduled_commands (scheduled_command_id,execute_at,command,status,created_on) VALUES ($1,$2,$3,$4,$5)",
+		scheduledCommand.ScheduledCommandId,
+		scheduledCommand.ExecuteAt,
+		command,
+		scheduledCommand.Status,
+		scheduledCommand.CreatedOn)
+	return apperrors.Wrap(err, apperrors.Internal, "database error")
+}
+
+func (s *DBStore) Cancel(scheduledCommandId uuid.UUID) error {
+	var status string
+	err := s.DB.QueryRowx("SELECT status FROM scheduled_commands where scheduled_command_id = $1", scheduledCommandId).Scan(&status)
+	if err == sql.ErrNoRows {
+		return ScheduledCommandNotFoundErr
+	}
+	if err != nil {
+		return apperrors.Wrap(err, apperrors.Internal, "database error")
+	}
+
+	result, err := s.DB.Exec("UPDATE scheduled_commands SET status = $1 where scheduled_command_id = $2 AND status = $3",
+		StatusCancelled, scheduledCommandId, StatusPending)
+	if err != nil {
+		return apperrors.Wrap(err, apperrors.Internal, "database error")
+	}
+	if updated, err := result.RowsAffected(); err != nil || updated == 0 {
+		return ScheduledCommandNotPendingErr
+	}
+	return nil
+}
+
+func (s *DBStore) ListPending() ([]ScheduledCommand, error) {
+	rows := []scheduledCommandRow{}
+	err := s.DB.Select(&rows, "SELECT "+scheduledCommandColumns+" FROM scheduled_commands where status = $1 ORDER BY execute_at",
+		StatusPending)
+	if err != nil {
+		return nil, apperrors.Wrap(err, apperrors.Internal, "database error")
+	}
+	return toScheduledCommands(rows)
+}
+
+func (s *DBStore) ClaimDue(now, staleBefore time.Time, limit int) ([]ScheduledCommand, error) {
+	rows := []scheduledCommandRow{}
+	err := s.DB.Select(&rows, `UPDATE scheduled_commands SET status = $1, claimed_on = $3
+WHERE scheduled_command_id IN (
+	SELECT scheduled_command_id FROM scheduled_commands
+	WHERE (status = $2 AND execute_at <= $3) OR (status = $1 AND claimed_on < $4)
+	ORDER BY execute_at LIMIT $5
+	FOR UPDATE SKIP LOCKED)
+RETURNING `+scheduledCommandColumns,
+		StatusRunning, StatusPending, now, staleBefore, limit)
+	if err != nil {
+		return nil, apperrors.Wrap(err, apperrors.Internal, "database error")
+	}
+	return toScheduledCommands(rows)
+}
+
+func (s *DBStore) Complete(scheduledCommandId uuid.UUID, status, errorMessage string) error {
+	_, err := s.DB.Exec("UPDATE scheduled_commands SET status = $1, error = NULLIF($2, '') where scheduled_command_id = $3",
+		status, errorMessage, scheduledCommandId)
+	return apperrors.Wrap(err, apperrors.Internal, "database error")
+}
+
+// MemoryStore is a Store kept in memory. Scheduled commands are lost when
+// the process exits so it should only be used for tests and development.
+type MemoryStore struct {
+	mutex             sync.Mutex
+	scheduledCommands map[uuid.UUID]ScheduledCommand
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{scheduledCommands: map[uuid.UUID]ScheduledCommand{}}
+}
+
+func (s *MemoryStore) Insert(scheduledCommand ScheduledCommand) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.scheduledCommands[scheduledCommand.ScheduledCommandId] = scheduledCommand
+	return nil
+}
+
+func (s *MemoryStore) Cancel(scheduledCommandId uuid.UUID) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	scheduledCommand, ok := s.scheduledCommands[scheduledCommandId]
+	if !ok {
+		return ScheduledCommandNotFoundErr
+	}
+	if scheduledCommand.Status != StatusPending {
+		return ScheduledCommandNotPendingErr
+	}
+	scheduledCommand.Status = StatusCancelled
+	s.scheduledCommands[scheduledCommandId] = scheduledCommand
+	return nil
+}
+
+func (s *MemoryStore) ListPending() ([]ScheduledCommand, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.filter(func(scheduledCommand ScheduledCommand) bool {
+		return scheduledCommand.Status == StatusPending
+	}), nil
+}
+
+func (s *MemoryStore) ClaimDue(now, staleBefore time.Time, limit int) ([]ScheduledCommand, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	dueCommands := s.filter(func(scheduledCommand ScheduledCommand) bool {
+		switch scheduledCommand.Status {
+		case StatusPending:
+			return !scheduledCommand.ExecuteAt.After(now)
+		case StatusRunning:
+			return scheduledCommand.ClaimedOn != nil && scheduledCommand.ClaimedOn.Before(staleBefore)
+		}
+		return false
+	})
+	if len(dueCommands) > limit {
+		dueCommands = dueCommands[:limit]
+	}
+	for i := range dueCommands {
+		claimedOn := now
+		dueCommands[i].Status = StatusRunning
+		dueCommands[i].ClaimedOn = &claimedOn
+		s.scheduledCommands[dueCommands[i].ScheduledCommandId] = dueCommands[i]
+	}
+	return dueCommands, nil
+}
+
+func (s *MemoryStore) Complete(scheduledCommandId uuid.UUID, status, errorMessage string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	scheduledCommand, ok := s.scheduledCommands[scheduledCommandId]
+	if !ok {
+		return ScheduledCommandNotFoundErr
+	}
+	scheduledCommand.Status = status
+	scheduledCommand.Error = errorMessage
+	s.scheduledCommands[scheduledCommandId] = scheduledCommand
+	return nil
+}
+
+// Get returns a scheduled command whatever its status.
+func (s *MemoryStore) Get(scheduledCommandId uuid.UUID) (ScheduledCommand, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	scheduledCommand, ok := s.scheduledCommands[scheduledCommandId]
+	if !ok {
+		return ScheduledCommand{}, ScheduledCommandNotFoundErr
+	}
+	return scheduledCommand, nil
+}
+
+// filter returns the scheduled commands matching keep ordered by ExecuteAt.
+func (s *MemoryStore) filter(keep func(ScheduledCommand) bool) []ScheduledCommand {
+	kept := []ScheduledCommand{}
+	for _, scheduledCommand := range s.scheduledCommands {
+		if keep(scheduledCommand) {
+			kept = append(kept, scheduledCommand)
+		}
+	}
+	sort.Sort(byExecuteAt(kept))
+	return kept
+}
+
+type byExecuteAt []ScheduledCommand
+
+func (s byExecuteAt) Len() int           { return len(s) }
+func (s byExecuteAt) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byExecuteAt) Less(i, j int) bool { return s[i].ExecuteAt.Before(s[j].ExecuteAt) }
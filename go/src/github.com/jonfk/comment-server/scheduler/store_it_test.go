@@ -0,0 +1,117 @@
+package scheduler
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/joho/godotenv"
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/commands"
+)
+
+var (
+	DBUser, DBName, DBPassword string
+)
+
+func TestMain(m *testing.M) {
+	err := godotenv.Load("../.env")
+	if err != nil {
+		log.Fatal("Error loading .env file")
+	}
+
+	DBUser = os.Getenv("DATABASE_USER")
+	DBName = os.Getenv("DATABASE_NAME")
+	DBPassword = os.Getenv("DATABASE_PASSWORD")
+
+	os.Exit(m.Run())
+}
+
+func TestDBStore(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode.")
+	}
+
+	db, err := sqlx.Connect("postgres", fmt.Sprintf("user=%s dbname=%s password=%s sslmode=disable", DBUser, DBName, DBPassword))
+	if err != nil {
+		t.Fatalf("sqlx.Connect failed : %v\n", err)
+	}
+
+	store := &DBStore{DB: db}
+	now := time.Now().UTC().Round(time.Second)
+
+	dueCommand := ScheduledCommand{
+		ScheduledCommandId: uuid.NewV4(),
+		ExecuteAt:          now.Add(-time.Minute),
+		Command:            commands.CreateCommand(commands.DeleteAccount{AccountId: uuid.NewV4()}),
+		Status:             StatusPending,
+		CreatedOn:          now,
+	}
+	laterCommand := ScheduledCommand{
+		ScheduledCommandId: uuid.NewV4(),
+		ExecuteAt:          now.Add(time.Hour),
+		Command:            commands.CreateCommand(commands.CreateCommentThread{PageUrl: "pageUrl", Title: "title"}),
+		Status:             StatusPending,
+		CreatedOn:          now,
+	}
+	defer db.Exec("DELETE FROM scheduled_commands where scheduled_command_id = $1 OR scheduled_command_id = $2",
+		dueCommand.ScheduledCommandId, laterCommand.ScheduledCommandId)
+
+	for _, scheduledCommand := range []ScheduledCommand{dueCommand, laterCommand} {
+		if err := store.Insert(scheduledCommand); err != nil {
+			t.Fatalf("store.Insert failed : %v\n", err)
+		}
+	}
+
+	claimed, err := store.ClaimDue(now, now.Add(-DefaultLease), 100)
+	if err != nil {
+		t.Fatalf("store.ClaimDue failed : %v\n", err)
+	}
+	found := false
+	for _, scheduledCommand := range claimed {
+		if uuid.Equal(scheduledCommand.ScheduledCommandId, laterCommand.ScheduledCommandId) {
+			t.Fatal("store.ClaimDue claimed a command that is not due")
+		}
+		if uuid.Equal(scheduledCommand.ScheduledCommandId, dueCommand.ScheduledCommandId) {
+			found = true
+			if scheduledCommand.Command.CommandType != commands.DeleteAccountTypeName {
+				t.Fatalf("claimed command was not decoded : %v", scheduledCommand.Command)
+			}
+		}
+	}
+	if !found {
+		t.Fatal("store.ClaimDue did not claim the due command")
+	}
+
+	if claimed, err := store.ClaimDue(now, now.Add(-DefaultLease), 100); err != nil || containsCommand(claimed, dueCommand.ScheduledCommandId) {
+		t.Fatalf("store.ClaimDue should not claim a command during its lease, claimed %v, err %v", claimed, err)
+	}
+	later := now.Add(2 * DefaultLease)
+	claimed, err = store.ClaimDue(later, later.Add(-DefaultLease), 100)
+	if err != nil || !containsCommand(claimed, dueCommand.ScheduledCommandId) {
+		t.Fatalf("store.ClaimDue should claim an abandoned command once its lease expired, claimed %v, err %v", claimed, err)
+	}
+
+	if err := store.Complete(dueCommand.ScheduledCommandId, StatusDone, ""); err != nil {
+		t.Fatalf("store.Complete failed : %v\n", err)
+	}
+	if err := store.Cancel(dueCommand.ScheduledCommandId); err != ScheduledCommandNotPendingErr {
+		t.Fatalf("store.Cancel should fail on a completed command but returned %v", err)
+	}
+	if err := store.Cancel(laterCommand.ScheduledCommandId); err != nil {
+		t.Fatalf("store.Cancel failed : %v\n", err)
+	}
+}
+
+func containsCommand(scheduledCommands []ScheduledCommand, scheduledCommandId uuid.UUID) bool {
+	for _, scheduledCommand := range scheduledCommands {
+		if uuid.Equal(scheduledCommand.ScheduledCommandId, scheduledCommandId) {
+			return true
+		}
+	}
+	return false
+}
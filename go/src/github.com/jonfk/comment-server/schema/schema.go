@@ -0,0 +1,335 @@
+package schema
+
+//go:generate go run ../bin/schema-gen/main.go -out .
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/jonfk/comment-server/apperrors"
+	"github.com/jonfk/comment-server/commands"
+	"github.com/jonfk/comment-server/events"
+)
+
+const (
+	jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+	openAPIVersion  = "3.1.0"
+
+	CommandsSchemaFileName = "commands.schema.json"
+	EventsSchemaFileName   = "events.schema.json"
+	OpenAPIFileName        = "openapi.json"
+)
+
+// Schema is a JSON Schema or OpenAPI document. It is a map so that it is
+// marshalled with sorted keys and the generated files are stable.
+type Schema map[string]interface{}
+
+var (
+	uuidType = reflect.TypeOf(uuid.UUID{})
+	timeType = reflect.TypeOf(time.Time{})
+)
+
+// ForType returns the JSON Schema of the JSON encoding of t. Named struct
+// types other than uuid.UUID and time.Time are referenced with refPrefix
+// followed by the type name and are expected to be defined by the caller.
+func ForType(t reflect.Type, refPrefix string) Schema {
+	switch t {
+	case uuidType:
+		return Schema{"type": "string", "format": "uuid"}
+	case timeType:
+		return Schema{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		elem := ForType(t.Elem(), refPrefix)
+		return Schema{"oneOf": []Schema{elem, Schema{"type": "null"}}}
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return Schema{"type": "string", "contentEncoding": "base64"}
+		}
+		return Schema{"type": "array", "items": ForType(t.Elem(), refPrefix)}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": ForType(t.Elem(), refPrefix)}
+	case reflect.Struct:
+		if t.Name() != "" {
+			return Schema{"$ref": refPrefix + t.Name()}
+		}
+		return structSchema(t, refPrefix)
+	default:
+		return Schema{}
+	}
+}
+
+// structSchema returns the JSON Schema of the fields of the struct type t.
+// Fields tagged with omitempty are optional, every other field is required.
+func structSchema(t reflect.Type, refPrefix string) Schema {
+	properties := Schema{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name, omitEmpty := jsonFieldName(field)
+		if name == "-" {
+			continue
+		}
+		properties[name] = ForType(field.Type, refPrefix)
+		if !omitEmpty {
+			required = append(required, name)
+		}
+	}
+	schema := Schema{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func jsonFieldName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "" {
+		return field.Name, false
+	}
+	parts := strings.Split(tag, ",")
+	name := parts[0]
+	if name == "" {
+		name = field.Name
+	}
+	for _, option := range parts[1:] {
+		if option == "omitempty" {
+			return name, true
+		}
+	}
+	return name, false
+}
+
+// Definitions returns the schema of every command and event payload, of
+// their envelopes and of command and error responses, keyed by name.
+func Definitions(refPrefix string) Schema {
+	definitions := Schema{}
+	commandRefs := []Schema{}
+	for _, payload := range commands.PayloadTypes() {
+		payloadType := reflect.TypeOf(payload)
+		definitions[payloadType.Name()] = structSchema(payloadType, refPrefix)
+		envelopeName := payloadType.Name() + "Command"
+		definitions[envelopeName] = Schema{
+			"type": "object",
+			"properties": Schema{
+				"commandType": Schema{"const": payload.CommandType()},
+				"payload":     Schema{"$ref": refPrefix + payloadType.Name()},
+			},
+			"required":             []string{"commandType", "payload"},
+			"additionalProperties": false,
+		}
+		commandRefs = append(commandRefs, Schema{"$ref": refPrefix + envelopeName})
+	}
+	definitions["Command"] = Schema{"oneOf": commandRefs}
+
+	eventRefs := []Schema{}
+	for _, payload := range events.PayloadTypes() {
+		payloadType := reflect.TypeOf(payload)
+		definitions[payloadType.Name()] = structSchema(payloadType, refPrefix)
+		envelopeName := payloadType.Name() + "Event"
+		definitions[envelopeName] = Schema{
+			"type": "object",
+			"properties": Schema{
+				"eventType": Schema{"const": payload.EventType()},
+				"timestamp": ForType(timeType, refPrefix),
+				"eventId":   ForType(uuidType, refPrefix),
+				"payload":   Schema{"$ref": refPrefix + payloadType.Name()},
+			},
+			"required":             []string{"eventType", "timestamp", "eventId", "payload"},
+			"additionalProperties": false,
+		}
+		eventRefs = append(eventRefs, Schema{"$ref": refPrefix + envelopeName})
+	}
+	definitions["Event"] = Schema{"oneOf": eventRefs}
+
+	for _, responseType := range []reflect.Type{reflect.TypeOf(commands.Response{}), reflect.TypeOf(commands.Secrets{})} {
+		definitions[responseType.Name()] = structSchema(responseType, refPrefix)
+	}
+
+	errorResponse := structSchema(reflect.TypeOf(apperrors.Response{}), refPrefix)
+	errorResponse["properties"].(Schema)["code"] = Schema{"type": "string", "enum": errorCodes()}
+	definitions["ErrorResponse"] = errorResponse
+
+	return definitions
+}
+
+// CommandsSchema returns the JSON Schema of a command.
+func CommandsSchema() Schema {
+	return Schema{
+		"$schema":     jsonSchemaDraft,
+		"title":       "Command",
+		"allOf":       []Schema{Schema{"$ref": "#/definitions/Command"}},
+		"definitions": onlyReachable(Definitions("#/definitions/"), "Command"),
+	}
+}
+
+// EventsSchema returns the JSON Schema of an event.
+func EventsSchema() Schema {
+	return Schema{
+		"$schema":     jsonSchemaDraft,
+		"title":       "Event",
+		"allOf":       []Schema{Schema{"$ref": "#/definitions/Event"}},
+		"definitions": onlyReachable(Definitions("#/definitions/"), "Event"),
+	}
+}
+
+// OpenAPI returns the OpenAPI description of the command endpoint. A
+// command is posted as JSON and a Response with the resulting event is
+// returned, or an ErrorResponse with a status taken from
+// apperrors.HTTPStatus.
+func OpenAPI() Schema {
+	errorResponse := Schema{
+		"description": "The command was rejected",
+		"content": Schema{
+			"application/json": Schema{
+				"schema": Schema{"$ref": "#/components/schemas/ErrorResponse"},
+			},
+		},
+	}
+	responses := Schema{
+		"200": Schema{
+			"description": "The command succeeded and resulted in an event",
+			"content": Schema{
+				"application/json": Schema{
+					"schema": Schema{"$ref": "#/components/schemas/Response"},
+				},
+			},
+		},
+	}
+	for _, status := range errorStatuses() {
+		responses[status] = errorResponse
+	}
+
+	return Schema{
+		"openapi": openAPIVersion,
+		"info": Schema{
+			"title":   "comment-server",
+			"version": "1",
+		},
+		"paths": Schema{
+			"/commands": Schema{
+				"post": Schema{
+					"operationId": "handleCommand",
+					"summary":     "Submit a command",
+					"requestBody": Schema{
+						"required": true,
+						"content": Schema{
+							"application/json": Schema{
+								"schema": Schema{"$ref": "#/components/schemas/Command"},
+							},
+						},
+					},
+					"responses": responses,
+				},
+			},
+		},
+		"components": Schema{
+			"schemas": Definitions("#/components/schemas/"),
+		},
+	}
+}
+
+// Files returns the content of every generated file keyed by file name.
+func Files() (map[string][]byte, error) {
+	documents := map[string]Schema{
+		CommandsSchemaFileName: CommandsSchema(),
+		EventsSchemaFileName:   EventsSchema(),
+		OpenAPIFileName:        OpenAPI(),
+	}
+	files := map[string][]byte{}
+	for name, document := range documents {
+		content, err := json.MarshalIndent(document, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		files[name] = append(content, '\n')
+	}
+	return files, nil
+}
+
+// onlyReachable returns the definitions that are referenced, directly or
+// not, from the definition root.
+func onlyReachable(definitions Schema, root string) Schema {
+	reachable := Schema{}
+	var visit func(name string)
+	visit = func(name string) {
+		if _, ok := reachable[name]; ok {
+			return
+		}
+		definition, ok := definitions[name]
+		if !ok {
+			return
+		}
+		reachable[name] = definition
+		for _, ref := range refs(definition) {
+			visit(ref[strings.LastIndex(ref, "/")+1:])
+		}
+	}
+	visit(root)
+	return reachable
+}
+
+func refs(value interface{}) []string {
+	found := []string{}
+	switch v := value.(type) {
+	case Schema:
+		for key, child := range v {
+			if ref, ok := child.(string); ok && key == "$ref" {
+				found = append(found, ref)
+			} else {
+				found = append(found, refs(child)...)
+			}
+		}
+	case []Schema:
+		for _, child := range v {
+			found = append(found, refs(child)...)
+		}
+	}
+	return found
+}
+
+func errorCodes() []string {
+	codes := []string{}
+	for code := range apperrors.HTTPStatus {
+		codes = append(codes, string(code))
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+func errorStatuses() []string {
+	seen := map[int]bool{}
+	statuses := []string{}
+	for _, status := range apperrors.HTTPStatus {
+		if !seen[status] {
+			seen[status] = true
+			statuses = append(statuses, strconv.Itoa(status))
+		}
+	}
+	sort.Strings(statuses)
+	return statuses
+}
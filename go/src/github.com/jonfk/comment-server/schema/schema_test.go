@@ -0,0 +1,26 @@
+package schema
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+// TestCheckedInFilesAreUpToDate fails when the commands or events changed
+// without regenerating the schema files with go generate.
+func TestCheckedInFilesAreUpToDate(t *testing.T) {
+	files, err := Files()
+	if err != nil {
+		t.Fatalf("Files failed : %v\n", err)
+	}
+
+	for name, expectedContent := range files {
+		content, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatalf("ReadFile failed : %v\n", err)
+		}
+		if !bytes.Equal(content, expectedContent) {
+			t.Fatalf("%s is out of date with the Go structs, run go generate github.com/jonfk/comment-server/schema", name)
+		}
+	}
+}